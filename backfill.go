@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	补抓指定日期范围内的历史分时数据
+//	用法: stockrecorder backfill --market America --from 20240101 --to 20240201 [--companies AAPL,MSFT]
+func backfill(args []string) error {
+
+	flags := newFlagSet("backfill", "--market America --from 20240101 [--to 20240201] [参数]")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	from := flags.String("from", "", "起始日期(20060102)")
+	to := flags.String("to", "", "结束日期(20060102),默认为昨天")
+	companies := flags.String("companies", "", "只补抓这些上市公司(逗号分隔),默认为全部")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *marketName == "" || *from == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	err := cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	start, err := parseDay(*from, yesterday())
+	if err != nil {
+		return err
+	}
+
+	end, err := parseDay(*to, yesterday())
+	if err != nil {
+		return err
+	}
+
+	codes := make([]string, 0)
+	for _, code := range strings.Split(*companies, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes = append(codes, code)
+		}
+	}
+
+	err = market.Backfill(*marketName, start, end, codes)
+	if err != nil {
+		return fmt.Errorf("补抓历史分时数据出错: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	参数错误(已经输出了用法)
+var errUsage = errors.New("参数错误")
+
+//	子命令
+type command struct {
+	name string
+	//	一行说明
+	description string
+	run         func(args []string) error
+}
+
+//	所有子命令(第一个为默认)
+var commands []command
+
+//	子命令的参数说明引用了commands,需要在init中初始化
+func init() {
+	commands = []command{
+		{"monitor", "监视市场,定时抓取分时数据并启动http服务(默认)", monitor},
+		{"backfill", "补抓指定日期范围内的历史分时数据", backfill},
+		{"export", "导出上市公司某日的分时数据(csv或json)", export},
+		{"gaps", "检查指定日期范围内缺失的交易日,有缺失时返回1", gaps},
+		{"reprocess", "用存档的原始数据重新解析市场某日的分时数据,不访问网络", reprocess},
+		{"prune", "删除超过保留天数的分时数据、处理状态和错误信息,试运行时只输出会删除的行数", prune},
+		{"maintain", "对市场所有上市公司的数据库运行VACUUM和PRAGMA optimize", maintain},
+		{"verify", "检查配置和市场的设置,指定了上市公司时用日线核对保存的分时数据,不一致时返回1", verify},
+		{"fetch", "抓取单个上市公司某日的分时数据并输出保存的结果", fetch},
+	}
+}
+
+//	按名称查找子命令
+func findCommand(name string) (command, bool) {
+
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+
+	return command{}, false
+}
+
+//	输出所有子命令的说明
+func usage() {
+
+	fmt.Fprintln(os.Stderr, "用法: stockrecorder <命令> [参数]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "命令:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s%s\n", c.name, c.description)
+	}
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "使用 stockrecorder <命令> --help 查看命令的参数")
+	fmt.Fprintln(os.Stderr, "退出码: 0 成功, 1 失败, 2 参数错误")
+}
+
+//	子命令的参数(-h/--help时输出说明)
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		c, _ := findCommand(name)
+		fmt.Fprintf(os.Stderr, "用法: stockrecorder %s %s\n\n%s\n\n参数:\n", name, synopsis, c.description)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+//	覆盖配置文件的通用参数
+type configFlags struct {
+	dataDir     *string
+	concurrency *int
+	dryRun      *bool
+}
+
+//	添加覆盖配置文件的通用参数
+func addConfigFlags(flags *flag.FlagSet) configFlags {
+
+	return configFlags{
+		dataDir:     flags.String("data-dir", "", "数据目录(覆盖配置文件的DataDir)"),
+		concurrency: flags.Int("concurrency", 0, "每个市场同时抓取的上市公司数(覆盖配置文件的Concurrency)"),
+		dryRun:      flags.Bool("dry-run", false, "只抓取和解析,不写入数据(覆盖配置文件的DryRun)")}
+}
+
+//	读取配置文件并加入所有市场,命令行中指定了的参数覆盖配置文件
+func (f configFlags) init(flags *flag.FlagSet) error {
+
+	return initialize(func(c *config.Config) {
+		f.override(flags, c)
+	})
+}
+
+//	用命令行中指定了的参数覆盖配置
+func (f configFlags) override(flags *flag.FlagSet, c *config.Config) {
+
+	flags.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "data-dir":
+			c.DataDir = *f.dataDir
+		case "concurrency":
+			c.Concurrency = *f.concurrency
+		case "dry-run":
+			c.DryRun = *f.dryRun
+		}
+	})
+}
+
+//	解析日期(20060102或2006-01-02),为空时返回defaultValue
+func parseDay(text string, defaultValue time.Time) (time.Time, error) {
+	return parseDayIn(text, defaultValue, time.UTC)
+}
+
+//	按指定时区解析日期(如市场所在时区)
+func parseDayIn(text string, defaultValue time.Time, location *time.Location) (time.Time, error) {
+
+	if text == "" {
+		return defaultValue, nil
+	}
+
+	for _, layout := range []string{"20060102", "2006-01-02"} {
+		day, err := time.ParseInLocation(layout, text, location)
+		if err == nil {
+			return day, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("错误的日期%s,格式应为20060102", text)
+}
+
+//	按本地日期的昨天(作为结束日期的默认值)
+func yesterday() time.Time {
+
+	now := time.Now()
+
+	return time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.UTC)
+}
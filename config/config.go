@@ -3,8 +3,10 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	"github.com/nzai/go-utility/io"
 	"github.com/nzai/go-utility/path"
@@ -14,60 +16,433 @@ const (
 	configFile = "project.json"
 )
 
+const (
+	defaultRetryInterval     = 60
+	defaultRetryMaxAttempts  = 5
+	defaultRateLimit         = 10
+	defaultRateLimitCooldown = 60
+	defaultHTTPTimeout       = 30
+	defaultCrawlTimeout      = 300
+	defaultDelistGraceDays   = 7
+	defaultInactiveAfterDays = 10
+	defaultRetentionInterval = 24
+	defaultBackupRegion      = "us-east-1"
+	defaultBackupKeep        = 7
+	defaultInfluxBatchSize   = 5000
+	defaultHealthMaxAge      = 26
+	defaultEventBuffer       = 1024
+	defaultVerifyTolerance   = 0.005
+
+	defaultShutdownTimeout        = 60
+	defaultCompanyArchiveVersions = 30
+	defaultCompanyListMaxShrink   = 0.5
+
+	defaultConcurrency           = 64
+	defaultHistoryDays           = 90
+	defaultDownloadRetries       = 50
+	defaultDownloadRetryInterval = 10
+
+	defaultSQLiteJournalMode = "WAL"
+	defaultSQLiteSynchronous = "NORMAL"
+	defaultSQLiteBusyTimeout = 5000
+	defaultSQLiteBusyRetries = 3
+	defaultSQLiteBatchSize   = 140
+	defaultSQLiteMaxIdleDBs  = 128
+)
+
+//	可以按市场覆盖的设置(为空时使用全局设置)
+type MarketConfig struct {
+	Concurrency           *int
+	HistoryDays           *int
+	DownloadRetries       *int
+	DownloadRetryInterval *int
+	//	只抓取这些上市公司(代码或通配符如A*,为空时抓取全部)
+	Include []string
+	//	不抓取这些上市公司(代码或通配符如A*)
+	Exclude []string
+	//	从本地的CSV或JSON文件读取上市公司列表(code, name, exchange),为空时从数据源获取
+	CompanyFile string
+	//	周末以外的休市日(20060102),当天不运行每日任务,缺失检查也不算交易日
+	Holidays []string
+	//	每日任务的运行时间(当地时间15:04),早于收盘时间时为第二天的这个时间,设置后不使用ScheduleDelay
+	RunAt string
+	//	数据源(yahoo或stooq),默认为yahoo,stooq只有日线
+	Source string
+	//	上市公司列表以外需要抓取的指数(以^开头,如^GSPC)和ETF代码,不参与上市和退市检查
+	Symbols []string
+}
+
 type Config struct {
 	RootDir string
-	DataDir string
-	Port    int
+	//	数据目录(数据库、上市公司列表和锁文件),可以是挂载的卷,不存在时自动创建,启动时检查是否可写
+	DataDir string `env:"STORAGE_ROOT"`
+	Port    int    `env:"PORT"`
+
+	//	只读查询接口的监听地址(如:8080),为空时不启动
+	APIAddress string `env:"API_ADDRESS"`
+	//	最近一次成功的每日任务超过多少小时后/healthz返回503,默认26
+	HealthMaxAge int
+
+	//	PostgreSQL连接字符串,为空时每个上市公司使用单独的sqlite文件
+	PostgresDSN string `env:"POSTGRES_DSN"`
+
+	//	sqlite的日志模式(journal_mode),默认WAL
+	SQLiteJournalMode string
+	//	sqlite的同步方式(synchronous),默认NORMAL
+	SQLiteSynchronous string
+	//	sqlite被锁定时等待的时间(毫秒),默认5000
+	SQLiteBusyTimeout int `env:"SQLITE_BUSY_TIMEOUT"`
+	//	等待后仍然被锁定(SQLITE_BUSY)时重新启动事务的次数,默认3
+	SQLiteBusyRetries int
+	//	sqlite每条insert语句保存的分时数据条数(1到142),默认140
+	SQLiteBatchSize int
+	//	最多保留的空闲sqlite连接数(每个上市公司每个间隔一个文件),默认128,应不少于同时抓取的上市公司数乘以间隔数
+	SQLiteMaxIdleDBs int `env:"SQLITE_MAX_IDLE_DBS"`
+
+	//	是否在http服务中提供/metrics
+	Metrics bool
+
+	//	重试队列的检查间隔(分钟)
+	RetryInterval int
+	//	重试次数超过后不再重试
+	RetryMaxAttempts int
+
+	//	每个市场每秒最多请求数
+	RateLimit float64
+	//	被限速后暂停的时间(秒)
+	RateLimitCooldown int
+
+	//	http代理地址,为空时使用环境变量中的代理
+	HTTPProxy string `env:"HTTP_PROXY"`
+	//	http请求超时时间(秒)
+	HTTPTimeout int
+	//	每次抓取(包括下载重试)的最长时间(秒),超时后放弃并加入重试队列,默认300
+	CrawlTimeout int `env:"CRAWL_TIMEOUT"`
+	//	http请求的User-Agent,为空时轮流使用内置的浏览器User-Agent
+	UserAgent string
+
+	//	每日任务和历史任务抓取的间隔(1m, 2m, 5m, 15m, 1d),为空时只抓取1m
+	//	只有1m的失败会记入错误信息和重试队列
+	Intervals []string `env:"INTERVALS"`
+
+	//	异常分时数据(最高价低于最低价等)的处理方式:drop为丢弃(默认),fail为整天失败并记录错误信息
+	InvalidPoints string
+	//	分时数据的检查规则(price, range, volume, session, duplicate),为空时全部检查
+	ValidationRules []string
+	//	异常分时数据的占比超过该值(0到1之间)时整天失败,为0时只丢弃异常数据
+	MaxInvalidRatio float64
+
+	//	原始数据的存档目录(gzip压缩),为空时不存档
+	RawDir string `env:"RAW_DIR"`
+	//	原始数据的gzip压缩级别(1到9),为0时使用默认级别
+	RawCompressionLevel int
+
+	//	核对分时数据时日线价格允许的相对误差,默认0.005
+	VerifyTolerance float64
+	//	核对分时数据时使用的日线数据源(stooq),为空时使用市场自己的数据源
+	VerifySource string
+	//	核对出不一致的日期清除处理状态并加入重试队列
+	VerifyReset bool
+
+	//	不记录每次抓取的用时、状态码、响应大小、重试次数和条数(crawl_stats)
+	DisableCrawlStats bool
+
+	//	分时数据保留的月数,超过的只保留日线,为0时不清理
+	RetentionMonths int
+	//	所有数据保留的天数,超过的分时数据、处理状态和错误信息都删除(1d间隔和日线保留),为0时不删除,不能少于HistoryDays
+	RetentionDays int
+	//	清理前分时数据的存档目录(gzip压缩),为空时直接删除
+	ArchiveDir string `env:"ARCHIVE_DIR"`
+	//	清理任务的运行间隔(小时),默认24
+	RetentionInterval int
+	//	数据库维护(VACUUM和PRAGMA optimize)任务的运行间隔(小时),为0时不运行
+	MaintainInterval int
+
+	//	收到SIGINT或SIGTERM后等待进行中的事务结束的最长时间(秒),默认60
+	ShutdownTimeout int
+
+	//	S3兼容的对象存储地址(如https://s3.amazonaws.com),为空时不备份
+	//	每日任务结束后上传有变化的sqlite数据库文件,键为市场/上市公司/日期.db
+	BackupEndpoint string `env:"BACKUP_ENDPOINT"`
+	//	备份的存储桶
+	BackupBucket string `env:"BACKUP_BUCKET"`
+	//	对象存储的区域,默认us-east-1
+	BackupRegion string
+	//	对象存储的访问密钥
+	BackupAccessKey string `env:"BACKUP_ACCESS_KEY"`
+	BackupSecretKey string `env:"BACKUP_SECRET_KEY"`
+	//	每个数据库文件保留的备份数,默认7
+	BackupKeep int
+
+	//	InfluxDB的写入地址(如http://localhost:8086/write?db=stock),为空时不导出
+	//	每日任务结束后把上次导出以来的分时数据按行协议写入
+	InfluxURL string `env:"INFLUX_URL"`
+	//	InfluxDB的访问令牌(InfluxDB 2.x),为空时不验证
+	InfluxToken string `env:"INFLUX_TOKEN"`
+	//	每次写入InfluxDB的行数,默认5000
+	InfluxBatchSize int
+
+	//	每日任务开始、结束和失败率超过阈值时通知的地址(POST JSON),为空时不通知
+	WebhookURL string `env:"WEBHOOK_URL"`
+	//	失败率超过多少时通知(0到1之间),为0时不通知
+	NotifyFailureRate float64
+
+	//	同步调用事件订阅(OnEvent),订阅者处理慢时会拖慢抓取
+	SyncEvents bool
+
+	//	每个事件订阅的缓冲区大小,满了以后丢弃新的事件,默认1024
+	EventBuffer int
+
+	//	东京证券交易所上市公司列表(JPX上市銘柄一覧另存的Shift-JIS编码CSV),可以是网址或本地文件路径
+	//	为空时读取数据目录下的Japan/japan_companies.csv
+	JapanCompanyList string
+	//	上市公司列表存档保留的版本数(每次更新保存一个带时间的文件),默认30
+	CompanyArchiveVersions int
+	//	更新的上市公司列表比上一版本少多少(0到1之间)时视为数据源异常,不保存并使用存档,默认0.5
+	CompanyListMaxShrink float64
+	//	确认数据源的上市公司确实大幅减少时设置,允许保存并读取减少后的列表
+	CompanyListForce bool
+
+	//	退市后继续抓取的天数(上市公司列表偶尔会漏掉仍在交易的股票)
+	DelistGraceDays int
+	//	每日任务连续失败多少个交易日后标记为不活跃,不再抓取,默认10
+	InactiveAfterDays int
+
+	//	每个市场收盘(包括盘后交易)后多少分钟运行每日任务(按市场名称),默认120
+	ScheduleDelay map[string]int
+	//	每日任务运行时间的随机偏移(正负多少分钟),避免多个市场同时开始抓取,为0时不偏移
+	ScheduleJitter int
+
+	//	每个市场同时抓取的上市公司数,默认64
+	Concurrency int `env:"CRAWL_CONCURRENCY"`
+	//	所有市场加起来同时抓取的上市公司数,为0时不限制(每个市场仍受Concurrency限制)
+	MaxConcurrency int `env:"MAX_CONCURRENCY"`
+	//	每日任务开始时相邻两个抓取的启动间隔(毫秒),为0时同时启动
+	WorkerStagger int
+	//	历史任务抓取最近多少天的数据,默认90(雅虎财经的分时数据一般只保留90天)
+	HistoryDays int `env:"HISTORY_DAYS"`
+	//	下载失败时的重试次数,默认50
+	DownloadRetries int
+	//	下载失败时重试的间隔(秒),默认10
+	DownloadRetryInterval int
+	//	按市场名称覆盖以上设置
+	Markets map[string]MarketConfig
+
+	//	试运行:抓取并解析但不写入任何数据(用于升级解析后验证),只运行每日任务
+	DryRun bool `env:"DRY_RUN"`
 }
 
-//	当前系统配置
-var configValue *Config = nil
+//	当前系统配置(*Config,只整体替换,不修改已经发布的配置)
+var configValue atomic.Value
 
 //	初始化配置文件
 func Init() error {
 
+	value, err := Load()
+	if err != nil {
+		return err
+	}
+
+	return Set(value)
+}
+
+//	读取配置文件并用环境变量覆盖(不应用默认值也不检查,可以先用命令行参数覆盖再调用Set)
+//	设置了STOCKRECORDER_开头的环境变量时可以没有配置文件
+func Load() (*Config, error) {
+
 	//	启动目录
 	startupDir, err := path.GetStartupDir()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	//	构造配置文件路径
-	filePath := filepath.Join(startupDir, configFile)
-	if !io.IsExists(filePath) {
-		return fmt.Errorf("配置文件 %s 不存在", filePath)
+	return loadFile(filepath.Join(startupDir, configFile))
+}
+
+//	读取指定的配置文件并用环境变量覆盖
+func loadFile(filePath string) (*Config, error) {
+
+	value := &Config{}
+	if io.IsExists(filePath) {
+		//	读取文件
+		buffer, err := io.ReadAllBytes(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		//	解析配置项
+		err = json.Unmarshal(buffer, value)
+		if err != nil {
+			return nil, fmt.Errorf("配置文件 %s 格式错误: %s", filePath, err.Error())
+		}
+	} else if !hasEnv() {
+		//	容器中可以只用环境变量配置
+		return nil, fmt.Errorf("配置文件 %s 不存在", filePath)
 	}
 
-	//	读取文件
-	buffer, err := io.ReadAllBytes(filePath)
+	//	环境变量覆盖配置文件
+	err := applyEnv(value)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return value, nil
+}
+
+//	使用指定的配置(没有设置的项使用默认值,数据目录不存在就创建),配置有错误时返回所有问题(*ValidationError)并保留当前配置,测试或者嵌入到其他程序时可以代替Init
+func Set(value *Config) error {
+
+	//	在副本上应用默认值,完成后再替换当前配置,读取配置的goroutine不会看到一半的配置
+	c := *value
+
+	//	默认值(只替换没有设置的0,负数保留,检查时报错)
+	if c.RetryInterval == 0 {
+		c.RetryInterval = defaultRetryInterval
+	}
+
+	if c.RetryMaxAttempts == 0 {
+		c.RetryMaxAttempts = defaultRetryMaxAttempts
+	}
+
+	if c.RateLimit == 0 {
+		c.RateLimit = defaultRateLimit
+	}
+
+	if c.RateLimitCooldown == 0 {
+		c.RateLimitCooldown = defaultRateLimitCooldown
 	}
 
-	//	解析配置项
-	configValue = &Config{}
-	err = json.Unmarshal(buffer, configValue)
+	if c.HTTPTimeout == 0 {
+		c.HTTPTimeout = defaultHTTPTimeout
+	}
+
+	if c.CrawlTimeout == 0 {
+		c.CrawlTimeout = defaultCrawlTimeout
+	}
+
+	if c.DelistGraceDays == 0 {
+		c.DelistGraceDays = defaultDelistGraceDays
+	}
+
+	if c.InactiveAfterDays == 0 {
+		c.InactiveAfterDays = defaultInactiveAfterDays
+	}
+
+	if c.RetentionInterval == 0 {
+		c.RetentionInterval = defaultRetentionInterval
+	}
+
+	if c.BackupRegion == "" {
+		c.BackupRegion = defaultBackupRegion
+	}
+
+	if c.BackupKeep == 0 {
+		c.BackupKeep = defaultBackupKeep
+	}
+
+	if c.InfluxBatchSize == 0 {
+		c.InfluxBatchSize = defaultInfluxBatchSize
+	}
+
+	if c.HealthMaxAge == 0 {
+		c.HealthMaxAge = defaultHealthMaxAge
+	}
+
+	if c.EventBuffer == 0 {
+		c.EventBuffer = defaultEventBuffer
+	}
+
+	if c.VerifyTolerance == 0 {
+		c.VerifyTolerance = defaultVerifyTolerance
+	}
+
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	if c.CompanyArchiveVersions == 0 {
+		c.CompanyArchiveVersions = defaultCompanyArchiveVersions
+	}
+
+	if c.CompanyListMaxShrink == 0 {
+		c.CompanyListMaxShrink = defaultCompanyListMaxShrink
+	}
+
+	if c.Concurrency == 0 {
+		c.Concurrency = defaultConcurrency
+	}
+
+	if c.HistoryDays == 0 {
+		c.HistoryDays = defaultHistoryDays
+	}
+
+	if c.DownloadRetries == 0 {
+		c.DownloadRetries = defaultDownloadRetries
+	}
+
+	if c.DownloadRetryInterval == 0 {
+		c.DownloadRetryInterval = defaultDownloadRetryInterval
+	}
+
+	if c.SQLiteJournalMode == "" {
+		c.SQLiteJournalMode = defaultSQLiteJournalMode
+	}
+
+	if c.SQLiteSynchronous == "" {
+		c.SQLiteSynchronous = defaultSQLiteSynchronous
+	}
+
+	if c.SQLiteBusyTimeout == 0 {
+		c.SQLiteBusyTimeout = defaultSQLiteBusyTimeout
+	}
+
+	if c.SQLiteBusyRetries == 0 {
+		c.SQLiteBusyRetries = defaultSQLiteBusyRetries
+	}
+
+	//	超出范围时启动监视时报错
+	if c.SQLiteBatchSize == 0 {
+		c.SQLiteBatchSize = defaultSQLiteBatchSize
+	}
+
+	if c.SQLiteMaxIdleDBs == 0 {
+		c.SQLiteMaxIdleDBs = defaultSQLiteMaxIdleDBs
+	}
+
+	if c.DataDir != "" {
+		c.DataDir = filepath.Clean(c.DataDir)
+	}
+
+	//	检查配置,数据目录不存在就创建,有错误时不替换当前配置
+	err := c.Validate()
 	if err != nil {
 		return err
 	}
 
-	if configValue == nil {
-		return fmt.Errorf("配置文件错误")
+	configValue.Store(&c)
+
+	return nil
+}
+
+//	目录不存在就创建,并写入临时文件检查是否可写(启动时就报错,避免抓取时才失败)
+func prepareDir(name, dir string) error {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("创建目录(%s) %s 失败: %s", name, dir, err.Error())
 	}
 
-	//	数据目录不存在就创建
-	_, err = os.Stat(configValue.DataDir)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(configValue.DataDir, 0666)
-		if err != nil {
-			return err
-		}
+	file, err := ioutil.TempFile(dir, ".stockrecorder")
+	if err != nil {
+		return fmt.Errorf("目录(%s) %s 不可写: %s", name, dir, err.Error())
 	}
+	file.Close()
 
-	return nil
+	return os.Remove(file.Name())
 }
 
 //	获取当前系统配置
 func Get() *Config {
-	return configValue
+	value, _ := configValue.Load().(*Config)
+	return value
 }
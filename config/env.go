@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//	环境变量的前缀,如STOCKRECORDER_CRAWL_CONCURRENCY
+const envPrefix = "STOCKRECORDER_"
+
+//	用环境变量覆盖配置文件中的设置(字段的env标签加上前缀STOCKRECORDER_为环境变量名)
+//	优先级:命令行参数 > 环境变量 > 配置文件 > 默认值,环境变量为空时不覆盖
+//	列表(如Intervals)用逗号分隔
+func applyEnv(value *Config) error {
+
+	v := reflect.ValueOf(value).Elem()
+	t := v.Type()
+	for index := 0; index < t.NumField(); index++ {
+		tag := t.Field(index).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+
+		name := envPrefix + tag
+		text := strings.TrimSpace(os.Getenv(name))
+		if text == "" {
+			continue
+		}
+
+		err := setField(v.Field(index), text)
+		if err != nil {
+			return fmt.Errorf("环境变量 %s 格式错误: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+//	按字段的类型解析环境变量的值
+func setField(field reflect.Value, text string) error {
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Int:
+		value, err := strconv.Atoi(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(value))
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(value)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	case reflect.Slice:
+		items := make([]string, 0)
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持的类型%s", field.Type())
+	}
+
+	return nil
+}
+
+//	是否设置了任何配置的环境变量
+func hasEnv() bool {
+
+	for _, item := range os.Environ() {
+		if strings.HasPrefix(item, envPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
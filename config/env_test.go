@@ -0,0 +1,71 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+
+	t.Setenv("STOCKRECORDER_CRAWL_CONCURRENCY", "8")
+	t.Setenv("STOCKRECORDER_STORAGE_ROOT", "/data")
+	t.Setenv("STOCKRECORDER_INTERVALS", "1m, 5m")
+
+	//	没有配置文件时只用环境变量
+	c, err := loadFile(filepath.Join(os.TempDir(), "stockrecorder-missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Concurrency != 8 || c.DataDir != "/data" || strings.Join(c.Intervals, ",") != "1m,5m" {
+		t.Errorf("应使用环境变量的设置,实际%d,%s,%v", c.Concurrency, c.DataDir, c.Intervals)
+	}
+}
+
+func TestApplyEnvOverridesFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, configFile)
+	err = ioutil.WriteFile(file, []byte(`{"DataDir": "/file", "Concurrency": 16, "HistoryDays": 30}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("STOCKRECORDER_CRAWL_CONCURRENCY", "8")
+
+	//	环境变量优先于配置文件,没有设置的项使用配置文件的值
+	c, err := loadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Concurrency != 8 || c.DataDir != "/file" || c.HistoryDays != 30 {
+		t.Errorf("环境变量应覆盖配置文件,实际%d,%s,%d", c.Concurrency, c.DataDir, c.HistoryDays)
+	}
+}
+
+func TestApplyEnvMalformed(t *testing.T) {
+
+	for name, value := range map[string]string{
+		"STOCKRECORDER_CRAWL_CONCURRENCY": "many",
+		"STOCKRECORDER_PORT":              "8080.5",
+		"STOCKRECORDER_DRY_RUN":           "maybe",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+
+			err := applyEnv(&Config{})
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("格式错误的%s=%s应返回错误,实际%v", name, value, err)
+			}
+		})
+	}
+}
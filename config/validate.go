@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+//	配置检查发现的所有问题
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("配置错误(共%d项):\n\t%s", len(e.Problems), strings.Join(e.Problems, "\n\t"))
+}
+
+//	检查必须的配置项、数值的范围和目录是否可用(应用默认值之后),一次返回所有问题
+func (c *Config) Validate() error {
+
+	v := &validator{}
+
+	//	数据目录
+	if c.DataDir == "" {
+		v.add("没有配置数据目录(DataDir)")
+	} else {
+		v.check(prepareDir("DataDir", c.DataDir))
+	}
+
+	//	原始数据和分时数据的存档目录可以不配置
+	if c.RawDir != "" {
+		v.check(prepareDir("RawDir", c.RawDir))
+	}
+
+	if c.ArchiveDir != "" {
+		v.check(prepareDir("ArchiveDir", c.ArchiveDir))
+	}
+
+	v.between("Port", c.Port, 0, 65535)
+
+	//	为0时使用默认值的配置项不能为负数
+	v.positive("Concurrency", c.Concurrency)
+	v.positive("HistoryDays", c.HistoryDays)
+	v.positive("DownloadRetries", c.DownloadRetries)
+	v.notNegative("DownloadRetryInterval", c.DownloadRetryInterval)
+	v.notNegative("RetryInterval", c.RetryInterval)
+	v.notNegative("RetryMaxAttempts", c.RetryMaxAttempts)
+	v.notNegative("RateLimitCooldown", c.RateLimitCooldown)
+	v.notNegative("HTTPTimeout", c.HTTPTimeout)
+	v.notNegative("CrawlTimeout", c.CrawlTimeout)
+	v.notNegative("MaxConcurrency", c.MaxConcurrency)
+	v.notNegative("WorkerStagger", c.WorkerStagger)
+	v.notNegative("ScheduleJitter", c.ScheduleJitter)
+	v.notNegative("HealthMaxAge", c.HealthMaxAge)
+	v.notNegative("RetentionMonths", c.RetentionMonths)
+	v.notNegative("RetentionDays", c.RetentionDays)
+	v.notNegative("RetentionInterval", c.RetentionInterval)
+	v.notNegative("MaintainInterval", c.MaintainInterval)
+	v.notNegative("ShutdownTimeout", c.ShutdownTimeout)
+	v.notNegative("BackupKeep", c.BackupKeep)
+	v.notNegative("InfluxBatchSize", c.InfluxBatchSize)
+	v.notNegative("EventBuffer", c.EventBuffer)
+	v.notNegative("DelistGraceDays", c.DelistGraceDays)
+	v.notNegative("InactiveAfterDays", c.InactiveAfterDays)
+	v.notNegative("CompanyArchiveVersions", c.CompanyArchiveVersions)
+	v.notNegative("SQLiteBusyTimeout", c.SQLiteBusyTimeout)
+	v.notNegative("SQLiteBusyRetries", c.SQLiteBusyRetries)
+	v.notNegative("SQLiteMaxIdleDBs", c.SQLiteMaxIdleDBs)
+
+	v.ratio("MaxInvalidRatio", c.MaxInvalidRatio)
+	v.ratio("NotifyFailureRate", c.NotifyFailureRate)
+	v.ratio("CompanyListMaxShrink", c.CompanyListMaxShrink)
+	if c.RateLimit < 0 {
+		v.add("每个市场每秒最多请求数(RateLimit)不能为负数,实际为%g", c.RateLimit)
+	}
+
+	if c.VerifyTolerance < 0 {
+		v.add("核对日线允许的相对误差(VerifyTolerance)不能为负数,实际为%g", c.VerifyTolerance)
+	}
+
+	//	保留天数少于抓取的历史天数时,每日任务会重新抓取刚删除的数据
+	if c.RetentionDays > 0 && c.RetentionDays < c.HistoryDays {
+		v.add("保留的天数(RetentionDays)不能少于抓取的历史天数(HistoryDays),实际为%d和%d", c.RetentionDays, c.HistoryDays)
+	}
+
+	for name, delay := range c.ScheduleDelay {
+		v.notNegative("ScheduleDelay["+name+"]", delay)
+	}
+
+	//	按市场覆盖的设置
+	for name, mc := range c.Markets {
+		if mc.Concurrency != nil {
+			v.positive("Markets["+name+"].Concurrency", *mc.Concurrency)
+		}
+
+		if mc.HistoryDays != nil {
+			v.positive("Markets["+name+"].HistoryDays", *mc.HistoryDays)
+			if c.RetentionDays > 0 && c.RetentionDays < *mc.HistoryDays {
+				v.add("保留的天数(RetentionDays)不能少于Markets[%s].HistoryDays,实际为%d和%d", name, c.RetentionDays, *mc.HistoryDays)
+			}
+		}
+
+		if mc.DownloadRetries != nil {
+			v.positive("Markets["+name+"].DownloadRetries", *mc.DownloadRetries)
+		}
+
+		if mc.DownloadRetryInterval != nil {
+			v.notNegative("Markets["+name+"].DownloadRetryInterval", *mc.DownloadRetryInterval)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{v.problems}
+	}
+
+	return nil
+}
+
+//	收集配置检查发现的问题
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) check(err error) {
+	if err != nil {
+		v.add("%s", err.Error())
+	}
+}
+
+func (v *validator) positive(name string, value int) {
+	if value < 1 {
+		v.add("%s必须大于0,实际为%d", name, value)
+	}
+}
+
+func (v *validator) notNegative(name string, value int) {
+	if value < 0 {
+		v.add("%s不能为负数,实际为%d", name, value)
+	}
+}
+
+func (v *validator) between(name string, value, min, max int) {
+	if value < min || value > max {
+		v.add("%s必须在%d到%d之间,实际为%d", name, min, max, value)
+	}
+}
+
+func (v *validator) ratio(name string, value float64) {
+	if value < 0 || value > 1 {
+		v.add("%s必须在0到1之间,实际为%g", name, value)
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = Set(&Config{DataDir: dir, Concurrency: 8})
+	if err != nil {
+		t.Fatalf("只配置数据目录时应该通过检查:%v", err)
+	}
+	previous := Get()
+
+	concurrency := 0
+	c := &Config{DataDir: dir, Port: 70000, HistoryDays: -1, MaxInvalidRatio: 2, Markets: map[string]MarketConfig{"America": {Concurrency: &concurrency}}}
+
+	//	一次返回所有问题
+	err = Set(c)
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("应返回*ValidationError,实际%v", err)
+	}
+
+	for _, name := range []string{"Port", "HistoryDays", "MaxInvalidRatio", "Markets[America].Concurrency"} {
+		if !strings.Contains(ve.Error(), name) {
+			t.Errorf("应该报告%s的问题:%s", name, ve.Error())
+		}
+	}
+
+	if len(ve.Problems) != 4 {
+		t.Errorf("应有4个问题,实际%d个:%v", len(ve.Problems), ve.Problems)
+	}
+
+	//	有错误的配置不替换当前配置
+	if Get() != previous || Get().Concurrency != 8 {
+		t.Errorf("检查失败时应保留当前配置,实际%+v", Get())
+	}
+}
+
+func TestValidateRetentionDays(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//	默认抓取90天的历史数据
+	err = Set(&Config{DataDir: dir, RetentionDays: 30})
+	if err == nil || !strings.Contains(err.Error(), "RetentionDays") {
+		t.Errorf("保留的天数少于抓取的历史天数时应该报错,实际%v", err)
+	}
+
+	err = Set(&Config{DataDir: dir, RetentionDays: 90})
+	if err != nil {
+		t.Errorf("保留的天数等于抓取的历史天数时应该通过检查:%v", err)
+	}
+
+	//	按市场覆盖的历史天数同样检查
+	historyDays := 120
+	err = Set(&Config{DataDir: dir, RetentionDays: 90, Markets: map[string]MarketConfig{"America": {HistoryDays: &historyDays}}})
+	if err == nil || !strings.Contains(err.Error(), "Markets[America].HistoryDays") {
+		t.Errorf("保留的天数少于市场的历史天数时应该报错,实际%v", err)
+	}
+}
+
+func TestValidateNegative(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//	只有0使用默认值,负数应该报错
+	err = Set(&Config{DataDir: dir, HealthMaxAge: -1, ShutdownTimeout: -1, BackupKeep: -1, InfluxBatchSize: -1, EventBuffer: -1, RetryInterval: -1, RateLimit: -1, SQLiteMaxIdleDBs: -1})
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("应返回*ValidationError,实际%v", err)
+	}
+
+	for _, name := range []string{"HealthMaxAge", "ShutdownTimeout", "BackupKeep", "InfluxBatchSize", "EventBuffer", "RetryInterval", "RateLimit", "SQLiteMaxIdleDBs"} {
+		if !strings.Contains(ve.Error(), name) {
+			t.Errorf("应该报告%s的问题:%s", name, ve.Error())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	导出上市公司某日的分时数据到标准输出
+//	用法: stockrecorder export --market America --company AAPL [--day 20240105] [--format csv|json]
+func export(args []string) error {
+
+	flags := newFlagSet("export", "--company AAPL [--market America] [--day 20240105] [--format csv]")
+	marketName := flags.String("market", "America", "市场(America, China, HongKong, Japan)")
+	company := flags.String("company", "", "上市公司代码")
+	day := flags.String("day", "", "日期(20060102),默认为昨天")
+	format := flags.String("format", "csv", "导出格式(csv或json)")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *company == "" || (*format != "csv" && *format != "json") {
+		flags.Usage()
+		return errUsage
+	}
+
+	err := cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	date, err := parseDay(*day, yesterday())
+	if err != nil {
+		return err
+	}
+
+	m, err := market.GetMarket(*marketName)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return market.ExportJSON(os.Stdout, m, *company, date)
+	}
+
+	return market.ExportCSV(os.Stdout, m, *company, date)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	抓取单个上市公司某日的分时数据并输出保存的结果
+//	用法: stockrecorder fetch --market America --company AAPL --day 2015-08-26 [--dry-run]
+func fetch(args []string) error {
+
+	flags := newFlagSet("fetch", "--market America --company AAPL --day 2015-08-26 [--dry-run]")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	company := flags.String("company", "", "上市公司代码")
+	day := flags.String("day", "", "日期(2006-01-02)")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *marketName == "" || *company == "" || *day == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	date, err := parseDay(*day, yesterday())
+	if err != nil {
+		return err
+	}
+
+	err = cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	return market.FetchCompanyDay(os.Stdout, *marketName, *company, date)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	有缺失的交易日
+var errGaps = errors.New("有缺失的交易日")
+
+//	检查指定日期范围内没有处理过的交易日,有缺失时返回errGaps
+//	用法: stockrecorder gaps --market America [--company AAPL] [--from 20240101] [--to 20240201]
+func gaps(args []string) error {
+
+	flags := newFlagSet("gaps", "--market America [--company AAPL] [--from 20240101] [--to 20240201]")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	company := flags.String("company", "", "上市公司代码,默认检查存档中的所有上市公司")
+	from := flags.String("from", "", "起始日期(20060102),默认为结束日期前30天")
+	to := flags.String("to", "", "结束日期(20060102),默认为昨天")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *marketName == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	err := cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	end, err := parseDay(*to, yesterday())
+	if err != nil {
+		return err
+	}
+
+	start, err := parseDay(*from, end.AddDate(0, 0, -30))
+	if err != nil {
+		return err
+	}
+
+	//	单个上市公司时输出缺失的日期
+	if *company != "" {
+		days, err := market.FindGaps(*marketName, *company, start, end)
+		if err != nil {
+			return err
+		}
+
+		for _, day := range days {
+			fmt.Fprintln(os.Stdout, day.Format("20060102"))
+		}
+
+		if len(days) > 0 {
+			return errGaps
+		}
+
+		return nil
+	}
+
+	report, err := market.CoverageReport(*marketName, start, end)
+	if err != nil {
+		return err
+	}
+
+	missing := 0
+	for _, coverage := range report {
+		if len(coverage.Gaps) == 0 {
+			continue
+		}
+
+		missing++
+		fmt.Fprintf(os.Stdout, "%s\t%.2f%%\t缺失%d天\n", coverage.Company, coverage.Percent(), len(coverage.Gaps))
+	}
+
+	if missing > 0 {
+		return errGaps
+	}
+
+	return nil
+}
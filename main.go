@@ -1,45 +1,104 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/nzai/stockrecorder/config"
 	"github.com/nzai/stockrecorder/market"
-	"github.com/nzai/stockrecorder/server"
 )
 
 func main() {
 
 	defer func() {
 		// 捕获panic异常
-		log.Print("发生了致命错误")
 		if err := recover(); err != nil {
+			log.Print("发生了致命错误")
 			log.Print("致命错误:", err)
+			os.Exit(1)
 		}
 	}()
 
+	//	没有指定命令时监视市场
+	name, args := commands[0].name, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	//	stockrecorder help或者stockrecorder --help输出所有命令
+	if name == "help" || len(os.Args) == 2 && (os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "--help") {
+		usage()
+		return
+	}
+
+	c, found := findCommand(name)
+	if !found {
+		log.Printf("未知的命令: %s", name)
+		usage()
+		os.Exit(2)
+	}
+
+	err := c.run(args)
+	market.CloseDatabases()
+	market.Unlock()
+
+	if err == errUsage {
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+//	读取配置文件并加入所有市场(overrides用命令行参数覆盖配置文件)
+func initialize(overrides func(c *config.Config)) error {
+
 	//	读取配置文件
-	err := config.Init()
+	value, err := config.Load()
 	if err != nil {
-		log.Fatal("读取配置文件错误: ", err)
-		return
+		return fmt.Errorf("读取配置文件错误: %s", err.Error())
 	}
 
-	log.Print("启动市场监视任务")
+	//	命令行参数覆盖后再应用默认值并检查(一次输出所有问题),数据目录不存在就创建
+	overrides(value)
+	err = config.Set(value)
+	if err != nil {
+		return err
+	}
+
+	//	使用PostgreSQL集中存储
+	if config.Get().PostgresDSN != "" {
+		store, err := market.NewPostgresStore(config.Get().PostgresDSN)
+		if err != nil {
+			return fmt.Errorf("连接PostgreSQL错误: %s", err.Error())
+		}
+
+		market.SetStore(store)
+	}
 
 	//	美国股市
-	market.Add(market.America{})
+	market.Add(withSource(market.America{}))
 	//	中国股市
-	market.Add(market.China{})
+	market.Add(withSource(market.China{}))
 	//	香港股市
-	market.Add(market.HongKong{})
+	market.Add(withSource(market.HongKong{}))
+	//	日本股市
+	market.Add(withSource(market.Japan{}))
 
-	//	启动监视
-	err = market.Monitor()
-	if err != nil {
-		log.Printf("启动市场监视任务时发生错误: %s", err.Error())
+	//	检查市场的时区和设置,避免在抓取时才出错
+	return market.VerifyConfig()
+}
+
+//	按配置的数据源(Source)包装市场
+func withSource(m market.Market) market.Market {
+
+	if config.Get().Markets[m.Name()].Source == "stooq" {
+		return market.NewStooq(m)
 	}
 
-	//	启动http server
-	server.Start()
+	return m
 }
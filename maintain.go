@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	回收市场所有上市公司的数据库文件的空间并更新查询统计
+//	用法: stockrecorder maintain --market America
+func maintain(args []string) error {
+
+	flags := newFlagSet("maintain", "--market America")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *marketName == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	err := cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	m, err := market.GetMarket(*marketName)
+	if err != nil {
+		return err
+	}
+
+	return market.Maintain(m)
+}
@@ -0,0 +1,128 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+//	上市公司的抓取情况(连续失败超过InactiveAfterDays个交易日后不再抓取)
+type CompanyActivity struct {
+	Market string
+	Code   string
+	//	每日任务连续失败的天数
+	Failures int
+	//	最后一次失败的日期
+	LastFailed time.Time
+	//	标记为不活跃的日期(为零值时是活跃的)
+	Inactive time.Time
+}
+
+//	是否已标记为不活跃
+func (a CompanyActivity) IsInactive() bool {
+	return !a.Inactive.IsZero()
+}
+
+//	被标记为不活跃(不再抓取)的上市公司
+func ListInactive(marketName string) ([]CompanyActivity, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Activity]\t未能找到市场%s", marketName)
+	}
+
+	return store.InactiveCompanies(market)
+}
+
+//	重新抓取被标记为不活跃的上市公司(运行期间锁定数据目录)
+func ReactivateCompany(marketName, code string) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return fmt.Errorf("[Activity]\t未能找到市场%s", marketName)
+	}
+
+	return store.SaveActivity(market, CompanyActivity{Market: market.Name(), Code: code})
+}
+
+//	去掉被标记为不活跃的上市公司(保留历史数据,只是不再抓取)
+func activeCompanies(market Market, companies []Company) []Company {
+
+	inactive, err := store.InactiveCompanies(market)
+	if err != nil {
+		logger.Warn("读取不活跃的上市公司出错,全部抓取", "market", market.Name(), "error", err)
+		return companies
+	}
+
+	if len(inactive) == 0 {
+		return companies
+	}
+
+	skip := make(map[string]bool, len(inactive))
+	for _, activity := range inactive {
+		skip[activity.Code] = true
+	}
+
+	active := make([]Company, 0, len(companies))
+	for _, company := range companies {
+		if !skip[company.Code] {
+			active = append(active, company)
+		}
+	}
+
+	if len(active) < len(companies) {
+		logger.Info("已跳过不活跃的上市公司", "market", market.Name(), "skipped", len(companies)-len(active))
+	}
+
+	return active
+}
+
+//	读取上市公司的抓取情况
+func scanActivities(market Market, rows *sql.Rows) ([]CompanyActivity, error) {
+
+	location := locationYesterdayZero(market).Location()
+
+	activities := make([]CompanyActivity, 0)
+	for rows.Next() {
+		activity := CompanyActivity{Market: market.Name()}
+		var lastFailed, inactive string
+		err := rows.Scan(&activity.Code, &activity.Failures, &lastFailed, &inactive)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastFailed != "" {
+			activity.LastFailed, err = time.ParseInLocation("20060102", lastFailed, location)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if inactive != "" {
+			activity.Inactive, err = time.ParseInLocation("20060102", inactive, location)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		activities = append(activities, activity)
+	}
+
+	return activities, rows.Err()
+}
+
+//	日期格式化为20060102(零值为空)
+func formatDay(day time.Time) string {
+
+	if day.IsZero() {
+		return ""
+	}
+
+	return day.Format("20060102")
+}
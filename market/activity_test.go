@@ -0,0 +1,90 @@
+package market
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	某家上市公司每天都抓取失败的市场
+type delistedMarket struct {
+	mockMarket
+	delisted string
+}
+
+func (m delistedMarket) Crawl(code string, day time.Time) (string, error) {
+	if code == m.delisted {
+		return "", fmt.Errorf("%s已退市", code)
+	}
+
+	return m.mockMarket.Crawl(code, day)
+}
+
+func TestInactiveCompany(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockInactive", "AAA", "BAD")
+	defer cleanup()
+	market := delistedMarket{mockMarket: mock, delisted: "BAD"}
+	Add(market)
+	defer Remove(market.Name())
+
+	defer func(days int) { config.Get().InactiveAfterDays = days }(config.Get().InactiveAfterDays)
+	config.Get().InactiveAfterDays = 3
+
+	day := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+	for index := 0; index < 3; index++ {
+		_, err := dailyTaskDay(market, day.AddDate(0, 0, index))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//	同一天重复失败只算一次
+		updateActivity(market, Company{Market: market.Name(), Code: "BAD"}, day.AddDate(0, 0, index), false)
+	}
+
+	inactive, err := ListInactive(market.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inactive) != 1 || inactive[0].Code != "BAD" || inactive[0].Failures != 3 || !inactive[0].Inactive.Equal(day.AddDate(0, 0, 2)) {
+		t.Fatalf("连续失败3天后BAD应被标记为不活跃,实际%+v", inactive)
+	}
+
+	//	不活跃的上市公司不再抓取
+	h := &countingHook{}
+	SetHook(h)
+	defer SetHook(nil)
+
+	_, err = dailyTaskDay(market, day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.started != 1 || h.failed != 0 {
+		t.Errorf("应只抓取1家且没有失败,实际抓取%d家失败%d家", h.started, h.failed)
+	}
+
+	//	重新抓取成功后恢复
+	updateActivity(market, Company{Market: market.Name(), Code: "BAD"}, day.AddDate(0, 0, 4), true)
+
+	inactive, err = ListInactive(market.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inactive) != 0 {
+		t.Errorf("抓取成功后应恢复为活跃,实际%+v", inactive)
+	}
+
+	activity, err := store.LoadActivity(market, "BAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if activity.Failures != 0 || activity.IsInactive() {
+		t.Errorf("抓取成功后失败次数应清零,实际%+v", activity)
+	}
+}
@@ -0,0 +1,98 @@
+package market
+
+import (
+	"fmt"
+	"time"
+)
+
+//	指定日期范围内的复权日线(以最新价格为基准向前复权,每次计算时按保存的分红和拆股重新计算)
+func AdjustedDaily(marketName, companyCode string, from, to time.Time) ([]Bar, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Adjust]\t未能找到市场%s", marketName)
+	}
+
+	//	按市场所在时区取整到0点
+	yesterday := locationYesterdayZero(market)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, yesterday.Location())
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, yesterday.Location())
+
+	bars := make([]Bar, 0)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		bar, err := DailyBar(market, companyCode, day)
+		if err == ErrNotProcessed || err == ErrNoData {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		bars = append(bars, bar)
+	}
+
+	//	范围之后的分红和拆股也会影响之前的价格
+	actions, err := GetCorporateActions(marketName, companyCode, from, yesterday)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustBars(bars, actions)
+
+	return bars, nil
+}
+
+//	向前复权:除权日之前的价格按拆股比例和分红比例调整(先处理拆股,分红金额按拆股后的价格计算)
+func adjustBars(bars []Bar, actions []CorporateAction) {
+
+	for _, a := range actions {
+		if a.Type != ActionSplit || a.Numerator <= 0 || a.Denominator <= 0 {
+			continue
+		}
+
+		ratio := a.Numerator / a.Denominator
+		for index := range bars {
+			if !isBeforeDay(bars[index].Day, a.Time) {
+				continue
+			}
+
+			bars[index].Open /= ratio
+			bars[index].High /= ratio
+			bars[index].Low /= ratio
+			bars[index].Close /= ratio
+			bars[index].Volume = int64(float32(bars[index].Volume) * ratio)
+		}
+	}
+
+	for _, a := range actions {
+		if a.Type != ActionDividend || a.Amount <= 0 {
+			continue
+		}
+
+		//	除权日前一个交易日的收盘价
+		last := -1
+		for index := range bars {
+			if isBeforeDay(bars[index].Day, a.Time) {
+				last = index
+			}
+		}
+
+		if last < 0 || bars[last].Close <= a.Amount {
+			continue
+		}
+
+		factor := 1 - a.Amount/bars[last].Close
+		for index := 0; index <= last; index++ {
+			bars[index].Open *= factor
+			bars[index].High *= factor
+			bars[index].Low *= factor
+			bars[index].Close *= factor
+		}
+	}
+}
+
+//	day是否在除权日之前(按年月日比较)
+func isBeforeDay(day, exDate time.Time) bool {
+	return day.Format("20060102") < exDate.Format("20060102")
+}
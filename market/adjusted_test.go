@@ -0,0 +1,62 @@
+package market
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAdjustedDaily(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockAdjust", "AAPL")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	//	第2天4:1拆股,第3天每股分红1
+	day1 := time.Date(2020, 8, 28, 0, 0, 0, 0, time.Local)
+	day2, day3 := day1.AddDate(0, 0, 3), day1.AddDate(0, 0, 4)
+	point := func(day time.Time, price float32, volume int64) Peroid60 {
+		return Peroid60{Time: day.Add(time.Hour * 10), Open: price, High: price, Low: price, Close: price, Volume: volume}
+	}
+
+	results := map[time.Time]*ParseResult{
+		day1: {Success: true, Regular: []Peroid60{point(day1, 400, 100)}},
+		day2: {Success: true, Regular: []Peroid60{point(day2, 100, 400)}, Splits: []Split{{Time: day2, Numerator: 4, Denominator: 1, Ratio: "4:1"}}},
+		day3: {Success: true, Regular: []Peroid60{point(day3, 100, 400)}, Dividends: []Dividend{{Time: day3, Amount: 1}}}}
+
+	for day, result := range results {
+		tx, err := store.Begin(market, "AAPL")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, result)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bars, err := AdjustedDaily(market.Name(), "AAPL", day1, day3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bars) != 3 {
+		t.Fatalf("应有3条日线,实际%d条", len(bars))
+	}
+
+	expected := []struct {
+		Close  float64
+		Volume int64
+	}{{99, 400}, {99, 400}, {100, 400}}
+
+	for index, e := range expected {
+		if math.Abs(float64(bars[index].Close)-e.Close) > 0.001 || bars[index].Volume != e.Volume {
+			t.Errorf("第%d条复权日线不正确:%+v", index+1, bars[index])
+		}
+	}
+}
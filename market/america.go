@@ -1,13 +1,12 @@
 package market
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/nzai/go-utility/net"
 )
 
 //	美股市场
@@ -23,26 +22,28 @@ func (m America) Timezone() string {
 	return "America/New_York"
 }
 
+//	收盘时间(盘后交易20点结束)
+func (m America) ClosingTime() (int, int) {
+	return 20, 0
+}
+
 //	更新上市公司列表
 func (m America) Companies() ([]Company, error) {
 
-	urls := [...]string{
-		"http://www.nasdaq.com/screening/companies-by-industry.aspx?exchange=NASDAQ&render=download",
-		"http://www.nasdaq.com/screening/companies-by-industry.aspx?exchange=NYSE&render=download",
-		"http://www.nasdaq.com/screening/companies-by-industry.aspx?exchange=AMEX&render=download",
-	}
+	exchanges := [...]string{"NASDAQ", "NYSE", "AMEX"}
 
 	list := make([]Company, 0)
-	for _, url := range urls {
+	for _, exchange := range exchanges {
+		url := fmt.Sprintf("http://www.nasdaq.com/screening/companies-by-industry.aspx?exchange=%s&render=download", exchange)
 
 		//	尝试从网络获取实时上市公司列表
-		csv, err := net.DownloadStringRetry(url, retryTimes, retryIntervalSeconds)
+		csv, err := downloadString(m.Name(), url, "")
 		if err != nil {
 			return nil, err
 		}
 
 		//	解析CSV
-		companies, err := m.parseCSV(csv)
+		companies, err := m.parseCSV(csv, exchange)
 		if err != nil {
 			return nil, err
 		}
@@ -56,8 +57,8 @@ func (m America) Companies() ([]Company, error) {
 	return list, nil
 }
 
-//	解析CSV
-func (m America) parseCSV(content string) ([]Company, error) {
+//	解析CSV(Symbol, Name, LastSale, MarketCap, IPOyear, Sector, industry, ...)
+func (m America) parseCSV(content, exchange string) ([]Company, error) {
 
 	reader := csv.NewReader(strings.NewReader(content))
 	records, err := reader.ReadAll()
@@ -82,15 +83,124 @@ func (m America) parseCSV(content string) ([]Company, error) {
 		}
 		dict[parts[0]] = true
 
-		companies = append(companies, Company{Market: m.Name(),
-			Code: strings.Trim(parts[0], " "),
-			Name: strings.Trim(parts[1], " ")})
+		company := Company{Market: m.Name(),
+			Code:     strings.Trim(parts[0], " "),
+			Name:     strings.Trim(parts[1], " "),
+			Exchange: exchange,
+			Currency: "USD"}
+
+		//	行业
+		if len(parts) > 6 {
+			company.Sector = americanField(parts[5])
+			company.Industry = americanField(parts[6])
+		}
+
+		companies = append(companies, company)
 	}
 
 	return companies, nil
 }
 
+//	美股上市公司CSV中的字段(n/a表示没有)
+func americanField(value string) string {
+
+	value = strings.Trim(value, " ")
+	if value == "n/a" {
+		return ""
+	}
+
+	return value
+}
+
 //	抓取
 func (m America) Crawl(code string, day time.Time) (string, error) {
-	return downloadCompanyDaily(m, code, code, day)
+	return m.CrawlInterval(code, day, Interval1m)
+}
+
+//	按指定间隔抓取
+func (m America) CrawlInterval(code string, day time.Time, interval Interval) (string, error) {
+	return m.CrawlContext(context.Background(), code, day, interval)
+}
+
+//	按指定间隔抓取,ctx取消或超时时中止
+//	code为雅虎财经的代码(抓取任务已经按NormalizeSymbol转换,如BRK-B),这里只检查不再转换
+func (m America) CrawlContext(ctx context.Context, code string, day time.Time, interval Interval) (string, error) {
+
+	err := checkAmericaSymbol(code)
+	if err != nil {
+		return "", err
+	}
+
+	return downloadCompanyDaily(ctx, m, code, code, day, interval)
+}
+
+//	检查美股代码(指数如^GSPC以外不能包含^和空格)
+func checkAmericaSymbol(code string) error {
+
+	if _, ok := indexSymbol(code); ok {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" || strings.ContainsAny(code, "^ ") {
+		return fmt.Errorf("错误的美股上市公司代码:%s", code)
+	}
+
+	return nil
+}
+
+//	上市公司列表的代码对应的雅虎财经美股代码(不需要后缀,分类股的分隔符为-,如BRK.B和BRK/B为BRK-B,指数如^GSPC不变)
+//	用于不经过抓取任务转换的数据源(如Stooq)
+func americaSymbol(code string) (string, error) {
+
+	err := checkAmericaSymbol(code)
+	if err != nil {
+		return "", err
+	}
+
+	return America{}.NormalizeSymbol(code), nil
+}
+
+//	上市公司列表与雅虎财经不同的美股代码后缀(分类股的分隔符以外的特殊情况)
+var americaSuffixes = [][2]string{
+	//	认股权证
+	{".WS", "-WT"},
+	//	单位(SPAC)
+	{".U", "-UN"},
+}
+
+//	上市公司列表的代码转换为雅虎财经的代码(BRK.B为BRK-B,ACAH.WS为ACAH-WT)
+func (m America) NormalizeSymbol(listingCode string) string {
+
+	code := strings.ToUpper(strings.TrimSpace(listingCode))
+	if _, ok := indexSymbol(code); ok {
+		return code
+	}
+
+	for _, suffix := range americaSuffixes {
+		if strings.HasSuffix(code, suffix[0]) {
+			code = strings.TrimSuffix(code, suffix[0]) + suffix[1]
+			break
+		}
+	}
+
+	return strings.NewReplacer(".", "-", "/", "-").Replace(code)
+}
+
+//	雅虎财经的代码转换回上市公司列表的代码(BRK-B为BRK.B,ACAH-WT为ACAH.WS)
+func (m America) ListingSymbol(crawlCode string) string {
+
+	code := strings.ToUpper(strings.TrimSpace(crawlCode))
+	if _, ok := indexSymbol(code); ok {
+		return code
+	}
+
+	for _, suffix := range americaSuffixes {
+		if strings.HasSuffix(code, suffix[1]) {
+			code = strings.TrimSuffix(code, suffix[1]) + suffix[0]
+			break
+		}
+	}
+
+	return strings.Replace(code, "-", ".", -1)
 }
@@ -0,0 +1,66 @@
+package market
+
+import "testing"
+
+func TestAmericaSymbol(t *testing.T) {
+
+	cases := map[string]string{
+		"AAPL":  "AAPL",
+		"msft":  "MSFT",
+		"BRK.B": "BRK-B",
+		"BF/A":  "BF-A",
+		//	指数不变
+		"^gspc": "^GSPC",
+	}
+
+	for code, expected := range cases {
+		symbol, err := americaSymbol(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if symbol != expected {
+			t.Errorf("%s的雅虎代码应为%s,实际%s", code, expected, symbol)
+		}
+	}
+
+	for _, code := range []string{"", "^", "A B", "^A B"} {
+		_, err := americaSymbol(code)
+		if err == nil {
+			t.Errorf("错误的代码%s应该返回错误", code)
+		}
+	}
+}
+
+func TestAmericaSymbolRoundTrip(t *testing.T) {
+
+	//	上市公司列表的代码 -> 雅虎财经的代码
+	cases := map[string]string{
+		"AAPL":    "AAPL",
+		"BRK.B":   "BRK-B",
+		"BF.A":    "BF-A",
+		"ACAH.WS": "ACAH-WT",
+		"ACAH.U":  "ACAH-UN",
+		"^GSPC":   "^GSPC",
+	}
+
+	m := America{}
+	for listing, crawl := range cases {
+		if symbol := m.NormalizeSymbol(listing); symbol != crawl {
+			t.Errorf("%s的雅虎代码应为%s,实际%s", listing, crawl, symbol)
+		}
+
+		if symbol := m.ListingSymbol(crawl); symbol != listing {
+			t.Errorf("雅虎代码%s应转换回%s,实际%s", crawl, listing, symbol)
+		}
+
+		//	双向转换后不变
+		if symbol := m.ListingSymbol(m.NormalizeSymbol(listing)); symbol != listing {
+			t.Errorf("%s转换后再转换回来应不变,实际%s", listing, symbol)
+		}
+
+		if symbol := m.NormalizeSymbol(m.ListingSymbol(crawl)); symbol != crawl {
+			t.Errorf("雅虎代码%s转换后再转换回来应不变,实际%s", crawl, symbol)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package market
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//	补抓统计
+type backfillCount struct {
+	Crawled int
+	Skipped int
+	Failed  int
+}
+
+//	补抓指定日期范围内的历史分时数据(companies为空时补抓所有上市公司),运行期间锁定数据目录
+func Backfill(marketName string, from, to time.Time, companies []string) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return fmt.Errorf("[Backfill]\t未能找到市场%s", marketName)
+	}
+
+	//	按市场所在时区取整到0点
+	yesterday := locationYesterdayZero(market)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, yesterday.Location())
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, yesterday.Location())
+
+	if to.After(yesterday) {
+		to = yesterday
+	}
+
+	if from.After(to) {
+		return fmt.Errorf("[%s]\t补抓的起始日期%s晚于结束日期%s", market.Name(), from.Format("20060102"), to.Format("20060102"))
+	}
+
+	//	数据源不支持的日期直接拒绝,避免每天都写入错误信息
+	historyDays := getSettings(market.Name()).historyDays
+	earliest := yesterday.AddDate(0, 0, 1-historyDays)
+	if from.Before(earliest) {
+		return fmt.Errorf("[%s]\t雅虎财经的历史分时数据没有超过%d天的,补抓的起始日期不能早于%s", market.Name(), historyDays, earliest.Format("20060102"))
+	}
+
+	list, err := getCompanies(market)
+	if err != nil {
+		return err
+	}
+
+	//	只补抓指定的上市公司
+	if len(companies) > 0 {
+		list = filterCompanies(market, list, companies)
+	}
+
+	logger.Info("开始补抓历史分时数据", "market", market.Name(), "companies", len(list), "from", from.Format("20060102"), "to", to.Format("20060102"))
+	startTime := time.Now()
+
+	chanSend := make(chan int, getSettings(market.Name()).concurrency)
+	defer close(chanSend)
+
+	chanCount := make(chan backfillCount, len(list))
+
+	var wg sync.WaitGroup
+	wg.Add(len(list))
+
+	for index, c := range list {
+		//	正在关闭时不再开始新的抓取
+		if isShuttingDown() {
+			wg.Add(index - len(list))
+			break
+		}
+
+		//	同时抓取的上市公司数不超过Concurrency
+		chanSend <- 1
+
+		//	并发抓取
+		go func(company Company) {
+			//	所有市场共用的抓取名额
+			release := acquireGlobalSlot()
+			chanCount <- backfillCompany(market, company, from, to)
+			release()
+
+			<-chanSend
+			wg.Done()
+		}(c)
+	}
+
+	//	阻塞，直到抓取所有
+	wg.Wait()
+	close(chanCount)
+
+	total := backfillCount{}
+	for count := range chanCount {
+		total.Crawled += count.Crawled
+		total.Skipped += count.Skipped
+		total.Failed += count.Failed
+	}
+
+	logger.Info("补抓历史分时数据已结束", "market", market.Name(), "crawled", total.Crawled, "skipped", total.Skipped, "failed", total.Failed, "duration", time.Since(startTime))
+
+	if total.Failed > 0 {
+		return fmt.Errorf("[%s]\t补抓历史分时数据时有%d天失败", market.Name(), total.Failed)
+	}
+
+	return nil
+}
+
+//	按代码筛选上市公司
+func filterCompanies(market Market, list []Company, codes []string) []Company {
+
+	dict := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		dict[code] = true
+	}
+
+	filtered := make([]Company, 0, len(codes))
+	for _, company := range list {
+		if dict[company.Code] {
+			filtered = append(filtered, company)
+			delete(dict, company.Code)
+		}
+	}
+
+	for code := range dict {
+		logger.Warn("上市公司不在列表中,已忽略", "market", market.Name(), "company", code)
+	}
+
+	return filtered
+}
+
+//	补抓单个上市公司,每天一个事务
+func backfillCompany(market Market, company Company, from, to time.Time) backfillCount {
+
+	count := backfillCount{}
+
+	for day := to; !day.Before(from) && !isShuttingDown(); day = day.AddDate(0, 0, -1) {
+
+		tx, err := beginTx(market, company.Code, Interval1m)
+		if err != nil {
+			logger.Error("启动事务时出错", "market", market.Name(), "company", company.Code, "error", err)
+			count.Failed++
+			continue
+		}
+
+		//	已经处理过的跳过
+		processed, err := tx.IsProcessed(day)
+		if err == nil && processed {
+			tx.Rollback()
+			count.Skipped++
+			continue
+		}
+
+		if err == nil {
+			_, err = companyDayTask(tx, market, company, day)
+		}
+
+		if err != nil {
+			logger.Error("抓取分时数据出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "error", err)
+			tx.Rollback()
+			count.Failed++
+			continue
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			logger.Error("提交事务时出错", "market", market.Name(), "company", company.Code, "error", err)
+			count.Failed++
+			continue
+		}
+
+		count.Crawled++
+	}
+
+	return count
+}
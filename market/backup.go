@@ -0,0 +1,331 @@
+package market
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	超过这个大小的备份分段上传(S3要求除最后一段外每段至少5MB)
+var backupPartSize int64 = 8 << 20
+
+//	根据配置创建备份用的对象存储客户端(没有配置时返回nil)
+func backupClient() (*s3Client, error) {
+
+	c := config.Get()
+	if c.BackupEndpoint == "" {
+		return nil, nil
+	}
+
+	return newS3Client(c.BackupEndpoint, c.BackupBucket, c.BackupRegion, c.BackupAccessKey, c.BackupSecretKey)
+}
+
+//	每日任务结束后备份市场的数据库文件(没有配置对象存储或者不是sqlite存储时忽略)
+func backupAfterDailyTask(market Market, day time.Time) {
+
+	client, err := backupClient()
+	if err != nil {
+		logger.Error("创建备份用的对象存储客户端时出错", "market", market.Name(), "error", err)
+		return
+	}
+
+	if client == nil {
+		return
+	}
+
+	if _, ok := store.(sqliteStore); !ok {
+		logger.Warn("只能备份sqlite数据库,已跳过备份", "market", market.Name())
+		return
+	}
+
+	uploaded, err := backupMarket(client, market, day)
+	if err != nil {
+		logger.Error("备份数据库时出错", "market", market.Name(), "day", day.Format("20060102"), "error", err)
+		return
+	}
+
+	logger.Info("备份数据库-完成", "market", market.Name(), "day", day.Format("20060102"), "uploaded", uploaded)
+}
+
+//	备份市场目录下所有的数据库文件(跳过没有变化的),返回上传的文件数
+func backupMarket(client *s3Client, market Market, day time.Time) (int, error) {
+
+	files, err := marketDBFiles(market)
+	if err != nil {
+		return 0, err
+	}
+
+	uploaded := 0
+	for _, file := range files {
+		changed, err := backupFile(client, market, file, day)
+		if err != nil {
+			return uploaded, err
+		}
+
+		if changed {
+			uploaded++
+		}
+	}
+
+	return uploaded, nil
+}
+
+//	市场目录下所有数据库文件相对于市场目录的路径(包括市场数据库和1m以外间隔的子目录)
+func marketDBFiles(market Market) ([]string, error) {
+
+	root := marketDir(market)
+
+	files := make([]string, 0)
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			//	市场目录还不存在
+			if os.IsNotExist(err) && filePath == root {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(filePath) != ".db" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+
+	return files, err
+}
+
+//	备份的键的前缀(市场/上市公司/,间隔子目录中的为市场/间隔/上市公司/)
+func backupPrefix(market Market, file string) string {
+	return market.Name() + "/" + strings.TrimSuffix(file, ".db") + "/"
+}
+
+//	备份一个数据库文件(与最近一次备份相同时跳过),返回是否上传
+func backupFile(client *s3Client, market Market, file string, day time.Time) (bool, error) {
+
+	//	生成一致的快照,避免上传写了一半的文件
+	snapshot, err := snapshotDB(filepath.Join(marketDir(market), filepath.FromSlash(file)))
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(snapshot)
+
+	checksum, err := fileChecksum(snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	prefix := backupPrefix(market, file)
+	keys, err := client.list(prefix)
+	if err != nil {
+		return false, err
+	}
+
+	//	与最近一次备份比较
+	if len(keys) > 0 {
+		meta, err := client.head(keys[len(keys)-1])
+		if err != nil {
+			return false, err
+		}
+
+		if meta["sha256"] == checksum {
+			return false, nil
+		}
+	}
+
+	key := prefix + day.Format("20060102") + ".db"
+	err = uploadFile(client, key, snapshot, map[string]string{"sha256": checksum})
+	if err != nil {
+		return false, err
+	}
+
+	if len(keys) == 0 || keys[len(keys)-1] != key {
+		keys = append(keys, key)
+	}
+
+	//	只保留最近的BackupKeep个备份
+	for len(keys) > config.Get().BackupKeep {
+		err = client.delete(keys[0])
+		if err != nil {
+			return true, err
+		}
+
+		keys = keys[1:]
+	}
+
+	return true, nil
+}
+
+//	用VACUUM INTO生成数据库的快照,返回快照文件路径
+func snapshotDB(filePath string) (string, error) {
+
+	migrations := companyMigrations
+	if filepath.Base(filePath) == marketDBFileName {
+		migrations = marketMigrations
+	}
+
+	db, err := openCachedDB(filePath, migrations)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	//	快照文件必须不存在或者为空
+	file, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	_, err = db.Exec("vacuum into ?", file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+//	文件的sha256
+func fileChecksum(filePath string) (string, error) {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//	上传文件(超过backupPartSize时分段上传)
+func uploadFile(client *s3Client, key, filePath string, meta map[string]string) error {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > backupPartSize {
+		return client.putMultipart(key, file, backupPartSize, meta)
+	}
+
+	buffer, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	return client.put(key, buffer, meta)
+}
+
+//	从对象存储下载市场所有数据库文件最近一次的备份到空的数据目录,返回下载的文件数(运行期间锁定数据目录)
+func Restore(marketName string) (int, error) {
+
+	err := Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return 0, fmt.Errorf("[Backup]\t未能找到市场%s", marketName)
+	}
+
+	client, err := backupClient()
+	if err != nil {
+		return 0, err
+	}
+
+	if client == nil {
+		return 0, fmt.Errorf("[Backup]\t没有配置备份用的对象存储(BackupEndpoint)")
+	}
+
+	//	不覆盖已有的数据
+	files, err := marketDBFiles(market)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(files) > 0 {
+		return 0, fmt.Errorf("[Backup]\t%s的数据目录中已有%d个数据库文件,只能恢复到空的数据目录", market.Name(), len(files))
+	}
+
+	keys, err := client.list(market.Name() + "/")
+	if err != nil {
+		return 0, err
+	}
+
+	//	每个数据库文件最近一次的备份(键按日期排序,后面的覆盖前面的)
+	latest := make(map[string]string)
+	for _, key := range keys {
+		latest[path.Dir(key)] = key
+	}
+
+	for dir, key := range latest {
+		rel := strings.TrimPrefix(dir, market.Name()+"/") + ".db"
+		err = downloadFile(client, key, filepath.Join(marketDir(market), filepath.FromSlash(rel)))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	logger.Info("已从备份恢复数据库", "market", market.Name(), "files", len(latest))
+
+	return len(latest), nil
+}
+
+//	下载对象到文件(先写临时文件,避免留下不完整的数据库)
+func downloadFile(client *s3Client, key, filePath string) error {
+
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = client.get(key, file)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filePath)
+}
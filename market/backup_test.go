@@ -0,0 +1,232 @@
+package market
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	测试用的对象存储(只实现备份用到的请求)
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	meta    map[string]http.Header
+	parts   map[string]map[int][]byte
+	puts    int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, meta: map[string]http.Header{}, parts: map[string]map[int][]byte{}}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	//	路径为/存储桶/键
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/bucket"), "/")
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == "GET" && key == "":
+		type content struct{ Key string }
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}{}
+		for k := range s.objects {
+			if strings.HasPrefix(k, query.Get("prefix")) {
+				result.Contents = append(result.Contents, content{k})
+			}
+		}
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == "POST" && query["uploads"] != nil:
+		s.parts[key] = map[int][]byte{}
+		s.meta[key] = r.Header
+		w.Write([]byte("<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>"))
+	case r.Method == "PUT" && query.Get("uploadId") != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		s.parts[key][number] = body
+		w.Header().Set("ETag", `"`+query.Get("partNumber")+`"`)
+	case r.Method == "POST" && query.Get("uploadId") != "":
+		numbers := make([]int, 0)
+		for number := range s.parts[key] {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+
+		var object []byte
+		for _, number := range numbers {
+			object = append(object, s.parts[key][number]...)
+		}
+		s.objects[key] = object
+		s.puts++
+		w.Write([]byte("<CompleteMultipartUploadResult></CompleteMultipartUploadResult>"))
+	case r.Method == "PUT":
+		s.objects[key] = body
+		s.meta[key] = r.Header
+		s.puts++
+	case r.Method == "HEAD" || r.Method == "GET":
+		object, found := s.objects[key]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range s.meta[key] {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				w.Header()[name] = values
+			}
+		}
+		w.Write(object)
+	case r.Method == "DELETE":
+		delete(s.objects, key)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+//	对象存储中以prefix开头的键
+func (s *fakeS3) keys(prefix string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0)
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func TestBackupAndRestore(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockBackup", "AAA")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	s3 := newFakeS3()
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	c := config.Get()
+	defer func(endpoint, bucket, access string, keep int) {
+		c.BackupEndpoint, c.BackupBucket, c.BackupAccessKey, c.BackupKeep = endpoint, bucket, access, keep
+	}(c.BackupEndpoint, c.BackupBucket, c.BackupAccessKey, c.BackupKeep)
+	c.BackupEndpoint, c.BackupBucket, c.BackupAccessKey, c.BackupKeep = server.URL, "bucket", "key", 2
+
+	//	测试分段上传
+	defer func(size int64) { backupPartSize = size }(backupPartSize)
+	backupPartSize = 1024
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	save := func(day time.Time) {
+		tx, err := store.Begin(market, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, &ParseResult{Success: true, Regular: []Peroid60{{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour * 10), Open: 1, High: 2, Low: 1, Close: 2, Volume: 100}}})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, err := backupClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save(day)
+	uploaded, err := backupMarket(client, market, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uploaded != 1 || len(s3.keys("MockBackup/aaa/")) != 1 {
+		t.Fatalf("应上传1个文件,实际上传%d个,对象存储中有%v", uploaded, s3.keys(""))
+	}
+
+	//	没有变化时不上传
+	puts := s3.puts
+	uploaded, err = backupMarket(client, market, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uploaded != 0 || s3.puts != puts {
+		t.Errorf("没有变化时不应上传,实际上传%d个", uploaded)
+	}
+
+	//	有变化时上传,只保留最近2个备份
+	for index := 2; index < 4; index++ {
+		save(day.AddDate(0, 0, index))
+		_, err = backupMarket(client, market, day.AddDate(0, 0, index))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := s3.keys("MockBackup/aaa/")
+	if len(keys) != 2 || keys[0] != "MockBackup/aaa/20240107.db" || keys[1] != "MockBackup/aaa/20240108.db" {
+		t.Errorf("应保留最近2个备份,实际%v", keys)
+	}
+
+	expected, err := DailyBar(market, "AAA", day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	数据目录不为空时不恢复
+	_, err = Restore(market.Name())
+	if err == nil {
+		t.Errorf("数据目录不为空时应返回错误")
+	}
+
+	CloseDatabases()
+	err = os.RemoveAll(filepath.Join(c.DataDir, market.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := Restore(market.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("应恢复1个文件,实际%d个", count)
+	}
+
+	bar, err := DailyBar(market, "AAA", day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bar != expected {
+		t.Errorf("恢复后的日线应为%+v,实际%+v", expected, bar)
+	}
+}
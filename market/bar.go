@@ -0,0 +1,147 @@
+package market
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//	成交量为0,无法计算成交量加权平均价
+var ErrZeroVolume = errors.New("成交量为0")
+
+//	日线
+type Bar struct {
+	Market string
+	Code   string
+	Day    time.Time
+	Open   float32
+	High   float32
+	Low    float32
+	Close  float32
+	Volume int64
+}
+
+//	用保存的分时数据计算某日的日线(只使用常规交易时段,没有数据时返回ErrNoData)
+func DailyBar(market Market, company string, day time.Time) (Bar, error) {
+	return dailyBar(market, company, day, false)
+}
+
+//	用保存的分时数据计算某日的日线,最高最低价包含盘前盘后
+func DailyBarExtended(market Market, company string, day time.Time) (Bar, error) {
+	return dailyBar(market, company, day, true)
+}
+
+//	计算日线(分时数据已被清理时使用清理前保存的日线,此时最高最低价不含盘前盘后)
+func dailyBar(market Market, company string, day time.Time, extended bool) (Bar, error) {
+
+	list, err := loadDay(market, company, day)
+	if err != nil {
+		return Bar{}, err
+	}
+
+	bar, err := sessionsBar(market, company, day, list, extended)
+	if err != ErrNoData {
+		return bar, err
+	}
+
+	return archivedBar(market, company, day)
+}
+
+//	清理分时数据前保存的日线(没有时返回ErrNoData)
+func archivedBar(market Market, company string, day time.Time) (Bar, error) {
+
+	tx, err := store.Begin(market, company)
+	if err != nil {
+		return Bar{}, err
+	}
+	defer tx.Rollback()
+
+	return tx.LoadBar(day)
+}
+
+//	用分时数据计算日线(开盘收盘价和成交量以常规交易时段为准)
+func sessionsBar(market Market, company string, day time.Time, list []sessionPeriods, extended bool) (Bar, error) {
+
+	var regular []Peroid60
+	for _, sp := range list {
+		if sp.Session == "regular" {
+			regular = sp.Peroids
+		}
+	}
+
+	if len(regular) == 0 {
+		return Bar{}, ErrNoData
+	}
+
+	bar := Bar{
+		Market: market.Name(),
+		Code:   company,
+		Day:    day,
+		Open:   regular[0].Open,
+		High:   regular[0].High,
+		Low:    regular[0].Low,
+		Close:  regular[len(regular)-1].Close}
+
+	for _, sp := range list {
+		if sp.Session != "regular" && !extended {
+			continue
+		}
+
+		for _, p := range sp.Peroids {
+			if p.High > bar.High {
+				bar.High = p.High
+			}
+
+			if p.Low < bar.Low {
+				bar.Low = p.Low
+			}
+
+			if sp.Session == "regular" {
+				bar.Volume += p.Volume
+			}
+		}
+	}
+
+	return bar, nil
+}
+
+//	用保存的分时数据计算某日的成交量加权平均价(sessions为空时只使用常规交易时段,每分钟的价格取(最高+最低+收盘)/3)
+func VWAP(market Market, company string, day time.Time, sessions ...string) (float64, error) {
+
+	if len(sessions) == 0 {
+		sessions = []string{"regular"}
+	}
+
+	wanted := make(map[string]bool, len(sessions))
+	for _, session := range sessions {
+		if !periods[session] {
+			return 0, fmt.Errorf("[VWAP]\t错误的交易时段%s", session)
+		}
+
+		wanted[session] = true
+	}
+
+	list, err := loadDay(market, company, day)
+	if err != nil {
+		return 0, err
+	}
+
+	var amount float64
+	var volume int64
+	for _, sp := range list {
+		if !wanted[sp.Session] {
+			continue
+		}
+
+		for _, p := range sp.Peroids {
+			amount += float64(p.High+p.Low+p.Close) / 3 * float64(p.Volume)
+			volume += p.Volume
+		}
+	}
+
+	if volume == 0 {
+		return 0, ErrZeroVolume
+	}
+
+	return amount / float64(volume), nil
+}
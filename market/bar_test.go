@@ -0,0 +1,134 @@
+package market
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDailyBar(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockBar", "AAA", "EMPTY")
+	defer cleanup()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	point := func(hour, minute int, open, high, low, close float32, volume int64) Peroid60 {
+		return Peroid60{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour*time.Duration(hour) + time.Minute*time.Duration(minute)), Open: open, High: high, Low: low, Close: close, Volume: volume}
+	}
+
+	result := &ParseResult{
+		Success: true,
+		Pre:     []Peroid60{point(8, 0, 10, 20, 5, 10, 100)},
+		Regular: []Peroid60{point(9, 30, 11, 12, 10, 11.5, 1000), point(9, 31, 11.5, 13, 11, 12, 2000)},
+		Post:    []Peroid60{point(16, 0, 12, 12, 1, 12, 300)}}
+
+	for code, r := range map[string]*ParseResult{"AAA": result, "EMPTY": {Success: true}} {
+		tx, err := store.Begin(market, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, r)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bar, err := DailyBar(market, "AAA", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bar.Open != 11 || bar.High != 13 || bar.Low != 10 || bar.Close != 12 || bar.Volume != 3000 {
+		t.Errorf("日线不正确:%+v", bar)
+	}
+
+	bar, err = DailyBarExtended(market, "AAA", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bar.Open != 11 || bar.High != 20 || bar.Low != 1 || bar.Close != 12 || bar.Volume != 3000 {
+		t.Errorf("包含盘前盘后的日线不正确:%+v", bar)
+	}
+
+	//	处理过但没有常规交易时段数据
+	_, err = DailyBar(market, "EMPTY", day)
+	if err != ErrNoData {
+		t.Errorf("没有数据时应返回ErrNoData,实际:%v", err)
+	}
+
+	//	没有处理过
+	_, err = DailyBar(market, "AAA", day.AddDate(0, 0, 1))
+	if err != ErrNotProcessed {
+		t.Errorf("没有处理过时应返回ErrNotProcessed,实际:%v", err)
+	}
+}
+
+func TestVWAP(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockVWAP", "AAA", "ZERO")
+	defer cleanup()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	point := func(minute int, price float32, volume int64) Peroid60 {
+		return Peroid60{Time: day.Add(time.Hour*10 + time.Minute*time.Duration(minute)), Open: price, High: price, Low: price, Close: price, Volume: volume}
+	}
+
+	results := map[string]*ParseResult{
+		"AAA": {
+			Success: true,
+			Pre:     []Peroid60{point(-60, 20, 100)},
+			Regular: []Peroid60{point(0, 10, 100), point(1, 12, 300)}},
+		"ZERO": {
+			Success: true,
+			Regular: []Peroid60{point(0, 10, 0)}}}
+
+	for code, r := range results {
+		tx, err := store.Begin(market, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, r)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//	(10*100 + 12*300) / 400 = 11.5
+	vwap, err := VWAP(market, "AAA", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(vwap-11.5) > 1e-6 {
+		t.Errorf("常规交易时段的VWAP应为11.5,实际%v", vwap)
+	}
+
+	//	(20*100 + 10*100 + 12*300) / 500 = 13.2
+	vwap, err = VWAP(market, "AAA", day, "pre", "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(vwap-13.2) > 1e-6 {
+		t.Errorf("包含盘前的VWAP应为13.2,实际%v", vwap)
+	}
+
+	_, err = VWAP(market, "ZERO", day)
+	if err != ErrZeroVolume {
+		t.Errorf("成交量为0时应返回ErrZeroVolume,实际:%v", err)
+	}
+
+	_, err = VWAP(market, "AAA", day, "lunch")
+	if err == nil {
+		t.Errorf("错误的交易时段应该返回错误")
+	}
+}
@@ -0,0 +1,85 @@
+package market
+
+import (
+	"database/sql"
+	"time"
+)
+
+//	历史任务的进度,Oldest和Newest之间(含)的日期都已经处理过
+type HistoryCheckpoint struct {
+	Oldest time.Time
+	Newest time.Time
+}
+
+//	是否还没有进度
+func (c HistoryCheckpoint) IsZero() bool {
+	return c.Oldest.IsZero() || c.Newest.IsZero()
+}
+
+//	日期是否已经处理过
+func (c HistoryCheckpoint) Contains(day time.Time) bool {
+	return !c.IsZero() && !day.Before(c.Oldest) && !day.After(c.Newest)
+}
+
+//	合并两段进度(两段之间有间隔时无法合并)
+func (c HistoryCheckpoint) merge(other HistoryCheckpoint) (HistoryCheckpoint, bool) {
+
+	if c.IsZero() {
+		return other, true
+	}
+
+	if other.Oldest.After(c.Newest.AddDate(0, 0, 1)) || c.Oldest.After(other.Newest.AddDate(0, 0, 1)) {
+		return c, false
+	}
+
+	merged := c
+	if other.Oldest.Before(merged.Oldest) {
+		merged.Oldest = other.Oldest
+	}
+
+	if other.Newest.After(merged.Newest) {
+		merged.Newest = other.Newest
+	}
+
+	return merged, true
+}
+
+//	解析保存的进度(日期格式为20060102)
+func parseHistoryCheckpoint(market Market, oldest, newest string) (HistoryCheckpoint, error) {
+
+	location := locationYesterdayZero(market).Location()
+
+	o, err := time.ParseInLocation("20060102", oldest, location)
+	if err != nil {
+		return HistoryCheckpoint{}, err
+	}
+
+	n, err := time.ParseInLocation("20060102", newest, location)
+	if err != nil {
+		return HistoryCheckpoint{}, err
+	}
+
+	return HistoryCheckpoint{Oldest: o, Newest: n}, nil
+}
+
+//	读取查询出的进度(每行依次为上市公司代码、oldest、newest)
+func scanHistoryCheckpoints(market Market, rows *sql.Rows) (map[string]HistoryCheckpoint, error) {
+
+	checkpoints := make(map[string]HistoryCheckpoint)
+	for rows.Next() {
+		var code, oldest, newest string
+		err := rows.Scan(&code, &oldest, &newest)
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint, err := parseHistoryCheckpoint(market, oldest, newest)
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoints[code] = checkpoint
+	}
+
+	return checkpoints, rows.Err()
+}
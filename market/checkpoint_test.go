@@ -0,0 +1,155 @@
+package market
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestHistoryCheckpointMerge(t *testing.T) {
+
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	checkpoint := HistoryCheckpoint{Oldest: day.AddDate(0, 0, -5), Newest: day}
+
+	//	相连的进度可以合并
+	merged, ok := checkpoint.merge(HistoryCheckpoint{Oldest: day.AddDate(0, 0, 1), Newest: day.AddDate(0, 0, 3)})
+	if !ok || !merged.Oldest.Equal(checkpoint.Oldest) || !merged.Newest.Equal(day.AddDate(0, 0, 3)) {
+		t.Errorf("合并后的进度不正确:%+v", merged)
+	}
+
+	//	有间隔的进度不能合并
+	_, ok = checkpoint.merge(HistoryCheckpoint{Oldest: day.AddDate(0, 0, 2), Newest: day.AddDate(0, 0, 3)})
+	if ok {
+		t.Errorf("有间隔的进度不应该合并")
+	}
+
+	if !checkpoint.Contains(day) || checkpoint.Contains(day.AddDate(0, 0, 1)) || (HistoryCheckpoint{}).Contains(day) {
+		t.Errorf("Contains不正确")
+	}
+}
+
+//	处理一定天数后panic,模拟进程中途退出
+type interruptingHook struct {
+	countingHook
+	limit int
+}
+
+func (h *interruptingHook) OnDayDone(market Market, company Company, day time.Time, interval Interval, result *ParseResult, err error) {
+	h.countingHook.OnDayDone(market, company, day, interval, result, err)
+
+	if h.days == h.limit {
+		panic("进程退出")
+	}
+}
+
+func TestHistoryTaskResume(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockResume", "AAA")
+	defer cleanup()
+
+	historyDays := 6
+	defer overrideSettings(market.Name(), config.MarketConfig{HistoryDays: &historyDays})()
+	defer SetHook(nil)
+
+	//	只处理1m间隔
+	intervals := config.Get().Intervals
+	config.Get().Intervals = nil
+	defer func() { config.Get().Intervals = intervals }()
+
+	yesterday := locationYesterdayZero(market)
+
+	//	第4天保存之后、记录进度之前退出
+	interrupted := &interruptingHook{limit: 4}
+	SetHook(interrupted)
+	historyTask(market, yesterday)
+
+	checkpoint, err := store.LoadHistoryCheckpoint(market, "AAA", Interval1m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkpoint.Oldest.Equal(yesterday.AddDate(0, 0, -2)) || !checkpoint.Newest.Equal(yesterday) {
+		t.Fatalf("中断后的进度应为最近3天,实际%+v", checkpoint)
+	}
+
+	//	重启后只处理剩下的日期
+	resumed := &countingHook{}
+	SetHook(resumed)
+	historyTask(market, yesterday)
+
+	if resumed.days != historyDays-3 {
+		t.Errorf("重启后应处理%d天,实际%d天", historyDays-3, resumed.days)
+	}
+
+	checkpoint, err = store.LoadHistoryCheckpoint(market, "AAA", Interval1m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkpoint.Oldest.Equal(yesterday.AddDate(0, 0, 1-historyDays)) || !checkpoint.Newest.Equal(yesterday) {
+		t.Errorf("重启后的进度应为最近%d天,实际%+v", historyDays, checkpoint)
+	}
+
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	days, err := tx.ProcessedDays(yesterday.AddDate(0, 0, 1-historyDays), yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != historyDays {
+		t.Errorf("应处理%d天,实际%d天", historyDays, len(days))
+	}
+}
+
+func TestHistoryTaskSkipsComplete(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockHistoryComplete", "AAA", "BBB")
+	defer cleanup()
+
+	historyDays := 3
+	defer overrideSettings(market.Name(), config.MarketConfig{HistoryDays: &historyDays})()
+	defer SetHook(nil)
+
+	intervals := config.Get().Intervals
+	config.Get().Intervals = nil
+	defer func() { config.Get().Intervals = intervals }()
+
+	yesterday := locationYesterdayZero(market)
+	historyTask(market, yesterday)
+
+	checkpoints, err := store.HistoryCheckpoints(market, Interval1m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(checkpoints) != 2 || !checkpoints["BBB"].Contains(yesterday.AddDate(0, 0, 1-historyDays)) {
+		t.Fatalf("两家上市公司都应有最近%d天的进度,实际%+v", historyDays, checkpoints)
+	}
+
+	//	进度覆盖了整个范围的上市公司不再处理
+	hook := &countingHook{}
+	SetHook(hook)
+	historyTask(market, yesterday)
+
+	if hook.started != 0 || hook.days != 0 {
+		t.Errorf("已经完成的上市公司不应处理,实际处理%d家%d天", hook.started, hook.days)
+	}
+
+	//	范围扩大后只处理缺少的日期
+	historyDays = 4
+	defer overrideSettings(market.Name(), config.MarketConfig{HistoryDays: &historyDays})()
+
+	hook = &countingHook{}
+	SetHook(hook)
+	historyTask(market, yesterday)
+
+	if hook.started != 2 || hook.days != 2 {
+		t.Errorf("应处理2家上市公司各1天,实际处理%d家%d天", hook.started, hook.days)
+	}
+}
@@ -1,13 +1,13 @@
 package market
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"sort"
 	"time"
 
 	"github.com/guotie/gogb2312"
-	"github.com/nzai/go-utility/net"
 )
 
 //	中国证券市场
@@ -21,48 +21,50 @@ func (m China) Timezone() string {
 	return "Asia/Shanghai"
 }
 
+//	收盘时间
+func (m China) ClosingTime() (int, int) {
+	return 15, 0
+}
+
 //	更新上市公司列表
 func (m China) Companies() ([]Company, error) {
 
-	dict := make(map[string]Company, 0)
-
 	//	上海证券交易所
 	sh, err := m.shanghaiCompanies()
 	if err != nil {
 		return nil, err
 	}
-	for _, company := range sh {
-		//	去重
-		if _, found := dict[company.Code]; found {
-			continue
-		}
-
-		dict[company.Code] = company
-	}
 
 	//	深圳证券交易所
 	sz, err := m.shenzhenCompanies()
 	if err != nil {
 		return nil, err
 	}
-	for _, company := range sz {
-		//	去重
-		if _, found := dict[company.Code]; found {
-			continue
-		}
 
-		dict[company.Code] = company
-	}
+	return mergeCompanies(sh, sz), nil
+}
+
+//	合并多个交易所的上市公司列表,按Code去重(先出现的优先)并排序
+func mergeCompanies(lists ...[]Company) []Company {
 
+	dict := make(map[string]bool, 0)
 	companies := make([]Company, 0)
-	for _, company := range dict {
-		companies = append(companies, company)
+	for _, list := range lists {
+		for _, company := range list {
+			//	去重
+			if dict[company.Code] {
+				continue
+			}
+
+			dict[company.Code] = true
+			companies = append(companies, company)
+		}
 	}
 
 	//	按Code排序
 	sort.Sort(CompanyList(companies))
 
-	return companies, nil
+	return companies
 }
 
 //	上海证券交易所上市公司
@@ -78,7 +80,7 @@ func (m China) shanghaiCompanies() ([]Company, error) {
 	for _, url := range urls {
 
 		//	尝试从网络获取实时上市公司列表
-		text, err := net.DownloadStringRefererRetry(url, referer, retryTimes, retryIntervalSeconds)
+		text, err := downloadString(m.Name(), url, referer)
 		if err != nil {
 			return nil, err
 		}
@@ -110,7 +112,7 @@ func (m China) parseShanghaiJson(text string) ([]Company, error) {
 
 	companies := make([]Company, 0)
 	for _, section := range group {
-		companies = append(companies, Company{Market: m.Name(), Code: section[1], Name: section[2]})
+		companies = append(companies, Company{Market: m.Name(), Code: section[1], Name: section[2], Exchange: "SSE", Currency: "CNY"})
 	}
 
 	if len(companies) == 0 {
@@ -130,7 +132,7 @@ func (m China) shenzhenCompanies() ([]Company, error) {
 	for _, url := range urls {
 
 		//	尝试从网络获取实时上市公司列表
-		html, err := net.DownloadStringRetry(url, retryTimes, retryIntervalSeconds)
+		html, err := downloadString(m.Name(), url, "")
 		if err != nil {
 			return nil, err
 		}
@@ -161,7 +163,7 @@ func (m China) parseShenzhenHtml(html string) ([]Company, error) {
 
 	companies := make([]Company, 0)
 	for _, section := range group {
-		companies = append(companies, Company{Market: m.Name(), Code: section[1], Name: section[2]})
+		companies = append(companies, Company{Market: m.Name(), Code: section[1], Name: section[2], Exchange: "SZSE", Currency: "CNY"})
 	}
 
 	if len(companies) == 0 {
@@ -178,15 +180,47 @@ var chineseSuffix map[string]string = map[string]string{
 	"0": "SZ",
 	"2": "SZ",
 	"3": "SZ",
+	//	ETF
+	"5": "SS",
+	"1": "SZ",
 }
 
 //	抓取
 func (m China) Crawl(code string, day time.Time) (string, error) {
+	return m.CrawlInterval(code, day, Interval1m)
+}
+
+//	按指定间隔抓取
+func (m China) CrawlInterval(code string, day time.Time, interval Interval) (string, error) {
+	return m.CrawlContext(context.Background(), code, day, interval)
+}
+
+//	按指定间隔抓取,ctx取消或超时时中止
+func (m China) CrawlContext(ctx context.Context, code string, day time.Time, interval Interval) (string, error) {
+
+	queryCode, err := chinaSymbol(code)
+	if err != nil {
+		return "", err
+	}
+
+	return downloadCompanyDaily(ctx, m, code, queryCode, day, interval)
+}
+
+//	雅虎财经的A股代码(上海为.SS,深圳为.SZ)
+func chinaSymbol(code string) (string, error) {
+
+	if symbol, ok := indexSymbol(code); ok {
+		return symbol, nil
+	}
+
+	if len(code) != 6 {
+		return "", fmt.Errorf("错误的中国上市公司代码:%s", code)
+	}
 
 	suffix, found := chineseSuffix[code[:1]]
 	if !found {
-		suffix = "SS"
+		return "", fmt.Errorf("雅虎财经不支持的中国上市公司代码:%s", code)
 	}
 
-	return downloadCompanyDaily(m, code, code+"."+suffix, day)
+	return code + "." + suffix, nil
 }
@@ -0,0 +1,66 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChinaSymbol(t *testing.T) {
+
+	cases := map[string]string{
+		"600000": "600000.SS",
+		"900901": "900901.SS",
+		"000001": "000001.SZ",
+		"200002": "200002.SZ",
+		"300750": "300750.SZ",
+	}
+
+	for code, expected := range cases {
+		symbol, err := chinaSymbol(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if symbol != expected {
+			t.Errorf("%s的雅虎代码应为%s,实际%s", code, expected, symbol)
+		}
+	}
+
+	//	北京证券交易所等雅虎财经没有的代码
+	for _, code := range []string{"", "60000", "830799"} {
+		_, err := chinaSymbol(code)
+		if err == nil {
+			t.Errorf("错误的代码%s应该返回错误", code)
+		}
+	}
+}
+
+func TestMergeCompanies(t *testing.T) {
+
+	sh := []Company{{Market: "China", Code: "600000", Name: "浦发银行"}, {Market: "China", Code: "601398", Name: "工商银行"}}
+	sz := []Company{{Market: "China", Code: "000001", Name: "平安银行"}, {Market: "China", Code: "600000", Name: "重复"}}
+
+	companies := mergeCompanies(sh, sz)
+	if len(companies) != 3 {
+		t.Fatalf("合并去重后应有3家,实际%d家", len(companies))
+	}
+
+	if companies[0].Code != "000001" || companies[1].Code != "600000" || companies[1].Name != "浦发银行" {
+		t.Errorf("合并结果不正确:%+v", companies)
+	}
+}
+
+func TestParseChinaMiddayBreak(t *testing.T) {
+
+	//	11:30到13:00午间休市,分成两段常规交易时段,没有盘前盘后
+	buffer := []byte(`{"chart":{"result":[{"meta":{"tradingPeriods":[[{"start":10,"end":20},{"start":30,"end":40}]]},"timestamp":[10,19,25,30,39],"indicators":{"quote":[{"open":[1,1,1,1,1],"close":[1,1,1,1,1],"high":[1,1,1,1,1],"low":[1,1,1,1,1],"volume":[1,1,1,1,1]}]}}],"error":null}}`)
+
+	result, err := processDailyYahooJson(China{}, "600000", time.Unix(0, 0), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success || len(result.Regular) != 4 || len(result.Pre) != 0 || len(result.Post) != 0 {
+		t.Errorf("午间休市前后应有4条常规交易时段数据,实际%d条", len(result.Regular))
+	}
+}
@@ -1,16 +1,25 @@
 package market
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/nzai/stockrecorder/config"
 	"github.com/nzai/go-utility/io"
 )
 
 const (
+	//	旧版本的上市公司列表存档(只有一个文件)
 	companiesFileName = "companies.txt"
+	//	上市公司列表存档的版本(companies_20060102150405.txt)
+	companiesFilePrefix    = "companies_"
+	companiesVersionLayout = "20060102150405"
 )
 
 //	公司
@@ -18,8 +27,27 @@ type Company struct {
 	Market string
 	Name   string
 	Code   string
+	//	交易所、交易币种、行业(数据源没有提供时为空)
+	Exchange string
+	Currency string
+	Sector   string
+	Industry string
+	//	首次和最后一次出现在上市公司列表中的日期(只有从存储读取时才有)
+	FirstSeen time.Time
+	LastSeen  time.Time
+	//	退市日期(仍在上市公司列表中时为零值)
+	Delisted time.Time
+	//	配置的指数(CompanyKindIndex)或ETF(CompanyKindETF),上市公司为空
+	Kind string
 }
 
+var (
+	//	没有找到上市公司
+	ErrCompanyNotFound = errors.New("没有找到上市公司")
+	//	上市公司列表比上一版本少太多,没有保存
+	ErrCompanyListShrunk = errors.New("上市公司列表比上一版本少太多")
+)
+
 //	公司列表
 type CompanyList []Company
 
@@ -33,41 +61,238 @@ func (l CompanyList) Less(i, j int) bool {
 	return l[i].Code < l[j].Code
 }
 
-//	保存上市公司列表到文件
+//	保存上市公司列表到带时间的存档文件(每行依次为代码、名称、交易所、行业、细分行业、币种),只保留最近CompanyArchiveVersions个版本
+//	比上一版本少了CompanyListMaxShrink以上时不保存(设置了CompanyListForce除外),返回ErrCompanyListShrunk
 func (l CompanyList) Save(market Market) error {
 
+	previous := CompanyList{}
+	if previous.Load(market) == nil && companyListShrunk(len(previous), len(l)) {
+		logger.Error("上市公司列表比上一版本少太多,没有保存(确认无误时设置CompanyListForce)", "market", market.Name(), "companies", len(l), "previous", len(previous))
+		return ErrCompanyListShrunk
+	}
+
 	lines := make([]string, 0)
 	companies := ([]Company)(l)
 	for _, company := range companies {
-		lines = append(lines, fmt.Sprintf("%s\t%s", company.Code, company.Name))
+		lines = append(lines, strings.Join([]string{company.Code, company.Name, company.Exchange, company.Sector, company.Industry, company.Currency}, "\t"))
 	}
 
-	return io.WriteLines(filepath.Join(config.Get().DataDir, market.Name(), companiesFileName), lines)
+	dir, err := ensureMarketDir(market)
+	if err != nil {
+		return err
+	}
+
+	name := companiesFilePrefix + time.Now().Format(companiesVersionLayout) + ".txt"
+	err = io.WriteLines(filepath.Join(dir, name), lines)
+	if err != nil {
+		return err
+	}
+
+	return removeCompanyVersions(market, config.Get().CompanyArchiveVersions)
 }
 
 //	从存档读取上市公司列表
+//	按时间从新到旧读取各个版本,使用第一个不比最多的版本少CompanyListMaxShrink以上的,都没有时读取旧版本的companies.txt
 func (l *CompanyList) Load(market Market) error {
 
-	lines, err := io.ReadLines(filepath.Join(config.Get().DataDir, market.Name(), companiesFileName))
+	paths, err := companyVersions(market)
+	if err != nil {
+		return err
+	}
+
+	versions := make([]CompanyList, 0, len(paths))
+	largest := 0
+	for index := len(paths) - 1; index >= 0; index-- {
+		companies, err := readCompanyFile(market, paths[index])
+		if err != nil {
+			logger.Warn("读取上市公司列表存档出错,已忽略", "market", market.Name(), "path", paths[index], "error", err)
+			continue
+		}
+
+		versions = append(versions, companies)
+		if len(companies) > largest {
+			largest = len(companies)
+		}
+	}
+
+	for _, companies := range versions {
+		if len(companies) > 0 && !companyListShrunk(largest, len(companies)) {
+			*l = companies
+			return nil
+		}
+	}
+
+	companies, err := readCompanyFile(market, filepath.Join(marketDir(market), companiesFileName))
+	if err != nil {
+		return err
+	}
+
+	*l = companies
+
+	return nil
+}
+
+//	更新的上市公司数比上一版本少了CompanyListMaxShrink以上(设置了CompanyListForce时总是false)
+func companyListShrunk(previous, current int) bool {
+
+	if config.Get().CompanyListForce {
+		return false
+	}
+
+	return float64(current) < float64(previous)*(1-config.Get().CompanyListMaxShrink)
+}
+
+//	上市公司列表存档的所有版本(按时间从旧到新)
+func companyVersions(market Market) ([]string, error) {
+
+	paths, err := filepath.Glob(filepath.Join(marketDir(market), companiesFilePrefix+"*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	//	文件名中的时间可以按字符串排序
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+//	只保留最近keep个版本
+func removeCompanyVersions(market Market, keep int) error {
+
+	paths, err := companyVersions(market)
 	if err != nil {
 		return err
 	}
 
+	for index := 0; index < len(paths)-keep; index++ {
+		err = os.Remove(paths[index])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//	读取一个上市公司列表存档文件
+func readCompanyFile(market Market, path string) (CompanyList, error) {
+
+	lines, err := io.ReadLines(path)
+	if err != nil {
+		return nil, err
+	}
+
 	companies := make([]Company, 0)
 	for _, line := range lines {
+		//	旧版本的存档只有代码和名称,或者没有币种
 		parts := strings.Split(line, "\t")
-		if len(parts) != 2 {
-			return fmt.Errorf("[%s]\t上市公司文件格式有错误: %s", market.Name(), line)
+		if len(parts) != 2 && len(parts) != 5 && len(parts) != 6 {
+			return nil, fmt.Errorf("[%s]\t上市公司文件格式有错误: %s", market.Name(), line)
 		}
 
-		companies = append(companies, Company{
+		company := Company{
 			Market: market.Name(),
 			Code:   parts[0],
-			Name:   parts[1]})
+			Name:   parts[1]}
+		if len(parts) >= 5 {
+			company.Exchange, company.Sector, company.Industry = parts[2], parts[3], parts[4]
+		}
+
+		if len(parts) == 6 {
+			company.Currency = parts[5]
+		}
+
+		companies = append(companies, company)
 	}
 
-	cl := CompanyList(companies)
-	l = &cl
+	return CompanyList(companies), nil
+}
+
+//	查询保存过的上市公司(包括已经不在上市公司列表中的)
+func GetCompany(marketName, code string) (*Company, error) {
 
-	return nil
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Company]\t未能找到市场%s", marketName)
+	}
+
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return nil, err
+	}
+
+	//	也可以使用数据源的代码查询(如BRK-B)
+	listing := listingSymbol(market, code)
+	for _, company := range companies {
+		if strings.EqualFold(company.Code, code) || strings.EqualFold(company.Code, listing) {
+			return &company, nil
+		}
+	}
+
+	return nil, ErrCompanyNotFound
+}
+
+//	按代码前缀或名称包含的文字查询上市公司(不区分大小写,按代码排序)
+func SearchCompanies(marketName, query string) ([]Company, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Company]\t未能找到市场%s", marketName)
+	}
+
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	list := make([]Company, 0)
+	for _, company := range companies {
+		if strings.HasPrefix(strings.ToLower(company.Code), query) || strings.Contains(strings.ToLower(company.Name), query) {
+			list = append(list, company)
+		}
+	}
+
+	//	按Code排序
+	sort.Sort(CompanyList(list))
+
+	return list, nil
+}
+
+//	读取查询结果中的上市公司(code, name, exchange, currency, sector, industry, first_seen, last_seen, delisted)
+func scanCompanies(market Market, rows *sql.Rows) ([]Company, error) {
+
+	location := locationYesterdayZero(market).Location()
+
+	companies := make([]Company, 0)
+	for rows.Next() {
+		company := Company{Market: market.Name()}
+		var firstSeen, lastSeen, delisted string
+		err := rows.Scan(&company.Code, &company.Name, &company.Exchange, &company.Currency, &company.Sector, &company.Industry, &firstSeen, &lastSeen, &delisted, &company.Kind)
+		if err != nil {
+			return nil, err
+		}
+
+		company.FirstSeen, err = time.ParseInLocation("20060102", firstSeen, location)
+		if err != nil {
+			return nil, err
+		}
+
+		company.LastSeen, err = time.ParseInLocation("20060102", lastSeen, location)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(delisted) != "" {
+			company.Delisted, err = time.ParseInLocation("20060102", delisted, location)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		companies = append(companies, company)
+	}
+
+	return companies, rows.Err()
 }
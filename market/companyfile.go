@@ -0,0 +1,156 @@
+package market
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	获取上市公司列表(配置了CompanyFile时从文件读取,否则从数据源获取)
+func marketCompanies(market Market) ([]Company, error) {
+
+	path := config.Get().Markets[market.Name()].CompanyFile
+	if path == "" {
+		return market.Companies()
+	}
+
+	return loadCompanyFile(market, path)
+}
+
+//	检查上市公司列表文件的格式
+func validateCompanyFile(marketName, path string) error {
+
+	if path == "" {
+		return nil
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".json":
+		return nil
+	default:
+		return fmt.Errorf("[%s]\t上市公司列表文件(CompanyFile)只支持.csv和.json:%s", marketName, path)
+	}
+}
+
+//	读取上市公司列表文件(按扩展名区分CSV和JSON,按Code排序并去掉重复的)
+func loadCompanyFile(market Market, path string) ([]Company, error) {
+
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]\t读取上市公司列表文件%s出错:%s", market.Name(), path, err.Error())
+	}
+
+	var companies []Company
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		companies, err = parseCompanyJSON(buffer)
+	} else {
+		companies, err = parseCompanyCSV(buffer)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("[%s]\t解析上市公司列表文件%s出错:%s", market.Name(), path, err.Error())
+	}
+
+	seen := make(map[string]bool, len(companies))
+	list := make([]Company, 0, len(companies))
+	for _, company := range companies {
+		company.Market = market.Name()
+		company.Code = strings.TrimSpace(company.Code)
+		company.Name = strings.TrimSpace(company.Name)
+		company.Exchange = strings.TrimSpace(company.Exchange)
+		company.Currency = strings.TrimSpace(company.Currency)
+
+		if company.Code == "" || seen[company.Code] {
+			continue
+		}
+
+		seen[company.Code] = true
+		list = append(list, company)
+	}
+
+	//	按Code排序
+	sort.Sort(CompanyList(list))
+
+	return list, nil
+}
+
+//	解析JSON格式的上市公司列表([{"code": "AAPL", "name": "Apple Inc.", "exchange": "NASDAQ", "currency": "USD"}])
+func parseCompanyJSON(buffer []byte) ([]Company, error) {
+
+	var items []struct {
+		Code     string `json:"code"`
+		Name     string `json:"name"`
+		Exchange string `json:"exchange"`
+		Currency string `json:"currency"`
+	}
+
+	err := json.Unmarshal(buffer, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	companies := make([]Company, 0, len(items))
+	for _, item := range items {
+		companies = append(companies, Company{Code: item.Code, Name: item.Name, Exchange: item.Exchange, Currency: item.Currency})
+	}
+
+	return companies, nil
+}
+
+//	解析CSV格式的上市公司列表(依次为code, name, exchange, currency,第一行有code列时按表头确定列的位置)
+func parseCompanyCSV(buffer []byte) ([]Company, error) {
+
+	reader := csv.NewReader(bytes.NewReader(buffer))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	codeIndex, nameIndex, exchangeIndex, currencyIndex := 0, 1, 2, 3
+	if len(records) > 0 {
+		indexes := map[string]int{"code": -1, "name": -1, "exchange": -1, "currency": -1}
+		for index, title := range records[0] {
+			title = strings.ToLower(strings.TrimSpace(title))
+			if _, found := indexes[title]; found {
+				indexes[title] = index
+			}
+		}
+
+		//	有表头
+		if indexes["code"] >= 0 {
+			codeIndex, nameIndex, exchangeIndex, currencyIndex = indexes["code"], indexes["name"], indexes["exchange"], indexes["currency"]
+			records = records[1:]
+		}
+	}
+
+	companies := make([]Company, 0, len(records))
+	for _, record := range records {
+		companies = append(companies, Company{
+			Code:     csvField(record, codeIndex),
+			Name:     csvField(record, nameIndex),
+			Exchange: csvField(record, exchangeIndex),
+			Currency: csvField(record, currencyIndex)})
+	}
+
+	return companies, nil
+}
+
+//	CSV中的可选字段(没有该列时为空)
+func csvField(record []string, index int) string {
+
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+
+	return record[index]
+}
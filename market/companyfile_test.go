@@ -0,0 +1,94 @@
+package market
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestLoadCompanyFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "companies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"header.csv":   "exchange,code,name,currency\nNASDAQ,MSFT,Microsoft,USD\nNASDAQ,AAPL,Apple Inc.,USD\nNYSE,IBM,IBM\n",
+		"plain.csv":    "MSFT,Microsoft,NASDAQ\nAAPL,\"Apple Inc.\",NASDAQ,USD\nIBM,IBM\nAAPL,Apple,NASDAQ\n",
+		"list.json":    `[{"code":"MSFT","name":"Microsoft","exchange":"NASDAQ"},{"code":"AAPL","name":"Apple Inc.","exchange":"NASDAQ","currency":"USD"},{"code":"IBM","name":"IBM"},{"code":""}]`,
+		"invalid.json": `{"code":"AAPL"}`,
+	}
+
+	for name, text := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	market := mockMarket{name: "MockCompanyFile"}
+	for _, name := range []string{"header.csv", "plain.csv", "list.json"} {
+		companies, err := loadCompanyFile(market, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//	按Code排序,去掉重复的和空的
+		if len(companies) != 3 || companies[0].Code != "AAPL" || companies[0].Name != "Apple Inc." || companies[0].Exchange != "NASDAQ" || companies[0].Currency != "USD" || companies[1].Code != "IBM" || companies[2].Code != "MSFT" {
+			t.Errorf("%s中的上市公司不正确:%+v", name, companies)
+		}
+
+		if companies[0].Market != market.Name() {
+			t.Errorf("%s中的上市公司应属于%s,实际%s", name, market.Name(), companies[0].Market)
+		}
+	}
+
+	_, err = loadCompanyFile(market, filepath.Join(dir, "invalid.json"))
+	if err == nil {
+		t.Errorf("JSON不是数组时应返回错误")
+	}
+}
+
+func TestGetCompaniesFromFile(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockCompanyFileList", "AAA", "BBB")
+	defer cleanup()
+
+	path := filepath.Join(config.Get().DataDir, market.Name(), "watchlist.csv")
+	err := ioutil.WriteFile(path, []byte("code,name,exchange\nZZZ,Zed,TEST\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer overrideSettings(market.Name(), config.MarketConfig{CompanyFile: path})()
+
+	companies, err := getCompanies(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(companies) != 1 || companies[0].Code != "ZZZ" || companies[0].Name != "Zed" || companies[0].Exchange != "TEST" {
+		t.Fatalf("应使用文件中的上市公司,实际%+v", companies)
+	}
+
+	//	文件中的上市公司同样存档
+	cl := CompanyList{}
+	err = cl.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cl) != 1 || cl[0].Code != "ZZZ" {
+		t.Errorf("存档的上市公司不正确:%+v", cl)
+	}
+
+	defer overrideSettings(market.Name(), config.MarketConfig{CompanyFile: path + ".txt"})()
+	if validateSettings(market.Name()) == nil {
+		t.Errorf("不支持的文件格式应该返回错误")
+	}
+}
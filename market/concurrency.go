@@ -0,0 +1,37 @@
+package market
+
+import (
+	"sync"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+var (
+	//	所有市场正在抓取的上市公司数
+	globalActive int
+	globalCond   = sync.NewCond(&sync.Mutex{})
+)
+
+//	获取所有市场共用的抓取名额(没有配置MaxConcurrency时不限制),返回释放名额的函数
+func acquireGlobalSlot() func() {
+
+	limit := config.Get().MaxConcurrency
+	if limit <= 0 {
+		return func() {}
+	}
+
+	globalCond.L.Lock()
+	for globalActive >= limit {
+		globalCond.Wait()
+	}
+	globalActive++
+	globalCond.L.Unlock()
+
+	return func() {
+		globalCond.L.Lock()
+		globalActive--
+		globalCond.L.Unlock()
+
+		globalCond.Broadcast()
+	}
+}
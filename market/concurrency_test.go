@@ -0,0 +1,104 @@
+package market
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestGlobalConcurrency(t *testing.T) {
+
+	first, cleanup := newMockMarket(t, "MockGlobalA", "AAA", "BBB", "CCC", "DDD")
+	defer cleanup()
+
+	second, cleanup2 := newMockMarket(t, "MockGlobalB", "EEE", "FFF", "GGG", "HHH")
+	defer cleanup2()
+
+	c := config.Get()
+	defer func(max int) { c.MaxConcurrency = max }(c.MaxConcurrency)
+	c.MaxConcurrency = 3
+
+	//	两个市场共用计数
+	var active, maxActive int32
+	markets := []slowMarket{
+		{first, time.Millisecond * 50, &active, &maxActive},
+		{second, time.Millisecond * 50, &active, &maxActive},
+	}
+
+	var wg sync.WaitGroup
+	for _, market := range markets {
+		wg.Add(1)
+		go func(market slowMarket) {
+			defer wg.Done()
+
+			result, err := dailyTaskDay(market, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if result.Success != 4 {
+				t.Errorf("%s应成功抓取4家上市公司,实际%d家", market.Name(), result.Success)
+			}
+		}(market)
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxActive); max > 3 || max < 2 {
+		t.Errorf("所有市场同时抓取的上市公司数不应超过3,实际最多%d", max)
+	}
+}
+
+func TestGlobalConcurrencyBackfillRetry(t *testing.T) {
+
+	first, cleanup := newMockMarket(t, "MockGlobalBackfill", "AAA", "BBB", "CCC", "DDD")
+	defer cleanup()
+
+	second, cleanup2 := newMockMarket(t, "MockGlobalRetry", "EEE", "FFF", "GGG", "HHH")
+	defer cleanup2()
+
+	c := config.Get()
+	defer func(max int) { c.MaxConcurrency = max }(c.MaxConcurrency)
+	c.MaxConcurrency = 2
+
+	//	补抓和重试队列共用计数
+	var active, maxActive int32
+	backfill := slowMarket{first, time.Millisecond * 50, &active, &maxActive}
+	retry := slowMarket{second, time.Millisecond * 50, &active, &maxActive}
+
+	Add(backfill)
+	defer Remove(backfill.Name())
+
+	day := locationYesterdayZero(retry)
+	for _, company := range retry.companies {
+		err := store.EnqueueRetry(retry, RetryEntry{Company: company.Code, Day: day, Message: "HTTP状态码429"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		yesterday := locationYesterdayZero(backfill)
+		err := Backfill(backfill.Name(), yesterday, yesterday, nil)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		drainRetryQueue(retry)
+	}()
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxActive); max > 2 {
+		t.Errorf("补抓和重试同时抓取的上市公司数不应超过2,实际最多%d", max)
+	}
+}
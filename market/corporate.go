@@ -0,0 +1,58 @@
+package market
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	//	分红
+	ActionDividend = "dividend"
+	//	拆股
+	ActionSplit = "split"
+)
+
+//	公司行为(分红或拆股,Time为除权日)
+type CorporateAction struct {
+	Market  string
+	Company string
+	Type    string
+	Time    time.Time
+	//	每股分红金额(分红)
+	Amount float32
+	//	拆股比例(拆股)
+	Numerator   float32
+	Denominator float32
+	Ratio       string
+}
+
+//	查询上市公司在指定日期范围内的分红和拆股
+func GetCorporateActions(marketName, companyCode string, from, to time.Time) ([]CorporateAction, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[CorporateAction]\t未能找到市场%s", marketName)
+	}
+
+	tx, err := store.Begin(market, companyCode)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	start, end := localDayRange(from, to)
+
+	return tx.CorporateActions(start, end)
+}
+
+//	按时间排序(同一时间分红在前)
+func sortCorporateActions(actions []CorporateAction) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].Time.Equal(actions[j].Time) {
+			return actions[i].Type < actions[j].Type
+		}
+
+		return actions[i].Time.Before(actions[j].Time)
+	})
+}
@@ -0,0 +1,60 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetCorporateActions(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockActions", "AAPL")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	day := time.Date(2020, 8, 31, 0, 0, 0, 0, time.Local)
+	result := &ParseResult{
+		Success:   true,
+		Dividends: []Dividend{{market.Name(), "AAPL", day.AddDate(0, 0, -24), 0.82}},
+		Splits:    []Split{{market.Name(), "AAPL", day, 4, 1, "4:1"}}}
+
+	tx, err := store.Begin(market, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = saveResult(tx, day, result)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := GetCorporateActions(market.Name(), "AAPL", day.AddDate(0, -1, 0), day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 2 {
+		t.Fatalf("应有2条公司行为,实际%d条", len(actions))
+	}
+
+	if actions[0].Type != ActionDividend || actions[0].Amount != 0.82 {
+		t.Errorf("分红不正确:%+v", actions[0])
+	}
+
+	if actions[1].Type != ActionSplit || actions[1].Numerator != 4 || actions[1].Denominator != 1 || actions[1].Ratio != "4:1" {
+		t.Errorf("拆股不正确:%+v", actions[1])
+	}
+
+	//	范围外的不返回
+	actions, err = GetCorporateActions(market.Name(), "AAPL", day.AddDate(0, 0, 1), day.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 0 {
+		t.Errorf("范围外不应有公司行为,实际%d条", len(actions))
+	}
+}
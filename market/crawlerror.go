@@ -0,0 +1,136 @@
+package market
+
+import (
+	"fmt"
+	"time"
+)
+
+//	抓取错误
+type CrawlError struct {
+	Market  string
+	Company string
+	Day     time.Time
+	Message string
+	//	记录时间
+	Time time.Time
+}
+
+//	查询上市公司在指定日期范围内的错误信息
+func GetErrors(marketName, companyCode string, from, to time.Time) ([]CrawlError, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Error]\t未能找到市场%s", marketName)
+	}
+
+	tx, err := store.Begin(market, companyCode)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	errors, err := tx.Errors(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for index := range errors {
+		errors[index].Market = market.Name()
+		errors[index].Company = companyCode
+	}
+
+	return errors, nil
+}
+
+//	市场所有上市公司在某日的错误数量
+func CountErrorsByDay(marketName string, day time.Time) (int, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return 0, fmt.Errorf("[Error]\t未能找到市场%s", marketName)
+	}
+
+	return store.CountErrors(market, day)
+}
+
+//	在单独的事务中保存错误信息(不标记为已处理)
+func recordError(market Market, company Company, day time.Time, message string) {
+
+	//	试运行时不写入
+	if isDryRun() {
+		return
+	}
+
+	tx, err := store.Begin(market, company.Code)
+	if err == nil {
+		err = tx.SaveError(day, message)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+
+	if err != nil {
+		logger.Error("保存错误信息时出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "error", err)
+	}
+}
+
+//	重新抓取上市公司保存过错误信息的日期(只处理数据源还能提供数据的日期),运行期间锁定数据目录
+func ReprocessFailed(market Market, company string) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	yesterday := locationYesterdayZero(market)
+	from := yesterday.AddDate(0, 0, 1-getSettings(market.Name()).historyDays)
+
+	tx, err := store.Begin(market, company)
+	if err != nil {
+		return err
+	}
+
+	errors, err := tx.Errors(from, yesterday)
+	tx.Rollback()
+	if err != nil {
+		return err
+	}
+
+	//	同一天可能有多条错误信息
+	days := make([]time.Time, 0, len(errors))
+	for index, e := range errors {
+		if index > 0 && e.Day.Equal(errors[index-1].Day) {
+			continue
+		}
+
+		days = append(days, e.Day)
+	}
+
+	c := Company{Market: market.Name(), Code: company}
+	failed := 0
+	for _, day := range days {
+		//	清除之前的处理状态和错误信息后重新抓取
+		result, err := companyTransaction(market, c, day, true)
+		if err != nil {
+			//	事务已回滚,单独保存错误信息
+			recordError(market, c, day, err.Error())
+			failed++
+			continue
+		}
+
+		if result != nil && !result.Success {
+			failed++
+		}
+	}
+
+	logger.Info("重新抓取失败的分时数据已结束", "market", market.Name(), "company", company, "days", len(days), "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("[%s]\t重新抓取%s的分时数据时有%d天失败", market.Name(), company, failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	上市公司某日某个间隔的一次抓取
+type CrawlStat struct {
+	Market   string
+	Company  string
+	Day      time.Time
+	Interval Interval
+	//	抓取、解析和保存的用时
+	Duration time.Duration
+	//	最后一次请求的HTTP状态码(没有请求或者网络错误时为0)
+	Status int
+	//	响应的字节数
+	Bytes int
+	//	重试次数
+	Retries int
+	//	解析出的分时数据条数
+	Rows    int
+	Success bool
+}
+
+//	市场某日所有抓取的汇总
+type CrawlStatsSummary struct {
+	Market   string
+	Day      time.Time
+	Total    int
+	Failures int
+	Retries  int
+	Rows     int
+	Bytes    int64
+	//	用时的中位数和95%分位数
+	P50 time.Duration
+	P95 time.Duration
+}
+
+//	一行汇总,如:抓取 120 / 失败 3, 用时 p50 850ms p95 2.1s, 4.2MB, 重试 5 次
+func (s CrawlStatsSummary) String() string {
+	return fmt.Sprintf("抓取 %d / 失败 %d, 用时 p50 %s p95 %s, %.1fMB, 重试 %d 次", s.Total, s.Failures, s.P50, s.P95, float64(s.Bytes)/1024/1024, s.Retries)
+}
+
+//	市场某日(所有间隔)抓取统计的汇总
+func GetCrawlStats(marketName string, day time.Time) (CrawlStatsSummary, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return CrawlStatsSummary{}, fmt.Errorf("[CrawlStats]\t未能找到市场%s", marketName)
+	}
+
+	stats, err := store.CrawlStats(market, day)
+	if err != nil {
+		return CrawlStatsSummary{}, err
+	}
+
+	return summarizeCrawlStats(market.Name(), day, stats), nil
+}
+
+//	汇总抓取统计
+func summarizeCrawlStats(marketName string, day time.Time, stats []CrawlStat) CrawlStatsSummary {
+
+	summary := CrawlStatsSummary{Market: marketName, Day: day, Total: len(stats)}
+
+	durations := make([]time.Duration, 0, len(stats))
+	for _, stat := range stats {
+		if !stat.Success {
+			summary.Failures++
+		}
+
+		summary.Retries += stat.Retries
+		summary.Rows += stat.Rows
+		summary.Bytes += int64(stat.Bytes)
+		durations = append(durations, stat.Duration)
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	summary.P50 = percentile(durations, 0.5)
+	summary.P95 = percentile(durations, 0.95)
+
+	return summary
+}
+
+//	已排序的用时的分位数(最近秩法,没有数据时为0)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+
+	return sorted[index]
+}
+
+//	读取查询出的抓取统计(每行依次为上市公司代码、间隔、用时毫秒数、状态码、字节数、重试次数、条数、是否成功)
+func scanCrawlStats(market Market, day time.Time, rows *sql.Rows) ([]CrawlStat, error) {
+
+	stats := make([]CrawlStat, 0)
+	for rows.Next() {
+		stat := CrawlStat{Market: market.Name(), Day: day}
+		var interval string
+		var duration int64
+		err := rows.Scan(&stat.Company, &interval, &duration, &stat.Status, &stat.Bytes, &stat.Retries, &stat.Rows, &stat.Success)
+		if err != nil {
+			return nil, err
+		}
+
+		stat.Interval, stat.Duration = Interval(interval), time.Duration(duration)*time.Millisecond
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
+//	保存一次抓取的统计(试运行或者配置了DisableCrawlStats时不保存,失败只记录日志)
+func saveCrawlStat(market Market, stat CrawlStat) {
+
+	if config.Get().DisableCrawlStats || isDryRun() {
+		return
+	}
+
+	err := store.SaveCrawlStat(market, stat)
+	if err != nil {
+		logger.Warn("保存抓取统计时出错", "market", market.Name(), "company", stat.Company, "day", stat.Day.Format("20060102"), "error", err)
+	}
+}
+
+//	一次下载的情况
+type downloadTrace struct {
+	//	最后一次请求的HTTP状态码
+	Status int
+	//	请求次数
+	Attempts int
+}
+
+//	重试次数
+func (t downloadTrace) retries() int {
+
+	if t.Attempts <= 1 {
+		return 0
+	}
+
+	return t.Attempts - 1
+}
+
+var (
+	//	按市场、上市公司、日期和间隔记录的下载情况,抓取结束后取出
+	//	Crawl只返回内容,下载情况由数据源的实现记录
+	downloadTraces = make(map[string]downloadTrace)
+	traceMutex     sync.Mutex
+)
+
+func traceKey(marketName, code string, day time.Time, interval Interval) string {
+	return strings.Join([]string{marketName, code, day.Format("20060102"), string(interval)}, "|")
+}
+
+//	记录上市公司某日的下载情况
+func recordDownload(marketName, code string, day time.Time, interval Interval, trace downloadTrace) {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	downloadTraces[traceKey(marketName, code, day, interval)] = trace
+}
+
+//	取出并删除上市公司某日的下载情况(没有记录时为零值)
+func takeDownload(marketName, code string, day time.Time, interval Interval) downloadTrace {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	key := traceKey(marketName, code, day, interval)
+	trace := downloadTraces[key]
+	delete(downloadTraces, key)
+
+	return trace
+}
@@ -0,0 +1,99 @@
+package market
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetCrawlStats(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockCrawlStats", "AAA", "BBB")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	_, err := dailyTaskDay(market, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := GetCrawlStats(market.Name(), day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Total != 2 || summary.Failures != 0 || summary.Rows != 2 || summary.Bytes != int64(2*len(mockYahooJson)) || summary.P95 < summary.P50 {
+		t.Errorf("抓取统计的汇总不正确:%+v", summary)
+	}
+
+	//	失败的抓取同样记录
+	failed := day.AddDate(0, 0, 3)
+	market.failDay = failed
+	_, err = dailyTaskDay(market, failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err = GetCrawlStats(market.Name(), failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Total != 2 || summary.Failures != 2 || summary.Rows != 0 {
+		t.Errorf("失败的抓取统计不正确:%+v", summary)
+	}
+}
+
+func TestCrawlStatsDownloadTrace(t *testing.T) {
+
+	content := "Date,Open,High,Low,Close,Volume\n2024-01-05,181.99,182.76,180.17,181.18,62303300\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	defer func(host string) { stooqHost = host }(stooqHost)
+	stooqHost = server.URL
+
+	//	数据源记录的下载情况由crawlInterval取出
+	market := NewStooq(America{})
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	raw, trace, err := crawlInterval(market, "AAPL", day, Interval1m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if raw != content || trace.Status != http.StatusOK || trace.Attempts != 1 || trace.retries() != 0 {
+		t.Errorf("下载情况不正确:%+v", trace)
+	}
+
+	if _, found := downloadTraces[traceKey(market.Name(), "AAPL", day, Interval1m)]; found {
+		t.Errorf("取出后不应保留下载情况")
+	}
+}
+
+func TestSummarizeCrawlStats(t *testing.T) {
+
+	stats := make([]CrawlStat, 0)
+	for index := 1; index <= 20; index++ {
+		stats = append(stats, CrawlStat{Duration: time.Duration(index) * time.Millisecond, Bytes: 100, Retries: index % 2, Rows: 10, Success: index != 20})
+	}
+
+	summary := summarizeCrawlStats("Mock", time.Now(), stats)
+	if summary.Total != 20 || summary.Failures != 1 || summary.Retries != 10 || summary.Rows != 200 || summary.Bytes != 2000 {
+		t.Errorf("汇总不正确:%+v", summary)
+	}
+
+	if summary.P50 != 10*time.Millisecond || summary.P95 != 19*time.Millisecond {
+		t.Errorf("p50应为10ms,p95应为19ms,实际%s %s", summary.P50, summary.P95)
+	}
+
+	empty := summarizeCrawlStats("Mock", time.Now(), nil)
+	if empty.Total != 0 || empty.P50 != 0 || empty.P95 != 0 {
+		t.Errorf("没有统计时汇总应为0:%+v", empty)
+	}
+}
@@ -0,0 +1,97 @@
+package market
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//	获取雅虎cookie的地址
+var yahooCookieURL = "https://fc.yahoo.com"
+
+//	雅虎财经的cookie和crumb(部分接口需要),同一个会话使用同一个User-Agent
+type yahooSession struct {
+	Cookie    string
+	Crumb     string
+	UserAgent string
+}
+
+var (
+	yahooSessionCache *yahooSession
+	yahooSessionMutex sync.Mutex
+)
+
+//	获取缓存的cookie和crumb,没有时使用市场的http客户端重新获取(获取失败时缓存空值,直到被要求验证时再重新获取)
+func getYahooSession(marketName string) *yahooSession {
+	yahooSessionMutex.Lock()
+	defer yahooSessionMutex.Unlock()
+
+	if yahooSessionCache == nil {
+		session, err := newYahooSession(marketName)
+		if err != nil {
+			logger.Warn("获取雅虎财经的cookie和crumb时出错,将不使用crumb", "error", err)
+			session = &yahooSession{UserAgent: pickUserAgent()}
+		}
+
+		yahooSessionCache = session
+	}
+
+	return yahooSessionCache
+}
+
+//	清除缓存的cookie和crumb(已被其他请求更新过的不清除)
+func resetYahooSession(session *yahooSession) {
+	yahooSessionMutex.Lock()
+	defer yahooSessionMutex.Unlock()
+
+	if yahooSessionCache == session {
+		yahooSessionCache = nil
+	}
+}
+
+//	获取cookie和crumb
+func newYahooSession(marketName string) (*yahooSession, error) {
+
+	userAgent := pickUserAgent()
+
+	//	cookie在响应中设置(响应本身可能是404)
+	client, request, err := newGetRequest(marketName, yahooCookieURL, http.Header{"User-Agent": []string{userAgent}})
+	if err != nil {
+		return nil, err
+	}
+
+	request, cancel := withTimeout(request)
+	defer cancel()
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	cookies := make([]string, 0)
+	for _, cookie := range response.Cookies() {
+		cookies = append(cookies, cookie.Name+"="+cookie.Value)
+	}
+
+	if len(cookies) == 0 {
+		return nil, fmt.Errorf("%s没有返回cookie", yahooCookieURL)
+	}
+
+	session := &yahooSession{Cookie: strings.Join(cookies, "; "), UserAgent: userAgent}
+
+	//	用cookie换取crumb
+	status, crumb, err := httpGet(marketName, yahooHost+"/v1/test/getcrumb", http.Header{"Cookie": []string{session.Cookie}, "User-Agent": []string{userAgent}})
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK || crumb == "" {
+		return nil, fmt.Errorf("获取crumb时出错,HTTP状态码%d", status)
+	}
+
+	session.Crumb = strings.TrimSpace(crumb)
+
+	return session, nil
+}
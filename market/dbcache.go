@@ -0,0 +1,121 @@
+package market
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	缓存的sqlite数据库连接,Close只释放引用,空闲的连接超过SQLiteMaxIdleDBs时关闭最久未使用的
+type sqliteDB struct {
+	*sql.DB
+	path     string
+	refs     int
+	lastUsed time.Time
+}
+
+var (
+	//	按文件路径缓存的数据库连接
+	dbCache      = make(map[string]*sqliteDB)
+	dbCacheMutex sync.Mutex
+)
+
+//	获取缓存的数据库连接,第一次打开时执行还没有执行过的迁移
+func openCachedDB(filePath string, migrations []migration) (*sqliteDB, error) {
+
+	if db := acquireCachedDB(filePath); db != nil {
+		return db, nil
+	}
+
+	//	打开数据库和迁移时不加锁
+	raw, err := openSQLite(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrate(raw, filePath, migrations)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	dbCacheMutex.Lock()
+	defer dbCacheMutex.Unlock()
+
+	//	其他goroutine已经打开了同一个数据库
+	if db, found := dbCache[filePath]; found {
+		raw.Close()
+		db.refs++
+		return db, nil
+	}
+
+	db := &sqliteDB{DB: raw, path: filePath, refs: 1}
+	dbCache[filePath] = db
+
+	return db, nil
+}
+
+//	已缓存时增加引用并返回,否则返回nil
+func acquireCachedDB(filePath string) *sqliteDB {
+	dbCacheMutex.Lock()
+	defer dbCacheMutex.Unlock()
+
+	db, found := dbCache[filePath]
+	if !found {
+		return nil
+	}
+
+	db.refs++
+
+	return db
+}
+
+//	释放引用(连接保留在缓存中)
+func (db *sqliteDB) Close() error {
+	dbCacheMutex.Lock()
+	defer dbCacheMutex.Unlock()
+
+	db.refs--
+	db.lastUsed = time.Now()
+
+	return closeIdleDBs(config.Get().SQLiteMaxIdleDBs)
+}
+
+//	关闭所有空闲的数据库连接(退出前或删除数据文件前调用)
+func CloseDatabases() error {
+	dbCacheMutex.Lock()
+	defer dbCacheMutex.Unlock()
+
+	return closeIdleDBs(0)
+}
+
+//	空闲的连接超过keep个时关闭最久未使用的(调用前需要加锁)
+func closeIdleDBs(keep int) error {
+
+	idle := make([]*sqliteDB, 0, len(dbCache))
+	for _, db := range dbCache {
+		if db.refs <= 0 {
+			idle = append(idle, db)
+		}
+	}
+
+	if len(idle) <= keep {
+		return nil
+	}
+
+	sort.Slice(idle, func(i, j int) bool { return idle[i].lastUsed.Before(idle[j].lastUsed) })
+
+	var err error
+	for _, db := range idle[:len(idle)-keep] {
+		delete(dbCache, db.path)
+
+		if e := db.DB.Close(); e != nil {
+			err = e
+		}
+	}
+
+	return err
+}
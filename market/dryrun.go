@@ -0,0 +1,98 @@
+package market
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+var (
+	//	试运行:抓取并解析,但不写入任何数据
+	dryRun      bool
+	dryRunMutex sync.Mutex
+)
+
+//	设置是否试运行(配置中的DryRun为true时总是试运行)
+func SetDryRun(enabled bool) {
+	dryRunMutex.Lock()
+	defer dryRunMutex.Unlock()
+
+	dryRun = enabled
+}
+
+//	是否试运行
+func isDryRun() bool {
+	dryRunMutex.Lock()
+	defer dryRunMutex.Unlock()
+
+	return dryRun || config.Get().DryRun
+}
+
+//	启动事务,试运行时不写入任何数据
+func beginTx(market Market, code string, interval Interval) (Tx, error) {
+
+	tx, err := store.BeginInterval(market, code, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isDryRun() {
+		return tx, nil
+	}
+
+	return dryRunTx{tx}, nil
+}
+
+//	试运行的事务:总是当作没有处理过,写入操作全部忽略,提交时回滚
+type dryRunTx struct {
+	Tx
+}
+
+func (t dryRunTx) IsProcessed(day time.Time) (bool, error) {
+	return false, nil
+}
+
+func (t dryRunTx) MarkProcessed(day time.Time, success bool) error {
+	return nil
+}
+
+func (t dryRunTx) SaveValidation(day time.Time, summary string) error {
+	return nil
+}
+
+func (t dryRunTx) SaveSessions(day time.Time, sessions TradingSessions) error {
+	return nil
+}
+
+func (t dryRunTx) SavePeriod(period string, peroids []Peroid60) error {
+	return nil
+}
+
+func (t dryRunTx) SaveDividends(dividends []Dividend) error {
+	return nil
+}
+
+func (t dryRunTx) SaveSplits(splits []Split) error {
+	return nil
+}
+
+func (t dryRunTx) SaveError(day time.Time, message string) error {
+	return nil
+}
+
+func (t dryRunTx) ClearProcessed(day time.Time) error {
+	return nil
+}
+
+func (t dryRunTx) DeletePeriod(period string, start, end time.Time) error {
+	return nil
+}
+
+func (t dryRunTx) SaveBar(bar Bar) error {
+	return nil
+}
+
+func (t dryRunTx) Commit() error {
+	return t.Tx.Rollback()
+}
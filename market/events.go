@@ -0,0 +1,109 @@
+package market
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	上市公司级别的事件类型(只发送给OnEvent的订阅,不发送给Notifier)
+const (
+	//	上市公司抓取成功
+	EventCompanyCrawled = "company_crawled"
+	//	上市公司抓取失败
+	EventCompanyFailed = "company_failed"
+)
+
+//	事件订阅
+type subscription struct {
+	handler func(Event)
+	//	异步订阅的缓冲区(同步订阅时为nil)
+	events chan Event
+}
+
+var (
+	//	所有的事件订阅
+	subscriptions     = make(map[*subscription]bool)
+	subscriptionMutex sync.RWMutex
+	//	因为订阅的缓冲区满而丢弃的事件数
+	droppedEvents int64
+)
+
+//	订阅抓取过程中的事件,返回取消订阅的函数
+//	默认在单独的goroutine中按顺序调用handler,缓冲区(EventBuffer)满时丢弃新的事件;配置了SyncEvents时在抓取的goroutine中同时调用
+func OnEvent(handler func(Event)) func() {
+
+	s := &subscription{handler: handler}
+	done := make(chan struct{})
+	if !config.Get().SyncEvents {
+		s.events = make(chan Event, config.Get().EventBuffer)
+		go func() {
+			defer close(done)
+
+			for event := range s.events {
+				s.handler(event)
+			}
+		}()
+	} else {
+		close(done)
+	}
+
+	subscriptionMutex.Lock()
+	subscriptions[s] = true
+	subscriptionMutex.Unlock()
+
+	return func() {
+		subscriptionMutex.Lock()
+		if subscriptions[s] {
+			delete(subscriptions, s)
+			if s.events != nil {
+				close(s.events)
+			}
+		}
+		subscriptionMutex.Unlock()
+
+		//	等待缓冲区中的事件处理完
+		<-done
+	}
+}
+
+//	因为订阅的缓冲区满而丢弃的事件数
+func DroppedEvents() int64 {
+	return atomic.LoadInt64(&droppedEvents)
+}
+
+//	发送事件到所有订阅
+func publish(event Event) {
+	subscriptionMutex.RLock()
+	defer subscriptionMutex.RUnlock()
+
+	for s := range subscriptions {
+		if s.events == nil {
+			s.handler(event)
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			atomic.AddInt64(&droppedEvents, 1)
+		}
+	}
+}
+
+//	上市公司抓取结束的事件(rows为保存的分时数据条数)
+func companyEvent(market Market, company Company, day time.Time, result *ParseResult, err error, duration time.Duration) Event {
+
+	event := Event{Type: EventCompanyCrawled, Market: market.Name(), Day: day, Company: company.Code, Duration: duration, Err: err}
+	if err != nil {
+		event.Type = EventCompanyFailed
+	}
+
+	if result != nil {
+		event.Rows = len(result.Pre) + len(result.Regular) + len(result.Post)
+	}
+
+	return event
+}
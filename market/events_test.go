@@ -0,0 +1,122 @@
+package market
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestOnEvent(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockEvents", "AAA", "BBB", "CCC")
+	defer cleanup()
+	market.panicCode = "CCC"
+
+	var mutex sync.Mutex
+	events := make([]Event, 0)
+	unsubscribe := OnEvent(func(e Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		events = append(events, e)
+	})
+
+	_, err := dailyTaskDay(market, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	等待缓冲区中的事件处理完
+	unsubscribe()
+
+	if len(events) != 5 || events[0].Type != EventDailyStart || events[4].Type != EventDailyEnd {
+		t.Fatalf("应依次有开始、3家上市公司和结束的事件,实际%+v", events)
+	}
+
+	//	上市公司的事件顺序不固定
+	companies := make([]string, 0)
+	for _, e := range events[1:4] {
+		companies = append(companies, e.Company+":"+e.Type)
+
+		if e.Market != market.Name() || e.Duration <= 0 {
+			t.Errorf("上市公司的事件不正确:%+v", e)
+		}
+
+		if e.Type == EventCompanyCrawled && e.Rows != 1 {
+			t.Errorf("%s应保存1条分时数据,实际%d条", e.Company, e.Rows)
+		}
+
+		if e.Type == EventCompanyFailed && e.Err == nil {
+			t.Errorf("%s失败的事件应包含错误", e.Company)
+		}
+	}
+	sort.Strings(companies)
+
+	expected := "AAA:company_crawled,BBB:company_crawled,CCC:company_failed"
+	if strings.Join(companies, ",") != expected {
+		t.Errorf("上市公司的事件应为%s,实际%s", expected, strings.Join(companies, ","))
+	}
+
+	if events[4].Total != 3 || events[4].Success != 2 || events[4].Failed != 1 {
+		t.Errorf("结束的事件不正确:%+v", events[4])
+	}
+
+	//	取消订阅后不再收到事件
+	publish(Event{Type: EventDailyStart})
+	if len(events) != 5 {
+		t.Errorf("取消订阅后不应收到事件")
+	}
+}
+
+func TestOnEventDropsWhenFull(t *testing.T) {
+
+	c := config.Get()
+	defer func(buffer int) { c.EventBuffer = buffer }(c.EventBuffer)
+	c.EventBuffer = 2
+
+	//	订阅者卡住时不阻塞发送
+	block := make(chan struct{})
+	received := 0
+	unsubscribe := OnEvent(func(e Event) {
+		<-block
+		received++
+	})
+
+	dropped := DroppedEvents()
+	for index := 0; index < 10; index++ {
+		publish(Event{Type: EventDailyStart})
+	}
+
+	if DroppedEvents()-dropped < 7 {
+		t.Errorf("缓冲区满时应丢弃事件,实际丢弃%d个", DroppedEvents()-dropped)
+	}
+
+	close(block)
+	unsubscribe()
+
+	if int64(received)+DroppedEvents()-dropped != 10 {
+		t.Errorf("处理和丢弃的事件总数应为10,实际处理%d个,丢弃%d个", received, DroppedEvents()-dropped)
+	}
+}
+
+func TestOnEventSync(t *testing.T) {
+
+	c := config.Get()
+	defer func(sync bool) { c.SyncEvents = sync }(c.SyncEvents)
+	c.SyncEvents = true
+
+	received := 0
+	unsubscribe := OnEvent(func(e Event) { received++ })
+	defer unsubscribe()
+
+	publish(Event{Type: EventDailyStart})
+
+	//	同步调用,发送后已经处理
+	if received != 1 {
+		t.Errorf("同步订阅应在发送时处理,实际处理%d个", received)
+	}
+}
@@ -0,0 +1,190 @@
+package market
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+//	交易时段(按时间顺序)
+var sessions = []string{"pre", "regular", "post"}
+
+//	某个交易时段的分时数据
+type sessionPeriods struct {
+	Session string
+	Peroids []Peroid60
+}
+
+var (
+	//	没有处理过
+	ErrNotProcessed = errors.New("该日数据没有处理过")
+	//	处理过但是没有分时数据
+	ErrNoData = errors.New("该日没有分时数据")
+)
+
+//	读取上市公司某日所有交易时段的分时数据(没有处理过时返回ErrNotProcessed)
+func loadDay(market Market, company string, day time.Time) ([]sessionPeriods, error) {
+
+	tx, err := store.Begin(market, company)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	processed, err := tx.IsProcessed(day)
+	if err != nil {
+		return nil, err
+	}
+
+	if !processed {
+		return nil, ErrNotProcessed
+	}
+
+	return loadSessions(tx, day)
+}
+
+//	在事务中读取某日所有交易时段的分时数据
+func loadSessions(tx Tx, day time.Time) ([]sessionPeriods, error) {
+
+	start, end := localDayRange(day, day)
+
+	list := make([]sessionPeriods, 0, len(sessions))
+	for _, session := range sessions {
+		peroids, err := tx.LoadPeriod(session, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, sessionPeriods{session, peroids})
+	}
+
+	return list, nil
+}
+
+//	导出上市公司某日的分时数据为CSV(处理过但没有数据时只输出表头)
+func ExportCSV(w io.Writer, market Market, company string, day time.Time) error {
+
+	list, err := loadDay(market, company, day)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	err = writer.Write([]string{"timestamp", "open", "high", "low", "close", "volume", "adjclose", "session"})
+	if err != nil {
+		return err
+	}
+
+	for _, sp := range list {
+		for _, p := range sp.Peroids {
+			err = writer.Write([]string{
+				p.Time.Format("2006-01-02 15:04:05"),
+				formatPrice(p.Open),
+				formatPrice(p.High),
+				formatPrice(p.Low),
+				formatPrice(p.Close),
+				strconv.FormatInt(p.Volume, 10),
+				formatPrice(p.AdjClose),
+				sp.Session})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+//	格式化价格
+func formatPrice(price float32) string {
+	return strconv.FormatFloat(float64(price), 'f', -1, 32)
+}
+
+//	JSON格式的分时数据
+type jsonPoint struct {
+	Time   string  `json:"time"`
+	Open   float32 `json:"open"`
+	High   float32 `json:"high"`
+	Low    float32 `json:"low"`
+	Close  float32 `json:"close"`
+	Volume int64   `json:"volume"`
+	//	分时数据一般没有复权收盘价,此时为0
+	AdjClose float32 `json:"adjclose"`
+}
+
+//	JSON格式的某日分时数据
+type jsonDay struct {
+	Market  string `json:"market"`
+	Company string `json:"company"`
+	//	保存过的上市公司信息(没有时省略)
+	Name     string      `json:"name,omitempty"`
+	Exchange string      `json:"exchange,omitempty"`
+	Currency string      `json:"currency,omitempty"`
+	Day      string      `json:"day"`
+	Pre      []jsonPoint `json:"pre"`
+	Regular  []jsonPoint `json:"regular"`
+	Post     []jsonPoint `json:"post"`
+}
+
+//	导出上市公司某日的分时数据为JSON(没有处理过返回ErrNotProcessed,处理过但没有数据返回ErrNoData)
+func ExportJSON(w io.Writer, market Market, company string, day time.Time) error {
+
+	list, err := loadDay(market, company, day)
+	if err != nil {
+		return err
+	}
+
+	points := make(map[string][]jsonPoint, len(list))
+	count := 0
+	for _, sp := range list {
+		ps := make([]jsonPoint, 0, len(sp.Peroids))
+		for _, p := range sp.Peroids {
+			ps = append(ps, jsonPoint{p.Time.Format("2006-01-02 15:04:05"), p.Open, p.High, p.Low, p.Close, p.Volume, p.AdjClose})
+		}
+
+		points[sp.Session] = ps
+		count += len(ps)
+	}
+
+	if count == 0 {
+		return ErrNoData
+	}
+
+	info, err := savedCompany(market, company)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(jsonDay{
+		Market:   market.Name(),
+		Company:  company,
+		Name:     info.Name,
+		Exchange: info.Exchange,
+		Currency: info.Currency,
+		Day:      day.Format("20060102"),
+		Pre:      points["pre"],
+		Regular:  points["regular"],
+		Post:     points["post"]})
+}
+
+//	保存过的上市公司信息(没有保存过时返回只有代码的)
+func savedCompany(market Market, code string) (Company, error) {
+
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return Company{}, err
+	}
+
+	for _, company := range companies {
+		if company.Code == code {
+			return company, nil
+		}
+	}
+
+	return Company{Market: market.Name(), Code: code}, nil
+}
@@ -0,0 +1,51 @@
+package market
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+//	重新抓取单个上市公司某日的1m数据并以CSV格式输出保存的结果(用于调试,试运行时不输出),运行期间锁定数据目录
+func FetchCompanyDay(w io.Writer, marketName, companyCode string, day time.Time) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return fmt.Errorf("[Fetch]\t未能找到市场%s", marketName)
+	}
+
+	err = validateTimezone(market)
+	if err != nil {
+		return err
+	}
+
+	//	按市场所在时区取整到0点
+	location := locationYesterdayZero(market).Location()
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+
+	//	清除之前的处理状态后重新抓取
+	company := Company{Market: market.Name(), Code: companyCode}
+	result, err := companyTransaction(market, company, day, true)
+	if err != nil {
+		return fmt.Errorf("[Fetch]\t抓取%s在%s的分时数据时出错:%s", companyCode, day.Format("20060102"), err.Error())
+	}
+
+	if !result.Success {
+		return fmt.Errorf("[Fetch]\t解析%s在%s的分时数据失败:%s", companyCode, day.Format("20060102"), result.Message)
+	}
+
+	logger.Info("抓取分时数据已结束", "market", market.Name(), "company", companyCode, "day", day.Format("20060102"), "pre", len(result.Pre), "regular", len(result.Regular), "post", len(result.Post))
+
+	//	试运行时没有保存任何数据
+	if isDryRun() {
+		return nil
+	}
+
+	return ExportCSV(w, market, companyCode, day)
+}
@@ -0,0 +1,92 @@
+package market
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+var (
+	//	按市场设置的上市公司过滤函数
+	companyFilters     = make(map[string]func(Company) bool)
+	companyFilterMutex sync.RWMutex
+)
+
+//	设置市场的上市公司过滤函数(返回false的上市公司不抓取,传入nil取消过滤)
+func SetCompanyFilter(marketName string, f func(Company) bool) {
+	companyFilterMutex.Lock()
+	defer companyFilterMutex.Unlock()
+
+	if f == nil {
+		delete(companyFilters, marketName)
+		return
+	}
+
+	companyFilters[marketName] = f
+}
+
+//	按配置的Include、Exclude和过滤函数筛选每日任务和历史任务要抓取的上市公司
+func selectCompanies(market Market, companies []Company) []Company {
+
+	mc := config.Get().Markets[market.Name()]
+
+	companyFilterMutex.RLock()
+	f := companyFilters[market.Name()]
+	companyFilterMutex.RUnlock()
+
+	if len(mc.Include) == 0 && len(mc.Exclude) == 0 && f == nil {
+		return companies
+	}
+
+	filtered := make([]Company, 0, len(companies))
+	for _, company := range companies {
+		//	Include为空时不限制
+		if len(mc.Include) > 0 && !matchCode(mc.Include, company.Code) {
+			continue
+		}
+
+		if matchCode(mc.Exclude, company.Code) {
+			continue
+		}
+
+		if f != nil && !f(company) {
+			continue
+		}
+
+		filtered = append(filtered, company)
+	}
+
+	if len(filtered) < len(companies) {
+		logger.Info("已过滤上市公司", "market", market.Name(), "filtered", len(companies)-len(filtered), "remaining", len(filtered))
+	}
+
+	return filtered
+}
+
+//	代码是否符合其中一个代码或通配符
+func matchCode(patterns []string, code string) bool {
+
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, code)
+		if err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+//	检查Include和Exclude中的通配符
+func validatePatterns(marketName string, patterns []string) error {
+
+	for _, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("[%s]\t错误的上市公司通配符%s:%s", marketName, pattern, err.Error())
+		}
+	}
+
+	return nil
+}
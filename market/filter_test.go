@@ -0,0 +1,81 @@
+package market
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestSelectCompanies(t *testing.T) {
+
+	market := mockMarket{name: "MockFilter"}
+	for _, code := range []string{"AAPL", "AMZN", "IBM", "MSFT", "ORCL"} {
+		market.companies = append(market.companies, Company{Market: market.name, Code: code, Name: code})
+	}
+
+	codes := func(mc config.MarketConfig, f func(Company) bool) []string {
+		defer overrideSettings(market.name, mc)()
+		SetCompanyFilter(market.name, f)
+		defer SetCompanyFilter(market.name, nil)
+
+		selected := make([]string, 0)
+		for _, company := range selectCompanies(market, market.companies) {
+			selected = append(selected, company.Code)
+		}
+
+		return selected
+	}
+
+	cases := []struct {
+		mc       config.MarketConfig
+		f        func(Company) bool
+		expected []string
+	}{
+		//	Include为空时抓取全部
+		{config.MarketConfig{}, nil, []string{"AAPL", "AMZN", "IBM", "MSFT", "ORCL"}},
+		{config.MarketConfig{Include: []string{"A*", "IBM"}}, nil, []string{"AAPL", "AMZN", "IBM"}},
+		{config.MarketConfig{Exclude: []string{"A*"}}, nil, []string{"IBM", "MSFT", "ORCL"}},
+		{config.MarketConfig{Include: []string{"A*", "IBM"}, Exclude: []string{"AMZN"}}, nil, []string{"AAPL", "IBM"}},
+		{config.MarketConfig{Exclude: []string{"IBM"}}, func(c Company) bool { return c.Code != "ORCL" }, []string{"AAPL", "AMZN", "MSFT"}},
+	}
+
+	for _, c := range cases {
+		selected := codes(c.mc, c.f)
+		if strings.Join(selected, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Include=%v Exclude=%v 应选中%v,实际%v", c.mc.Include, c.mc.Exclude, c.expected, selected)
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+
+	defer overrideSettings("MockFilter", config.MarketConfig{Exclude: []string{"A["}})()
+
+	if validateSettings("MockFilter") == nil {
+		t.Errorf("错误的通配符应该返回错误")
+	}
+}
+
+func TestDailyTaskFilteredCompanies(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockFilterDaily", "AAPL", "AMZN", "IBM", "MSFT")
+	defer cleanup()
+
+	defer overrideSettings(market.Name(), config.MarketConfig{Include: []string{"A*"}})()
+
+	h := &countingHook{}
+	SetHook(h)
+	defer SetHook(nil)
+
+	//	WaitGroup按过滤后的数量计数,否则任务不会结束
+	result, err := dailyTaskDay(market, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 2 || h.started != 2 || h.done != 2 {
+		t.Errorf("应只抓取2家上市公司,实际总数%d,开始%d家,结束%d家", result.Total, h.started, h.done)
+	}
+}
@@ -0,0 +1,199 @@
+package market
+
+import (
+	"fmt"
+	"time"
+)
+
+//	上市公司数据完整度
+type Coverage struct {
+	Company   string
+	Expected  int
+	Processed int
+	Gaps      []time.Time
+}
+
+//	完整度百分比
+func (c Coverage) Percent() float64 {
+	if c.Expected == 0 {
+		return 100
+	}
+
+	return float64(c.Processed) * 100 / float64(c.Expected)
+}
+
+//	查找指定日期范围内没有处理过的交易日
+func FindGaps(marketName, companyCode string, from, to time.Time) ([]time.Time, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Gap]\t未能找到市场%s", marketName)
+	}
+
+	return findGaps(market, companyCode, from, to)
+}
+
+//	重新抓取指定日期范围内没有处理过的交易日(运行期间锁定数据目录)
+func RepairGaps(marketName, companyCode string, from, to time.Time) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return fmt.Errorf("[Gap]\t未能找到市场%s", marketName)
+	}
+
+	gaps, err := findGaps(market, companyCode, from, to)
+	if err != nil {
+		return err
+	}
+
+	company := Company{Market: market.Name(), Code: companyCode}
+
+	failed := 0
+	for _, day := range gaps {
+		_, err = companyTask(market, company, day)
+		if err != nil {
+			logger.Error("修复缺失的分时数据出错", "market", market.Name(), "company", companyCode, "day", day.Format("20060102"), "error", err)
+			failed++
+		}
+	}
+
+	logger.Info("修复缺失的分时数据已结束", "market", market.Name(), "company", companyCode, "gaps", len(gaps), "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("[%s]\t修复[%s]缺失的分时数据时有%d天失败", market.Name(), companyCode, failed)
+	}
+
+	return nil
+}
+
+//	市场所有上市公司在指定日期范围内的数据完整度
+func CoverageReport(marketName string, from, to time.Time) ([]Coverage, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Gap]\t未能找到市场%s", marketName)
+	}
+
+	//	从存档读取上市公司列表,避免访问网络
+	cl := CompanyList{}
+	err := cl.Load(market)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := len(tradingDays(market, from, to))
+
+	report := make([]Coverage, 0, len(cl))
+	for _, company := range cl {
+		gaps, err := findGaps(market, company.Code, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		coverage := Coverage{
+			Company:   company.Code,
+			Expected:  expected,
+			Processed: expected - len(gaps),
+			Gaps:      gaps}
+		report = append(report, coverage)
+
+		logger.Info("数据完整度", "market", market.Name(), "company", company.Code, "percent", fmt.Sprintf("%.2f", coverage.Percent()))
+	}
+
+	return report, nil
+}
+
+//	查找没有处理过的交易日
+func findGaps(market Market, code string, from, to time.Time) ([]time.Time, error) {
+
+	days := tradingDays(market, from, to)
+	if len(days) == 0 {
+		return days, nil
+	}
+
+	tx, err := store.Begin(market, code)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	processed, err := tx.ProcessedDays(days[0], days[len(days)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	dict := make(map[string]bool, len(processed))
+	for _, day := range processed {
+		dict[day.Format("20060102")] = true
+	}
+
+	gaps := make([]time.Time, 0)
+	for _, day := range days {
+		if !dict[day.Format("20060102")] {
+			gaps = append(gaps, day)
+		}
+	}
+
+	return gaps, nil
+}
+
+//	查找指定日期范围内没有常规交易时段分时数据的交易日
+//	与FindGaps不同,处理过但是失败或者没有数据的日期也算作缺失
+func FindDataGaps(market Market, company string, start, end time.Time) ([]time.Time, error) {
+
+	days := tradingDays(market, start, end)
+	if len(days) == 0 {
+		return days, nil
+	}
+
+	tx, err := store.Begin(market, company)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	from, to := localDayRange(days[0], days[len(days)-1])
+	peroids, err := tx.LoadPeriod("regular", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	dict := make(map[string]bool)
+	for _, p := range peroids {
+		dict[p.Time.Format("20060102")] = true
+	}
+
+	gaps := make([]time.Time, 0)
+	for _, day := range days {
+		if !dict[day.Format("20060102")] {
+			gaps = append(gaps, day)
+		}
+	}
+
+	return gaps, nil
+}
+
+//	指定日期范围内的交易日(市场所在时区的周一至周五,不包括配置的休市日)
+func tradingDays(market Market, from, to time.Time) []time.Time {
+
+	location := locationYesterdayZero(market).Location()
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, location)
+
+	days := make([]time.Time, 0)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday || isHoliday(market, day) {
+			continue
+		}
+
+		days = append(days, day)
+	}
+
+	return days
+}
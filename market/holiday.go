@@ -0,0 +1,34 @@
+package market
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	某日是否为配置的休市日(按市场所在时区的日期)
+func isHoliday(market Market, day time.Time) bool {
+
+	date := day.Format("20060102")
+	for _, holiday := range config.Get().Markets[market.Name()].Holidays {
+		if holiday == date {
+			return true
+		}
+	}
+
+	return false
+}
+
+//	检查休市日的格式
+func validateHolidays(marketName string, holidays []string) error {
+
+	for _, holiday := range holidays {
+		_, err := time.Parse("20060102", holiday)
+		if err != nil {
+			return fmt.Errorf("[%s]\t错误的休市日(Holidays):%s", marketName, holiday)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package market
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestHolidays(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockHoliday", "AAA")
+	defer cleanup()
+
+	//	2024年劳动节休市5月1日至5日,其中4日和5日是周末
+	defer overrideSettings(market.Name(), config.MarketConfig{Holidays: []string{"20240501", "20240502", "20240503"}})()
+
+	days := tradingDays(market, time.Date(2024, 4, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC))
+	if len(days) != 4 || days[1].Format("20060102") != "20240430" || days[2].Format("20060102") != "20240506" {
+		t.Errorf("交易日不应包括周末和休市日,实际%v", days)
+	}
+
+	h := &countingHook{}
+	SetHook(h)
+	defer SetHook(nil)
+
+	result, err := dailyTaskDay(market, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 0 || h.started != 0 {
+		t.Errorf("休市日不应抓取,实际总数%d,开始%d家", result.Total, h.started)
+	}
+
+	result, err = dailyTaskDay(market, time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 1 || h.started != 1 {
+		t.Errorf("交易日应照常抓取,实际总数%d,开始%d家", result.Total, h.started)
+	}
+
+	defer overrideSettings(market.Name(), config.MarketConfig{Holidays: []string{"2024-05-01"}})()
+	if validateSettings(market.Name()) == nil {
+		t.Errorf("错误的休市日应该返回错误")
+	}
+}
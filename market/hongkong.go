@@ -1,12 +1,12 @@
 package market
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"time"
-
-	"github.com/nzai/go-utility/net"
 )
 
 //	香港证券市场
@@ -20,6 +20,11 @@ func (m HongKong) Timezone() string {
 	return "Asia/Hong_Kong"
 }
 
+//	收盘时间(收市竞价16点10分结束)
+func (m HongKong) ClosingTime() (int, int) {
+	return 16, 10
+}
+
 //	更新上市公司列表
 func (m HongKong) Companies() ([]Company, error) {
 
@@ -32,7 +37,7 @@ func (m HongKong) Companies() ([]Company, error) {
 	for _, url := range urls {
 
 		//	尝试从网络获取实时上市公司列表
-		html, err := net.DownloadStringRetry(url, retryTimes, retryIntervalSeconds)
+		html, err := downloadString(m.Name(), url, "")
 		if err != nil {
 			return nil, err
 		}
@@ -61,7 +66,7 @@ func (m HongKong) parseHtml(html string) ([]Company, error) {
 
 	companies := make([]Company, 0)
 	for _, section := range group {
-		companies = append(companies, Company{Market: m.Name(), Code: section[1], Name: section[3]})
+		companies = append(companies, Company{Market: m.Name(), Code: section[1], Name: section[3], Exchange: "HKEX", Currency: "HKD"})
 	}
 
 	if len(companies) == 0 {
@@ -73,10 +78,36 @@ func (m HongKong) parseHtml(html string) ([]Company, error) {
 
 //	抓取
 func (m HongKong) Crawl(code string, day time.Time) (string, error) {
-	queryCode := code[1:] + ".HK"
-	if code[:1] != "0" {
-		queryCode = code + ".HK"
+	return m.CrawlInterval(code, day, Interval1m)
+}
+
+//	按指定间隔抓取
+func (m HongKong) CrawlInterval(code string, day time.Time, interval Interval) (string, error) {
+	return m.CrawlContext(context.Background(), code, day, interval)
+}
+
+//	按指定间隔抓取,ctx取消或超时时中止
+func (m HongKong) CrawlContext(ctx context.Context, code string, day time.Time, interval Interval) (string, error) {
+
+	queryCode, err := hongKongSymbol(code)
+	if err != nil {
+		return "", err
+	}
+
+	return downloadCompanyDaily(ctx, m, code, queryCode, day, interval)
+}
+
+//	雅虎财经的香港股票代码(去掉前导0后补足4位,如00700为0700.HK)
+func hongKongSymbol(code string) (string, error) {
+
+	if symbol, ok := indexSymbol(code); ok {
+		return symbol, nil
+	}
+
+	number, err := strconv.Atoi(code)
+	if err != nil || number <= 0 {
+		return "", fmt.Errorf("错误的香港上市公司代码:%s", code)
 	}
 
-	return downloadCompanyDaily(m, code, queryCode, day)
+	return fmt.Sprintf("%04d.HK", number), nil
 }
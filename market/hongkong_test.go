@@ -0,0 +1,61 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHongKongSymbol(t *testing.T) {
+
+	cases := map[string]string{
+		"00700": "0700.HK",
+		"00005": "0005.HK",
+		"08001": "8001.HK",
+		"80737": "80737.HK",
+	}
+
+	for code, expected := range cases {
+		symbol, err := hongKongSymbol(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if symbol != expected {
+			t.Errorf("%s的雅虎代码应为%s,实际%s", code, expected, symbol)
+		}
+	}
+
+	for _, code := range []string{"", "HK700", "00000"} {
+		_, err := hongKongSymbol(code)
+		if err == nil {
+			t.Errorf("错误的代码%s应该返回错误", code)
+		}
+	}
+}
+
+func TestParseHongKongSessions(t *testing.T) {
+
+	//	午间休市分成两段,休市期间的数据不属于任何交易时段
+	full := []byte(`{"chart":{"result":[{"meta":{"tradingPeriods":{"pre":[[{"start":0,"end":10}]],"regular":[[{"start":10,"end":20},{"start":30,"end":40}]],"post":[[{"start":40,"end":50}]]}},"timestamp":[12,25,35,45],"indicators":{"quote":[{"open":[1,1,1,1],"close":[1,1,1,1],"high":[1,1,1,1],"low":[1,1,1,1],"volume":[1,1,1,1]}]}}],"error":null}}`)
+
+	result, err := processDailyYahooJson(HongKong{}, "00700", time.Unix(0, 0), full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Regular) != 2 || len(result.Post) != 1 {
+		t.Errorf("午间休市前后应有2条常规交易时段数据,实际%d条", len(result.Regular))
+	}
+
+	//	节假日前只有半天交易
+	half := []byte(`{"chart":{"result":[{"meta":{"tradingPeriods":{"pre":[[{"start":0,"end":10}]],"regular":[[{"start":10,"end":20}]],"post":[[{"start":20,"end":20}]]}},"timestamp":[12,15],"indicators":{"quote":[{"open":[1,1],"close":[1,1],"high":[1,1],"low":[1,1],"volume":[1,1]}]}}],"error":null}}`)
+
+	result, err = processDailyYahooJson(HongKong{}, "00700", time.Unix(0, 0), half)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success || len(result.Regular) != 2 {
+		t.Errorf("半天交易应有2条常规交易时段数据,实际%d条", len(result.Regular))
+	}
+}
@@ -0,0 +1,43 @@
+package market
+
+import (
+	"time"
+)
+
+//	任务进度的回调(会在多个抓取goroutine中同时调用,实现需要保证并发安全)
+type Hook interface {
+	//	开始处理上市公司
+	OnCompanyStart(market Market, company Company)
+	//	上市公司处理结束(err为nil表示没有失败)
+	OnCompanyDone(market Market, company Company, err error)
+	//	上市公司某日某个间隔处理结束(已经处理过的result和err都为nil)
+	OnDayDone(market Market, company Company, day time.Time, interval Interval, result *ParseResult, err error)
+}
+
+//	关心上市公司列表变化的回调(Hook同时实现该接口时调用)
+type ListingHook interface {
+	//	更新上市公司列表后有新上市或退市的上市公司
+	OnListingChanged(market Market, changes []ListingChange)
+}
+
+//	不做任何事的回调(可以嵌入到只关心部分事件的实现中)
+type NopHook struct{}
+
+func (h NopHook) OnCompanyStart(market Market, company Company) {}
+
+func (h NopHook) OnCompanyDone(market Market, company Company, err error) {}
+
+func (h NopHook) OnDayDone(market Market, company Company, day time.Time, interval Interval, result *ParseResult, err error) {
+}
+
+//	当前使用的回调
+var hook Hook = NopHook{}
+
+//	设置任务进度的回调(传入nil则不回调)
+func SetHook(h Hook) {
+	if h == nil {
+		h = NopHook{}
+	}
+
+	hook = h
+}
@@ -0,0 +1,236 @@
+package market
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	发送http请求(*http.Client实现了该接口)
+type Doer interface {
+	Do(request *http.Request) (*http.Response, error)
+}
+
+var (
+	httpClient  Doer
+	httpHeaders http.Header
+	httpMutex   sync.Mutex
+	//	各个市场单独设置的http客户端
+	marketClients = make(map[string]Doer)
+)
+
+//	没有配置User-Agent时轮流使用的浏览器User-Agent(雅虎财经会拒绝Go默认的User-Agent)
+var defaultUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+}
+
+//	下一个使用的默认User-Agent
+var userAgentIndex uint32
+
+//	设置抓取时使用的http客户端(为nil时按配置创建)
+func SetHTTPClient(client Doer) {
+	httpMutex.Lock()
+	defer httpMutex.Unlock()
+
+	httpClient = client
+}
+
+//	设置某个市场使用的http客户端,包括抓取分时数据和更新上市公司列表(为nil时使用默认客户端)
+func SetMarketHTTPClient(marketName string, client Doer) {
+	httpMutex.Lock()
+	defer httpMutex.Unlock()
+
+	if client == nil {
+		delete(marketClients, marketName)
+		return
+	}
+
+	marketClients[marketName] = client
+}
+
+//	设置每个请求默认附加的header(如User-Agent)
+func SetHTTPHeaders(headers http.Header) {
+	httpMutex.Lock()
+	defer httpMutex.Unlock()
+
+	httpHeaders = headers
+}
+
+//	获取市场的http客户端和默认header,没有设置时按配置创建
+func getHTTPClient(marketName string) (Doer, http.Header, error) {
+	httpMutex.Lock()
+	defer httpMutex.Unlock()
+
+	if httpClient == nil {
+		client, err := newHTTPClient(config.Get())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		httpClient = client
+	}
+
+	if httpHeaders == nil {
+		httpHeaders = http.Header{}
+		if config.Get().UserAgent != "" {
+			httpHeaders.Set("User-Agent", config.Get().UserAgent)
+		}
+	}
+
+	if client, found := marketClients[marketName]; found {
+		return client, httpHeaders, nil
+	}
+
+	return httpClient, httpHeaders, nil
+}
+
+//	选择User-Agent:优先使用SetHTTPHeaders或配置中指定的,没有时轮流使用默认的浏览器User-Agent
+func pickUserAgent() string {
+
+	httpMutex.Lock()
+	headers := httpHeaders
+	httpMutex.Unlock()
+
+	if headers != nil && headers.Get("User-Agent") != "" {
+		return headers.Get("User-Agent")
+	}
+
+	if config.Get().UserAgent != "" {
+		return config.Get().UserAgent
+	}
+
+	index := atomic.AddUint32(&userAgentIndex, 1) - 1
+
+	return defaultUserAgents[int(index)%len(defaultUserAgents)]
+}
+
+//	按配置创建http客户端
+func newHTTPClient(c *config.Config) (*http.Client, error) {
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if c.HTTPProxy != "" {
+		proxy, err := url.Parse(c.HTTPProxy)
+		if err != nil {
+			return nil, fmt.Errorf("错误的http代理地址%s:%s", c.HTTPProxy, err.Error())
+		}
+
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport, Timeout: time.Second * time.Duration(c.HTTPTimeout)}, nil
+}
+
+//	创建Get请求(附加默认header和指定的header)
+func newGetRequest(marketName, url string, header http.Header) (Doer, *http.Request, error) {
+
+	client, headers, err := getHTTPClient(marketName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, h := range []http.Header{headers, header} {
+		for key, values := range h {
+			request.Header.Del(key)
+			for _, value := range values {
+				request.Header.Add(key, value)
+			}
+		}
+	}
+
+	if request.Header.Get("User-Agent") == "" {
+		request.Header.Set("User-Agent", pickUserAgent())
+	}
+
+	return client, request, nil
+}
+
+//	给请求加上超时(包括读取响应内容),注入的http客户端没有设置超时时也不会一直等待
+func withTimeout(request *http.Request) (*http.Request, context.CancelFunc) {
+
+	ctx, cancel := context.WithTimeout(request.Context(), time.Second*time.Duration(config.Get().HTTPTimeout))
+
+	return request.WithContext(ctx), cancel
+}
+
+//	Get请求(超时等错误由调用方重试)
+func httpGet(marketName, url string, header http.Header) (int, string, error) {
+	return httpGetContext(context.Background(), marketName, url, header)
+}
+
+//	Get请求,ctx取消或超时时中止
+func httpGetContext(ctx context.Context, marketName, url string, header http.Header) (int, string, error) {
+
+	client, request, err := newGetRequest(marketName, url, header)
+	if err != nil {
+		return 0, "", err
+	}
+
+	request, cancel := withTimeout(request.WithContext(ctx))
+	defer cancel()
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	buffer, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response.StatusCode, "", err
+	}
+
+	return response.StatusCode, string(buffer), nil
+}
+
+//	下载网页内容,失败时重试(referer为空时不发送Referer)
+func downloadString(marketName, url, referer string) (string, error) {
+
+	body, _, err := downloadTraced(marketName, url, referer)
+
+	return body, err
+}
+
+//	下载网页内容,同时返回最后一次请求的状态码和请求次数
+func downloadTraced(marketName, url, referer string) (string, downloadTrace, error) {
+
+	header := http.Header{}
+	if referer != "" {
+		header.Set("Referer", referer)
+	}
+
+	settings := getSettings(marketName)
+
+	var err error
+	trace := downloadTrace{}
+	for index := 0; index < settings.downloadRetries; index++ {
+		status, body, e := httpGet(marketName, url, header)
+		trace.Status, trace.Attempts = status, index+1
+		switch {
+		case e != nil:
+			err = e
+		case status == http.StatusOK:
+			return body, trace, nil
+		default:
+			err = fmt.Errorf("下载%s时出错,HTTP状态码%d", url, status)
+		}
+
+		time.Sleep(settings.downloadRetryInterval)
+	}
+
+	return "", trace, err
+}
@@ -0,0 +1,288 @@
+package market
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	记录请求次数的http客户端
+type countingDoer struct {
+	client *http.Client
+	count  int
+}
+
+func (d *countingDoer) Do(request *http.Request) (*http.Response, error) {
+	d.count++
+	return d.client.Do(request)
+}
+
+func TestInjectedHTTPClient(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "stockrecorder-test" {
+			http.Error(w, "错误的User-Agent:"+r.Header.Get("User-Agent"), http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte(mockYahooJson))
+	}))
+	defer server.Close()
+
+	doer := &countingDoer{client: server.Client()}
+	SetHTTPClient(doer)
+	SetHTTPHeaders(http.Header{"User-Agent": []string{"stockrecorder-test"}})
+	defer SetHTTPClient(nil)
+	defer SetHTTPHeaders(nil)
+
+	defer useYahooServer(server.URL)()
+
+	json, err := America{}.Crawl("AAPL", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if json != mockYahooJson {
+		t.Errorf("返回的内容不正确:%s", json)
+	}
+
+	if doer.count == 0 {
+		t.Errorf("应该使用注入的http客户端发送请求")
+	}
+}
+
+//	使用测试服务器代替雅虎财经,返回恢复原设置的函数
+func useYahooServer(url string) func() {
+
+	host, cookieURL := yahooHost, yahooCookieURL
+	yahooHost, yahooCookieURL = url, url+"/cookie"
+	resetYahooSession(getYahooSessionCache())
+
+	return func() {
+		yahooHost, yahooCookieURL = host, cookieURL
+		resetYahooSession(getYahooSessionCache())
+	}
+}
+
+func getYahooSessionCache() *yahooSession {
+	yahooSessionMutex.Lock()
+	defer yahooSessionMutex.Unlock()
+
+	return yahooSessionCache
+}
+
+func TestYahooCrumb(t *testing.T) {
+
+	crumbs, unauthorized := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cookie":
+			http.SetCookie(w, &http.Cookie{Name: "A3", Value: "session"})
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/test/getcrumb":
+			if r.Header.Get("Cookie") != "A3=session" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			crumbs++
+			fmt.Fprintf(w, "crumb%d", crumbs)
+		default:
+			//	第一个crumb模拟已经失效
+			if r.URL.Query().Get("crumb") != "crumb2" || r.Header.Get("Cookie") != "A3=session" {
+				unauthorized++
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.Write([]byte(mockYahooJson))
+		}
+	}))
+	defer server.Close()
+
+	SetHTTPClient(server.Client())
+	defer SetHTTPClient(nil)
+	defer useYahooServer(server.URL)()
+
+	json, err := America{}.Crawl("AAPL", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if json != mockYahooJson {
+		t.Errorf("返回的内容不正确:%s", json)
+	}
+
+	if crumbs != 2 || unauthorized != 1 {
+		t.Errorf("crumb失效后应该重新获取1次,实际获取%d次,被拒绝%d次", crumbs, unauthorized)
+	}
+}
+
+func TestHTTPGetReferer(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Referer")))
+	}))
+	defer server.Close()
+
+	SetHTTPClient(server.Client())
+	defer SetHTTPClient(nil)
+
+	text, err := downloadString("", server.URL, "http://www.sse.com.cn/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text != "http://www.sse.com.cn/" {
+		t.Errorf("应该发送Referer,实际:%s", text)
+	}
+}
+
+//	把所有请求转发到测试服务器的http客户端
+type rewriteDoer struct {
+	server *httptest.Server
+	count  int
+}
+
+func (d *rewriteDoer) Do(request *http.Request) (*http.Response, error) {
+	d.count++
+
+	target, err := url.Parse(d.server.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	request.URL.Scheme, request.URL.Host = target.Scheme, target.Host
+
+	return d.server.Client().Do(request)
+}
+
+//	默认客户端,不应被使用
+type failingDoer struct{}
+
+func (d failingDoer) Do(request *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("不应使用默认客户端请求%s", request.URL)
+}
+
+func TestMarketHTTPClient(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "companies-by-industry") {
+			w.Write([]byte("Symbol,Name\nAAPL,Apple Inc.\n"))
+			return
+		}
+
+		w.Write([]byte(mockYahooJson))
+	}))
+	defer server.Close()
+
+	SetHTTPClient(failingDoer{})
+	defer SetHTTPClient(nil)
+
+	doer := &rewriteDoer{server: server}
+	SetMarketHTTPClient(America{}.Name(), doer)
+	defer SetMarketHTTPClient(America{}.Name(), nil)
+
+	defer useYahooServer(server.URL)()
+
+	//	更新上市公司列表
+	companies, err := America{}.Companies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(companies) == 0 || companies[0].Code != "AAPL" {
+		t.Errorf("上市公司列表不正确:%+v", companies)
+	}
+
+	if doer.count != 3 {
+		t.Errorf("更新上市公司列表应使用市场的http客户端请求3次,实际%d次", doer.count)
+	}
+
+	//	抓取分时数据
+	json, err := America{}.Crawl("AAPL", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if json != mockYahooJson {
+		t.Errorf("返回的内容不正确:%s", json)
+	}
+}
+
+func TestHTTPGetTimeout(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second * 3):
+			w.Write([]byte(mockYahooJson))
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	//	不带超时的客户端
+	SetHTTPClient(&http.Client{})
+	defer SetHTTPClient(nil)
+
+	timeout := config.Get().HTTPTimeout
+	config.Get().HTTPTimeout = 1
+	defer func() { config.Get().HTTPTimeout = timeout }()
+
+	start := time.Now()
+	_, _, err := httpGet("", server.URL, nil)
+	if err == nil {
+		t.Fatal("超时后应该返回错误")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("应该在1秒左右超时,实际%s", elapsed)
+	}
+}
+
+func TestYahooForbiddenRotatesUserAgent(t *testing.T) {
+
+	agents := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cookie":
+			http.SetCookie(w, &http.Cookie{Name: "A3", Value: "session"})
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/test/getcrumb":
+			fmt.Fprint(w, "crumb")
+		default:
+			//	第一个User-Agent模拟被拒绝
+			agents = append(agents, r.Header.Get("User-Agent"))
+			if len(agents) == 1 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.Write([]byte(mockYahooJson))
+		}
+	}))
+	defer server.Close()
+
+	SetHTTPClient(server.Client())
+	defer SetHTTPClient(nil)
+	defer useYahooServer(server.URL)()
+
+	json, err := America{}.Crawl("AAPL", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if json != mockYahooJson {
+		t.Errorf("返回的内容不正确:%s", json)
+	}
+
+	if len(agents) != 2 || agents[0] == agents[1] || !strings.HasPrefix(agents[1], "Mozilla/5.0") {
+		t.Errorf("被拒绝后应该更换浏览器User-Agent重新获取crumb,实际%v", agents)
+	}
+}
@@ -0,0 +1,263 @@
+package market
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	导出进度中InfluxDB的名称
+	influxTarget = "influx"
+	//	写入InfluxDB失败时的重试次数
+	influxRetries = 3
+)
+
+//	写入InfluxDB失败时重试的间隔(每次递增)
+var influxRetryInterval = time.Second * 5
+
+//	InfluxDB行协议中标签需要转义的字符
+var influxTagEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+//	一条分时数据的行协议(分时数据按市场当地时间保存,时间戳换算成纳秒)
+func influxLine(market Market, location *time.Location, session string, p Peroid60) string {
+
+	t := time.Date(p.Time.Year(), p.Time.Month(), p.Time.Day(), p.Time.Hour(), p.Time.Minute(), p.Time.Second(), 0, location)
+
+	return fmt.Sprintf("stock,market=%s,company=%s,session=%s o=%s,h=%s,l=%s,c=%s,volume=%di %d",
+		influxTagEscaper.Replace(market.Name()),
+		influxTagEscaper.Replace(p.Code),
+		session,
+		formatPrice(p.Open),
+		formatPrice(p.High),
+		formatPrice(p.Low),
+		formatPrice(p.Close),
+		p.Volume,
+		t.UnixNano())
+}
+
+//	按InfluxDB行协议写入上市公司指定日期范围内所有交易时段的分时数据,返回行数
+func WriteInflux(w io.Writer, market Market, company string, from, to time.Time) (int, error) {
+
+	location := locationYesterdayZero(market).Location()
+
+	count := 0
+	for _, session := range sessions {
+		peroids, err := LoadPeriodsRange(market, company, from, to, session)
+		if err != nil {
+			return count, err
+		}
+
+		for _, p := range peroids {
+			_, err = io.WriteString(w, influxLine(market, location, session, p)+"\n")
+			if err != nil {
+				return count, err
+			}
+
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+//	增量导出市场所有上市公司上次导出以来到to为止的分时数据,返回行数(运行期间锁定数据目录)
+//	w实现了Flush() error时每家上市公司写完后调用,成功后才更新导出进度
+func ExportInflux(marketName string, w io.Writer, to time.Time) (int, error) {
+
+	err := Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return 0, fmt.Errorf("[Influx]\t未能找到市场%s", marketName)
+	}
+
+	return exportInflux(market, w, to)
+}
+
+//	增量导出市场所有上市公司的分时数据
+func exportInflux(market Market, w io.Writer, to time.Time) (int, error) {
+
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return 0, err
+	}
+
+	flusher, _ := w.(interface {
+		Flush() error
+	})
+
+	total := 0
+	for _, company := range companies {
+		watermark, err := store.LoadExportWatermark(market, company.Code, influxTarget)
+		if err != nil {
+			return total, err
+		}
+
+		//	没有导出过时导出全部
+		var from time.Time
+		if !watermark.IsZero() {
+			if !watermark.Before(to) {
+				continue
+			}
+
+			from = watermark.AddDate(0, 0, 1)
+		}
+
+		count, err := WriteInflux(w, market, company.Code, from, to)
+		if err != nil {
+			return total, err
+		}
+
+		if flusher != nil {
+			err = flusher.Flush()
+			if err != nil {
+				return total, err
+			}
+		}
+
+		total += count
+
+		//	试运行时不记录导出进度
+		if isDryRun() {
+			continue
+		}
+
+		err = store.SaveExportWatermark(market, company.Code, influxTarget, to)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+//	每日任务结束后导出到InfluxDB(没有配置InfluxURL时忽略)
+func exportInfluxAfterDailyTask(market Market, day time.Time) {
+
+	c := config.Get()
+	if c.InfluxURL == "" {
+		return
+	}
+
+	count, err := exportInflux(market, NewInfluxWriter(c.InfluxURL, c.InfluxToken, c.InfluxBatchSize), day)
+	if err != nil {
+		logger.Error("导出到InfluxDB时出错", "market", market.Name(), "day", day.Format("20060102"), "error", err)
+		return
+	}
+
+	logger.Info("导出到InfluxDB-完成", "market", market.Name(), "day", day.Format("20060102"), "lines", count)
+}
+
+//	按批写入InfluxDB的HTTP接口(失败时重试),写完后需要调用Flush
+type InfluxWriter struct {
+	url       string
+	token     string
+	batchSize int
+	client    *http.Client
+	buffer    []byte
+	lines     int
+}
+
+//	创建InfluxDB写入(url为完整的写入地址,如http://localhost:8086/write?db=stock或http://localhost:8086/api/v2/write?org=o&bucket=stock)
+func NewInfluxWriter(url, token string, batchSize int) *InfluxWriter {
+
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	return &InfluxWriter{url: url, token: token, batchSize: batchSize, client: &http.Client{Timeout: time.Second * time.Duration(config.Get().HTTPTimeout)}}
+}
+
+//	缓存行协议,满一批时写入
+func (w *InfluxWriter) Write(p []byte) (int, error) {
+
+	w.buffer = append(w.buffer, p...)
+	w.lines += bytes.Count(p, []byte("\n"))
+
+	if w.lines >= w.batchSize {
+		err := w.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+//	写入缓存中完整的行
+func (w *InfluxWriter) Flush() error {
+
+	end := bytes.LastIndexByte(w.buffer, '\n')
+	if end < 0 {
+		return nil
+	}
+
+	err := w.post(w.buffer[:end+1])
+	if err != nil {
+		return err
+	}
+
+	w.buffer = append(w.buffer[:0], w.buffer[end+1:]...)
+	w.lines = 0
+
+	return nil
+}
+
+//	发送一批行协议(网络错误、429和5xx时重试)
+func (w *InfluxWriter) post(body []byte) error {
+
+	var err error
+	for attempt := 1; attempt <= influxRetries; attempt++ {
+		var retry bool
+		retry, err = w.send(body)
+		if err == nil || !retry {
+			return err
+		}
+
+		if attempt < influxRetries {
+			time.Sleep(influxRetryInterval * time.Duration(attempt))
+		}
+	}
+
+	return err
+}
+
+//	发送一次请求,返回失败时是否可以重试
+func (w *InfluxWriter) send(body []byte) (bool, error) {
+
+	request, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		request.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, fmt.Errorf("[Influx]\t写入%s出错:%s", w.url, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	message, _ := ioutil.ReadAll(response.Body)
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+
+	return retry, fmt.Errorf("[Influx]\t写入%s出错,HTTP状态码%d:%s", w.url, response.StatusCode, strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,128 @@
+package market
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportInflux(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockInflux", "AAA")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	first := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	second := first.AddDate(0, 0, 1)
+
+	err := store.SaveCompanies(market, market.companies, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, day := range []time.Time{first, second} {
+		tx, err := store.Begin(market, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, &ParseResult{
+			Success: true,
+			Pre:     []Peroid60{{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour * 8), Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 10}},
+			Regular: []Peroid60{{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour * 10), Open: 1.5, High: 3, Low: 1, Close: 2.25, Volume: 100}}})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	count, err := ExportInflux(market.Name(), buffer, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	分时数据按市场当地时间(UTC)保存
+	timestamp := time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC).UnixNano()
+	expected := fmt.Sprintf("stock,market=MockInflux,company=AAA,session=regular o=1.5,h=3,l=1,c=2.25,volume=100i %d", timestamp)
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if count != 2 || len(lines) != 2 || lines[1] != expected {
+		t.Fatalf("应导出2行,第2行为%s,实际%d行:%v", expected, count, lines)
+	}
+
+	//	已经导出过的日期不再导出
+	buffer.Reset()
+	count, err = ExportInflux(market.Name(), buffer, first)
+	if err != nil || count != 0 {
+		t.Errorf("再次导出应为0行,实际%d行,%v", count, err)
+	}
+
+	count, err = ExportInflux(market.Name(), buffer, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 || !strings.Contains(buffer.String(), "session=pre") || strings.Contains(buffer.String(), fmt.Sprint(timestamp)) {
+		t.Errorf("应只导出第二天的2行,实际%d行:%s", count, buffer.String())
+	}
+}
+
+func TestInfluxWriter(t *testing.T) {
+
+	defer func(interval time.Duration) { influxRetryInterval = interval }(influxRetryInterval)
+	influxRetryInterval = 0
+
+	requests := 0
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		//	第一次请求失败,重试
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := NewInfluxWriter(server.URL+"/write?db=stock", "secret", 2)
+	for _, line := range []string{"a 1\n", "b 2\n", "c 3\n"} {
+		_, err := writer.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 3 || len(bodies) != 2 || bodies[0] != "a 1\nb 2\n" || bodies[1] != "c 3\n" {
+		t.Errorf("应按每批2行写入并重试1次,实际请求%d次:%q", requests, bodies)
+	}
+
+	//	4xx不重试
+	writer = NewInfluxWriter(server.URL+"/write?db=stock", "wrong", 1)
+	requests = 10
+	_, err = writer.Write([]byte("d 4\n"))
+	if err == nil || requests != 11 {
+		t.Errorf("401时应返回错误且不重试,实际请求%d次,%v", requests-10, err)
+	}
+}
@@ -0,0 +1,152 @@
+package market
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	分时数据的间隔
+type Interval string
+
+const (
+	Interval1m  Interval = "1m"
+	Interval2m  Interval = "2m"
+	Interval5m  Interval = "5m"
+	Interval15m Interval = "15m"
+	Interval1d  Interval = "1d"
+)
+
+//	支持的间隔
+var intervals = map[Interval]bool{Interval1m: true, Interval2m: true, Interval5m: true, Interval15m: true, Interval1d: true}
+
+//	解析间隔
+func ParseInterval(text string) (Interval, error) {
+
+	interval := Interval(text)
+	if !intervals[interval] {
+		return "", fmt.Errorf("错误的间隔%s", text)
+	}
+
+	return interval, nil
+}
+
+//	支持按指定间隔抓取的市场
+type IntervalCrawler interface {
+	CrawlInterval(code string, day time.Time, interval Interval) (string, error)
+}
+
+//	支持取消的市场(ctx超时或取消时中止正在进行的请求,不再重试)
+type ContextCrawler interface {
+	CrawlContext(ctx context.Context, code string, day time.Time, interval Interval) (string, error)
+}
+
+//	抓取超时(和其他抓取错误一样加入重试队列)
+type CrawlTimeoutError struct {
+	Market  string
+	Code    string
+	Day     time.Time
+	Timeout time.Duration
+}
+
+func (e *CrawlTimeoutError) Error() string {
+	return fmt.Sprintf("[%s]\t抓取%s在%s的数据超过%s,已放弃", e.Market, e.Code, e.Day.Format("20060102"), e.Timeout)
+}
+
+//	按指定间隔抓取(1m使用Market.Crawl),同时返回数据源记录的下载情况
+//	超过CrawlTimeout时返回*CrawlTimeoutError,正在关闭时返回ErrShutdown,释放抓取的工作者
+func crawlInterval(market Market, code string, day time.Time, interval Interval) (string, downloadTrace, error) {
+
+	//	数据源的代码(保存时仍使用上市公司列表的代码)
+	code = crawlSymbol(market, code)
+
+	_, isContext := market.(ContextCrawler)
+	_, isInterval := market.(IntervalCrawler)
+	if interval != Interval1m && !isContext && !isInterval {
+		return "", downloadTrace{}, fmt.Errorf("市场%s不支持按%s间隔抓取", market.Name(), interval)
+	}
+
+	timeout := time.Second * time.Duration(config.Get().CrawlTimeout)
+	//	关闭时取消
+	ctx, cancel := context.WithTimeout(shutdownContext(), timeout)
+	defer cancel()
+
+	raw, err := crawlContext(ctx, market, code, day, interval)
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		raw, err = "", &CrawlTimeoutError{market.Name(), code, day, timeout}
+	case context.Canceled:
+		raw, err = "", ErrShutdown
+	}
+
+	//	数据源记录的下载情况(没有记录时为零值)
+	return raw, takeDownload(market.Name(), code, day, interval), err
+}
+
+//	在ctx结束前抓取
+//	不支持取消的市场在单独的goroutine中抓取,超时后不再等待(请求结束前goroutine仍然存在)
+func crawlContext(ctx context.Context, market Market, code string, day time.Time, interval Interval) (string, error) {
+
+	if crawler, ok := market.(ContextCrawler); ok {
+		return crawler.CrawlContext(ctx, code, day, interval)
+	}
+
+	type crawlResult struct {
+		raw string
+		err error
+		//	抓取时的panic,由调用方重新panic(由companyTransaction恢复)
+		panic interface{}
+	}
+
+	done := make(chan crawlResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- crawlResult{panic: r}
+			}
+		}()
+
+		var result crawlResult
+		if interval == Interval1m {
+			result.raw, result.err = market.Crawl(code, day)
+		} else {
+			result.raw, result.err = market.(IntervalCrawler).CrawlInterval(code, day, interval)
+		}
+
+		done <- result
+	}()
+
+	select {
+	case result := <-done:
+		if result.panic != nil {
+			panic(result.panic)
+		}
+
+		return result.raw, result.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+//	每日任务和历史任务需要抓取的间隔(没有配置时只抓取1m)
+func crawlIntervals() []Interval {
+
+	list := make([]Interval, 0, len(config.Get().Intervals))
+	for _, text := range config.Get().Intervals {
+		interval, err := ParseInterval(text)
+		if err != nil {
+			logger.Warn("配置中的间隔不正确,已忽略", "interval", text)
+			continue
+		}
+
+		list = append(list, interval)
+	}
+
+	if len(list) == 0 {
+		list = append(list, Interval1m)
+	}
+
+	return list
+}
@@ -0,0 +1,140 @@
+package market
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestIntervalStoredSeparately(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockInterval", "AAA")
+	defer cleanup()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	bar := Peroid60{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour * 10), Open: 1, Close: 1, High: 1, Low: 1, Volume: 1}
+
+	tx, err := store.BeginInterval(market, "AAA", Interval5m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.SavePeriod("regular", []Peroid60{bar})
+	if err == nil {
+		err = tx.MarkProcessed(day, true)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadIntervalPeriodsRange(market, "AAA", Interval5m, day, day, "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 {
+		t.Errorf("5m应有1条数据,实际%d条", len(list))
+	}
+
+	list, err = LoadPeriods(market, "AAA", day, "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 0 {
+		t.Errorf("1m不应有数据,实际%d条", len(list))
+	}
+
+	//	不支持按间隔抓取的市场
+	_, err = companyIntervalTransaction(market, market.companies[0], day.AddDate(0, 0, 1), Interval5m, false)
+	if err == nil {
+		t.Errorf("不支持按间隔抓取的市场应该返回错误")
+	}
+}
+
+func TestCrawlTimeout(t *testing.T) {
+
+	previous := config.Get()
+	defer config.Set(previous)
+
+	err := config.Set(&config.Config{DataDir: previous.DataDir, CrawlTimeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	分时数据的请求一直不返回,直到客户端取消
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/test/getcrumb" {
+			w.Write([]byte("crumb"))
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/v8/") {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second * 10):
+			}
+		}
+	}))
+	defer server.Close()
+
+	SetHTTPClient(server.Client())
+	defer SetHTTPClient(nil)
+	defer useYahooServer(server.URL)()
+
+	start := time.Now()
+	_, _, err = crawlInterval(America{}, "AAA", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Interval1m)
+	if _, ok := err.(*CrawlTimeoutError); !ok {
+		t.Errorf("应返回*CrawlTimeoutError,实际%v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*3 {
+		t.Errorf("超时后应立即返回,实际用时%s", elapsed)
+	}
+}
+
+func TestCrawlTimeoutRetried(t *testing.T) {
+
+	previous := config.Get()
+	defer config.Set(previous)
+
+	err := config.Set(&config.Config{DataDir: previous.DataDir, CrawlTimeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock, cleanup := newMockMarket(t, "MockCrawlTimeout", "AAA")
+	defer cleanup()
+
+	//	不支持取消的市场超时后也释放工作者
+	var active, maxActive int32
+	market := slowMarket{mock, time.Second * 3, &active, &maxActive}
+	day := locationYesterdayZero(market)
+
+	start := time.Now()
+	_, err = companyMinuteTask(market, market.companies[0], day)
+	if _, ok := err.(*CrawlTimeoutError); !ok {
+		t.Errorf("应返回*CrawlTimeoutError,实际%v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("超时后应立即返回,实际用时%s", elapsed)
+	}
+
+	//	超时和其他抓取错误一样加入重试队列
+	entries, err := store.RetryEntries(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Company != "AAA" {
+		t.Errorf("超时的抓取应加入重试队列,实际%+v", entries)
+	}
+}
@@ -0,0 +1,204 @@
+package market
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+const (
+	//	默认的东京证券交易所上市公司列表文件名(在数据目录的Japan目录下)
+	japanCompaniesFileName = "japan_companies.csv"
+)
+
+//	东京证券交易所股票代码(4位,2024年起第2、4位可以是字母,如130A)
+var japanCodeRegex = regexp.MustCompile(`^[0-9][0-9A-Z][0-9][0-9A-Z]$`)
+
+//	日本证券市场(东京证券交易所)
+type Japan struct{}
+
+func (m Japan) Name() string {
+	return "Japan"
+}
+
+func (m Japan) Timezone() string {
+	return "Asia/Tokyo"
+}
+
+//	收盘时间(2024年11月起15点30分收盘)
+func (m Japan) ClosingTime() (int, int) {
+	return 15, 30
+}
+
+//	更新上市公司列表
+func (m Japan) Companies() ([]Company, error) {
+
+	source := config.Get().JapanCompanyList
+	if source == "" {
+		source = filepath.Join(marketDir(m), japanCompaniesFileName)
+	}
+
+	var buffer []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		//	从网络获取上市公司列表
+		text, err := downloadString(m.Name(), source, "")
+		if err != nil {
+			return nil, err
+		}
+
+		buffer = []byte(text)
+	} else {
+		//	读取本地的上市公司列表
+		b, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("[Japan]\t读取东京证券交易所上市公司列表%s出错:%s", source, err.Error())
+		}
+
+		buffer = b
+	}
+
+	companies, err := m.parseCSV(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	//	按Code排序
+	sort.Sort(CompanyList(companies))
+
+	return companies, nil
+}
+
+//	解析东京证券交易所上市公司列表(Shift-JIS编码,根据表头确定列的位置,只保留内国株式)
+func (m Japan) parseCSV(buffer []byte) ([]Company, error) {
+
+	//	Shift-JIS转UTF-8
+	decoded, err := ioutil.ReadAll(transform.NewReader(bytes.NewReader(buffer), japanese.ShiftJIS.NewDecoder()))
+	if err != nil {
+		return nil, fmt.Errorf("[Japan]\t转换Shift-JIS编码出错:%s", err.Error())
+	}
+
+	reader := csv.NewReader(bytes.NewReader(decoded))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("[Japan]\t解析东京证券交易所上市公司列表出错:%s", err.Error())
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("[Japan]\t东京证券交易所上市公司列表为空")
+	}
+
+	//	根据表头确定列的位置
+	codeIndex, nameIndex, sectionIndex, sectorIndex, industryIndex := -1, -1, -1, -1, -1
+	for index, title := range records[0] {
+		switch strings.TrimSpace(title) {
+		case "コード":
+			codeIndex = index
+		case "銘柄名":
+			nameIndex = index
+		case "市場・商品区分":
+			sectionIndex = index
+		case "17業種区分":
+			sectorIndex = index
+		case "33業種区分":
+			industryIndex = index
+		}
+	}
+
+	if codeIndex < 0 || nameIndex < 0 {
+		return nil, fmt.Errorf("[Japan]\t东京证券交易所上市公司列表缺少コード或銘柄名列:%v", records[0])
+	}
+
+	companies := make([]Company, 0, len(records)-1)
+	for _, record := range records[1:] {
+		if len(record) <= codeIndex || len(record) <= nameIndex {
+			continue
+		}
+
+		//	忽略ETF、REIT等
+		if sectionIndex >= 0 && (len(record) <= sectionIndex || !strings.Contains(record[sectionIndex], "内国株式")) {
+			continue
+		}
+
+		code := strings.TrimSpace(record[codeIndex])
+		if !japanCodeRegex.MatchString(code) {
+			continue
+		}
+
+		companies = append(companies, Company{
+			Market:   m.Name(),
+			Code:     code,
+			Name:     strings.TrimSpace(record[nameIndex]),
+			Exchange: "TSE",
+			Currency: "JPY",
+			Sector:   japanField(record, sectorIndex),
+			Industry: japanField(record, industryIndex)})
+	}
+
+	if len(companies) == 0 {
+		return nil, fmt.Errorf("[Japan]\t东京证券交易所上市公司列表中没有股票")
+	}
+
+	return companies, nil
+}
+
+//	上市公司列表中的可选字段(没有该列或为"-"时为空)
+func japanField(record []string, index int) string {
+
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+
+	value := strings.TrimSpace(record[index])
+	if value == "-" {
+		return ""
+	}
+
+	return value
+}
+
+//	抓取
+func (m Japan) Crawl(code string, day time.Time) (string, error) {
+	return m.CrawlInterval(code, day, Interval1m)
+}
+
+//	按指定间隔抓取
+func (m Japan) CrawlInterval(code string, day time.Time, interval Interval) (string, error) {
+	return m.CrawlContext(context.Background(), code, day, interval)
+}
+
+//	按指定间隔抓取,ctx取消或超时时中止
+func (m Japan) CrawlContext(ctx context.Context, code string, day time.Time, interval Interval) (string, error) {
+
+	queryCode, err := japanSymbol(code)
+	if err != nil {
+		return "", err
+	}
+
+	return downloadCompanyDaily(ctx, m, code, queryCode, day, interval)
+}
+
+//	雅虎财经的东京股票代码(如7203为7203.T)
+func japanSymbol(code string) (string, error) {
+
+	if symbol, ok := indexSymbol(code); ok {
+		return symbol, nil
+	}
+
+	if !japanCodeRegex.MatchString(code) {
+		return "", fmt.Errorf("错误的东京上市公司代码:%s", code)
+	}
+
+	return code + ".T", nil
+}
@@ -0,0 +1,97 @@
+package market
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestJapanSymbol(t *testing.T) {
+
+	cases := map[string]string{
+		"7203": "7203.T",
+		"1301": "1301.T",
+		"130A": "130A.T",
+	}
+
+	for code, expected := range cases {
+		symbol, err := japanSymbol(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if symbol != expected {
+			t.Errorf("%s的雅虎代码应为%s,实际%s", code, expected, symbol)
+		}
+	}
+
+	for _, code := range []string{"", "720", "72030", "7203.T", "A203"} {
+		_, err := japanSymbol(code)
+		if err == nil {
+			t.Errorf("错误的代码%s应该返回错误", code)
+		}
+	}
+}
+
+func TestParseJapanCSV(t *testing.T) {
+
+	text := "日付,コード,銘柄名,市場・商品区分\n" +
+		"20240131,7203,トヨタ自動車,プライム（内国株式）\n" +
+		"20240131,130A,Ｖｅｒｉｔａｓ　Ｉｎ　Ｓｉｌｉｃｏ,グロース（内国株式）\n" +
+		"20240131,1305,ｉＦｒｅｅＥＴＦ　ＴＯＰＩＸ,ETF・ETN\n"
+
+	buffer, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	companies, err := Japan{}.parseCSV(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	ETF不算上市公司
+	if len(companies) != 2 {
+		t.Fatalf("应有2家上市公司,实际%d家", len(companies))
+	}
+
+	if c := companies[0]; c.Market != "Japan" || c.Code != "7203" || c.Name != "トヨタ自動車" {
+		t.Errorf("上市公司不正确:%+v", c)
+	}
+
+	_, err = Japan{}.parseCSV([]byte("a,b\n1,2\n"))
+	if err == nil {
+		t.Errorf("缺少コード和銘柄名列时应该返回错误")
+	}
+}
+
+func TestParseJapanLunchBreak(t *testing.T) {
+
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_7203_t.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := processDailyYahooJson(Japan{}, "7203", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	午间休市(11:30-12:30)没有数据不算错误
+	if !result.Success {
+		t.Fatalf("解析失败:%s", result.Message)
+	}
+
+	//	没有盘前盘后,休市时的null被忽略
+	if len(result.Pre) != 0 || len(result.Regular) != 6 || len(result.Post) != 0 {
+		t.Fatalf("盘前应为0条,常规应为6条,盘后应为0条,实际%d,%d,%d条", len(result.Pre), len(result.Regular), len(result.Post))
+	}
+
+	p := result.Regular[len(result.Regular)-1]
+	if p.Open != 2687 || p.Close != 2689 || p.High != 2690 || p.Low != 2686.5 || p.Volume != 402100 {
+		t.Errorf("分时数据不正确:%+v", p)
+	}
+}
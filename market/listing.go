@@ -0,0 +1,166 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	新上市
+	ListingListed = "listed"
+	//	退市
+	ListingDelisted = "delisted"
+)
+
+//	上市或退市记录
+type ListingChange struct {
+	Market string
+	Code   string
+	Name   string
+	Day    time.Time
+	//	ListingListed或ListingDelisted
+	Change string
+}
+
+//	指定日期以来的上市和退市记录(按日期排序)
+func ListingChanges(marketName string, since time.Time) ([]ListingChange, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Listing]\t未能找到市场%s", marketName)
+	}
+
+	return store.ListingChanges(market, since)
+}
+
+//	对比新的上市公司列表与保存过的上市公司,保存上市和退市记录,返回需要抓取的上市公司(包括退市未超过宽限期的)
+func updateListing(market Market, companies []Company, day time.Time) ([]Company, error) {
+
+	previous, err := store.LoadCompanies(market)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffCompanies(market, previous, companies, day)
+	listed, delisted := 0, 0
+	for _, change := range changes {
+		logger.Info("上市公司列表发生变化", "market", market.Name(), "company", change.Code, "name", change.Name, "change", change.Change)
+		if change.Change == ListingListed {
+			listed++
+		} else {
+			delisted++
+		}
+	}
+
+	if len(changes) > 0 {
+		logger.Info("上市公司列表的变化", "market", market.Name(), "listed", listed, "delisted", delisted)
+	}
+
+	//	保存上市公司信息并记录出现日期
+	err = store.SaveCompanies(market, companies, day)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.SaveListingChanges(market, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	//	通知上市公司列表的变化
+	if h, ok := hook.(ListingHook); ok && len(changes) > 0 {
+		h.OnListingChanged(market, changes)
+	}
+
+	//	退市未超过宽限期的继续抓取
+	list := make([]Company, len(companies))
+	copy(list, companies)
+
+	grace := day.AddDate(0, 0, -config.Get().DelistGraceDays)
+	for _, change := range changes {
+		if change.Change == ListingDelisted {
+			list = append(list, Company{Market: market.Name(), Code: change.Code, Name: change.Name})
+		}
+	}
+
+	for _, company := range previous {
+		if !company.Delisted.IsZero() && company.Delisted.After(grace) && !containsCompany(companies, company.Code) {
+			list = append(list, company)
+		}
+	}
+
+	return list, nil
+}
+
+//	对比保存过的上市公司和新的上市公司列表(第一次保存时不算新上市)
+func diffCompanies(market Market, previous, current []Company, day time.Time) []ListingChange {
+
+	changes := make([]ListingChange, 0)
+	if len(previous) == 0 {
+		return changes
+	}
+
+	//	仍在上市的(配置的指数和ETF不参与上市和退市检查)
+	listed := make(map[string]Company, len(previous))
+	for _, company := range previous {
+		if company.Delisted.IsZero() && company.Kind == "" {
+			listed[company.Code] = company
+		}
+	}
+
+	seen := make(map[string]bool, len(current))
+	for _, company := range current {
+		seen[company.Code] = true
+		if _, found := listed[company.Code]; !found && company.Kind == "" {
+			changes = append(changes, ListingChange{market.Name(), company.Code, company.Name, day, ListingListed})
+		}
+	}
+
+	for _, company := range previous {
+		if _, found := listed[company.Code]; found && !seen[company.Code] {
+			changes = append(changes, ListingChange{market.Name(), company.Code, company.Name, day, ListingDelisted})
+		}
+	}
+
+	return changes
+}
+
+//	上市公司列表中是否有指定代码
+func containsCompany(companies []Company, code string) bool {
+
+	for _, company := range companies {
+		if company.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+//	读取查询结果中的上市和退市记录(code, day, change, name)
+func scanListingChanges(market Market, rows *sql.Rows) ([]ListingChange, error) {
+
+	location := locationYesterdayZero(market).Location()
+
+	changes := make([]ListingChange, 0)
+	for rows.Next() {
+		change := ListingChange{Market: market.Name()}
+		var day string
+		err := rows.Scan(&change.Code, &day, &change.Change, &change.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		change.Day, err = time.ParseInLocation("20060102", day, location)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
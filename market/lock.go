@@ -0,0 +1,126 @@
+package market
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	数据目录下的进程锁文件
+	lockFileName = "stockrecorder.lock"
+)
+
+//	数据目录已经被其他进程锁定
+var ErrLocked = errors.New("数据目录正被其他进程使用")
+
+var (
+	//	本进程持有的锁文件及引用数
+	heldLock  *os.File
+	lockRefs  int
+	lockMutex sync.Mutex
+	//	锁文件中记录的进程已经退出时强制解除(只对Windows有效)
+	forceLock bool
+)
+
+//	设置是否强制解除失效的锁(Windows上异常退出后留下的锁文件,锁文件中记录的进程已经退出)
+//	Unix上的flock在进程退出时由内核释放,不会留下失效的锁,启用时返回错误
+func SetForceLock(enabled bool) error {
+
+	if enabled && !forceLockSupported {
+		return fmt.Errorf("[Lock]\t数据目录的锁在进程退出时自动释放,不支持强制解除(--force只对Windows有效)")
+	}
+
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	forceLock = enabled
+
+	return nil
+}
+
+//	锁定数据目录,避免两个进程同时写入同一批数据库(本进程已经持有时只增加引用数,需要同样次数的Unlock)
+//	其他进程持有时立即返回错误而不是等待,进程退出时操作系统自动释放
+func Lock() error {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	if heldLock != nil {
+		lockRefs++
+		return nil
+	}
+
+	path := filepath.Join(config.Get().DataDir, lockFileName)
+	file, err := acquireLock(path)
+	if err == ErrLocked && forceLock {
+		file, err = breakStaleLock(path)
+	}
+
+	if err == ErrLocked {
+		owner, _ := ioutil.ReadFile(path)
+		return fmt.Errorf("[Lock]\t%s:%s(%s)", ErrLocked.Error(), path, strings.TrimSpace(string(owner)))
+	}
+
+	if err != nil {
+		return fmt.Errorf("[Lock]\t锁定数据目录%s出错:%s", path, err.Error())
+	}
+
+	//	记录持有锁的进程,方便排查
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = fmt.Fprintf(file, "pid %d, 启动于%s\n", os.Getpid(), time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	if err != nil {
+		releaseLock(file)
+		return err
+	}
+
+	heldLock, lockRefs = file, 1
+
+	return nil
+}
+
+//	释放一次数据目录的锁,引用数为0时解锁(没有持有时忽略)
+func Unlock() error {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	if heldLock == nil {
+		return nil
+	}
+
+	lockRefs--
+	if lockRefs > 0 {
+		return nil
+	}
+
+	err := releaseLock(heldLock)
+	heldLock = nil
+
+	return err
+}
+
+//	锁文件中记录的进程ID(格式为"pid 123, 启动于...",无法读取时为0)
+func lockOwner(path string) int {
+
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	var pid int
+	_, err = fmt.Sscanf(string(buffer), "pid %d", &pid)
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
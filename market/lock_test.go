@@ -0,0 +1,87 @@
+package market
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestLock(t *testing.T) {
+
+	path := filepath.Join(config.Get().DataDir, lockFileName)
+
+	err := Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	同一进程可以重复锁定
+	err = Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Unlock()
+
+	//	其他实例无法锁定
+	_, err = acquireLock(path)
+	if err != ErrLocked {
+		t.Errorf("持有锁时其他实例应返回ErrLocked,实际%v", err)
+	}
+
+	Unlock()
+
+	//	其他实例持有锁时立即返回错误
+	other, err := acquireLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Lock()
+	if err == nil || !strings.Contains(err.Error(), ErrLocked.Error()) {
+		t.Errorf("其他实例持有锁时应返回错误,实际%v", err)
+	}
+
+	market := mockMarket{name: "MockLocked"}
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	for name, write := range map[string]func() error{
+		"RunOnce":         func() error { _, err := RunOnce(market); return err },
+		"Backfill":        func() error { return Backfill(market.Name(), day, day, nil) },
+		"Restore":         func() error { _, err := Restore(market.Name()); return err },
+		"ReprocessFailed": func() error { return ReprocessFailed(market, "AAA") },
+		"FetchCompanyDay": func() error { return FetchCompanyDay(ioutil.Discard, market.Name(), "AAA", day) },
+		"RepairGaps":      func() error { return RepairGaps(market.Name(), "AAA", day, day) },
+		"ExportInflux":    func() error { _, err := ExportInflux(market.Name(), ioutil.Discard, day); return err },
+		"Maintain":        func() error { return Maintain(market) },
+		"Prune":           func() error { return Prune(market, day) },
+		"Reparse":         func() error { return Reparse(market.Name(), "AAA", day, day) },
+		"ReprocessAll":    func() error { return ReprocessAll(market, day) },
+		"Reprocess":       func() error { return Reprocess(market.Name(), "AAA", day) },
+		"RestoreArchive":  func() error { _, err := RestoreArchive(market.Name(), "AAA", day, day); return err },
+		"PurgeRetryQueue": func() error { _, err := PurgeRetryQueue(market.Name(), false); return err },
+		"ArchiveBefore":   func() error { return ArchiveBefore(market.Name(), day) },
+		"Reactivate":      func() error { return ReactivateCompany(market.Name(), "AAA") },
+		"VerifyReset": func() error {
+			defer func() { config.Get().VerifyReset = false }()
+			config.Get().VerifyReset = true
+			_, err := Verify(market.Name(), "AAA", day, day)
+			return err
+		},
+	} {
+		err = write()
+		if err == nil || !strings.Contains(err.Error(), ErrLocked.Error()) {
+			t.Errorf("其他实例持有锁时%s应返回ErrLocked,实际%v", name, err)
+		}
+	}
+
+	releaseLock(other)
+
+	err = Lock()
+	if err != nil {
+		t.Errorf("其他实例释放后应能锁定:%v", err)
+	}
+	Unlock()
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package market
+
+import (
+	"os"
+	"syscall"
+)
+
+//	打开锁文件并加独占的flock(进程退出时自动释放,不会留下失效的锁)
+func acquireLock(path string) (*os.File, error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, ErrLocked
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+//	释放flock并关闭锁文件(保留文件,删除会让等待中的进程锁住不同的文件)
+func releaseLock(file *os.File) error {
+
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+//	flock在持有的进程退出时由内核释放,仍被锁定说明持有的进程还在运行(可能在其他PID命名空间、容器或主机上)
+//	不能按锁文件中记录的进程ID判断,也不能删除锁文件,否则两个实例会同时写入
+const forceLockSupported = false
+
+//	不支持强制解除(SetForceLock启用时返回错误),仍被锁定时返回ErrLocked
+func breakStaleLock(path string) (*os.File, error) {
+	return nil, ErrLocked
+}
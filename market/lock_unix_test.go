@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package market
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestForceLockUnsupported(t *testing.T) {
+
+	//	flock在进程退出时自动释放,不支持强制解除
+	err := SetForceLock(true)
+	if err == nil {
+		SetForceLock(false)
+		t.Fatal("Unix上启用强制解除时应该返回错误")
+	}
+
+	err = SetForceLock(false)
+	if err != nil {
+		t.Errorf("关闭强制解除时不应返回错误:%v", err)
+	}
+}
+
+func TestStaleLockFile(t *testing.T) {
+
+	path := filepath.Join(config.Get().DataDir, lockFileName)
+
+	//	异常退出后留下的锁文件没有被flock,不需要强制解除就能锁定
+	err := ioutil.WriteFile(path, []byte("pid 999999, 启动于2024-01-05 09:30:00\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Lock()
+	if err != nil {
+		t.Fatalf("留下的锁文件没有被锁定时应能锁定:%v", err)
+	}
+
+	if owner := lockOwner(path); owner != os.Getpid() {
+		t.Errorf("锁定后锁文件应记录本进程,实际%d", owner)
+	}
+
+	Unlock()
+}
@@ -0,0 +1,63 @@
+//go:build windows
+// +build windows
+
+package market
+
+import (
+	"os"
+)
+
+//	异常退出后留下的锁文件可以按记录的进程ID强制解除
+const forceLockSupported = true
+
+//	进程是否仍在运行(进程不存在时无法打开)
+func processAlive(pid int) bool {
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+
+	return true
+}
+
+//	独占创建锁文件(已存在时视为被其他进程锁定,异常退出后需要手动删除)
+func acquireLock(path string) (*os.File, error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, ErrLocked
+	}
+
+	return file, err
+}
+
+//	关闭并删除锁文件
+func releaseLock(file *os.File) error {
+
+	err := file.Close()
+	if e := os.Remove(file.Name()); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+//	锁文件中记录的进程已经退出时删除锁文件并重新锁定(异常退出后留下的锁文件),进程仍在运行或无法确定时返回ErrLocked
+func breakStaleLock(path string) (*os.File, error) {
+
+	pid := lockOwner(path)
+	if pid <= 0 || pid == os.Getpid() || processAlive(pid) {
+		return nil, ErrLocked
+	}
+
+	logger.Warn("锁文件中记录的进程已经退出,强制解除", "path", path, "pid", pid)
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return acquireLock(path)
+}
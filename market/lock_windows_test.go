@@ -0,0 +1,55 @@
+//go:build windows
+// +build windows
+
+package market
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestForceLock(t *testing.T) {
+
+	path := filepath.Join(config.Get().DataDir, lockFileName)
+
+	//	异常退出后留下的锁文件,记录的进程已经退出
+	err := ioutil.WriteFile(path, []byte(fmt.Sprintf("pid %d, 启动于2024-01-05 09:30:00\n", exitedPid(t))), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SetForceLock(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Lock()
+	SetForceLock(false)
+	if err != nil {
+		t.Fatalf("记录的进程已经退出时应能锁定:%v", err)
+	}
+
+	if owner := lockOwner(path); owner != os.Getpid() {
+		t.Errorf("锁定后锁文件应记录本进程,实际%d", owner)
+	}
+
+	Unlock()
+}
+
+//	已经退出的进程ID
+func exitedPid(t *testing.T) int {
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
@@ -0,0 +1,64 @@
+package market
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+//	日志
+//	keyvals为成对出现的字段名和字段值,如 "market", "America", "company", "AAPL"
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+//	当前使用的日志
+var logger Logger = stdLogger{}
+
+//	设置日志(传入nil则恢复为标准库log)
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+
+	logger = l
+}
+
+//	基于标准库log的默认日志
+type stdLogger struct{}
+
+func (l stdLogger) Debug(msg string, keyvals ...interface{}) {
+	l.print("DEBUG", msg, keyvals)
+}
+
+func (l stdLogger) Info(msg string, keyvals ...interface{}) {
+	l.print("INFO", msg, keyvals)
+}
+
+func (l stdLogger) Warn(msg string, keyvals ...interface{}) {
+	l.print("WARN", msg, keyvals)
+}
+
+func (l stdLogger) Error(msg string, keyvals ...interface{}) {
+	l.print("ERROR", msg, keyvals)
+}
+
+//	输出格式: [INFO]	消息 market=America company=AAPL
+func (l stdLogger) print(level, msg string, keyvals []interface{}) {
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("[%s]\t%s", level, msg))
+
+	for index := 0; index < len(keyvals); index += 2 {
+		if index+1 < len(keyvals) {
+			buffer.WriteString(fmt.Sprintf(" %v=%v", keyvals[index], keyvals[index+1]))
+		} else {
+			buffer.WriteString(fmt.Sprintf(" %v=?", keyvals[index]))
+		}
+	}
+
+	log.Print(buffer.String())
+}
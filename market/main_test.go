@@ -0,0 +1,33 @@
+package market
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	测试使用临时的数据目录和默认配置,不需要配置文件和网络
+func TestMain(m *testing.M) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		log.Fatal("创建临时数据目录发生错误: ", err)
+	}
+
+	err = config.Set(&config.Config{DataDir: dir})
+	if err != nil {
+		log.Fatal("初始化配置发生错误: ", err)
+	}
+
+	Add(America{})
+
+	code := m.Run()
+
+	CloseDatabases()
+	os.RemoveAll(dir)
+
+	os.Exit(code)
+}
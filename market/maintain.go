@@ -0,0 +1,77 @@
+package market
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	定时维护市场所有上市公司的数据库(done关闭时停止)
+func maintainTask(market Market, done <-chan struct{}) {
+
+	ticker := time.NewTicker(time.Hour * time.Duration(config.Get().MaintainInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := Maintain(market)
+			if err != nil {
+				logger.Error("维护数据库时出错", "market", market.Name(), "error", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+//	逐个回收市场所有上市公司各个间隔的数据库文件的空间并更新查询统计(每日任务运行时暂停),运行期间锁定数据目录
+//	正在使用的数据库等待其他事务结束,超时的跳过并在最后返回错误
+func Maintain(market Market) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	if isDryRun() {
+		logger.Info("试运行时不维护数据库", "market", market.Name())
+		return nil
+	}
+
+	//	包括已经退市的上市公司
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("维护数据库-开始", "market", market.Name(), "companies", len(companies))
+	startTime := time.Now()
+
+	failed := 0
+	for _, company := range companies {
+
+		//	不和每日任务争抢数据库
+		for isDailyTaskRunning(market) {
+			time.Sleep(retentionWait)
+		}
+
+		for _, interval := range crawlIntervals() {
+			err = store.Compact(market, company.Code, interval)
+			if err != nil {
+				logger.Error("维护上市公司的数据库时出错", "market", market.Name(), "company", company.Code, "interval", interval, "error", err)
+				failed++
+			}
+		}
+	}
+
+	logger.Info("维护数据库-结束", "market", market.Name(), "failed", failed, "duration", time.Since(startTime))
+
+	if failed > 0 {
+		return fmt.Errorf("[Maintain]\t维护%s的数据库时有%d个出错", market.Name(), failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintain(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockMaintain", "AAA", "BBB")
+	defer cleanup()
+
+	err := store.SaveCompanies(market, market.companies, time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Maintain(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	有事务时等待超时后跳过,不会一直等待
+	defer func(timeout time.Duration) { compactTimeout = timeout }(compactTimeout)
+	compactTimeout = time.Millisecond * 100
+
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Maintain(market)
+	tx.Rollback()
+	if err == nil {
+		t.Errorf("有事务的数据库应该超时并返回错误")
+	}
+
+	//	事务结束后照常维护
+	err = Maintain(market)
+	if err != nil {
+		t.Errorf("事务结束后应维护成功:%v", err)
+	}
+}
@@ -1,19 +1,22 @@
 package market
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/metrics"
 )
 
 const (
-	//	雅虎财经的历史分时数据没有超过90天的
-	lastestDays          = 90
-	companyGCCount       = 64
-	retryTimes           = 50
-	retryIntervalSeconds = 10
+	//	每日任务的间隔
+	dailyInterval = time.Hour * 24
 )
 
 //	市场更新
@@ -29,88 +32,334 @@ type Market interface {
 	Crawl(companyCode string, day time.Time) (string, error)
 }
 
+//	解析自定义格式原始数据的市场(没有实现时按雅虎Json解析)
+type Parser interface {
+	Parse(code string, day time.Time, raw []byte) (*ParseResult, error)
+}
+
+//	按市场的格式解析抓取的原始数据
+func parseRaw(market Market, code string, day time.Time, raw []byte) (*ParseResult, error) {
+
+	if parser, ok := market.(Parser); ok {
+		return parser.Parse(code, day, raw)
+	}
+
+	return processDailyYahooJson(market, code, day, raw)
+}
+
 var (
-	markets                       = make(map[string]Market)
-	marketOffset map[string]int64 = make(map[string]int64)
+	markets = make(map[string]Market)
+	//	保护markets、monitoring和marketStops
+	marketMutex sync.RWMutex
+	//	Monitor是否已经启动
+	monitoring bool
+	//	已经启动定时任务的市场及停止任务的函数
+	marketStops = make(map[string]func())
+
+	//	正在运行每日任务的市场及开始时间
+	runningTasks = make(map[string]time.Time)
+	runningMutex sync.Mutex
+
+	//	上一次每日任务还没有结束
+	ErrDailyTaskRunning = errors.New("上一次数据获取任务还没有结束")
+	//	没有找到市场
+	ErrMarketNotFound = errors.New("没有找到市场")
 )
 
-//	添加市场
+//	添加市场(替换同名的市场)
+//	Monitor启动后加入的市场会立即检查并启动定时任务,检查失败时只记录日志,不启动任务
 func Add(market Market) {
 
+	marketMutex.Lock()
+	defer marketMutex.Unlock()
+
+	stopMarket(market.Name())
 	markets[market.Name()] = market
 
-	log.Printf("市场[%s]已经加入监视列表", market.Name())
+	logger.Info("市场已经加入监视列表", "market", market.Name())
+
+	if !monitoring {
+		return
+	}
+
+	err := checkMarket(market)
+	if err != nil {
+		logger.Error("市场的设置有误,未启动定时任务", "market", market.Name(), "error", err)
+		return
+	}
+
+	startMarket(market)
 }
 
-//	监视市场(所有操作的入口)
+//	移除市场并停止它的定时任务(正在运行的任务会继续运行完)
+func Remove(name string) {
+
+	marketMutex.Lock()
+	defer marketMutex.Unlock()
+
+	if _, found := markets[name]; !found {
+		return
+	}
+
+	stopMarket(name)
+	delete(markets, name)
+	removeStatus(name)
+
+	logger.Info("市场已经移出监视列表", "market", name)
+}
+
+//	按名称查找已加入的市场
+func Get(name string) (Market, bool) {
+	marketMutex.RLock()
+	defer marketMutex.RUnlock()
+
+	market, found := markets[name]
+
+	return market, found
+}
+
+//	已加入的所有市场名称(按名称排序)
+func MarketNames() []string {
+
+	marketMutex.RLock()
+	defer marketMutex.RUnlock()
+
+	names := make([]string, 0, len(markets))
+	for name := range markets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+//	按名称查找已加入的市场(没有时返回ErrMarketNotFound)
+func GetMarket(marketName string) (Market, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, ErrMarketNotFound
+	}
+
+	return market, nil
+}
+
+//	监视市场(所有操作的入口),同一数据目录已经有其他进程在监视时返回错误
 func Monitor() error {
-	log.Print("启动监视")
+	logger.Info("启动监视")
 
-	for _, m := range markets {
-		//	本地时间
-		now := time.Now()
-		_, offsetLocal := now.Zone()
+	//	一直持有到进程退出
+	err := Lock()
+	if err != nil {
+		return err
+	}
+
+	//	关闭后可以重新启动
+	resetShutdown()
+
+	err = monitor()
+	if err != nil {
+		Unlock()
+	}
+
+	return err
+}
+
+//	检查配置并启动所有市场的定时任务
+func monitor() error {
+
+	marketMutex.Lock()
+	defer marketMutex.Unlock()
 
-		//	获取市场所在时区
-		location, err := time.LoadLocation(m.Timezone())
+	for _, m := range markets {
+		err := checkMarket(m)
 		if err != nil {
 			return err
 		}
+	}
 
-		//	市场所处时区当前时间
-		marketNow := now.In(location)
-		_, offsetMarket := marketNow.Zone()
+	//	确保分时数据的检查规则有效
+	err := validateValidationRules()
+	if err != nil {
+		return err
+	}
 
-		//	计算TimeZoneOffset
-		marketOffset[m.Name()] = int64(offsetMarket - offsetLocal)
+	//	确保sqlite的配置有效
+	err = validateSQLiteConfig()
+	if err != nil {
+		return err
 	}
 
+	//	确保原始数据存档的配置有效
+	err = validateRawConfig()
+	if err != nil {
+		return err
+	}
+
+	//	每日任务的webhook通知
+	setWebhook(config.Get().WebhookURL)
+
 	//	启动处理队列
 	//	go startProcessQueue()
 
 	//	启动抓取任务
 	for _, m := range markets {
+		startMarket(m)
+	}
 
-		//	启动每日定时任务
-		go func(market Market) {
-			//	所处时区距明日0点的间隔
-			now := marketow(market)
-			du := locationYesterdayZero(market).Add(time.Hour * 48).Sub(now)
-
-			log.Printf("[%s]\t定时任务已启动，将于%s后激活首次任务", market.Name(), du.String())
-			time.AfterFunc(du, func() {
-				//	立即运行一次
-				go dailyTask(market)
-
-				//	每天运行一次
-				ticker := time.NewTicker(time.Hour * 24)
-				for _ = range ticker.C {
-					dailyTask(market)
-				}
-			})
+	//	之后加入的市场在Add时启动
+	monitoring = true
+
+	return nil
+}
+
+//	检查市场的时区和抓取设置,并初始化运行状态
+func checkMarket(market Market) error {
+
+	err := validateTimezone(market)
+	if err != nil {
+		return err
+	}
+
+	//	检查抓取设置
+	err = validateSettings(market.Name())
+	if err != nil {
+		return err
+	}
+
+	//	初始化运行状态
+	return initStatus(market)
+}
+
+//	检查所有市场的时区和抓取设置以及全局的配置(不启动任何任务)
+func VerifyConfig() error {
+
+	marketMutex.RLock()
+	defer marketMutex.RUnlock()
+
+	for _, m := range markets {
+		err := validateTimezone(m)
+		if err != nil {
+			return err
+		}
+
+		err = validateSettings(m.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	err := validateValidationRules()
+	if err != nil {
+		return err
+	}
+
+	err = validateSQLiteConfig()
+	if err != nil {
+		return err
+	}
+
+	return validateRawConfig()
+}
+
+//	启动市场的定时任务(需要持有marketMutex,已经启动的会先停止)
+func startMarket(market Market) {
+
+	stopMarket(market.Name())
 
-		}(m)
+	//	启动每日定时任务(收盘后延迟一段时间运行)
+	stopDaily := scheduleDailyTask(market, func(now time.Time) (time.Time, time.Time) {
+		return nextDailyRun(market, now)
+	})
 
-		//	启动历史数据获取任务
-		go func(market Market) {
-			historyTask(market, locationYesterdayZero(market))
-		}(m)
+	done := make(chan struct{})
+	marketStops[market.Name()] = func() {
+		stopDaily()
+		close(done)
+	}
+
+	//	试运行时只运行每日任务
+	if isDryRun() {
+		return
+	}
+
+	//	启动历史数据获取任务
+	goTask(func() { historyTask(market, locationYesterdayZero(market)) })
+
+	//	启动重试任务
+	goTask(func() { retryTask(market, done) })
+
+	//	启动分时数据清理任务
+	if config.Get().RetentionMonths > 0 || config.Get().RetentionDays > 0 {
+		goTask(func() { retentionTask(market, done) })
+	}
+
+	//	启动数据库维护任务
+	if config.Get().MaintainInterval > 0 {
+		goTask(func() { maintainTask(market, done) })
+	}
+}
+
+//	停止市场的定时任务(需要持有marketMutex)
+func stopMarket(name string) {
+
+	stop, found := marketStops[name]
+	if !found {
+		return
+	}
+
+	stop()
+	delete(marketStops, name)
+}
+
+//	检查市场的时区
+func validateTimezone(market Market) error {
+
+	_, err := time.LoadLocation(market.Timezone())
+	if err != nil {
+		return fmt.Errorf("[%s]\t错误的时区%s:%s", market.Name(), market.Timezone(), err.Error())
 	}
 
 	return nil
 }
 
-//	市场所处时区当前时间
-func marketow(market Market) time.Time {
-	now := time.Now()
+//	市场所在时区(错误的时区按本地时区)
+func marketLocation(market Market) *time.Location {
 
-	//	获取市场所在时区
 	location, err := time.LoadLocation(market.Timezone())
 	if err != nil {
-		return now
+		return time.Local
 	}
 
-	return now.In(location)
+	return location
+}
+
+//	市场所处时区当前时间
+func marketow(market Market) time.Time {
+	return time.Now().In(marketLocation(market))
+}
+
+//	市场的数据目录(DataDir/市场),所有市场级别的文件都保存在这里
+func marketDir(market Market) string {
+	return filepath.Join(config.Get().DataDir, market.Name())
+}
+
+//	创建市场的数据目录(还没有抓取过时可能不存在),返回目录
+func ensureMarketDir(market Market) (string, error) {
+
+	dir := marketDir(market)
+
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+//	unix时间戳在市场当地的时间(分时数据按市场当地的时间保存在本地时区中)
+//	每个时间戳按当时的时差换算,市场或者本地时区切换夏令时前后都不会偏移
+func marketWallClock(location *time.Location, ts int64) time.Time {
+
+	t := time.Unix(ts, 0).In(location)
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
 }
 
 //	昨天0点
@@ -121,82 +370,382 @@ func locationYesterdayZero(market Market) time.Time {
 	return time.Date(year, month, day, 0, 0, 0, 0, now.Location())
 }
 
-//	每日定时任务
-func dailyTask(market Market) {
+//	任务执行结果
+type TaskResult struct {
+	Market  string
+	Day     time.Time
+	Total   int
+	Success int
+	Failed  int
+	Errors  []error
+}
+
+//	上市公司处理错误
+type CompanyError struct {
+	Market  string
+	Company string
+	Day     time.Time
+	Err     error
+}
+
+func (e *CompanyError) Error() string {
+	return fmt.Sprintf("[%s]\t处理[%s]在%s的分时数据出错:%s", e.Market, e.Company, e.Day.Format("20060102"), e.Err.Error())
+}
+
+//	失败率
+func (r TaskResult) FailureRate() float64 {
+	if r.Total == 0 {
+		return 0
+	}
 
-	//	昨天零点
-	yesterday := locationYesterdayZero(market)
-	log.Printf("[%s]\t%s数据获取任务已启动", market.Name(), yesterday.Format("20060102"))
+	return float64(r.Failed) / float64(r.Total)
+}
+
+//	立即执行一次每日任务(运行期间锁定数据目录)
+func RunOnce(market Market) (*TaskResult, error) {
+
+	err := Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer Unlock()
+
+	return dailyTask(market)
+}
+
+//	开始运行每日任务,同一市场已经在运行时返回false和已运行的时间
+func startDailyTask(market Market) (bool, time.Duration) {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	if started, found := runningTasks[market.Name()]; found {
+		return false, time.Since(started)
+	}
+
+	runningTasks[market.Name()] = time.Now()
+
+	return true, 0
+}
+
+//	每日任务运行结束
+func finishDailyTask(market Market) {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	delete(runningTasks, market.Name())
+}
+
+//	每日任务是否正在运行
+func isDailyTaskRunning(market Market) bool {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	_, found := runningTasks[market.Name()]
+
+	return found
+}
+
+//	每日任务最后一次的完成时间(没有运行过时为零值),用于检查定时任务是否还在运行
+func LastRun(market Market) (time.Time, error) {
+	return store.LastRun(market)
+}
+
+//	每日定时任务(抓取昨天的数据)
+func dailyTask(market Market) (*TaskResult, error) {
+	return dailyTaskDay(market, locationYesterdayZero(market))
+}
+
+//	抓取所有上市公司某日的数据
+func dailyTaskDay(market Market, day time.Time) (*TaskResult, error) {
+
+	//	同一市场同时只运行一个每日任务
+	started, elapsed := startDailyTask(market)
+	if !started {
+		logger.Warn("上一次数据获取任务还没有结束,跳过本次", "market", market.Name(), "elapsed", elapsed)
+		return nil, ErrDailyTaskRunning
+	}
+	defer finishDailyTask(market)
+
+	recordRunStart(market)
+
+	//	休市日没有数据
+	if isHoliday(market, day) {
+		logger.Info("休市日,跳过数据获取任务", "market", market.Name(), "day", day.Format("20060102"))
+		result := &TaskResult{Market: market.Name(), Day: day, Errors: make([]error, 0)}
+		recordRunEnd(market, result)
+		return result, nil
+	}
+
+	logger.Info("数据获取任务已启动", "market", market.Name(), "day", day.Format("20060102"))
+	startTime := time.Now()
+	notify(Event{Type: EventDailyStart, Market: market.Name(), Day: day})
+	defer func() {
+		//	运行时间超过了定时任务的间隔
+		if duration := time.Since(startTime); duration > dailyInterval {
+			logger.Warn("数据获取任务的运行时间超过了间隔", "market", market.Name(), "day", day.Format("20060102"), "duration", duration, "overrun", duration-dailyInterval)
+		}
+	}()
 
 	//	获取市场所有上市公司
 	companies, err := getCompanies(market)
 	if err != nil {
-		log.Printf("[%s]\t获取上市公司失败: %s", market.Name(), err.Error())
-		return
+		logger.Error("获取上市公司失败", "market", market.Name(), "error", err)
+		recordRunEnd(market, nil)
+		notify(Event{Type: EventDailyFailed, Market: market.Name(), Day: day, Duration: time.Since(startTime), Message: err.Error()})
+		return nil, err
 	}
 
-	chanSend := make(chan int, companyGCCount)
+	//	只抓取需要的上市公司,跳过不活跃的
+	companies = activeCompanies(market, selectCompanies(market, companies))
+
+	concurrency := getSettings(market.Name()).concurrency
+	chanSend := make(chan int, concurrency)
 	defer close(chanSend)
 
+	//	收集每家公司的处理结果
+	chanResult := make(chan error, len(companies))
+
 	var wg sync.WaitGroup
 	wg.Add(len(companies))
 
-	for _, c := range companies {
+	//	开始时错开启动抓取,避免同时请求数据源
+	stagger := time.Millisecond * time.Duration(config.Get().WorkerStagger)
+
+	for index, c := range companies {
+		//	正在关闭时不再开始新的抓取
+		if isShuttingDown() {
+			wg.Add(index - len(companies))
+			companies = companies[:index]
+			break
+		}
+
+		if stagger > 0 && index > 0 && index < concurrency {
+			time.Sleep(stagger)
+		}
+
+		//	同时抓取的上市公司数不超过Concurrency
+		chanSend <- 1
+
 		//	并发抓取
 		go func(company Company) {
+			var result *ParseResult
+			var err error
+			companyStart := time.Now()
+			defer func() {
+				if r := recover(); r != nil {
+					err = companyPanic(market, company, day, r)
+				}
 
-			//	打开数据库连接
-			db, err := getDB(market, company.Code)
-			if err != nil {
-				log.Printf("[%s]\t打开[%s]的数据库连接时出错:%s", market.Name(), company.Code, err.Error())
+				if err != nil {
+					err = &CompanyError{Market: market.Name(), Company: company.Code, Day: day, Err: err}
+				}
+				hook.OnCompanyDone(market, company, err)
+				notify(companyEvent(market, company, day, result, err, time.Since(companyStart)))
+				chanResult <- err
 
 				<-chanSend
 				wg.Done()
+			}()
 
-				return
-			}
-			defer db.Close()
+			//	所有市场共用的抓取名额
+			release := acquireGlobalSlot()
+			defer release()
+			companyStart = time.Now()
 
-			//	启动事务
-			tx, err := db.Begin()
+			hook.OnCompanyStart(market, company)
+
+			result, err = companyTask(market, company, day)
 			if err != nil {
-				log.Printf("[%s]\t启动[%s]数据库事务时出错:%s", market.Name(), company.Code, err.Error())
+				logger.Error("抓取分时数据出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "error", err)
+			}
+		}(c)
+	}
 
-				<-chanSend
-				wg.Done()
+	//	阻塞，直到抓取所有
+	wg.Wait()
+	close(chanResult)
 
-				return
-			}
+	result := &TaskResult{Market: market.Name(), Day: day, Total: len(companies), Errors: make([]error, 0)}
+	for err := range chanResult {
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, err)
+		} else {
+			result.Success++
+		}
+	}
 
-			//	抓取
-			err = companyDayTask(tx, market, company, yesterday)
-			if err != nil {
-				log.Printf("[%s]\t抓取[%s]在%s的分时数据出错:%s", market.Name(), company.Code, yesterday.Format("20060102"), err.Error())
+	metrics.CompaniesProcessed.WithLabelValues(market.Name(), "success").Add(float64(result.Success))
+	metrics.CompaniesProcessed.WithLabelValues(market.Name(), "failed").Add(float64(result.Failed))
 
-				//	回滚事务
-				err = tx.Rollback()
-				if err != nil {
-					log.Printf("[%s]\t回滚[%s]事务时出错:%s", market.Name(), company.Code, err.Error())
-				}
-			} else {
-				//	提交事务
-				err = tx.Commit()
-				if err != nil {
-					log.Printf("[%s]\t提交[%s]事务时出错:%s", market.Name(), company.Code, err.Error())
-				}
-			}
+	recordRunEnd(market, result)
 
-			<-chanSend
-			wg.Done()
-		}(c)
+	logger.Info("数据获取任务已结束", "market", market.Name(), "day", day.Format("20060102"), "success", result.Success, "failed", result.Failed, "duration", time.Since(startTime))
 
-		chanSend <- 1
+	//	被中断的任务不记录完成时间
+	if isShuttingDown() {
+		logger.Warn("正在关闭,数据获取任务没有运行完", "market", market.Name(), "day", day.Format("20060102"), "companies", result.Total)
+		return result, ErrShutdown
 	}
 
-	//	阻塞，直到抓取所有
-	wg.Wait()
+	//	记录完成时间(试运行时不记录)
+	if !isDryRun() {
+		completed := time.Now()
+		metrics.LastRun.WithLabelValues(market.Name()).Set(float64(completed.Unix()))
 
-	log.Printf("[%s]\t%s数据获取任务已结束", market.Name(), yesterday.Format("20060102"))
+		err = store.SaveLastRun(market, completed)
+		if err != nil {
+			logger.Error("保存每日任务的完成时间时出错", "market", market.Name(), "error", err)
+		}
+
+		//	备份到对象存储
+		backupAfterDailyTask(market, day)
+
+		//	导出到InfluxDB
+		exportInfluxAfterDailyTask(market, day)
+	}
+
+	//	以错误信息表为准的汇总
+	errors, err := store.CountErrors(market, day)
+	if err != nil {
+		logger.Error("统计错误数量时出错", "market", market.Name(), "day", day.Format("20060102"), "error", err)
+	} else {
+		logger.Info(fmt.Sprintf("成功 %d / 失败 %d", result.Total-errors, errors), "market", market.Name(), "day", day.Format("20060102"))
+	}
+
+	//	抓取统计的汇总
+	if !config.Get().DisableCrawlStats && !isDryRun() {
+		stats, err := store.CrawlStats(market, day)
+		if err != nil {
+			logger.Error("读取抓取统计时出错", "market", market.Name(), "day", day.Format("20060102"), "error", err)
+		} else {
+			logger.Info(summarizeCrawlStats(market.Name(), day, stats).String(), "market", market.Name(), "day", day.Format("20060102"))
+		}
+	}
+
+	//	通知每日任务结束
+	notifyDailyEnd(result, time.Since(startTime))
+
+	return result, nil
+}
+
+//	按配置的间隔抓取上市公司某日数据(返回1m的结果)
+func companyTask(market Market, company Company, day time.Time) (*ParseResult, error) {
+
+	var result *ParseResult
+	var err error
+	for _, interval := range crawlIntervals() {
+		if interval == Interval1m {
+			result, err = companyMinuteTask(market, company, day)
+			continue
+		}
+
+		companyIntervalTask(market, company, day, interval)
+	}
+
+	return result, err
+}
+
+//	在单独的事务中抓取上市公司某日1m数据,失败的加入重试队列
+func companyMinuteTask(market Market, company Company, day time.Time) (*ParseResult, error) {
+
+	startTime := time.Now()
+	result, err := companyTransaction(market, company, day, false)
+	logger.Debug("抓取分时数据已结束", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "duration", time.Since(startTime))
+	if (err != nil || (result != nil && !result.Success)) && !isDryRun() {
+		message := ""
+		if err != nil {
+			//	事务已回滚,单独保存错误信息
+			message = err.Error()
+			recordError(market, company, day, message)
+		} else {
+			message = result.Message
+		}
+
+		//	加入重试队列
+		e := store.EnqueueRetry(market, RetryEntry{Company: company.Code, Day: day, Message: message})
+		if e != nil {
+			logger.Error("加入重试队列时出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "error", e)
+		}
+
+		updateActivity(market, company, day, false)
+	} else if result != nil && result.Success && !isDryRun() {
+		updateActivity(market, company, day, true)
+		recordCrawl(market)
+	}
+
+	return result, err
+}
+
+//	在单独的事务中抓取上市公司某日数据(reset为true时先清除之前的处理状态)
+func companyTransaction(market Market, company Company, day time.Time, reset bool) (*ParseResult, error) {
+	return companyIntervalTransaction(market, company, day, Interval1m, reset)
+}
+
+//	在单独的事务中抓取上市公司某日1m以外间隔的数据(失败时只记录日志)
+func companyIntervalTask(market Market, company Company, day time.Time, interval Interval) {
+
+	result, err := companyIntervalTransaction(market, company, day, interval, false)
+	if err != nil {
+		logger.Error("抓取分时数据出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "interval", interval, "error", err)
+	} else if result != nil && !result.Success {
+		logger.Warn("解析分时数据失败", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "interval", interval, "message", result.Message)
+	}
+}
+
+//	在单独的事务中抓取上市公司某日指定间隔的数据(reset为true时先清除处理状态)
+func companyIntervalTransaction(market Market, company Company, day time.Time, interval Interval, reset bool) (result *ParseResult, err error) {
+
+	//	回调最终的结果
+	defer func() {
+		hook.OnDayDone(market, company, day, interval, result, err)
+	}()
+
+	//	启动事务
+	tx, err := beginTx(market, company.Code, interval)
+	if err != nil {
+		return nil, fmt.Errorf("启动事务时出错:%s", err.Error())
+	}
+
+	//	发生panic时回滚事务
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			result, err = nil, companyPanic(market, company, day, r)
+		}
+	}()
+
+	//	清除处理状态
+	if reset {
+		err = tx.ClearProcessed(day)
+	}
+
+	//	抓取
+	if err == nil {
+		result, err = companyDayTask(tx, market, company, day)
+	}
+
+	if err != nil {
+		//	回滚事务
+		if e := tx.Rollback(); e != nil {
+			logger.Error("回滚事务时出错", "market", market.Name(), "company", company.Code, "error", e)
+		}
+
+		return nil, err
+	}
+
+	//	提交事务
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("提交事务时出错:%s", err.Error())
+	}
+
+	//	通知订阅(试运行时没有保存)
+	if interval == Interval1m && result != nil && result.Success && !isDryRun() {
+		publishDayResult(market, company, day, result)
+	}
+
+	return result, nil
 }
 
 //	历史数据获取任务
@@ -204,144 +753,364 @@ func historyTask(market Market, yesterday time.Time) {
 	//	获取市场所有上市公司
 	companies, err := getCompanies(market)
 	if err != nil {
-		log.Printf("[%s]\t获取上市公司失败: %s", market.Name(), err.Error())
+		logger.Error("获取上市公司失败", "market", market.Name(), "error", err)
 		return
 	}
 
-	log.Printf("[%s]\t开始抓取%d家上市公司在%s之前的历史", market.Name(), len(companies), yesterday.Format("20060102"))
+	//	只抓取需要的上市公司
+	companies = selectCompanies(market, companies)
+
+	//	跳过所有间隔的进度都已经覆盖了整个范围的上市公司
+	checkpoints := loadHistoryCheckpoints(market)
+	companies, complete := incompleteHistory(market, companies, checkpoints, yesterday)
 
-	chanSend := make(chan int, companyGCCount)
+	logger.Info("开始抓取上市公司的历史分时数据", "market", market.Name(), "companies", len(companies), "complete", complete, "before", yesterday.Format("20060102"))
+	startTime := time.Now()
+
+	chanSend := make(chan int, getSettings(market.Name()).concurrency)
 	defer close(chanSend)
-	
+
+	chanCount := make(chan backfillCount, len(companies))
+
 	var wg sync.WaitGroup
 	wg.Add(len(companies))
 
-	for _, c := range companies {
+	for index, c := range companies {
+
+		//	正在关闭时不再开始新的抓取
+		if isShuttingDown() {
+			wg.Add(index - len(companies))
+			break
+		}
+
+		//	同时抓取的上市公司数不超过Concurrency
+		chanSend <- 1
 
 		//	并发抓取
 		go func(company Company) {
+			count := backfillCount{}
+			var err error
+			defer func() {
+				if r := recover(); r != nil {
+					err = companyPanic(market, company, yesterday, r)
+				} else if count.Failed > 0 {
+					err = fmt.Errorf("[%s]\t%s有%d天的历史分时数据抓取失败", market.Name(), company.Code, count.Failed)
+				}
 
-			//	打开数据库连接
-			db, err := getDB(market, company.Code)
-			if err != nil {
-				log.Printf("[%s]\t打开[%s]的数据库连接时出错:%s", market.Name(), company.Code, err.Error())
+				hook.OnCompanyDone(market, company, err)
+				chanCount <- count
 
 				<-chanSend
 				wg.Done()
+			}()
+
+			//	所有市场共用的抓取名额
+			release := acquireGlobalSlot()
+			defer release()
 
-				return
+			hook.OnCompanyStart(market, company)
+
+			for _, interval := range crawlIntervals() {
+				n := companyHistoryTask(market, company, yesterday, interval, checkpoints[interval][company.Code])
+				count.Crawled += n.Crawled
+				count.Skipped += n.Skipped
+				count.Failed += n.Failed
 			}
-			defer db.Close()
+		}(c)
+	}
 
-			//	启动事务
-			tx, err := db.Begin()
-			if err != nil {
-				log.Printf("[%s]\t启动[%s]数据库事务时出错:%s", market.Name(), company.Code, err.Error())
+	//	阻塞，直到抓取所有
+	wg.Wait()
+	close(chanCount)
 
-				<-chanSend
-				wg.Done()
+	total := backfillCount{}
+	for count := range chanCount {
+		total.Crawled += count.Crawled
+		total.Skipped += count.Skipped
+		total.Failed += count.Failed
+	}
 
-				return
-			}
+	logger.Info("上市公司的历史分时数据已经抓取结束", "market", market.Name(), "crawled", total.Crawled, "skipped", total.Skipped, "failed", total.Failed, "duration", time.Since(startTime))
+}
 
-			for index := 0; index < lastestDays; index++ {
-				day := yesterday.Add(-time.Hour * 24 * time.Duration(index))
+//	读取所有间隔中所有上市公司的历史任务进度(出错时该间隔从头开始)
+func loadHistoryCheckpoints(market Market) map[Interval]map[string]HistoryCheckpoint {
 
-				//	抓取
-				err = companyDayTask(tx, market, company, day)
-				if err != nil {
-					err = fmt.Errorf("[%s]\t抓取[%s]在%s的分时数据出错:%s", market.Name(), company.Code, day.Format("20060102"), err.Error())
-					break
-				}
+	checkpoints := make(map[Interval]map[string]HistoryCheckpoint)
+	for _, interval := range crawlIntervals() {
+		list, err := store.HistoryCheckpoints(market, interval)
+		if err != nil {
+			logger.Warn("读取历史任务进度出错,将从头开始", "market", market.Name(), "interval", interval, "error", err)
+			list = make(map[string]HistoryCheckpoint)
+		}
+
+		checkpoints[interval] = list
+	}
+
+	return checkpoints
+}
+
+//	进度没有覆盖最近HistoryDays天的上市公司,同时返回已经完成的上市公司数
+func incompleteHistory(market Market, companies []Company, checkpoints map[Interval]map[string]HistoryCheckpoint, yesterday time.Time) ([]Company, int) {
+
+	oldest := yesterday.AddDate(0, 0, 1-getSettings(market.Name()).historyDays)
+
+	list := make([]Company, 0, len(companies))
+	for _, company := range companies {
+		for _, interval := range crawlIntervals() {
+			checkpoint := checkpoints[interval][company.Code]
+			if !checkpoint.Contains(oldest) || !checkpoint.Contains(yesterday) {
+				list = append(list, company)
+				break
 			}
+		}
+	}
 
-			if err != nil {
-				log.Print(err.Error())
+	return list, len(companies) - len(list)
+}
 
-				//	回滚事务
-				err = tx.Rollback()
-				if err != nil {
-					log.Printf("[%s]\t回滚[%s]事务时出错:%s", market.Name(), company.Code, err.Error())
-				}
-			} else {
-				//	提交事务
-				err = tx.Commit()
-				if err != nil {
-					log.Printf("[%s]\t提交[%s]事务时出错:%s", market.Name(), company.Code, err.Error())
-				}
+//	获取上市公司最近的历史数据(天数见HistoryDays),每天一个事务,某天失败时记录错误信息并继续处理其他日期
+//	每处理完一天保存进度,重启后跳过进度范围内的日期
+func companyHistoryTask(market Market, company Company, yesterday time.Time, interval Interval, checkpoint HistoryCheckpoint) backfillCount {
+
+	count := backfillCount{}
+
+	//	出错的日期没有处理完,进度不能越过它
+	failed := false
+	for index := 0; index < getSettings(market.Name()).historyDays && !isShuttingDown(); index++ {
+		day := yesterday.AddDate(0, 0, -index)
+
+		//	上次运行时已经处理过
+		if checkpoint.Contains(day) {
+			count.Skipped++
+			continue
+		}
+
+		//	抓取(已经处理过的返回nil)
+		result, err := companyIntervalTransaction(market, company, day, interval, false)
+		switch {
+		case err != nil:
+			logger.Error("抓取分时数据出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "interval", interval, "error", err)
+
+			//	事务已回滚,1m单独保存错误信息
+			if interval == Interval1m {
+				recordError(market, company, day, err.Error())
 			}
 
-			<-chanSend
-			wg.Done()
-		}(c)
+			count.Failed++
+			failed = true
+		case result == nil:
+			count.Skipped++
+		case !result.Success:
+			count.Failed++
+		default:
+			count.Crawled++
+		}
 
-		chanSend <- 1
+		if failed || isDryRun() {
+			continue
+		}
+
+		//	本次从yesterday处理到day,与上次的进度相连时保存合并后的进度
+		merged, ok := checkpoint.merge(HistoryCheckpoint{Oldest: day, Newest: yesterday})
+		if ok {
+			err = store.SaveHistoryCheckpoint(market, company.Code, interval, merged)
+			if err != nil {
+				logger.Warn("保存历史任务进度出错", "market", market.Name(), "company", company.Code, "interval", interval, "error", err)
+			}
+		}
 	}
 
-	//	阻塞，直到抓取所有
-	wg.Wait()
+	logger.Info("上市公司的历史分时数据抓取结束", "market", market.Name(), "company", company.Code, "interval", interval, "crawled", count.Crawled, "skipped", count.Skipped, "failed", count.Failed)
 
-	log.Printf("[%s]\t上市公司的历史分时数据已经抓取结束", market.Name())
+	return count
 }
 
-//	获取上市公司某日数据
-func companyDayTask(tx *sql.Tx, market Market, company Company, day time.Time) error {
-	dayString := day.Format("20060102")
+//	记录处理上市公司时发生的panic,并保存为错误信息
+func companyPanic(market Market, company Company, day time.Time, r interface{}) error {
+
+	err := fmt.Errorf("发生了致命错误:%v", r)
+	logger.Error("处理上市公司时发生了致命错误", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "error", err, "stack", string(debug.Stack()))
+
+	recordError(market, company, day, err.Error())
+
+	return err
+}
+
+//	获取上市公司某日数据(已经处理过的返回nil)
+func companyDayTask(tx Tx, market Market, company Company, day time.Time) (*ParseResult, error) {
 
 	//	查询是否已经处理过
-	processed, err := isProcessed(tx, day.Format("20060102"))
+	processed, err := tx.IsProcessed(day)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	//	避免重复处理
 	if processed {
-		return nil
+		return nil, nil
+	}
+
+	metrics.CrawlAttempts.WithLabelValues(market.Name()).Inc()
+	metrics.InFlight.WithLabelValues(market.Name()).Inc()
+	defer metrics.InFlight.WithLabelValues(market.Name()).Dec()
+
+	startTime := time.Now()
+	stat := CrawlStat{Market: market.Name(), Company: company.Code, Day: day, Interval: tx.Interval()}
+	result, err := crawlCompanyDay(tx, market, company, day, &stat)
+	metrics.CrawlDuration.WithLabelValues(market.Name()).Observe(time.Since(startTime).Seconds())
+
+	//	记录抓取统计
+	stat.Duration, stat.Success = time.Since(startTime), err == nil && result.Success
+	saveCrawlStat(market, stat)
+
+	if isDryRun() && err == nil {
+		logger.Info("试运行", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "interval", tx.Interval(), "success", result.Success, "message", result.Message, "pre", len(result.Pre), "regular", len(result.Regular), "post", len(result.Post))
+	}
+
+	if err != nil || !result.Success {
+		metrics.CrawlFailures.WithLabelValues(market.Name()).Inc()
+	} else {
+		metrics.CrawlSuccesses.WithLabelValues(market.Name()).Inc()
+		metrics.RowsSaved.WithLabelValues(market.Name(), "pre").Add(float64(len(result.Pre)))
+		metrics.RowsSaved.WithLabelValues(market.Name(), "regular").Add(float64(len(result.Regular)))
+		metrics.RowsSaved.WithLabelValues(market.Name(), "post").Add(float64(len(result.Post)))
+	}
+
+	return result, err
+}
+
+//	记录每日任务的抓取结果,连续失败超过InactiveAfterDays天时标记为不活跃,重新成功时恢复
+func updateActivity(market Market, company Company, day time.Time, success bool) {
+
+	activity, err := store.LoadActivity(market, company.Code)
+	if err != nil {
+		logger.Error("读取上市公司的抓取情况时出错", "market", market.Name(), "company", company.Code, "error", err)
+		return
 	}
 
+	if success {
+		//	没有失败过就不用保存
+		if activity.Failures == 0 && !activity.IsInactive() {
+			return
+		}
+
+		if activity.IsInactive() {
+			logger.Info("上市公司恢复抓取", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"))
+		}
+
+		activity = CompanyActivity{Market: market.Name(), Code: company.Code}
+	} else {
+		//	同一天只算一次
+		if activity.LastFailed.Equal(day) {
+			return
+		}
+
+		activity.Failures++
+		activity.LastFailed = day
+
+		if !activity.IsInactive() && activity.Failures >= config.Get().InactiveAfterDays {
+			activity.Inactive = day
+			logger.Warn("上市公司连续失败,标记为不活跃", "market", market.Name(), "company", company.Code, "failures", activity.Failures, "day", day.Format("20060102"))
+		}
+	}
+
+	err = store.SaveActivity(market, activity)
+	if err != nil {
+		logger.Error("保存上市公司的抓取情况时出错", "market", market.Name(), "company", company.Code, "error", err)
+	}
+}
+
+//	抓取、解析并保存上市公司某日数据(同时在stat中记录下载情况和分时数据条数)
+func crawlCompanyDay(tx Tx, market Market, company Company, day time.Time, stat *CrawlStat) (*ParseResult, error) {
+
 	//	抓取
-	raw, err := market.Crawl(company.Code, day)
+	raw, trace, err := crawlInterval(market, company.Code, day, tx.Interval())
+	stat.Status, stat.Retries, stat.Bytes = trace.Status, trace.retries(), len(raw)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	//	存档原始数据(失败不影响抓取,试运行时不存档)
+	if !isDryRun() {
+		err = archiveRaw(market, company.Code, day, tx.Interval(), raw)
+		if err != nil {
+			logger.Warn("存档原始数据时出错", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "error", err)
+		}
 	}
 
 	//	解析
-	result, err := processDailyYahooJson(market, company.Code, day, []byte(raw))
+	result, err := parseRaw(market, company.Code, day, []byte(raw))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	stat.Rows = len(result.Pre) + len(result.Regular) + len(result.Post)
+
+	//	常规交易时段的分时数据明显偏少(按数据源提供的交易时段计算,提前收盘的日期不算)
+	if result.Success && result.Sessions.isShort(len(result.Regular), tx.Interval()) {
+		logger.Debug("常规交易时段的分时数据偏少", "market", market.Name(), "company", company.Code, "day", day.Format("20060102"), "rows", len(result.Regular), "expected", result.Sessions.ExpectedRows("regular", tx.Interval()), "sessions", result.Sessions.String())
+	}
+
+	return result, saveResult(tx, day, result)
+}
+
+//	保存解析结果
+func saveResult(tx Tx, day time.Time, result *ParseResult) error {
+
 	//	保存处理状态
-	err = saveProcessStatus(tx, dayString, result.Success)
+	err := tx.MarkProcessed(day, result.Success)
 	if err != nil {
 		return err
 	}
 
+	//	有异常数据时保存检查结果
+	if result.Validation.Invalid > 0 {
+		err = tx.SaveValidation(day, result.Validation.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	//	交易时段(用于按实际的交易时段计算应有的分时数据条数)
+	if len(result.Sessions) > 0 {
+		err = tx.SaveSessions(day, result.Sessions)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !result.Success {
 		//	保存错误信息
-		return saveError(tx, dayString, result.Message)
+		return tx.SaveError(day, result.Message)
 	}
 
 	//	保存分时数据
 	// Pre
-	err = savePeroid(tx, "pre", result.Pre)
+	err = tx.SavePeriod("pre", result.Pre)
 	if err != nil {
 		return err
 	}
 
 	// Regular
-	err = savePeroid(tx, "regular", result.Regular)
+	err = tx.SavePeriod("regular", result.Regular)
 	if err != nil {
 		return err
 	}
 
 	// Post
-	err = savePeroid(tx, "post", result.Post)
+	err = tx.SavePeriod("post", result.Post)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	//	分红和拆股
+	err = tx.SaveDividends(result.Dividends)
+	if err != nil {
+		return err
+	}
+
+	return tx.SaveSplits(result.Splits)
 }
 
 //	抓取市场上市公司信息
@@ -349,21 +1118,30 @@ func getCompanies(market Market) ([]Company, error) {
 
 	cl := CompanyList{}
 	//	尝试更新上市公司列表
-	log.Printf("[%s]\t更新上市公司列表-开始", market.Name())
-	companies, err := market.Companies()
+	logger.Info("更新上市公司列表-开始", "market", market.Name())
+	companies, err := marketCompanies(market)
 	if err != nil {
 
 		//	如果更新失败，则尝试从上次的存档文件中读取上市公司列表
-		log.Printf("[%s]\t更新上市公司列表失败，尝试从存档读取:%v", market.Name(), err)
-		err = cl.Load(market)
-		if err != nil {
-			return nil, fmt.Errorf("[%s]\t尝试从存档读取上市公司列表-失败:%s", market.Name(), err.Error())
-		}
+		logger.Warn("更新上市公司列表失败，尝试从存档读取", "market", market.Name(), "error", err)
+		return archivedCompanies(market)
+	}
 
-		companies = cl
-		log.Printf("[%s]\t尝试从存档读取上市公司列表-成功,共%d家上市公司", market.Name(), len(companies))
+	//	数据源偶尔返回空的或者不完整的列表,这时不覆盖存档
+	archived := CompanyList{}
+	if cl.Load(market) == nil {
+		archived = cl
+	}
+
+	if len(companies) == 0 || companyListShrunk(len(archived), len(companies)) {
+		logger.Error("更新的上市公司列表过少，尝试从存档读取(确认无误时设置CompanyListForce)", "market", market.Name(), "companies", len(companies), "archived", len(archived))
+		return archivedCompanies(market)
+	}
 
-		return companies, nil
+	//	试运行时不存档
+	if isDryRun() {
+		logger.Info("更新上市公司列表-成功(试运行)", "market", market.Name(), "companies", len(companies))
+		return withSymbols(market, companies), nil
 	}
 
 	//	存档
@@ -373,7 +1151,29 @@ func getCompanies(market Market) ([]Company, error) {
 		return nil, err
 	}
 
-	log.Printf("[%s]\t更新上市公司列表-成功,共%d家上市公司", market.Name(), len(companies))
+	metrics.CompanyListUpdates.WithLabelValues(market.Name(), "success").Inc()
+	logger.Info("更新上市公司列表-成功", "market", market.Name(), "companies", len(companies))
+
+	//	记录上市和退市(存档中只有上市公司,配置的指数和ETF只保存到存储中)
+	return updateListing(market, withSymbols(market, companies), marketow(market))
+}
+
+//	从上次的存档文件中读取上市公司列表
+func archivedCompanies(market Market) ([]Company, error) {
+
+	cl := CompanyList{}
+	err := cl.Load(market)
+	if err == nil && len(cl) == 0 {
+		err = fmt.Errorf("存档中没有上市公司")
+	}
+
+	if err != nil {
+		metrics.CompanyListUpdates.WithLabelValues(market.Name(), "failed").Inc()
+		return nil, fmt.Errorf("[%s]\t尝试从存档读取上市公司列表-失败:%s", market.Name(), err.Error())
+	}
+
+	metrics.CompanyListUpdates.WithLabelValues(market.Name(), "archive").Inc()
+	logger.Info("尝试从存档读取上市公司列表-成功", "market", market.Name(), "companies", len(cl))
 
-	return companies, nil
+	return withSymbols(market, cl), nil
 }
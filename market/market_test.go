@@ -0,0 +1,1045 @@
+package market
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	只有一个常规交易时段数据点的雅虎Json
+const mockYahooJson = `{"chart":{"result":[{"meta":{"tradingPeriods":{"pre":[[{"start":0,"end":1}]],"regular":[[{"start":1,"end":2}]],"post":[[{"start":2,"end":3}]]}},"timestamp":[1],"indicators":{"quote":[{"open":[1],"close":[1],"high":[1],"low":[1],"volume":[1]}]}}],"error":null}}`
+
+//	测试用市场
+type mockMarket struct {
+	name      string
+	companies []Company
+	//	抓取时会panic的上市公司
+	panicCode string
+	//	抓取时会出错的日期
+	failDay time.Time
+}
+
+func (m mockMarket) Name() string {
+	return m.name
+}
+
+func (m mockMarket) Timezone() string {
+	return "UTC"
+}
+
+func (m mockMarket) Companies() ([]Company, error) {
+	return m.companies, nil
+}
+
+func (m mockMarket) Crawl(code string, day time.Time) (string, error) {
+	if code == m.panicCode {
+		panic(fmt.Sprintf("抓取%s时panic", code))
+	}
+
+	if day.Equal(m.failDay) {
+		return "", fmt.Errorf("抓取%s在%s的数据时出错", code, day.Format("20060102"))
+	}
+
+	return mockYahooJson, nil
+}
+
+//	创建测试用市场并清空其数据目录
+func newMockMarket(t *testing.T, name string, codes ...string) (mockMarket, func()) {
+
+	companies := make([]Company, 0, len(codes))
+	for _, code := range codes {
+		companies = append(companies, Company{Market: name, Code: code, Name: code})
+	}
+
+	//	删除数据文件前关闭缓存的连接
+	CloseDatabases()
+
+	dir := filepath.Join(config.Get().DataDir, name)
+	os.RemoveAll(dir)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mockMarket{name: name, companies: companies}, func() {
+		CloseDatabases()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDailyTaskRecoversPanic(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockPanic", "AAA", "BAD", "CCC")
+	defer cleanup()
+	market.panicCode = "BAD"
+
+	before := time.Now()
+	result, err := dailyTask(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 3 || result.Success != 2 || result.Failed != 1 {
+		t.Fatalf("应成功2家失败1家,实际: %+v", result)
+	}
+
+	//	有失败的上市公司也算完成
+	lastRun, err := LastRun(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lastRun.Before(before.Add(-time.Second)) {
+		t.Errorf("完成时间%s应晚于开始时间%s", lastRun, before)
+	}
+
+	yesterday := locationYesterdayZero(market)
+	for _, code := range []string{"AAA", "CCC"} {
+		tx, err := store.Begin(market, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		processed, err := tx.IsProcessed(yesterday)
+		tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !processed {
+			t.Errorf("%s应该已经处理过", code)
+		}
+	}
+
+	tx, err := store.Begin(market, "BAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	errors, err := tx.Errors(yesterday, yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errors) != 1 {
+		t.Errorf("BAD应该保存了1条错误信息,实际%d条", len(errors))
+	}
+}
+
+//	抓取时返回指定数据的市场
+type fixtureMarket struct {
+	mockMarket
+	raw string
+}
+
+func (m fixtureMarket) Crawl(code string, day time.Time) (string, error) {
+	return m.raw, nil
+}
+
+func TestForceRecrawlNoDuplicates(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockRecrawl", "AAA")
+	defer cleanup()
+
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_v8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	market := fixtureMarket{mock, string(buffer)}
+	company := market.companies[0]
+	day := locationYesterdayZero(market)
+
+	//	每个交易时段的数据条数
+	counts := func() map[string]int {
+		db, err := getDB(market, company.Code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		result := make(map[string]int)
+		for _, period := range []string{"pre", "regular", "post"} {
+			var n int
+			err = db.QueryRow("select count(*) from " + period).Scan(&n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result[period] = n
+		}
+
+		return result
+	}
+
+	_, err = companyTransaction(market, company, day, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := counts()
+	if first["regular"] == 0 {
+		t.Fatalf("应该保存了常规交易时段的数据")
+	}
+
+	//	强制重新抓取同一天
+	_, err = companyTransaction(market, company, day, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := counts()
+	for period, n := range first {
+		if second[period] != n {
+			t.Errorf("重新抓取后%s应有%d条,实际%d条", period, n, second[period])
+		}
+	}
+}
+
+//	返回指定数据并记录每家上市公司抓取次数的市场
+type countingMarket struct {
+	fixtureMarket
+	failCode string
+	mutex    *sync.Mutex
+	crawls   map[string]int
+}
+
+func (m countingMarket) Crawl(code string, day time.Time) (string, error) {
+
+	m.mutex.Lock()
+	m.crawls[code]++
+	m.mutex.Unlock()
+
+	if code == m.failCode {
+		return "", fmt.Errorf("抓取%s时出错", code)
+	}
+
+	return m.fixtureMarket.Crawl(code, day)
+}
+
+func TestDailyTaskPipeline(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockPipeline", "AAA", "BAD")
+	defer cleanup()
+
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_v8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	market := countingMarket{fixtureMarket{mock, string(buffer)}, "BAD", &sync.Mutex{}, make(map[string]int)}
+	day := locationYesterdayZero(market)
+
+	for run := 0; run < 2; run++ {
+		result, err := dailyTaskDay(market, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//	第二次运行时已经处理过的不算在内
+		if result.Failed != 1 {
+			t.Errorf("第%d次运行应失败1家,实际%+v", run+1, result)
+		}
+	}
+
+	//	处理过的不重复抓取,失败的每次都重新抓取
+	if market.crawls["AAA"] != 1 || market.crawls["BAD"] != 2 {
+		t.Errorf("AAA应抓取1次,BAD应抓取2次,实际%v", market.crawls)
+	}
+
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peroids, err := tx.LoadPeriod("regular", time.Time{}, time.Now())
+	tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) == 0 {
+		t.Errorf("AAA应该保存了常规交易时段的分时数据")
+	}
+
+	tx, err = store.Begin(market, "BAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	processed, err := tx.IsProcessed(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errors, err := tx.Errors(day, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if processed || len(errors) == 0 {
+		t.Errorf("BAD不应标记为已处理并且应保存错误信息,实际%v,%d条", processed, len(errors))
+	}
+}
+
+func TestReprocessFailed(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockReprocess", "AAA")
+	defer cleanup()
+
+	//	模拟一次临时性失败:已标记为处理过并保存了错误信息
+	yesterday := locationYesterdayZero(market)
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.SaveError(yesterday, "HTTP状态码429")
+	if err == nil {
+		err = tx.MarkProcessed(yesterday, false)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReprocessFailed(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	errors, err := tx.Errors(yesterday, yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errors) != 0 {
+		t.Errorf("重新抓取成功后不应再有错误信息,实际%d条", len(errors))
+	}
+
+	processed, err := tx.IsProcessed(yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !processed {
+		t.Errorf("重新抓取后应该标记为已处理")
+	}
+}
+
+func TestFetchCompanyDay(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockFetch", "AAA")
+	defer cleanup()
+
+	Add(market)
+	defer Remove(market.Name())
+
+	day := locationYesterdayZero(market)
+	buffer := &bytes.Buffer{}
+	err := FetchCompanyDay(buffer, market.Name(), "AAA", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buffer.String(), "timestamp,open,high,low,close,volume,adjclose,session") {
+		t.Errorf("应该以CSV格式输出保存的结果,实际:%s", buffer.String())
+	}
+
+	//	已经处理过的也会重新抓取
+	buffer.Reset()
+	err = FetchCompanyDay(buffer, market.Name(), "AAA", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() == 0 {
+		t.Errorf("重新抓取后应该输出保存的结果")
+	}
+
+	err = FetchCompanyDay(buffer, "MockUnknown", "AAA", day)
+	if err == nil {
+		t.Errorf("未知的市场应该返回错误")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockDryRun", "AAA", "BAD")
+	defer cleanup()
+	market.panicCode = "BAD"
+
+	SetDryRun(true)
+	defer SetDryRun(false)
+
+	result, err := RunOnce(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Success != 1 || result.Failed != 1 {
+		t.Fatalf("试运行也应成功1家失败1家,实际: %+v", result)
+	}
+
+	yesterday := locationYesterdayZero(market)
+	for _, code := range []string{"AAA", "BAD"} {
+		tx, err := store.Begin(market, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		processed, err := tx.IsProcessed(yesterday)
+		if err == nil && !processed {
+			var errors []CrawlError
+			errors, err = tx.Errors(yesterday, yesterday)
+			if len(errors) != 0 {
+				t.Errorf("试运行不应保存%s的错误信息", code)
+			}
+		}
+		tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if processed {
+			t.Errorf("试运行不应标记%s为已处理", code)
+		}
+	}
+
+	entries, err := store.RetryEntries(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("试运行不应加入重试队列,实际%d条", len(entries))
+	}
+
+	lastRun, err := LastRun(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !lastRun.IsZero() {
+		t.Errorf("试运行不应记录完成时间,实际%s", lastRun)
+	}
+}
+
+func TestHistoryTaskContinuesAfterFailure(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockHistory", "AAA")
+	defer cleanup()
+
+	yesterday := locationYesterdayZero(market)
+	market.failDay = yesterday.AddDate(0, 0, -getSettings(market.Name()).historyDays/2)
+
+	historyTask(market, yesterday)
+
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	historyDays := getSettings(market.Name()).historyDays
+	days, err := tx.ProcessedDays(yesterday.AddDate(0, 0, 1-historyDays), yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	只有出错的那天没有处理
+	if len(days) != historyDays-1 {
+		t.Errorf("应处理%d天,实际%d天", historyDays-1, len(days))
+	}
+
+	for _, day := range days {
+		if day.Equal(market.failDay) {
+			t.Errorf("出错的日期%s不应标记为已处理", day.Format("20060102"))
+		}
+	}
+
+	errors, err := tx.Errors(market.failDay, market.failDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errors) != 1 {
+		t.Errorf("出错的日期应保存1条错误信息,实际%d条", len(errors))
+	}
+}
+
+func TestHistoryTaskLateFailure(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockLateFailure", "AAA")
+	defer cleanup()
+
+	historyDays := 5
+	defer overrideSettings(market.Name(), config.MarketConfig{HistoryDays: &historyDays})()
+	defer SetHook(nil)
+
+	//	最后处理的一天出错
+	yesterday := locationYesterdayZero(market)
+	market.failDay = yesterday.AddDate(0, 0, 1-historyDays)
+	historyTask(market, yesterday)
+
+	failDay := market.failDay
+	processed := func() (int, int) {
+		tx, err := store.Begin(market, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		days, err := tx.ProcessedDays(failDay, yesterday)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errors, err := tx.Errors(failDay, yesterday)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(days), len(errors)
+	}
+
+	//	之前每天的处理状态都已经提交,只有出错的那天保存了错误信息
+	if days, errors := processed(); days != historyDays-1 || errors != 1 {
+		t.Errorf("应已提交%d天的处理状态和1条错误信息,实际%d天,%d条", historyDays-1, days, errors)
+	}
+
+	//	再次运行时只处理出错的那天
+	hook := &countingHook{}
+	SetHook(hook)
+	market.failDay = time.Time{}
+	historyTask(market, yesterday)
+
+	if hook.days != 1 {
+		t.Errorf("应只处理出错的那天,实际处理%d天", hook.days)
+	}
+
+	if days, _ := processed(); days != historyDays {
+		t.Errorf("应已处理%d天,实际%d天", historyDays, days)
+	}
+}
+
+func TestGetCompaniesFallbackToArchive(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockArchive", "AAA", "BBB", "CCC", "DDD")
+	defer cleanup()
+
+	//	第一次更新后存档
+	companies, err := getCompanies(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(companies) != 4 {
+		t.Fatalf("应有4家上市公司,实际%d家", len(companies))
+	}
+
+	//	数据源返回空列表或者少于存档的一半时使用存档,且不覆盖存档
+	for _, codes := range [][]string{{}, {"AAA"}} {
+		market.companies = make([]Company, 0, len(codes))
+		for _, code := range codes {
+			market.companies = append(market.companies, Company{Market: market.name, Code: code, Name: code})
+		}
+
+		companies, err = getCompanies(market)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(companies) != 4 {
+			t.Errorf("返回%d家时应使用存档的4家,实际%d家", len(codes), len(companies))
+		}
+
+		archived := CompanyList{}
+		err = archived.Load(market)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(archived) != 4 {
+			t.Errorf("返回%d家时不应覆盖存档,存档中有%d家", len(codes), len(archived))
+		}
+	}
+
+	//	正常的变化照常更新存档
+	market.companies = market.companies[:0]
+	for _, code := range []string{"AAA", "BBB", "CCC"} {
+		market.companies = append(market.companies, Company{Market: market.name, Code: code, Name: code})
+	}
+
+	_, err = getCompanies(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archived := CompanyList{}
+	err = archived.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 3 {
+		t.Errorf("存档应更新为3家上市公司,实际%d家", len(archived))
+	}
+}
+
+func TestGetCompaniesEmptyWithoutArchive(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockEmpty")
+	defer cleanup()
+
+	_, err := getCompanies(market)
+	if err == nil {
+		t.Errorf("没有存档且数据源返回空列表时应该返回错误")
+	}
+}
+
+func TestCompanyArchiveVersions(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockArchiveVersion")
+	defer cleanup()
+
+	//	旧版本的存档只有代码和名称,或者没有币种
+	path := filepath.Join(config.Get().DataDir, market.Name(), companiesFileName)
+	err := ioutil.WriteFile(path, []byte("AAA\tA Inc.\nBBB\tB Inc.\tNYSE\tTechnology\tSoftware\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archived := CompanyList{}
+	err = archived.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 2 || archived[0].Name != "A Inc." || archived[0].Exchange != "" || archived[1].Exchange != "NYSE" || archived[1].Industry != "Software" || archived[1].Currency != "" {
+		t.Fatalf("旧版本的存档读取不正确:%+v", archived)
+	}
+
+	archived[1].Currency = "USD"
+	err = archived.Save(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := CompanyList{}
+	err = loaded.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 2 || loaded[0].Currency != "" || loaded[1].Currency != "USD" || loaded[1].Sector != "Technology" {
+		t.Errorf("存档的币种不正确:%+v", loaded)
+	}
+}
+
+func TestCompanyArchiveHistory(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockArchiveHistory")
+	defer cleanup()
+
+	c := config.Get()
+	defer func(versions int) { c.CompanyArchiveVersions = versions }(c.CompanyArchiveVersions)
+	c.CompanyArchiveVersions = 3
+
+	//	最新的版本不完整时使用之前完整的版本
+	dir := filepath.Join(c.DataDir, market.Name())
+	for name, content := range map[string]string{
+		"companies_20240101000000.txt": "AAA\tA\nBBB\tB\n",
+		"companies_20240102000000.txt": "AAA\tA\nBBB\tB\nCCC\tC\nDDD\tD\n",
+		"companies_20240103000000.txt": "AAA\tA\n"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archived := CompanyList{}
+	err := archived.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archived) != 4 {
+		t.Fatalf("应使用有4家上市公司的版本,实际%d家", len(archived))
+	}
+
+	//	比上一版本少太多时不保存
+	err = CompanyList(archived[:1]).Save(market)
+	if err != ErrCompanyListShrunk {
+		t.Errorf("少太多时应返回ErrCompanyListShrunk,实际%v", err)
+	}
+
+	err = CompanyList(archived[:3]).Save(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	只保留最近3个版本
+	paths, err := companyVersions(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 3 || filepath.Base(paths[0]) != "companies_20240102000000.txt" {
+		t.Errorf("应保留最近3个版本,实际%v", paths)
+	}
+
+	loaded := CompanyList{}
+	err = loaded.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 3 {
+		t.Errorf("应读取最新保存的3家上市公司,实际%d家", len(loaded))
+	}
+
+	//	确认无误时可以强制保存并读取
+	c.CompanyListForce = true
+	defer func() { c.CompanyListForce = false }()
+
+	err = CompanyList(archived[:1]).Save(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = CompanyList{}
+	err = loaded.Load(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded) != 1 {
+		t.Errorf("强制保存后应读取1家上市公司,实际%d家", len(loaded))
+	}
+}
+
+//	抓取很慢并记录同时运行数量的市场
+type slowMarket struct {
+	mockMarket
+	delay     time.Duration
+	active    *int32
+	maxActive *int32
+}
+
+func (m slowMarket) Crawl(code string, day time.Time) (string, error) {
+
+	active := atomic.AddInt32(m.active, 1)
+	defer atomic.AddInt32(m.active, -1)
+
+	for {
+		max := atomic.LoadInt32(m.maxActive)
+		if active <= max || atomic.CompareAndSwapInt32(m.maxActive, max, active) {
+			break
+		}
+	}
+
+	time.Sleep(m.delay)
+
+	return m.mockMarket.Crawl(code, day)
+}
+
+func TestDailyTaskNoOverlap(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockOverlap", "AAA")
+	defer cleanup()
+
+	var active, maxActive int32
+	market := slowMarket{mock, time.Millisecond * 200, &active, &maxActive}
+
+	//	间隔远小于每次运行的时间
+	day := locationYesterdayZero(market)
+	stop := scheduleDailyTask(market, func(now time.Time) (time.Time, time.Time) {
+		return now.Add(time.Millisecond * 20), day
+	})
+	time.Sleep(time.Millisecond * 300)
+	stop()
+	time.Sleep(time.Millisecond * 20)
+
+	//	等待正在运行的任务结束
+	for index := 0; index < 50; index++ {
+		started, _ := startDailyTask(market)
+		if started {
+			finishDailyTask(market)
+			break
+		}
+
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	if max := atomic.LoadInt32(&maxActive); max != 1 {
+		t.Errorf("同时只应运行1个每日任务,实际最多%d个", max)
+	}
+
+	_, err := dailyTask(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	已在运行时跳过
+	startDailyTask(market)
+	defer finishDailyTask(market)
+
+	_, err = dailyTask(market)
+	if err != ErrDailyTaskRunning {
+		t.Errorf("已在运行时应返回ErrDailyTaskRunning,实际%v", err)
+	}
+}
+
+//	记录回调的次数
+type countingHook struct {
+	mutex   sync.Mutex
+	started int
+	done    int
+	failed  int
+	days    int
+}
+
+func (h *countingHook) OnCompanyStart(market Market, company Company) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.started++
+}
+
+func (h *countingHook) OnCompanyDone(market Market, company Company, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.done++
+	if err != nil {
+		h.failed++
+	}
+}
+
+func (h *countingHook) OnDayDone(market Market, company Company, day time.Time, interval Interval, result *ParseResult, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.days++
+}
+
+func TestHook(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockHook", "AAA", "BAD", "CCC")
+	defer cleanup()
+	market.panicCode = "BAD"
+
+	h := &countingHook{}
+	SetHook(h)
+	defer SetHook(nil)
+
+	_, err := dailyTask(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.started != 3 || h.done != 3 || h.failed != 1 || h.days != 3 {
+		t.Errorf("应开始3家结束3家失败1家,处理3天,实际开始%d家结束%d家失败%d家,处理%d天", h.started, h.done, h.failed, h.days)
+	}
+}
+
+//	记录上市公司列表变化的回调
+type listingHook struct {
+	NopHook
+	changes []ListingChange
+}
+
+func (h *listingHook) OnListingChanged(market Market, changes []ListingChange) {
+	h.changes = append(h.changes, changes...)
+}
+
+func TestListingHook(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockListingHook", "A", "B")
+	defer cleanup()
+
+	h := &listingHook{}
+	SetHook(h)
+	defer SetHook(nil)
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	_, err := updateListing(market, market.companies, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	第一次保存时不算变化
+	if len(h.changes) != 0 {
+		t.Fatalf("第一次保存时不应回调,实际%+v", h.changes)
+	}
+
+	_, err = updateListing(market, []Company{market.companies[0], {Market: market.Name(), Code: "C", Name: "C"}}, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.changes) != 2 || h.changes[0].Code != "C" || h.changes[0].Change != ListingListed || h.changes[1].Code != "B" || h.changes[1].Change != ListingDelisted {
+		t.Errorf("应回调C新上市和B退市,实际%+v", h.changes)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+
+	//	测试期间使用单独的市场列表,试运行时不启动历史数据任务
+	marketMutex.Lock()
+	saved := markets
+	markets = make(map[string]Market)
+	marketMutex.Unlock()
+
+	SetDryRun(true)
+	defer func() {
+		SetDryRun(false)
+
+		marketMutex.Lock()
+		for name := range marketStops {
+			stopMarket(name)
+		}
+		markets = saved
+		monitoring = false
+		marketMutex.Unlock()
+	}()
+
+	Add(mockMarket{name: "MockRegistry0"})
+
+	var wg sync.WaitGroup
+	for index := 0; index < 8; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("MockRegistry%d", index%4)
+			for count := 0; count < 50; count++ {
+				Add(mockMarket{name: name})
+				Get(name)
+				MarketNames()
+				if count%3 == 0 {
+					Remove(name)
+				}
+			}
+		}(index)
+	}
+
+	err := Monitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	//	Monitor之后加入的市场立即启动定时任务,移除时停止
+	Add(mockMarket{name: "MockRegistryLate"})
+	if _, found := Get("MockRegistryLate"); !found {
+		t.Fatal("应能找到Monitor之后加入的市场")
+	}
+
+	marketMutex.RLock()
+	_, started := marketStops["MockRegistryLate"]
+	marketMutex.RUnlock()
+	if !started {
+		t.Error("Monitor之后加入的市场应启动定时任务")
+	}
+
+	Remove("MockRegistryLate")
+	if _, found := Get("MockRegistryLate"); found {
+		t.Error("移除后不应找到市场")
+	}
+
+	marketMutex.RLock()
+	_, started = marketStops["MockRegistryLate"]
+	marketMutex.RUnlock()
+	if started {
+		t.Error("移除后应停止定时任务")
+	}
+}
+
+func TestWorkerStagger(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockStagger", "AAA", "BBB", "CCC")
+	defer cleanup()
+
+	c := config.Get()
+	defer func(stagger int) { c.WorkerStagger = stagger }(c.WorkerStagger)
+	c.WorkerStagger = 50
+
+	//	3家上市公司之间间隔2次
+	startTime := time.Now()
+	result, err := dailyTaskDay(market, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Success != 3 {
+		t.Errorf("应抓取成功3家上市公司,实际%d家", result.Success)
+	}
+
+	if elapsed := time.Since(startTime); elapsed < time.Millisecond*100 {
+		t.Errorf("错开启动时至少需要100ms,实际%s", elapsed)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+
+	previous := config.Get()
+	defer config.Set(previous)
+
+	root, err := ioutil.TempDir("", "stockrecorder-datadir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	//	不能在文件下创建目录
+	file := filepath.Join(root, "file")
+	err = ioutil.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*config.Config{{}, {DataDir: filepath.Join(file, "data")}, {DataDir: root, RawDir: filepath.Join(file, "raw")}} {
+		if config.Set(c) == nil {
+			t.Errorf("数据目录%q(RawDir %q)不可用时应该返回错误", c.DataDir, c.RawDir)
+		}
+	}
+
+	//	不存在的多级目录自动创建,上市公司列表保存在其中
+	dir := filepath.Join(root, "volume", "data")
+	err = config.Set(&config.Config{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	market := &mockMarket{name: "MockDataDir"}
+	err = CompanyList{{Market: market.Name(), Code: "AAA"}}.Save(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, market.Name(), companiesFilePrefix+"*.txt"))
+	if err != nil || len(paths) != 1 {
+		t.Errorf("上市公司列表应保存在数据目录中,实际%v,%v", paths, err)
+	}
+}
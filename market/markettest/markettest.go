@@ -0,0 +1,169 @@
+package markettest
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	只有一个常规交易时段数据点的雅虎财经JSON(没有指定抓取结果时返回)
+const DefaultJSON = `{"chart":{"result":[{"meta":{"tradingPeriods":{"pre":[[{"start":0,"end":1}]],"regular":[[{"start":1,"end":2}]],"post":[[{"start":2,"end":3}]]}},"timestamp":[1],"indicators":{"quote":[{"open":[1],"close":[1],"high":[1],"low":[1],"volume":[1]}]}}],"error":null}}`
+
+//	抓取的结果
+type Response struct {
+	//	返回的雅虎财经JSON
+	Body string
+	//	返回的错误(不为nil时忽略Body)
+	Err error
+	//	返回前等待的时间
+	Delay time.Duration
+}
+
+//	测试用的市场,上市公司列表和每次抓取的结果都可以预先指定,不需要访问网络(可以在多个goroutine中同时使用)
+type Market struct {
+	name     string
+	timezone string
+
+	mutex        sync.Mutex
+	companies    []market.Company
+	companiesErr error
+	//	按代码或者代码/日期(20060102)指定的抓取结果
+	responses map[string]Response
+	//	每家上市公司的抓取次数
+	calls map[string]int
+}
+
+//	创建测试用的市场(时区为UTC)
+func New(name string, codes ...string) *Market {
+
+	companies := make([]market.Company, 0, len(codes))
+	for _, code := range codes {
+		companies = append(companies, market.Company{Market: name, Code: code, Name: code})
+	}
+
+	return &Market{
+		name:      name,
+		timezone:  "UTC",
+		companies: companies,
+		responses: make(map[string]Response),
+		calls:     make(map[string]int)}
+}
+
+func (m *Market) Name() string {
+	return m.name
+}
+
+func (m *Market) Timezone() string {
+	return m.timezone
+}
+
+//	设置时区
+func (m *Market) SetTimezone(timezone string) {
+	m.timezone = timezone
+}
+
+//	上市公司列表
+func (m *Market) Companies() ([]market.Company, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.companiesErr != nil {
+		return nil, m.companiesErr
+	}
+
+	return append([]market.Company(nil), m.companies...), nil
+}
+
+//	设置上市公司列表(err不为nil时获取上市公司列表返回该错误)
+func (m *Market) SetCompanies(companies []market.Company, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.companies, m.companiesErr = companies, err
+}
+
+//	指定上市公司每天的抓取结果
+func (m *Market) Respond(code string, response Response) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.responses[code] = response
+}
+
+//	指定上市公司某日的抓取结果(优先于Respond)
+func (m *Market) RespondDay(code string, day time.Time, response Response) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.responses[code+"/"+day.Format("20060102")] = response
+}
+
+//	上市公司被抓取的次数
+func (m *Market) Calls(code string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.calls[code]
+}
+
+//	按指定的结果抓取(没有指定时返回DefaultJSON)
+func (m *Market) Crawl(code string, day time.Time) (string, error) {
+
+	m.mutex.Lock()
+	m.calls[code]++
+	response, found := m.responses[code+"/"+day.Format("20060102")]
+	if !found {
+		response, found = m.responses[code]
+	}
+	m.mutex.Unlock()
+
+	if !found {
+		response = Response{Body: DefaultJSON}
+	}
+
+	if response.Delay > 0 {
+		time.Sleep(response.Delay)
+	}
+
+	if response.Err != nil {
+		return "", response.Err
+	}
+
+	return response.Body, nil
+}
+
+//	生成雅虎财经的分时数据JSON(只有常规交易时段,从start开始每分钟一条,开高低收都为prices中的价格,成交量为100)
+func YahooJSON(start time.Time, prices ...float32) string {
+
+	type section struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	}
+
+	type quote struct {
+		Open   []float32 `json:"open"`
+		Close  []float32 `json:"close"`
+		High   []float32 `json:"high"`
+		Low    []float32 `json:"low"`
+		Volume []int64   `json:"volume"`
+	}
+
+	timestamps := make([]int64, 0, len(prices))
+	volumes := make([]int64, 0, len(prices))
+	for index := range prices {
+		timestamps = append(timestamps, start.Unix()+int64(index)*60)
+		volumes = append(volumes, 100)
+	}
+
+	result := map[string]interface{}{
+		"meta":       map[string]interface{}{"tradingPeriods": [][]section{{{start.Unix(), start.Unix() + int64(len(prices))*60}}}},
+		"timestamp":  timestamps,
+		"indicators": map[string]interface{}{"quote": []quote{{prices, prices, prices, prices, volumes}}},
+	}
+
+	buffer, _ := json.Marshal(map[string]interface{}{"chart": map[string]interface{}{"result": []interface{}{result}, "error": nil}})
+
+	return string(buffer)
+}
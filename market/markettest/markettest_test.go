@@ -0,0 +1,87 @@
+package markettest_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/market"
+	"github.com/nzai/stockrecorder/market/markettest"
+)
+
+//	每个测试使用单独的数据目录(go test -count=2时不会看到上一次运行处理过的日期)
+func useDataDir(t *testing.T) func() {
+
+	dir, err := ioutil.TempDir("", "markettest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.Set(&config.Config{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		market.CloseDatabases()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+
+	defer useDataDir(t)()
+
+	m := markettest.New("Fake", "AAA", "BBB", "CCC")
+	market.Add(m)
+	defer market.Remove(m.Name())
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	yesterday = time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 0, 0, 0, 0, time.UTC)
+
+	m.Respond("AAA", markettest.Response{Body: markettest.YahooJSON(yesterday.Add(time.Hour*14+time.Minute*30), 10, 10.5, 11)})
+	m.Respond("BBB", markettest.Response{Err: errors.New("网络错误")})
+	m.RespondDay("CCC", yesterday, markettest.Response{Body: markettest.DefaultJSON, Delay: time.Millisecond * 10})
+
+	result, err := market.RunOnce(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 3 || result.Success != 2 || result.Failed != 1 {
+		t.Errorf("应成功2家失败1家,实际%+v", result)
+	}
+
+	for _, code := range []string{"AAA", "BBB", "CCC"} {
+		if m.Calls(code) == 0 {
+			t.Errorf("%s应该被抓取", code)
+		}
+	}
+
+	peroids, err := market.QueryPeroid60(m.Name(), "AAA", yesterday.AddDate(0, 0, -1), yesterday.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) != 3 || peroids[1].Close != 10.5 {
+		t.Errorf("应保存3条分时数据,实际%+v", peroids)
+	}
+}
+
+func TestCompaniesError(t *testing.T) {
+
+	defer useDataDir(t)()
+
+	m := markettest.New("FakeBroken")
+	m.SetCompanies(nil, errors.New("列表不可用"))
+	market.Add(m)
+	defer market.Remove(m.Name())
+
+	_, err := market.RunOnce(m)
+	if err == nil {
+		t.Errorf("获取上市公司列表失败时应返回错误")
+	}
+}
@@ -0,0 +1,128 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+//	数据库迁移
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx *sql.Tx) error
+}
+
+//	*sql.DB和*sql.Tx共有的方法
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var (
+	//	上市公司数据库的迁移(按版本排序,只能在最后增加)
+	companyMigrations = []migration{
+		{1, "初始表结构", func(tx *sql.Tx) error { return ensureSchema(tx, companyTables, companyColumns) }},
+		{2, "日线", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS [bar] ([date] CHAR(8) NOT NULL, [open] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, PRIMARY KEY ([date]));`)
+			return err
+		}},
+		{3, "交易时段", func(tx *sql.Tx) error {
+			return ensureColumn(tx, "process", "sessions", "TEXT NOT NULL DEFAULT ''")
+		}},
+	}
+
+	//	市场数据库的迁移(按版本排序,只能在最后增加)
+	marketMigrations = []migration{
+		{1, "初始表结构", func(tx *sql.Tx) error { return ensureSchema(tx, marketTables, marketColumns) }},
+		{2, "上市公司的抓取情况", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS [activity] ([code] VARCHAR(20) NOT NULL, [failures] INTEGER NOT NULL, [last_failed] CHAR(8) NOT NULL, [inactive] CHAR(8) NOT NULL, PRIMARY KEY ([code]));`)
+			return err
+		}},
+		{3, "导出进度", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS [export] ([code] VARCHAR(20) NOT NULL, [target] VARCHAR(20) NOT NULL, [day] CHAR(8) NOT NULL, PRIMARY KEY ([code], [target]));`)
+			return err
+		}},
+		{4, "上市公司的币种", func(tx *sql.Tx) error {
+			return ensureColumn(tx, "companies", "currency", "VARCHAR(8) NOT NULL DEFAULT ''")
+		}},
+		{5, "抓取统计", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS [crawl_stats] ([code] VARCHAR(20) NOT NULL, [date] CHAR(8) NOT NULL, [interval] VARCHAR(4) NOT NULL, [duration] INTEGER NOT NULL, [status] INTEGER NOT NULL, [bytes] INTEGER NOT NULL, [retries] INTEGER NOT NULL, [rows] INTEGER NOT NULL, [success] INTEGER NOT NULL, PRIMARY KEY ([date], [code], [interval]));`)
+			return err
+		}},
+		{6, "指数和ETF", func(tx *sql.Tx) error {
+			return ensureColumn(tx, "companies", "kind", "VARCHAR(8) NOT NULL DEFAULT ''")
+		}},
+	}
+)
+
+const (
+	//	记录已经执行过的迁移
+	schemaVersionScript = `CREATE TABLE IF NOT EXISTS [schema_version] ([version] INTEGER NOT NULL, [description] TEXT NOT NULL, [applied] DATETIME NOT NULL, PRIMARY KEY ([version]));`
+)
+
+//	依次执行还没有执行过的迁移,每个迁移一个事务
+//	事务以immediate方式启动,同时打开同一个文件时后来的等待前面的迁移完成,再跳过已经执行过的迁移
+func migrate(db *sql.DB, filePath string, migrations []migration) error {
+
+	for _, m := range migrations {
+		err := migrateOne(db, filePath, m)
+		if err != nil {
+			return fmt.Errorf("[Migrate]\t%s执行迁移%d(%s)时出错:%s", filePath, m.version, m.description, err.Error())
+		}
+	}
+
+	return nil
+}
+
+//	在事务中执行一个迁移(已经执行过时跳过)
+func migrateOne(db *sql.DB, filePath string, m migration) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		_, err := tx.Exec(schemaVersionScript)
+		if err != nil {
+			return err
+		}
+
+		version, err := schemaVersion(tx)
+		if err != nil || version >= m.version {
+			return err
+		}
+
+		err = m.migrate(tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("insert into schema_version values(?,?,?)", m.version, m.description, time.Now())
+		if err != nil {
+			return err
+		}
+
+		logger.Info("数据库迁移完成", "file", filePath, "version", m.version, "description", m.description)
+
+		return nil
+	}()
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//	数据库当前的版本(没有执行过迁移时为0)
+func schemaVersion(q sqlQueryer) (int, error) {
+
+	var version int
+	err := q.QueryRow("select coalesce(max(version), 0) from schema_version").Scan(&version)
+
+	return version, err
+}
@@ -0,0 +1,198 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+//	临时目录中的数据库文件
+func tempDBPath(t *testing.T) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "test.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestMigrateOldDatabase(t *testing.T) {
+
+	filePath, cleanup := tempDBPath(t)
+	defer cleanup()
+
+	//	旧版本的数据库:没有版本记录,error表缺少created字段,process表缺少validation字段
+	db, err := openSQLite(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE [process] ([date] CHAR(8) NOT NULL, [success] TINYINT(1) NOT NULL, PRIMARY KEY ([date]));" +
+		"CREATE TABLE [error] ([date] CHAR(8) NOT NULL, [message] TEXT NOT NULL, PRIMARY KEY ([date]));" +
+		"insert into process values('20240105', 1);")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrate(db, filePath, companyMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != len(companyMigrations) {
+		t.Errorf("版本应为%d,实际%d", len(companyMigrations), version)
+	}
+
+	//	旧数据保留,缺少的字段和表都已补上
+	var validation string
+	err = db.QueryRow("select validation from process where [date]='20240105'").Scan(&validation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("insert into error values('20240105', 'message', 1)")
+	if err != nil {
+		t.Errorf("error表应该已经有created字段:%s", err.Error())
+	}
+
+	_, err = db.Exec("select count(*) from dividend")
+	if err != nil {
+		t.Errorf("应该已经建立dividend表:%s", err.Error())
+	}
+}
+
+func TestMigrateOrderAndRollback(t *testing.T) {
+
+	filePath, cleanup := tempDBPath(t)
+	defer cleanup()
+
+	db, err := openSQLite(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations := []migration{
+		{1, "建表", func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE [item] ([id] INTEGER NOT NULL)")
+			return err
+		}},
+		{2, "加字段", func(tx *sql.Tx) error {
+			_, err := tx.Exec("ALTER TABLE [item] ADD COLUMN [name] TEXT NOT NULL DEFAULT ''")
+			return err
+		}},
+	}
+
+	//	重复执行时跳过已经执行过的迁移
+	for index := 0; index < 2; index++ {
+		err = migrate(db, filePath, migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//	失败的迁移整体回滚,版本不变
+	failed := append(migrations, migration{3, "失败", func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE [other] ([id] INTEGER NOT NULL)")
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("迁移失败")
+	}})
+
+	if migrate(db, filePath, failed) == nil {
+		t.Errorf("迁移失败时应该返回错误")
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 2 {
+		t.Errorf("版本应为2,实际%d", version)
+	}
+
+	var count int
+	err = db.QueryRow("select count(*) from sqlite_master where type='table' and name='other'").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("失败的迁移应该回滚")
+	}
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+
+	filePath, cleanup := tempDBPath(t)
+	defer cleanup()
+
+	//	重复执行会失败的迁移
+	migrations := []migration{
+		{1, "建表", func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE [item] ([id] INTEGER NOT NULL)")
+			return err
+		}},
+		{2, "插入", func(tx *sql.Tx) error {
+			_, err := tx.Exec("insert into item values(1)")
+			return err
+		}},
+	}
+
+	//	多个连接同时打开同一个文件
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for index := 0; index < 8; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			db, err := openSQLite(filePath)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+
+			errs <- migrate(db, filePath, migrations)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := openSQLite(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow("select count(*) from item").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("每个迁移只应执行一次,实际插入了%d条", count)
+	}
+}
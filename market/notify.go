@@ -0,0 +1,269 @@
+package market
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	通知的事件类型
+const (
+	//	每日任务开始
+	EventDailyStart = "daily_start"
+	//	每日任务结束
+	EventDailyEnd = "daily_end"
+	//	每日任务没能运行完(如获取上市公司失败)
+	EventDailyFailed = "daily_failed"
+	//	每日任务的失败率超过了NotifyFailureRate
+	EventFailureRate = "failure_rate"
+)
+
+const (
+	//	通知中最多包含的错误信息数
+	notifyTopErrors = 5
+	//	发送webhook失败时的重试次数
+	webhookRetries = 3
+)
+
+//	发送webhook失败时重试的间隔(每次递增)
+var webhookRetryInterval = time.Second * 5
+
+//	抓取过程中的事件
+type Event struct {
+	Type   string
+	Market string
+	Day    time.Time
+	//	上市公司级别的事件才有
+	Company string
+	Rows    int
+	Err     error
+	//	每日任务的上市公司数
+	Total   int
+	Success int
+	Failed  int
+	//	每日任务或者上市公司抓取的运行时间(开始时为0)
+	Duration time.Duration
+	//	出现次数最多的错误信息
+	Errors []string
+	//	说明(如任务失败的原因)
+	Message string
+}
+
+//	事件通知(可以实现该接口发送到Slack、Telegram等)
+type Notifier interface {
+	Notify(event Event) error
+}
+
+var (
+	//	已添加的通知
+	notifiers     = make([]Notifier, 0)
+	notifierMutex sync.Mutex
+	//	配置的webhook通知(重新Monitor时替换而不是重复添加)
+	webhook Notifier
+)
+
+//	添加事件通知
+func AddNotifier(n Notifier) {
+	notifierMutex.Lock()
+	defer notifierMutex.Unlock()
+
+	notifiers = append(notifiers, n)
+}
+
+//	移除所有事件通知
+func ClearNotifiers() {
+	notifierMutex.Lock()
+	defer notifierMutex.Unlock()
+
+	notifiers = make([]Notifier, 0)
+	webhook = nil
+}
+
+//	设置webhook通知,替换之前设置的webhook,url为空时只移除
+func setWebhook(url string) {
+	notifierMutex.Lock()
+	defer notifierMutex.Unlock()
+
+	list := make([]Notifier, 0, len(notifiers)+1)
+	for _, n := range notifiers {
+		if n != webhook {
+			list = append(list, n)
+		}
+	}
+
+	webhook = nil
+	if url != "" {
+		webhook = NewWebhookNotifier(url)
+		list = append(list, webhook)
+	}
+
+	notifiers = list
+}
+
+//	发送事件到所有订阅和通知(上市公司级别的事件不发送给通知,通知失败时只记录日志)
+func notify(event Event) {
+
+	publish(event)
+
+	if event.Type == EventCompanyCrawled || event.Type == EventCompanyFailed {
+		return
+	}
+
+	notifierMutex.Lock()
+	list := notifiers
+	notifierMutex.Unlock()
+
+	for _, n := range list {
+		err := n.Notify(event)
+		if err != nil {
+			logger.Error("发送通知时出错", "market", event.Market, "event", event.Type, "error", err)
+		}
+	}
+}
+
+//	每日任务结束的事件,失败率超过NotifyFailureRate时同时发送超过阈值的事件
+func notifyDailyEnd(result *TaskResult, duration time.Duration) {
+
+	event := Event{
+		Type:     EventDailyEnd,
+		Market:   result.Market,
+		Day:      result.Day,
+		Total:    result.Total,
+		Success:  result.Success,
+		Failed:   result.Failed,
+		Duration: duration,
+		Errors:   topErrors(result.Errors, notifyTopErrors)}
+
+	notify(event)
+
+	threshold := config.Get().NotifyFailureRate
+	if threshold > 0 && result.FailureRate() > threshold {
+		event.Type = EventFailureRate
+		event.Message = fmt.Sprintf("失败率%.1f%%超过了%.1f%%", result.FailureRate()*100, threshold*100)
+		notify(event)
+	}
+}
+
+//	出现次数最多的count个错误信息(上市公司错误按原因合并),格式为"错误信息 (次数)"
+func topErrors(errs []error, count int) []string {
+
+	counts := make(map[string]int)
+	for _, err := range errs {
+		if e, ok := err.(*CompanyError); ok {
+			err = e.Err
+		}
+
+		counts[err.Error()]++
+	}
+
+	messages := make([]string, 0, len(counts))
+	for message := range counts {
+		messages = append(messages, message)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		if counts[messages[i]] != counts[messages[j]] {
+			return counts[messages[i]] > counts[messages[j]]
+		}
+
+		return messages[i] < messages[j]
+	})
+
+	if len(messages) > count {
+		messages = messages[:count]
+	}
+
+	for index, message := range messages {
+		messages[index] = fmt.Sprintf("%s (%d)", message, counts[message])
+	}
+
+	return messages
+}
+
+//	webhook的JSON内容
+type webhookPayload struct {
+	Event    string   `json:"event"`
+	Market   string   `json:"market"`
+	Day      string   `json:"day"`
+	Total    int      `json:"total"`
+	Success  int      `json:"success"`
+	Failed   int      `json:"failed"`
+	Duration float64  `json:"duration"`
+	Errors   []string `json:"errors"`
+	Message  string   `json:"message,omitempty"`
+}
+
+//	把事件以JSON POST到指定地址的通知(失败时重试)
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+//	创建webhook通知
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: time.Second * time.Duration(config.Get().HTTPTimeout)}}
+}
+
+//	发送事件(网络错误、429和5xx时重试)
+func (n *WebhookNotifier) Notify(event Event) error {
+
+	errors := event.Errors
+	if errors == nil {
+		errors = []string{}
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		Event:    event.Type,
+		Market:   event.Market,
+		Day:      event.Day.Format("20060102"),
+		Total:    event.Total,
+		Success:  event.Success,
+		Failed:   event.Failed,
+		Duration: event.Duration.Seconds(),
+		Errors:   errors,
+		Message:  event.Message})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt <= webhookRetries; attempt++ {
+		var retry bool
+		retry, err = n.send(body)
+		if err == nil || !retry {
+			return err
+		}
+
+		if attempt < webhookRetries {
+			time.Sleep(webhookRetryInterval * time.Duration(attempt))
+		}
+	}
+
+	return err
+}
+
+//	发送一次请求,返回失败时是否可以重试
+func (n *WebhookNotifier) send(body []byte) (bool, error) {
+
+	response, err := n.client.Post(n.url, "application/json; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		return true, fmt.Errorf("[Webhook]\t发送到%s出错:%s", n.url, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	message, _ := ioutil.ReadAll(response.Body)
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+
+	return retry, fmt.Errorf("[Webhook]\t发送到%s出错,HTTP状态码%d:%s", n.url, response.StatusCode, strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,171 @@
+package market
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	记录收到的事件
+type recordingNotifier struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (n *recordingNotifier) Notify(event Event) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.events = append(n.events, event)
+
+	return nil
+}
+
+func TestNotifyDailyTask(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockNotify", "AAA", "BBB")
+	defer cleanup()
+	market.panicCode = "BBB"
+
+	c := config.Get()
+	defer func(rate float64) { c.NotifyFailureRate = rate }(c.NotifyFailureRate)
+	c.NotifyFailureRate = 0.3
+
+	n := &recordingNotifier{}
+	AddNotifier(n)
+	defer ClearNotifiers()
+
+	_, err := dailyTaskDay(market, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.events) != 3 || n.events[0].Type != EventDailyStart || n.events[1].Type != EventDailyEnd || n.events[2].Type != EventFailureRate {
+		t.Fatalf("应依次通知开始、结束和失败率超过阈值,实际%+v", n.events)
+	}
+
+	end := n.events[1]
+	if end.Market != market.Name() || end.Total != 2 || end.Success != 1 || end.Failed != 1 || len(end.Errors) != 1 {
+		t.Errorf("结束的事件不正确:%+v", end)
+	}
+
+	//	失败率没有超过阈值时不通知
+	c.NotifyFailureRate = 0.6
+	n.events = nil
+
+	_, err = dailyTaskDay(market, time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.events) != 2 {
+		t.Errorf("失败率没有超过阈值时应只通知开始和结束,实际%+v", n.events)
+	}
+}
+
+func TestSetWebhook(t *testing.T) {
+
+	n := &recordingNotifier{}
+	AddNotifier(n)
+	defer ClearNotifiers()
+
+	//	重复Monitor时替换之前的webhook,不影响其他通知
+	setWebhook("http://localhost/a")
+	setWebhook("http://localhost/b")
+
+	notifierMutex.Lock()
+	list := notifiers
+	notifierMutex.Unlock()
+
+	if len(list) != 2 || list[0] != n {
+		t.Fatalf("应只保留一个webhook通知,实际%d个通知", len(list))
+	}
+
+	if w, ok := list[1].(*WebhookNotifier); !ok || w.url != "http://localhost/b" {
+		t.Errorf("应使用最后设置的webhook,实际%+v", list[1])
+	}
+
+	//	url为空时移除webhook
+	setWebhook("")
+
+	notifierMutex.Lock()
+	list = notifiers
+	notifierMutex.Unlock()
+
+	if len(list) != 1 || list[0] != n {
+		t.Errorf("url为空时应移除webhook,实际%d个通知", len(list))
+	}
+}
+
+func TestTopErrors(t *testing.T) {
+
+	errs := []error{
+		&CompanyError{Market: "M", Company: "A", Err: errors.New("timeout")},
+		&CompanyError{Market: "M", Company: "B", Err: errors.New("timeout")},
+		&CompanyError{Market: "M", Company: "C", Err: errors.New("not found")},
+		errors.New("bad json"),
+	}
+
+	messages := topErrors(errs, 2)
+	if len(messages) != 2 || messages[0] != "timeout (2)" || messages[1] != "bad json (1)" {
+		t.Errorf("出现最多的错误信息不正确:%v", messages)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+
+	defer func(interval time.Duration) { webhookRetryInterval = interval }(webhookRetryInterval)
+	webhookRetryInterval = time.Millisecond
+
+	var mutex sync.Mutex
+	requests := 0
+	var payload webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		requests++
+
+		//	第一次返回500,应该重试
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL).Notify(Event{Type: EventDailyEnd, Market: "America", Day: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Total: 3, Success: 2, Failed: 1, Duration: time.Minute, Errors: []string{"timeout (1)"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 || payload.Event != EventDailyEnd || payload.Market != "America" || payload.Day != "20240105" || payload.Failed != 1 || payload.Duration != 60 || len(payload.Errors) != 1 {
+		t.Errorf("webhook的内容不正确,请求%d次:%+v", requests, payload)
+	}
+
+	//	4xx不重试
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer bad.Close()
+
+	requests = 0
+	err = NewWebhookNotifier(bad.URL).Notify(Event{Type: EventDailyStart, Market: "America"})
+	if err == nil || requests != 1 {
+		t.Errorf("4xx应返回错误且不重试,请求%d次:%v", requests, err)
+	}
+}
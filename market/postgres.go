@@ -0,0 +1,609 @@
+package market
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+//	所有上市公司共用一个PostgreSQL数据库的存储
+type postgresStore struct {
+	db *sql.DB
+}
+
+//	PostgreSQL事务
+type postgresTx struct {
+	tx       *sql.Tx
+	market   string
+	code     string
+	interval Interval
+}
+
+//	PostgreSQL表结构
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS process (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, day CHAR(8) NOT NULL, success BOOLEAN NOT NULL, PRIMARY KEY (market, company, day))`,
+	`CREATE TABLE IF NOT EXISTS peroid (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, session VARCHAR(8) NOT NULL, day CHAR(8) NOT NULL, time TIMESTAMP NOT NULL, open DOUBLE PRECISION NOT NULL, close DOUBLE PRECISION NOT NULL, high DOUBLE PRECISION NOT NULL, low DOUBLE PRECISION NOT NULL, volume BIGINT NOT NULL, PRIMARY KEY (market, company, session, time))`,
+	`ALTER TABLE peroid ADD COLUMN IF NOT EXISTS adjclose DOUBLE PRECISION NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS peroid_market_company_day ON peroid (market, company, day)`,
+	`CREATE TABLE IF NOT EXISTS error (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, day CHAR(8) NOT NULL, message TEXT NOT NULL, PRIMARY KEY (market, company, day))`,
+	`ALTER TABLE error ADD COLUMN IF NOT EXISTS created TIMESTAMP NOT NULL DEFAULT now()`,
+	//	间隔(interval是保留字,使用bar_interval),旧的主键换成包含间隔的唯一索引
+	`ALTER TABLE process ADD COLUMN IF NOT EXISTS bar_interval VARCHAR(4) NOT NULL DEFAULT '1m'`,
+	`ALTER TABLE process DROP CONSTRAINT IF EXISTS process_pkey`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS process_interval ON process (market, company, bar_interval, day)`,
+	`ALTER TABLE process ADD COLUMN IF NOT EXISTS validation TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE process ADD COLUMN IF NOT EXISTS sessions TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE peroid ADD COLUMN IF NOT EXISTS bar_interval VARCHAR(4) NOT NULL DEFAULT '1m'`,
+	`ALTER TABLE peroid DROP CONSTRAINT IF EXISTS peroid_pkey`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS peroid_interval ON peroid (market, company, bar_interval, session, time)`,
+	`ALTER TABLE error ADD COLUMN IF NOT EXISTS bar_interval VARCHAR(4) NOT NULL DEFAULT '1m'`,
+	`ALTER TABLE error DROP CONSTRAINT IF EXISTS error_pkey`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS error_interval ON error (market, company, bar_interval, day)`,
+	`CREATE TABLE IF NOT EXISTS dividend (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, time TIMESTAMP NOT NULL, amount DOUBLE PRECISION NOT NULL, PRIMARY KEY (market, company, time))`,
+	`CREATE TABLE IF NOT EXISTS split (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, time TIMESTAMP NOT NULL, numerator DOUBLE PRECISION NOT NULL, denominator DOUBLE PRECISION NOT NULL, ratio VARCHAR(20) NOT NULL, PRIMARY KEY (market, company, time))`,
+	`CREATE TABLE IF NOT EXISTS retry (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, day CHAR(8) NOT NULL, message TEXT NOT NULL, attempts INTEGER NOT NULL, dead BOOLEAN NOT NULL, updated TIMESTAMP NOT NULL, PRIMARY KEY (market, company, day))`,
+	`CREATE TABLE IF NOT EXISTS companies (market VARCHAR(32) NOT NULL, code VARCHAR(32) NOT NULL, name TEXT NOT NULL, exchange VARCHAR(32) NOT NULL, sector TEXT NOT NULL, industry TEXT NOT NULL, first_seen CHAR(8) NOT NULL, last_seen CHAR(8) NOT NULL, PRIMARY KEY (market, code))`,
+	`ALTER TABLE companies ADD COLUMN IF NOT EXISTS delisted CHAR(8) NOT NULL DEFAULT ''`,
+	`ALTER TABLE companies ADD COLUMN IF NOT EXISTS currency VARCHAR(8) NOT NULL DEFAULT ''`,
+	`ALTER TABLE companies ADD COLUMN IF NOT EXISTS kind VARCHAR(8) NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS listing (market VARCHAR(32) NOT NULL, code VARCHAR(32) NOT NULL, day CHAR(8) NOT NULL, change VARCHAR(8) NOT NULL, name TEXT NOT NULL, PRIMARY KEY (market, code, day, change))`,
+	`CREATE TABLE IF NOT EXISTS lastrun (market VARCHAR(32) NOT NULL, completed TIMESTAMP WITH TIME ZONE NOT NULL, PRIMARY KEY (market))`,
+	`CREATE TABLE IF NOT EXISTS bar (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, day CHAR(8) NOT NULL, open DOUBLE PRECISION NOT NULL, high DOUBLE PRECISION NOT NULL, low DOUBLE PRECISION NOT NULL, close DOUBLE PRECISION NOT NULL, volume BIGINT NOT NULL, PRIMARY KEY (market, company, day))`,
+	`CREATE TABLE IF NOT EXISTS export (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, target VARCHAR(20) NOT NULL, day CHAR(8) NOT NULL, PRIMARY KEY (market, company, target))`,
+	`CREATE TABLE IF NOT EXISTS activity (market VARCHAR(32) NOT NULL, code VARCHAR(32) NOT NULL, failures INTEGER NOT NULL, last_failed CHAR(8) NOT NULL, inactive CHAR(8) NOT NULL, PRIMARY KEY (market, code))`,
+	`CREATE TABLE IF NOT EXISTS checkpoint (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, bar_interval VARCHAR(4) NOT NULL, oldest CHAR(8) NOT NULL, newest CHAR(8) NOT NULL, PRIMARY KEY (market, company, bar_interval))`,
+	`CREATE TABLE IF NOT EXISTS crawl_stats (market VARCHAR(32) NOT NULL, company VARCHAR(32) NOT NULL, day CHAR(8) NOT NULL, bar_interval VARCHAR(4) NOT NULL, duration BIGINT NOT NULL, status INTEGER NOT NULL, bytes INTEGER NOT NULL, retries INTEGER NOT NULL, rows INTEGER NOT NULL, success BOOLEAN NOT NULL, PRIMARY KEY (market, day, company, bar_interval))`,
+}
+
+//	连接PostgreSQL并确保表结构存在
+func NewPostgresStore(dsn string) (Store, error) {
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, script := range postgresSchema {
+		_, err = db.Exec(script)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &postgresStore{db}, nil
+}
+
+//	针对某个上市公司启动事务
+func (s *postgresStore) Begin(market Market, code string) (Tx, error) {
+	return s.BeginInterval(market, code, Interval1m)
+}
+
+//	针对某个上市公司的指定间隔启动事务
+func (s *postgresStore) BeginInterval(market Market, code string, interval Interval) (Tx, error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	//	关闭时等待进行中的事务
+	return trackTx(&postgresTx{tx, market.Name(), code, interval}), nil
+}
+
+//	加入重试队列(已存在则忽略)
+func (s *postgresStore) EnqueueRetry(market Market, entry RetryEntry) error {
+	_, err := s.db.Exec("insert into retry values($1,$2,$3,$4,$5,$6,$7) on conflict do nothing",
+		market.Name(), entry.Company, entry.Day.Format("20060102"), entry.Message, entry.Attempts, entry.Dead, time.Now())
+	return err
+}
+
+//	重试队列中的所有条目
+func (s *postgresStore) RetryEntries(market Market) ([]RetryEntry, error) {
+
+	rows, err := s.db.Query("select company, day, message, attempts, dead, updated from retry where market=$1 order by day, company", market.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	location := locationYesterdayZero(market).Location()
+
+	entries := make([]RetryEntry, 0)
+	for rows.Next() {
+		var entry RetryEntry
+		var day string
+		err = rows.Scan(&entry.Company, &day, &entry.Message, &entry.Attempts, &entry.Dead, &entry.Updated)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Day, err = time.ParseInLocation("20060102", day, location)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+//	更新重试队列中的条目
+func (s *postgresStore) UpdateRetry(market Market, entry RetryEntry) error {
+	_, err := s.db.Exec("update retry set message=$1, attempts=$2, dead=$3, updated=$4 where market=$5 and company=$6 and day=$7",
+		entry.Message, entry.Attempts, entry.Dead, time.Now(), market.Name(), entry.Company, entry.Day.Format("20060102"))
+	return err
+}
+
+//	从重试队列中移除
+func (s *postgresStore) RemoveRetry(market Market, entry RetryEntry) error {
+	_, err := s.db.Exec("delete from retry where market=$1 and company=$2 and day=$3", market.Name(), entry.Company, entry.Day.Format("20060102"))
+	return err
+}
+
+//	市场所有上市公司在某日的错误数量
+func (s *postgresStore) CountErrors(market Market, day time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow("select count(*) from error where market=$1 and day=$2 and bar_interval=$3", market.Name(), day.Format("20060102"), Interval1m).Scan(&count)
+	return count, err
+}
+
+//	保存某日的上市公司列表
+func (s *postgresStore) SaveCompanies(market Market, companies []Company, day time.Time) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`insert into companies (market, code, name, exchange, currency, sector, industry, first_seen, last_seen, kind) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		on conflict (market, code) do update set name=excluded.name, exchange=excluded.exchange, currency=excluded.currency, sector=excluded.sector, industry=excluded.industry, last_seen=excluded.last_seen, delisted='', kind=excluded.kind`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	date := day.Format("20060102")
+	for _, company := range companies {
+		_, err = stmt.Exec(market.Name(), company.Code, company.Name, company.Exchange, company.Currency, company.Sector, company.Industry, date, date, company.Kind)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//	读取保存过的所有上市公司
+func (s *postgresStore) LoadCompanies(market Market) ([]Company, error) {
+
+	rows, err := s.db.Query("select code, name, exchange, currency, sector, industry, first_seen, last_seen, delisted, kind from companies where market=$1 order by code", market.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCompanies(market, rows)
+}
+
+//	保存上市和退市记录,退市的上市公司同时标记退市日期
+func (s *postgresStore) SaveListingChanges(market Market, changes []ListingChange) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		day := change.Day.Format("20060102")
+		_, err = tx.Exec("insert into listing values($1,$2,$3,$4,$5) on conflict do nothing", market.Name(), change.Code, day, change.Change, change.Name)
+		if err == nil && change.Change == ListingDelisted {
+			_, err = tx.Exec("update companies set delisted=$1 where market=$2 and code=$3", day, market.Name(), change.Code)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//	指定日期以来的上市和退市记录(按日期排序)
+func (s *postgresStore) ListingChanges(market Market, since time.Time) ([]ListingChange, error) {
+
+	rows, err := s.db.Query("select code, day, change, name from listing where market=$1 and day>=$2 order by day, code", market.Name(), since.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListingChanges(market, rows)
+}
+
+//	保存每日任务的完成时间
+func (s *postgresStore) SaveLastRun(market Market, completed time.Time) error {
+	_, err := s.db.Exec("insert into lastrun values($1,$2) on conflict (market) do update set completed=excluded.completed", market.Name(), completed)
+	return err
+}
+
+//	每日任务最后一次的完成时间
+func (s *postgresStore) LastRun(market Market) (time.Time, error) {
+
+	var completed time.Time
+	err := s.db.QueryRow("select completed from lastrun where market=$1", market.Name()).Scan(&completed)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+
+	return completed, err
+}
+
+//	保存上市公司指定间隔的历史任务进度
+func (s *postgresStore) SaveHistoryCheckpoint(market Market, code string, interval Interval, checkpoint HistoryCheckpoint) error {
+	_, err := s.db.Exec("insert into checkpoint values($1,$2,$3,$4,$5) on conflict (market, company, bar_interval) do update set oldest=excluded.oldest, newest=excluded.newest", market.Name(), code, interval, checkpoint.Oldest.Format("20060102"), checkpoint.Newest.Format("20060102"))
+	return err
+}
+
+//	上市公司指定间隔的历史任务进度
+func (s *postgresStore) LoadHistoryCheckpoint(market Market, code string, interval Interval) (HistoryCheckpoint, error) {
+
+	var oldest, newest string
+	err := s.db.QueryRow("select oldest, newest from checkpoint where market=$1 and company=$2 and bar_interval=$3", market.Name(), code, interval).Scan(&oldest, &newest)
+	if err == sql.ErrNoRows {
+		return HistoryCheckpoint{}, nil
+	}
+
+	if err != nil {
+		return HistoryCheckpoint{}, err
+	}
+
+	return parseHistoryCheckpoint(market, oldest, newest)
+}
+
+//	所有上市公司指定间隔的历史任务进度
+func (s *postgresStore) HistoryCheckpoints(market Market, interval Interval) (map[string]HistoryCheckpoint, error) {
+
+	rows, err := s.db.Query("select company, oldest, newest from checkpoint where market=$1 and bar_interval=$2", market.Name(), interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanHistoryCheckpoints(market, rows)
+}
+
+//	保存上市公司导出的最后日期
+func (s *postgresStore) SaveExportWatermark(market Market, code, target string, day time.Time) error {
+	_, err := s.db.Exec("insert into export values($1,$2,$3,$4) on conflict (market, company, target) do update set day=excluded.day", market.Name(), code, target, day.Format("20060102"))
+	return err
+}
+
+//	上市公司导出的最后日期
+func (s *postgresStore) LoadExportWatermark(market Market, code, target string) (time.Time, error) {
+
+	var day string
+	err := s.db.QueryRow("select day from export where market=$1 and company=$2 and target=$3", market.Name(), code, target).Scan(&day)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.ParseInLocation("20060102", day, locationYesterdayZero(market).Location())
+}
+
+//	不做任何处理,直接返回nil:PostgreSQL由autovacuum回收删除数据后的空间,不需要像sqlite那样手动VACUUM
+func (s *postgresStore) Compact(market Market, code string, interval Interval) error {
+	return nil
+}
+
+//	保存一次抓取的统计(用时以毫秒保存)
+func (s *postgresStore) SaveCrawlStat(market Market, stat CrawlStat) error {
+	_, err := s.db.Exec("insert into crawl_stats values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) on conflict (market, day, company, bar_interval) do update set duration=excluded.duration, status=excluded.status, bytes=excluded.bytes, retries=excluded.retries, rows=excluded.rows, success=excluded.success",
+		market.Name(), stat.Company, stat.Day.Format("20060102"), stat.Interval, stat.Duration.Nanoseconds()/int64(time.Millisecond), stat.Status, stat.Bytes, stat.Retries, stat.Rows, stat.Success)
+	return err
+}
+
+//	市场某日的抓取统计
+func (s *postgresStore) CrawlStats(market Market, day time.Time) ([]CrawlStat, error) {
+
+	rows, err := s.db.Query("select company, bar_interval, duration, status, bytes, retries, rows, success from crawl_stats where market=$1 and day=$2 order by company, bar_interval", market.Name(), day.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCrawlStats(market, day, rows)
+}
+
+//	上市公司的抓取情况
+func (s *postgresStore) LoadActivity(market Market, code string) (CompanyActivity, error) {
+
+	rows, err := s.db.Query("select code, failures, last_failed, inactive from activity where market=$1 and code=$2", market.Name(), code)
+	if err != nil {
+		return CompanyActivity{}, err
+	}
+	defer rows.Close()
+
+	activities, err := scanActivities(market, rows)
+	if err != nil || len(activities) == 0 {
+		return CompanyActivity{Market: market.Name(), Code: code}, err
+	}
+
+	return activities[0], nil
+}
+
+//	保存上市公司的抓取情况
+func (s *postgresStore) SaveActivity(market Market, activity CompanyActivity) error {
+	_, err := s.db.Exec("insert into activity values($1,$2,$3,$4,$5) on conflict (market, code) do update set failures=excluded.failures, last_failed=excluded.last_failed, inactive=excluded.inactive",
+		market.Name(), activity.Code, activity.Failures, formatDay(activity.LastFailed), formatDay(activity.Inactive))
+	return err
+}
+
+//	被标记为不活跃的上市公司
+func (s *postgresStore) InactiveCompanies(market Market) ([]CompanyActivity, error) {
+
+	rows, err := s.db.Query("select code, failures, last_failed, inactive from activity where market=$1 and inactive<>'' order by code", market.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanActivities(market, rows)
+}
+
+func (t *postgresTx) Interval() Interval {
+	return t.interval
+}
+
+func (t *postgresTx) IsProcessed(day time.Time) (bool, error) {
+
+	rows, err := t.tx.Query("select success from process where market=$1 and company=$2 and day=$3 and bar_interval=$4", t.market, t.code, day.Format("20060102"), t.interval)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), rows.Err()
+}
+
+func (t *postgresTx) MarkProcessed(day time.Time, success bool) error {
+	_, err := t.tx.Exec("insert into process(market, company, day, success, bar_interval) values($1,$2,$3,$4,$5) on conflict (market, company, bar_interval, day) do update set success=excluded.success, validation='', sessions=''",
+		t.market, t.code, day.Format("20060102"), success, t.interval)
+	return err
+}
+
+func (t *postgresTx) SaveValidation(day time.Time, summary string) error {
+	_, err := t.tx.Exec("update process set validation=$1 where market=$2 and company=$3 and day=$4 and bar_interval=$5", summary, t.market, t.code, day.Format("20060102"), t.interval)
+	return err
+}
+
+func (t *postgresTx) SaveSessions(day time.Time, sessions TradingSessions) error {
+	_, err := t.tx.Exec("update process set sessions=$1 where market=$2 and company=$3 and day=$4 and bar_interval=$5", sessions.String(), t.market, t.code, day.Format("20060102"), t.interval)
+	return err
+}
+
+func (t *postgresTx) LoadSessions(day time.Time) (TradingSessions, error) {
+
+	var text string
+	err := t.tx.QueryRow("select sessions from process where market=$1 and company=$2 and day=$3 and bar_interval=$4", t.market, t.code, day.Format("20060102"), t.interval).Scan(&text)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotProcessed
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTradingSessions(day, text)
+}
+
+func (t *postgresTx) SavePeriod(period string, peroids []Peroid60) error {
+
+	if len(peroids) == 0 {
+		return nil
+	}
+
+	stmt, err := t.tx.Prepare("insert into peroid(market, company, session, day, time, open, close, high, low, volume, adjclose, bar_interval) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) on conflict (market, company, bar_interval, session, time) do update set open=excluded.open, close=excluded.close, high=excluded.high, low=excluded.low, volume=excluded.volume, adjclose=excluded.adjclose")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range peroids {
+		_, err = stmt.Exec(t.market, t.code, period, p.Time.Format("20060102"), p.Time, p.Open, p.Close, p.High, p.Low, p.Volume, p.AdjClose, t.interval)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *postgresTx) SaveDividends(dividends []Dividend) error {
+
+	for _, d := range dividends {
+		_, err := t.tx.Exec("insert into dividend values($1,$2,$3,$4) on conflict (market, company, time) do update set amount=excluded.amount",
+			t.market, t.code, d.Time, d.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *postgresTx) SaveSplits(splits []Split) error {
+
+	for _, s := range splits {
+		_, err := t.tx.Exec("insert into split values($1,$2,$3,$4,$5,$6) on conflict (market, company, time) do update set numerator=excluded.numerator, denominator=excluded.denominator, ratio=excluded.ratio",
+			t.market, t.code, s.Time, s.Numerator, s.Denominator, s.Ratio)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *postgresTx) SaveError(day time.Time, message string) error {
+	_, err := t.tx.Exec("insert into error(market, company, day, message, created, bar_interval) values($1,$2,$3,$4,$5,$6) on conflict (market, company, bar_interval, day) do update set message=excluded.message, created=excluded.created",
+		t.market, t.code, day.Format("20060102"), message, time.Now(), t.interval)
+	return err
+}
+
+func (t *postgresTx) ProcessedDays(start, end time.Time) ([]time.Time, error) {
+
+	rows, err := t.tx.Query("select day from process where market=$1 and company=$2 and day >= $3 and day <= $4 and bar_interval=$5 order by day",
+		t.market, t.code, start.Format("20060102"), end.Format("20060102"), t.interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make([]time.Time, 0)
+	for rows.Next() {
+		var date string
+		err = rows.Scan(&date)
+		if err != nil {
+			return nil, err
+		}
+
+		day, err := time.ParseInLocation("20060102", date, start.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		days = append(days, day)
+	}
+
+	return days, rows.Err()
+}
+
+func (t *postgresTx) ClearProcessed(day time.Time) error {
+
+	_, err := t.tx.Exec("delete from process where market=$1 and company=$2 and day=$3 and bar_interval=$4", t.market, t.code, day.Format("20060102"), t.interval)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.tx.Exec("delete from error where market=$1 and company=$2 and day=$3 and bar_interval=$4", t.market, t.code, day.Format("20060102"), t.interval)
+
+	return err
+}
+
+func (t *postgresTx) Errors(start, end time.Time) ([]CrawlError, error) {
+
+	rows, err := t.tx.Query("select day, message, created from error where market=$1 and company=$2 and day >= $3 and day <= $4 and bar_interval=$5 order by day",
+		t.market, t.code, start.Format("20060102"), end.Format("20060102"), t.interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	errors := make([]CrawlError, 0)
+	for rows.Next() {
+		var date, message string
+		var created time.Time
+		err = rows.Scan(&date, &message, &created)
+		if err != nil {
+			return nil, err
+		}
+
+		day, err := time.ParseInLocation("20060102", date, start.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		errors = append(errors, CrawlError{Market: t.market, Company: t.code, Day: day, Message: message, Time: created})
+	}
+
+	return errors, rows.Err()
+}
+
+func (t *postgresTx) LoadPeriod(period string, start, end time.Time) ([]Peroid60, error) {
+
+	rows, err := t.tx.Query("select time, open, close, high, low, volume, adjclose from peroid where market=$1 and company=$2 and session=$3 and time >= $4 and time <= $5 and bar_interval=$6 order by time",
+		t.market, t.code, period, start, end, t.interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	peroids := make([]Peroid60, 0)
+	for rows.Next() {
+		p := Peroid60{Market: t.market, Code: t.code}
+		err = rows.Scan(&p.Time, &p.Open, &p.Close, &p.High, &p.Low, &p.Volume, &p.AdjClose)
+		if err != nil {
+			return nil, err
+		}
+
+		peroids = append(peroids, p)
+	}
+
+	return peroids, rows.Err()
+}
+
+func (t *postgresTx) CorporateActions(start, end time.Time) ([]CorporateAction, error) {
+
+	rows, err := t.tx.Query("select 'dividend', time, amount, 0, 0, '' from dividend where market=$1 and company=$2 and time >= $3 and time <= $4 union all select 'split', time, 0, numerator, denominator, ratio from split where market=$1 and company=$2 and time >= $3 and time <= $4 order by 2, 1",
+		t.market, t.code, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := make([]CorporateAction, 0)
+	for rows.Next() {
+		a := CorporateAction{Market: t.market, Company: t.code}
+		err = rows.Scan(&a.Type, &a.Time, &a.Amount, &a.Numerator, &a.Denominator, &a.Ratio)
+		if err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, a)
+	}
+
+	return actions, rows.Err()
+}
+
+func (t *postgresTx) DeletePeriod(period string, start, end time.Time) error {
+
+	_, err := t.tx.Exec("delete from peroid where market=$1 and company=$2 and session=$3 and time >= $4 and time <= $5 and bar_interval=$6",
+		t.market, t.code, period, start, end, t.interval)
+
+	return err
+}
+
+func (t *postgresTx) SaveBar(bar Bar) error {
+	_, err := t.tx.Exec("insert into bar values($1,$2,$3,$4,$5,$6,$7,$8) on conflict (market, company, day) do update set open=excluded.open, high=excluded.high, low=excluded.low, close=excluded.close, volume=excluded.volume",
+		t.market, t.code, bar.Day.Format("20060102"), bar.Open, bar.High, bar.Low, bar.Close, bar.Volume)
+	return err
+}
+
+func (t *postgresTx) LoadBar(day time.Time) (Bar, error) {
+
+	bar := Bar{Market: t.market, Code: t.code, Day: day}
+	err := t.tx.QueryRow("select open, high, low, close, volume from bar where market=$1 and company=$2 and day=$3", t.market, t.code, day.Format("20060102")).Scan(&bar.Open, &bar.High, &bar.Low, &bar.Close, &bar.Volume)
+	if err == sql.ErrNoRows {
+		return Bar{}, ErrNoData
+	}
+
+	return bar, err
+}
+
+func (t *postgresTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *postgresTx) Rollback() error {
+	return t.tx.Rollback()
+}
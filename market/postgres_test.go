@@ -0,0 +1,155 @@
+package market
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+//	测试用的PostgreSQL连接字符串,没有设置时跳过
+const postgresTestDSN = "STOCKRECORDER_TEST_POSTGRES_DSN"
+
+//	连接测试数据库(没有设置时跳过),cleanup删除测试市场的数据并关闭连接
+func newTestPostgresStore(t *testing.T, market Market) (*postgresStore, func()) {
+
+	dsn := os.Getenv(postgresTestDSN)
+	if dsn == "" {
+		t.Skipf("没有设置%s,跳过PostgreSQL测试", postgresTestDSN)
+	}
+
+	//	迁移可以重复执行(删除旧主键后使用包含间隔的唯一索引)
+	first, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.(*postgresStore).db.Close()
+
+	s, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := s.(*postgresStore)
+	cleanup := func() {
+		for _, table := range []string{"process", "peroid", "error", "activity"} {
+			ps.db.Exec("delete from "+table+" where market=$1", market.Name())
+		}
+		ps.db.Close()
+	}
+
+	return ps, cleanup
+}
+
+func TestPostgresPeriod(t *testing.T) {
+
+	market := mockMarket{name: "MockPostgres"}
+	s, cleanup := newTestPostgresStore(t, market)
+	defer cleanup()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	point := func(minute int, price float32) Peroid60 {
+		return Peroid60{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour*14 + time.Minute*time.Duration(minute)), Open: price, High: price + 1, Low: price - 1, Close: price, Volume: 100}
+	}
+
+	tx, err := s.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.SavePeriod("regular", []Peroid60{point(30, 10), point(31, 11)})
+	if err == nil {
+		//	重复保存时覆盖而不是报错
+		err = tx.SavePeriod("regular", []Peroid60{point(31, 12)})
+	}
+	if err == nil {
+		err = tx.MarkProcessed(day, true)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	其他间隔的同一天互不影响
+	other, err := s.BeginInterval(market, "AAA", Interval5m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = other.MarkProcessed(day, true)
+	if err == nil {
+		err = other.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = s.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	start, end := localDayRange(day, day)
+	peroids, err := tx.LoadPeriod("regular", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) != 2 || !peroids[0].Time.Equal(point(30, 10).Time) || peroids[0].Open != 10 || peroids[1].Open != 12 {
+		t.Fatalf("读取的分时数据不正确:%+v", peroids)
+	}
+
+	processed, err := tx.IsProcessed(day)
+	if err != nil || !processed {
+		t.Errorf("应该标记为已处理,实际%v,%v", processed, err)
+	}
+
+	err = tx.DeletePeriod("regular", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peroids, err = tx.LoadPeriod("regular", start, end)
+	if err != nil || len(peroids) != 0 {
+		t.Errorf("删除后不应有分时数据,实际%d条,%v", len(peroids), err)
+	}
+}
+
+func TestPostgresActivity(t *testing.T) {
+
+	market := mockMarket{name: "MockPostgresActivity"}
+	s, cleanup := newTestPostgresStore(t, market)
+	defer cleanup()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	err := s.SaveActivity(market, CompanyActivity{Market: market.Name(), Code: "AAA", Failures: 3, LastFailed: day, Inactive: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inactive, err := s.InactiveCompanies(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inactive) != 1 || inactive[0].Code != "AAA" || inactive[0].Failures != 3 {
+		t.Fatalf("应有1家不活跃的上市公司,实际%+v", inactive)
+	}
+
+	//	重新激活时覆盖
+	err = s.SaveActivity(market, CompanyActivity{Market: market.Name(), Code: "AAA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activity, err := s.LoadActivity(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if activity.IsInactive() || activity.Failures != 0 {
+		t.Errorf("重新激活后应该是活跃的,实际%+v", activity)
+	}
+}
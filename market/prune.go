@@ -0,0 +1,216 @@
+package market
+
+import (
+	"fmt"
+	"time"
+)
+
+//	删除(或试运行时统计)的数据
+type pruneCount struct {
+	//	处理状态
+	Days int
+	//	分时数据
+	Rows int
+	//	错误信息
+	Errors int
+}
+
+func (c *pruneCount) add(other pruneCount) {
+	c.Days += other.Days
+	c.Rows += other.Rows
+	c.Errors += other.Errors
+}
+
+func (c pruneCount) empty() bool {
+	return c.Days == 0 && c.Rows == 0 && c.Errors == 0
+}
+
+//	删除olderThan之前的分时数据、处理状态和错误信息(1d间隔和日线保留),运行期间锁定数据目录
+//	分时数据和清理(ArchiveBefore)一样先保存日线,配置了ArchiveDir时先存档
+//	试运行时不删除,只在日志中输出会删除的行数
+func Prune(market Market, olderThan time.Time) error {
+
+	//	历史任务抓取范围内的数据删除后会被重新抓取
+	historyDays := getSettings(market.Name()).historyDays
+	if latest := PruneCutoff(market, historyDays); olderThan.After(latest) {
+		return fmt.Errorf("[Retention]	%s抓取最近%d天的数据,只能删除%s之前的数据,实际为%s", market.Name(), historyDays, latest.Format("20060102"), olderThan.Format("20060102"))
+	}
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	_, err = prune(market, olderThan)
+
+	return err
+}
+
+//	保留days天时的起始日期(市场所在时区),之前的数据会被删除
+func PruneCutoff(market Market, days int) time.Time {
+
+	now := marketow(market)
+
+	return time.Date(now.Year(), now.Month(), now.Day()-days, 0, 0, 0, 0, now.Location())
+}
+
+//	逐个上市公司删除旧数据(每日任务运行时暂停),返回删除的数量
+func prune(market Market, cutoff time.Time) (pruneCount, error) {
+
+	dryRun := isDryRun()
+
+	//	包括已经退市的上市公司
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return pruneCount{}, err
+	}
+
+	logger.Info("删除旧数据-开始", "market", market.Name(), "cutoff", cutoff.Format("20060102"), "companies", len(companies), "dryrun", dryRun)
+
+	var total pruneCount
+	failed := 0
+	for _, company := range companies {
+
+		//	不和每日任务争抢数据库
+		for isDailyTaskRunning(market) {
+			time.Sleep(retentionWait)
+		}
+
+		count, err := pruneCompany(market, company.Code, cutoff, dryRun)
+		if err != nil {
+			logger.Error("删除上市公司的旧数据时出错", "market", market.Name(), "company", company.Code, "error", err)
+			failed++
+			continue
+		}
+
+		total.add(count)
+	}
+
+	if dryRun {
+		logger.Info("试运行,没有删除旧数据", "market", market.Name(), "days", total.Days, "rows", total.Rows, "errors", total.Errors, "failed", failed)
+	} else {
+		logger.Info("删除旧数据-结束", "market", market.Name(), "days", total.Days, "rows", total.Rows, "errors", total.Errors, "failed", failed)
+	}
+
+	if failed > 0 {
+		return total, fmt.Errorf("[Retention]\t删除%s的旧数据时有%d家上市公司出错", market.Name(), failed)
+	}
+
+	return total, nil
+}
+
+//	删除上市公司所有分时间隔的旧数据(不包括1d)
+func pruneCompany(market Market, code string, cutoff time.Time, dryRun bool) (pruneCount, error) {
+
+	var total pruneCount
+	for _, interval := range crawlIntervals() {
+		if interval == Interval1d {
+			continue
+		}
+
+		count, err := pruneCompanyInterval(market, code, interval, cutoff, dryRun)
+		if err != nil {
+			return total, err
+		}
+
+		total.add(count)
+		if dryRun || count.empty() {
+			continue
+		}
+
+		//	回收空间
+		err = store.Compact(market, code, interval)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+//	在一个事务中删除上市公司指定间隔cutoff之前的数据(试运行时只统计并回滚)
+func pruneCompanyInterval(market Market, code string, interval Interval, cutoff time.Time, dryRun bool) (pruneCount, error) {
+
+	tx, err := store.BeginInterval(market, code, interval)
+	if err != nil {
+		return pruneCount{}, err
+	}
+
+	count, err := pruneTx(tx, market, code, interval, cutoff, dryRun)
+	if err != nil {
+		tx.Rollback()
+		return pruneCount{}, err
+	}
+
+	if dryRun || count.empty() {
+		return count, tx.Rollback()
+	}
+
+	return count, tx.Commit()
+}
+
+//	统计并删除cutoff之前的数据,分时数据与清理时一样先保存日线和存档(archiveDay)
+func pruneTx(tx Tx, market Market, code string, interval Interval, cutoff time.Time, dryRun bool) (pruneCount, error) {
+
+	first := time.Date(1, 1, 1, 0, 0, 0, 0, cutoff.Location())
+	last := cutoff.AddDate(0, 0, -1)
+
+	days, err := tx.ProcessedDays(first, last)
+	if err != nil {
+		return pruneCount{}, err
+	}
+
+	errors, err := tx.Errors(first, last)
+	if err != nil {
+		return pruneCount{}, err
+	}
+
+	count := pruneCount{Days: len(days), Errors: len(errors)}
+
+	//	逐日处理,不一次读取所有的分时数据
+	for _, day := range days {
+		if dryRun {
+			list, err := loadSessions(tx, day)
+			if err != nil {
+				return pruneCount{}, err
+			}
+
+			count.Rows += sessionsRows(list)
+			continue
+		}
+
+		rows, err := archiveDay(tx, market, code, interval, day)
+		if err != nil {
+			return pruneCount{}, err
+		}
+
+		count.Rows += rows
+	}
+
+	if dryRun {
+		return count, nil
+	}
+
+	//	同时清除没有处理状态的错误信息
+	cleared := make(map[string]bool)
+	for _, day := range days {
+		cleared[day.Format("20060102")] = true
+	}
+
+	for _, e := range errors {
+		if !cleared[e.Day.Format("20060102")] {
+			cleared[e.Day.Format("20060102")] = true
+			days = append(days, e.Day)
+		}
+	}
+
+	for _, day := range days {
+		err = tx.ClearProcessed(day)
+		if err != nil {
+			return pruneCount{}, err
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,201 @@
+package market
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestPrune(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockPrune", "AAA")
+	defer cleanup()
+
+	old := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	failed := old.AddDate(0, 0, 1)
+	recent := old.AddDate(0, 0, 2)
+
+	err := store.SaveCompanies(market, market.companies, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := map[time.Time]*ParseResult{failed: {Success: false, Message: "没有数据"}}
+	for _, day := range []time.Time{old, recent} {
+		point := func(hour, minute int, price float32) Peroid60 {
+			return Peroid60{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour*time.Duration(hour) + time.Minute*time.Duration(minute)), Open: price, High: price + 1, Low: price - 1, Close: price, Volume: 100}
+		}
+
+		results[day] = &ParseResult{Success: true, Pre: []Peroid60{point(8, 0, 10)}, Regular: []Peroid60{point(9, 30, 11), point(9, 31, 12)}}
+	}
+
+	for day, result := range results {
+		tx, err := store.Begin(market, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, result)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//	试运行只统计
+	SetDryRun(true)
+	count, err := prune(market, recent)
+	SetDryRun(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := pruneCount{Days: 2, Rows: 3, Errors: 1}
+	if count != expected {
+		t.Errorf("试运行应统计%+v,实际%+v", expected, count)
+	}
+
+	_, err = DailyBar(market, "AAA", old)
+	if err != nil {
+		t.Fatalf("试运行不应删除数据:%v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Get().ArchiveDir = dir
+	defer func() { config.Get().ArchiveDir = "" }()
+
+	count, err = prune(market, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != expected {
+		t.Errorf("应删除%+v,实际%+v", expected, count)
+	}
+
+	//	与清理一样先保存日线和存档
+	bar, err := archivedBar(market, "AAA", old)
+	if err != nil || bar.Open != 11 || bar.Close != 12 {
+		t.Errorf("删除前应保存日线,实际%+v,%v", bar, err)
+	}
+
+	_, err = os.Stat(archivePath(market, "AAA", old, Interval1m))
+	if err != nil {
+		t.Errorf("删除前应存档分时数据:%v", err)
+	}
+
+	//	cutoff之前的处理状态也删除了
+	for _, day := range []time.Time{old, failed} {
+		_, err = DailyBar(market, "AAA", day)
+		if err != ErrNotProcessed {
+			t.Errorf("%s的数据应已删除,实际%v", day.Format("20060102"), err)
+		}
+	}
+
+	_, err = DailyBar(market, "AAA", recent)
+	if err != nil {
+		t.Errorf("cutoff当天的数据应该保留:%v", err)
+	}
+
+	//	再次删除时没有需要删除的数据
+	count, err = prune(market, recent)
+	if err != nil || !count.empty() {
+		t.Errorf("再次删除应删除0行,实际%+v,%v", count, err)
+	}
+}
+
+func TestPruneKeepsDaily(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockPruneDaily", "AAA")
+	defer cleanup()
+
+	c := config.Get()
+	defer func(intervals []string) { c.Intervals = intervals }(c.Intervals)
+	c.Intervals = []string{"1m", "1d"}
+
+	old := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	recent := old.AddDate(0, 0, 1)
+
+	err := store.SaveCompanies(market, market.companies, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, interval := range []Interval{Interval1m, Interval1d} {
+		tx, err := store.BeginInterval(market, "AAA", interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		point := Peroid60{Market: market.Name(), Code: "AAA", Time: old.Add(time.Hour*9 + time.Minute*30), Open: 10, High: 11, Low: 9, Close: 10, Volume: 100}
+		err = saveResult(tx, old, &ParseResult{Success: true, Regular: []Peroid60{point}})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := prune(market, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := pruneCount{Days: 1, Rows: 1}
+	if count != expected {
+		t.Errorf("应只删除1m的数据%+v,实际%+v", expected, count)
+	}
+
+	//	1d的数据保留
+	tx, err := store.BeginInterval(market, "AAA", Interval1d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	days, err := tx.ProcessedDays(old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 1 {
+		t.Errorf("1d的处理状态应该保留,实际%v", days)
+	}
+}
+
+func TestPruneHistoryDays(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockPruneHistory", "AAA")
+	defer cleanup()
+
+	c := config.Get()
+	defer func(days int) { c.HistoryDays = days }(c.HistoryDays)
+	c.HistoryDays = 30
+
+	//	每日任务仍会抓取的数据不能删除
+	err := Prune(market, PruneCutoff(market, 10))
+	if err == nil {
+		t.Error("删除历史任务抓取范围内的数据时应该报错")
+	}
+
+	err = Prune(market, PruneCutoff(market, 30))
+	if err != nil {
+		t.Errorf("保留的天数等于HistoryDays时应该可以删除:%v", err)
+	}
+
+	cutoff := PruneCutoff(market, 30)
+	if cutoff.Location().String() != market.Timezone() {
+		t.Errorf("应按市场所在时区计算日期,实际%s", cutoff.Location())
+	}
+}
@@ -5,13 +5,89 @@ import (
 	"time"
 )
 
+//	可以查询的交易时段
+var periods = map[string]bool{"pre": true, "regular": true, "post": true}
+
 //	查询
 func QueryPeroid60(market, code string, start, end time.Time) ([]Peroid60, error) {
 
-	_market, found := markets[market]
+	_market, found := Get(market)
 	if !found {
 		return nil, fmt.Errorf("[Query]\t未能找到市场%s", market)
 	}
 
-	return loadPeroid(_market, code, start, end, "regular")
+	return loadPeriods(_market, code, start, end, "regular")
+}
+
+//	按间隔查询常规交易时段的数据
+func QueryInterval(market, code string, interval Interval, start, end time.Time) ([]Peroid60, error) {
+
+	_market, found := Get(market)
+	if !found {
+		return nil, fmt.Errorf("[Query]\t未能找到市场%s", market)
+	}
+
+	return loadIntervalPeriods(_market, code, interval, start, end, "regular")
+}
+
+//	读取上市公司某日某个交易时段(pre, regular, post)的分时数据
+func LoadPeriods(market Market, company string, day time.Time, period string) ([]Peroid60, error) {
+	return LoadPeriodsRange(market, company, day, day, period)
+}
+
+//	读取上市公司在指定日期范围内某个交易时段(pre, regular, post)的分时数据
+func LoadPeriodsRange(market Market, company string, from, to time.Time, period string) ([]Peroid60, error) {
+
+	return LoadIntervalPeriodsRange(market, company, Interval1m, from, to, period)
+}
+
+//	读取上市公司在指定日期范围内某个间隔某个交易时段(pre, regular, post)的数据
+func LoadIntervalPeriodsRange(market Market, company string, interval Interval, from, to time.Time, period string) ([]Peroid60, error) {
+
+	start, end := localDayRange(from, to)
+
+	return loadIntervalPeriods(market, company, interval, start, end, period)
+}
+
+//	上市公司在指定日期范围内处理过的日期(按日期排序)
+func ProcessedDays(market Market, company string, from, to time.Time) ([]time.Time, error) {
+
+	tx, err := store.Begin(market, company)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return tx.ProcessedDays(from, to)
+}
+
+//	分时数据的时间按市场当地时间的年月日时分保存
+func localDayRange(from, to time.Time) (time.Time, time.Time) {
+	return time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local),
+		time.Date(to.Year(), to.Month(), to.Day(), 23, 59, 59, 0, time.Local)
+}
+
+//	读取分时数据
+func loadPeriods(market Market, company string, start, end time.Time, period string) ([]Peroid60, error) {
+	return loadIntervalPeriods(market, company, Interval1m, start, end, period)
+}
+
+//	读取指定间隔的数据
+func loadIntervalPeriods(market Market, company string, interval Interval, start, end time.Time, period string) ([]Peroid60, error) {
+
+	if !periods[period] {
+		return nil, fmt.Errorf("[Query]\t错误的交易时段%s", period)
+	}
+
+	if !intervals[interval] {
+		return nil, fmt.Errorf("[Query]\t错误的间隔%s", interval)
+	}
+
+	tx, err := store.BeginInterval(market, company, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return tx.LoadPeriod(period, start, end)
 }
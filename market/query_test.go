@@ -3,33 +3,97 @@ package market
 import (
 	"testing"
 	"time"
+)
+
+func TestQueryPeroid60(t *testing.T) {
 
-	"log"
+	market, cleanup := newMockMarket(t, "MockQuery", "AAA")
+	defer cleanup()
 
-	"github.com/nzai/stockrecorder/config"
-)
+	Add(market)
+	defer Remove(market.Name())
 
-func init() {
+	day := time.Date(2015, 10, 1, 0, 0, 0, 0, time.Local)
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	//	读取配置文件
-	err := config.Init()
+	err = saveResult(tx, day, &ParseResult{Success: true,
+		Pre:     []Peroid60{{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour * 8), Open: 1, High: 1, Low: 1, Close: 1, Volume: 10}},
+		Regular: []Peroid60{{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour * 10), Open: 1, High: 2, Low: 1, Close: 2, Volume: 100}, {Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour*10 + time.Minute), Open: 2, High: 3, Low: 2, Close: 3, Volume: 200}}})
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		log.Fatal("读取配置文件发生错误: ", err)
-		return
+		t.Fatal(err)
+	}
+
+	//	只查询常规交易时段
+	peroids, err := QueryPeroid60(market.Name(), "AAA", day, day.Add(time.Hour*24-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) != 2 || peroids[0].Close != 2 || peroids[1].Volume != 200 {
+		t.Errorf("应查询到2条常规交易时段的分时数据,实际%+v", peroids)
+	}
+
+	_, err = QueryPeroid60("Nowhere", "AAA", day, day)
+	if err == nil {
+		t.Errorf("没有的市场应返回错误")
 	}
-	
-	markets["America"] = America{}
 }
 
-func TestQueryPeroid60(t *testing.T) {
+func TestGetMarket(t *testing.T) {
 
-	start, _ := time.Parse("20060102", "20151001")
-	end, _ := time.Parse("20060102", "20151001")
-	peroids, err := QueryPeroid60("America", "AAPL", start, end)
+	market, err := GetMarket("America")
+	if err != nil || market.Name() != "America" {
+		t.Fatalf("应找到America,实际%v,%v", market, err)
+	}
+
+	_, err = GetMarket("Nowhere")
+	if err != ErrMarketNotFound {
+		t.Errorf("没有的市场应返回ErrMarketNotFound,实际%v", err)
+	}
+
+	found := false
+	for _, name := range MarketNames() {
+		found = found || name == "America"
+	}
+
+	if !found {
+		t.Errorf("市场名称中应包括America:%v", MarketNames())
+	}
+}
+
+func TestProcessedDays(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockProcessedDays", "AAA")
+	defer cleanup()
+
+	first := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	for _, day := range []time.Time{first, first.AddDate(0, 0, 3)} {
+		tx, err := store.Begin(market, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = tx.MarkProcessed(day, true)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	days, err := ProcessedDays(market, "AAA", first.AddDate(0, 0, 1), first.AddDate(0, 0, 10))
 	if err != nil {
-		log.Fatal("查询分时数据发生错误: ", err)
-		return
+		t.Fatal(err)
 	}
 
-	t.Log(len(peroids))
+	if len(days) != 1 || days[0].Format("20060102") != "20240108" {
+		t.Errorf("应只有20240108,实际%v", days)
+	}
 }
@@ -0,0 +1,129 @@
+package market
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/metrics"
+)
+
+//	限速器(同一市场的所有抓取共用)
+type RateLimiter interface {
+	//	等待直到可以发起下一个请求
+	Wait()
+	//	被数据源限速时调用
+	Throttle()
+	//	当前每秒请求数
+	Rate() float64
+}
+
+var (
+	rateLimiters      = make(map[string]RateLimiter)
+	rateLimitersMutex sync.Mutex
+)
+
+//	设置市场的限速器(用于测试或自定义限速策略)
+func SetRateLimiter(marketName string, l RateLimiter) {
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+
+	rateLimiters[marketName] = l
+}
+
+//	获取市场的限速器,没有设置时按配置创建
+func getRateLimiter(market Market) RateLimiter {
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+
+	l, found := rateLimiters[market.Name()]
+	if !found {
+		l = NewAdaptiveLimiter(market.Name(), config.Get().RateLimit, time.Second*time.Duration(config.Get().RateLimitCooldown))
+		rateLimiters[market.Name()] = l
+	}
+
+	return l
+}
+
+//	自适应限速器:被限速时速率减半并暂停所有请求,冷却后逐步恢复
+type adaptiveLimiter struct {
+	market   string
+	max      float64
+	cooldown time.Duration
+
+	mutex       sync.Mutex
+	rate        float64
+	next        time.Time
+	pausedUntil time.Time
+	changed     time.Time
+}
+
+//	创建自适应限速器(rate为每秒最大请求数)
+func NewAdaptiveLimiter(marketName string, rate float64, cooldown time.Duration) RateLimiter {
+
+	l := &adaptiveLimiter{market: marketName, max: rate, rate: rate, cooldown: cooldown, changed: time.Now()}
+	metrics.RateLimit.WithLabelValues(marketName).Set(rate)
+
+	return l
+}
+
+func (l *adaptiveLimiter) Wait() {
+
+	l.mutex.Lock()
+
+	now := time.Now()
+
+	//	冷却后逐步恢复
+	if l.rate < l.max && now.After(l.pausedUntil) && now.Sub(l.changed) >= l.cooldown {
+		l.rate *= 2
+		if l.rate > l.max {
+			l.rate = l.max
+		}
+		l.changed = now
+
+		metrics.RateLimit.WithLabelValues(l.market).Set(l.rate)
+		logger.Info("恢复请求频率", "market", l.market, "rate", l.rate)
+	}
+
+	//	计算下一个请求的时间
+	at := now
+	if l.next.After(at) {
+		at = l.next
+	}
+	if l.pausedUntil.After(at) {
+		at = l.pausedUntil
+	}
+	l.next = at.Add(time.Duration(float64(time.Second) / l.rate))
+
+	l.mutex.Unlock()
+
+	time.Sleep(at.Sub(now))
+}
+
+func (l *adaptiveLimiter) Throttle() {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	//	暂停期间的重复通知只计一次
+	if now.Before(l.pausedUntil) {
+		return
+	}
+
+	l.rate /= 2
+	l.pausedUntil = now.Add(l.cooldown)
+	l.changed = l.pausedUntil
+
+	metrics.RateLimit.WithLabelValues(l.market).Set(l.rate)
+	logger.Warn("被数据源限速,降低请求频率", "market", l.market, "rate", l.rate, "cooldown", l.cooldown)
+}
+
+func (l *adaptiveLimiter) Rate() float64 {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.rate
+}
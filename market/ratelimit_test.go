@@ -0,0 +1,34 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterThrottle(t *testing.T) {
+
+	l := NewAdaptiveLimiter("MockLimit", 100, time.Millisecond*50)
+
+	l.Throttle()
+	if l.Rate() != 50 {
+		t.Fatalf("被限速后速率应减半,实际%v", l.Rate())
+	}
+
+	//	暂停期间的重复通知只计一次
+	l.Throttle()
+	if l.Rate() != 50 {
+		t.Fatalf("暂停期间重复限速不应再次降低速率,实际%v", l.Rate())
+	}
+
+	start := time.Now()
+	l.Wait()
+	if time.Since(start) < time.Millisecond*40 {
+		t.Fatalf("暂停期间应等待冷却结束")
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	l.Wait()
+	if l.Rate() != 100 {
+		t.Fatalf("冷却后速率应恢复,实际%v", l.Rate())
+	}
+}
@@ -0,0 +1,291 @@
+package market
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	原始数据存档文件路径(RawDir/市场/上市公司/日期_raw.txt.gz,1m以外的间隔在文件名中加上间隔)
+func rawPath(market Market, code string, day time.Time, interval Interval) string {
+
+	name := day.Format("20060102")
+	if interval != Interval1m {
+		name += "_" + string(interval)
+	}
+
+	return filepath.Join(config.Get().RawDir, market.Name(), code, name+rawSuffix+".gz")
+}
+
+//	gzip压缩存档原始数据(没有配置存档目录时忽略)
+func archiveRaw(market Market, code string, day time.Time, interval Interval, raw string) error {
+
+	if config.Get().RawDir == "" {
+		return nil
+	}
+
+	path := rawPath(market, code, day, interval)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	//	先写临时文件,避免留下不完整的存档
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer, err := gzip.NewWriterLevel(file, rawCompressionLevel())
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	_, err = writer.Write([]byte(raw))
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+//	原始数据存档的压缩级别(1到9,没有配置时为gzip的默认级别)
+func rawCompressionLevel() int {
+
+	if level := config.Get().RawCompressionLevel; level != 0 {
+		return level
+	}
+
+	return gzip.DefaultCompression
+}
+
+//	检查原始数据存档的压缩级别
+func validateRawConfig() error {
+
+	level := config.Get().RawCompressionLevel
+	if level < 0 || level > gzip.BestCompression {
+		return fmt.Errorf("[Config]\t原始数据的压缩级别(RawCompressionLevel)必须在1到9之间,实际为%d", level)
+	}
+
+	return nil
+}
+
+//	读取存档的1m原始数据(兼容没有压缩的旧存档)
+func loadRaw(market Market, code string, day time.Time) ([]byte, error) {
+
+	path := rawPath(market, code, day, Interval1m)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ioutil.ReadFile(strings.TrimSuffix(path, ".gz"))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+//	用存档的原始数据重新解析并覆盖指定日期范围内的分时数据(没有存档的日期忽略,都没有存档时返回错误),运行期间锁定数据目录
+func Reparse(marketName, companyCode string, from, to time.Time) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return fmt.Errorf("[Reparse]\t未能找到市场%s", marketName)
+	}
+
+	if config.Get().RawDir == "" {
+		return fmt.Errorf("[Reparse]\t没有配置原始数据的存档目录")
+	}
+
+	//	按市场所在时区取整到0点
+	location := locationYesterdayZero(market).Location()
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, location)
+
+	count := 0
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+
+		raw, err := loadRaw(market, companyCode, day)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("[Reparse]\t读取%s在%s的原始数据时出错:%s", companyCode, day.Format("20060102"), err.Error())
+		}
+
+		err = reparseDay(market, companyCode, day, raw)
+		if err != nil {
+			return fmt.Errorf("[Reparse]\t重新解析%s在%s的原始数据时出错:%s", companyCode, day.Format("20060102"), err.Error())
+		}
+
+		count++
+	}
+
+	if count == 0 {
+		return fmt.Errorf("[Reparse]\t%s在%s到%s之间没有存档的原始数据", companyCode, from.Format("20060102"), to.Format("20060102"))
+	}
+
+	logger.Info("重新解析原始数据已结束", "market", market.Name(), "company", companyCode, "days", count)
+
+	return nil
+}
+
+//	用存档的原始数据重新解析并覆盖某日的分时数据(没有存档时返回错误),运行期间锁定数据目录
+func Reprocess(marketName, companyCode string, day time.Time) error {
+	return Reparse(marketName, companyCode, day, day)
+}
+
+//	用存档的原始数据重新解析并覆盖市场所有上市公司某日的分时数据,不访问网络(运行期间锁定数据目录)
+//	上市公司为保存过的列表和存档目录中的并集,没有存档的上市公司跳过并记录日志,有上市公司解析失败时返回错误
+func ReprocessAll(market Market, day time.Time) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	if config.Get().RawDir == "" {
+		return fmt.Errorf("[Reparse]\t没有配置原始数据的存档目录")
+	}
+
+	//	按市场所在时区取整到0点
+	location := locationYesterdayZero(market).Location()
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+
+	codes, err := rawCompanies(market)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0)
+	failed := 0
+	for _, code := range codes {
+		raw, err := loadRaw(market, code, day)
+		if os.IsNotExist(err) {
+			missing = append(missing, code)
+			continue
+		}
+
+		if err == nil {
+			err = reparseDay(market, code, day, raw)
+		}
+
+		if err != nil {
+			logger.Error("重新解析原始数据出错", "market", market.Name(), "company", code, "day", day.Format("20060102"), "error", err)
+			failed++
+		}
+	}
+
+	if len(missing) > 0 {
+		logger.Warn("没有存档的原始数据,已跳过", "market", market.Name(), "day", day.Format("20060102"), "count", len(missing), "companies", strings.Join(missing, ","))
+	}
+
+	logger.Info("重新解析市场的原始数据已结束", "market", market.Name(), "day", day.Format("20060102"), "companies", len(codes), "missing", len(missing), "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("[%s]\t重新解析%s的原始数据时有%d家上市公司失败", market.Name(), day.Format("20060102"), failed)
+	}
+
+	return nil
+}
+
+//	保存过的上市公司和存档目录中的上市公司(按代码排序)
+func rawCompanies(market Market) ([]string, error) {
+
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return nil, err
+	}
+
+	dict := make(map[string]bool, len(companies))
+	for _, company := range companies {
+		dict[company.Code] = true
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(config.Get().RawDir, market.Name()))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			dict[info.Name()] = true
+		}
+	}
+
+	codes := make([]string, 0, len(dict))
+	for code := range dict {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	return codes, nil
+}
+
+//	在一个事务中重新解析并覆盖某日的分时数据
+func reparseDay(market Market, code string, day time.Time, raw []byte) error {
+
+	result, err := parseRaw(market, code, day, raw)
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTx(market, code, Interval1m)
+	if err != nil {
+		return err
+	}
+
+	//	清除之前的处理状态、错误信息和分时数据
+	err = tx.ClearProcessed(day)
+
+	start, end := localDayRange(day, day)
+	for _, session := range sessions {
+		if err == nil {
+			err = tx.DeletePeriod(session, start, end)
+		}
+	}
+
+	if err == nil {
+		err = saveResult(tx, day, result)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,268 @@
+package market
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestReparse(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockReparse", "AAPL")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Get().RawDir = dir
+	defer func() { config.Get().RawDir = "" }()
+
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_v8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	err = archiveRaw(market, "AAPL", day, Interval1m, string(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	重复解析不应产生重复数据
+	for index := 0; index < 2; index++ {
+		err = Reparse(market.Name(), "AAPL", day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := store.Begin(market, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	processed, err := tx.IsProcessed(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !processed {
+		t.Errorf("重新解析后应该标记为已处理")
+	}
+
+	peroids, err := tx.LoadPeriod("regular", time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) != 2 {
+		t.Errorf("应该有2条常规交易时段数据,实际%d条", len(peroids))
+	}
+}
+
+func TestReprocess(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockReprocessRaw", "AAPL")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Get().RawDir = dir
+	defer func() { config.Get().RawDir = "" }()
+
+	//	抓取时存档原始数据
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	_, err = companyTransaction(market, Company{Market: market.Name(), Code: "AAPL"}, day, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(rawPath(market, "AAPL", day, Interval1m)); err != nil {
+		t.Fatalf("抓取后应存档原始数据:%v", err)
+	}
+
+	err = Reprocess(market.Name(), "AAPL", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	测试用数据的时间是1970-01-01
+	peroids, err := LoadPeriodsRange(market, "AAPL", time.Unix(0, 0), time.Now(), "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) != 1 {
+		t.Errorf("重新解析后应有1条常规交易时段数据,实际%d条", len(peroids))
+	}
+
+	//	没有存档的日期
+	err = Reprocess(market.Name(), "AAPL", day.AddDate(0, 0, 1))
+	if err == nil {
+		t.Errorf("没有存档的原始数据时应该返回错误")
+	}
+}
+
+func TestReprocessDryRun(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockReprocessDryRun", "AAPL")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Get().RawDir = dir
+	defer func() { config.Get().RawDir = "" }()
+
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_v8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	err = archiveRaw(market, "AAPL", day, Interval1m, string(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	试运行时只解析不写入
+	SetDryRun(true)
+	err = Reprocess(market.Name(), "AAPL", day)
+	SetDryRun(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days, err := ProcessedDays(market, "AAPL", day, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != 0 {
+		t.Errorf("试运行时不应保存处理状态,实际%v", days)
+	}
+}
+
+func TestReprocessAll(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockReprocessAll", "AAA", "BBB")
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Get().RawDir = dir
+	defer func() { config.Get().RawDir = "" }()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	err = store.SaveCompanies(market, market.companies, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	BBB没有存档,CCC不在上市公司列表中但有存档
+	for _, code := range []string{"AAA", "CCC"} {
+		err = archiveRaw(market, code, day, Interval1m, mockYahooJson)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ReprocessAll(market, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for code, expected := range map[string]int{"AAA": 1, "BBB": 0, "CCC": 1} {
+		peroids, err := LoadPeriodsRange(market, code, time.Unix(0, 0), time.Now(), "regular")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(peroids) != expected {
+			t.Errorf("%s应有%d条常规交易时段数据,实际%d条", code, expected, len(peroids))
+		}
+	}
+
+	//	解析失败时返回错误
+	err = archiveRaw(market, "AAA", day, Interval1m, "{")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReprocessAll(market, day)
+	if err == nil {
+		t.Errorf("有上市公司解析失败时应该返回错误")
+	}
+}
+
+func TestRawCompression(t *testing.T) {
+
+	market := mockMarket{name: "MockRawCompression"}
+
+	dir, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := config.Get()
+	defer func(rawDir string, level int) { c.RawDir, c.RawCompressionLevel = rawDir, level }(c.RawDir, c.RawCompressionLevel)
+	c.RawDir, c.RawCompressionLevel = dir, 9
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	err = archiveRaw(market, "AAA", day, Interval1m, mockYahooJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := loadRaw(market, "AAA", day)
+	if err != nil || string(raw) != mockYahooJson {
+		t.Errorf("读取压缩的存档不正确:%s %v", raw, err)
+	}
+
+	//	没有压缩的旧存档
+	path := strings.TrimSuffix(rawPath(market, "BBB", day, Interval1m), ".gz")
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(mockYahooJson), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err = loadRaw(market, "BBB", day)
+	if err != nil || string(raw) != mockYahooJson {
+		t.Errorf("读取没有压缩的存档不正确:%s %v", raw, err)
+	}
+
+	c.RawCompressionLevel = 10
+	if validateRawConfig() == nil {
+		t.Errorf("错误的压缩级别应该返回错误")
+	}
+}
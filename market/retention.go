@@ -0,0 +1,401 @@
+package market
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	分时数据存档文件的后缀
+	archiveSuffix = ".json.gz"
+	//	每日任务运行时清理任务等待的间隔
+	retentionWait = time.Minute
+)
+
+//	分时数据存档文件路径(ArchiveDir/市场/上市公司/日期.json.gz,1m以外的间隔在文件名中加上间隔)
+func archivePath(market Market, code string, day time.Time, interval Interval) string {
+
+	name := day.Format("20060102")
+	if interval != Interval1m {
+		name += "_" + string(interval)
+	}
+
+	return filepath.Join(config.Get().ArchiveDir, market.Name(), code, name+archiveSuffix)
+}
+
+//	定时清理超过保留期限的分时数据并删除超过保留天数的旧数据(done关闭时停止)
+func retentionTask(market Market, done <-chan struct{}) {
+
+	ticker := time.NewTicker(time.Hour * time.Duration(config.Get().RetentionInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if config.Get().RetentionMonths > 0 {
+				_, err := archiveBefore(market, retentionCutoff(market))
+				if err != nil {
+					logger.Error("清理分时数据时出错", "market", market.Name(), "error", err)
+				}
+			}
+
+			if config.Get().RetentionDays > 0 {
+				err := Prune(market, PruneCutoff(market, config.Get().RetentionDays))
+				if err != nil {
+					logger.Error("删除旧数据时出错", "market", market.Name(), "error", err)
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+//	保留期限的起始日期(市场所在时区)
+func retentionCutoff(market Market) time.Time {
+
+	now := marketow(market)
+
+	return time.Date(now.Year(), now.Month()-time.Month(config.Get().RetentionMonths), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+//	存档(配置了ArchiveDir时)并删除cutoff之前的分时数据,只保留日线(运行期间锁定数据目录)
+func ArchiveBefore(marketName string, cutoff time.Time) error {
+
+	err := Lock()
+	if err != nil {
+		return err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return fmt.Errorf("[Retention]\t未能找到市场%s", marketName)
+	}
+
+	_, err = archiveBefore(market, cutoff)
+
+	return err
+}
+
+//	逐个上市公司清理分时数据(每日任务运行时暂停),返回清理的天数
+func archiveBefore(market Market, cutoff time.Time) (int, error) {
+
+	if isDryRun() {
+		logger.Info("试运行时不清理分时数据", "market", market.Name())
+		return 0, nil
+	}
+
+	//	包括已经退市的上市公司
+	companies, err := store.LoadCompanies(market)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info("清理分时数据-开始", "market", market.Name(), "cutoff", cutoff.Format("20060102"), "companies", len(companies))
+
+	total, failed := 0, 0
+	for _, company := range companies {
+
+		//	不和每日任务争抢数据库
+		for isDailyTaskRunning(market) {
+			time.Sleep(retentionWait)
+		}
+
+		count, err := archiveCompany(market, company.Code, cutoff)
+		if err != nil {
+			logger.Error("清理上市公司的分时数据时出错", "market", market.Name(), "company", company.Code, "error", err)
+			failed++
+			continue
+		}
+
+		total += count
+	}
+
+	logger.Info("清理分时数据-结束", "market", market.Name(), "days", total, "failed", failed)
+
+	if failed > 0 {
+		return total, fmt.Errorf("[Retention]\t清理%s的分时数据时有%d家上市公司出错", market.Name(), failed)
+	}
+
+	return total, nil
+}
+
+//	清理上市公司所有分时间隔的数据(不包括1d),返回清理的天数
+func archiveCompany(market Market, code string, cutoff time.Time) (int, error) {
+
+	total := 0
+	for _, interval := range crawlIntervals() {
+		if interval == Interval1d {
+			continue
+		}
+
+		count, err := archiveCompanyInterval(market, code, interval, cutoff)
+		if err != nil {
+			return total, err
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		//	回收空间
+		err = store.Compact(market, code, interval)
+		if err != nil {
+			return total, err
+		}
+
+		total += count
+	}
+
+	return total, nil
+}
+
+//	在一个事务中清理上市公司指定间隔cutoff之前的分时数据(1m同时保存日线),返回清理的天数
+func archiveCompanyInterval(market Market, code string, interval Interval, cutoff time.Time) (int, error) {
+
+	tx, err := store.BeginInterval(market, code, interval)
+	if err != nil {
+		return 0, err
+	}
+
+	days, err := tx.ProcessedDays(time.Date(1, 1, 1, 0, 0, 0, 0, cutoff.Location()), cutoff.AddDate(0, 0, -1))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	count := 0
+	for _, day := range days {
+		rows, err := archiveDay(tx, market, code, interval, day)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		if rows > 0 {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, tx.Rollback()
+	}
+
+	return count, tx.Commit()
+}
+
+//	保存日线、存档并删除某日的分时数据,返回删除的条数(已经清理过或者当天失败时为0)
+func archiveDay(tx Tx, market Market, code string, interval Interval, day time.Time) (int, error) {
+
+	list, err := loadSessions(tx, day)
+	if err != nil {
+		return 0, err
+	}
+
+	count := sessionsRows(list)
+	if count == 0 {
+		return 0, nil
+	}
+
+	//	保留日线
+	if interval == Interval1m {
+		bar, err := sessionsBar(market, code, day, list, false)
+		if err == nil {
+			err = tx.SaveBar(bar)
+		}
+
+		if err != nil && err != ErrNoData {
+			return 0, err
+		}
+	}
+
+	//	先存档再删除
+	if config.Get().ArchiveDir != "" {
+		err = writeArchive(archivePath(market, code, day, interval), list)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	start, end := localDayRange(day, day)
+	for _, sp := range list {
+		err = tx.DeletePeriod(sp.Session, start, end)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+//	分时数据的条数
+func sessionsRows(list []sessionPeriods) int {
+
+	count := 0
+	for _, sp := range list {
+		count += len(sp.Peroids)
+	}
+
+	return count
+}
+
+//	gzip压缩保存某日的分时数据
+func writeArchive(path string, list []sessionPeriods) error {
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	//	先写临时文件,避免留下不完整的存档
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	writer := gzip.NewWriter(file)
+	err = json.NewEncoder(writer).Encode(list)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+//	读取存档的某日分时数据
+func readArchive(path string) ([]sessionPeriods, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var list []sessionPeriods
+	err = json.NewDecoder(reader).Decode(&list)
+	if err != nil {
+		return nil, fmt.Errorf("[Retention]\t解析存档%s出错:%s", path, err.Error())
+	}
+
+	return list, nil
+}
+
+//	从存档重新导入上市公司指定日期范围内的分时数据(存档文件保留),返回导入的天数(运行期间锁定数据目录)
+func RestoreArchive(marketName, companyCode string, from, to time.Time) (int, error) {
+
+	err := Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return 0, fmt.Errorf("[Retention]\t未能找到市场%s", marketName)
+	}
+
+	if config.Get().ArchiveDir == "" {
+		return 0, fmt.Errorf("[Retention]\t没有配置分时数据的存档目录(ArchiveDir)")
+	}
+
+	dir := filepath.Join(config.Get().ArchiveDir, market.Name(), companyCode)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	//	按间隔分组,每个间隔一个事务
+	paths := make(map[Interval][]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), archiveSuffix)
+		if name == file.Name() {
+			continue
+		}
+
+		date, interval := name, Interval1m
+		if index := strings.Index(name, "_"); index > 0 {
+			date, interval = name[:index], Interval(name[index+1:])
+		}
+
+		if !intervals[interval] || date < from.Format("20060102") || date > to.Format("20060102") {
+			continue
+		}
+
+		paths[interval] = append(paths[interval], filepath.Join(dir, file.Name()))
+	}
+
+	total := 0
+	for interval, list := range paths {
+		count, err := restoreInterval(market, companyCode, interval, list)
+		if err != nil {
+			return total, err
+		}
+
+		total += count
+	}
+
+	logger.Info("已从存档导入分时数据", "market", market.Name(), "company", companyCode, "days", total)
+
+	return total, nil
+}
+
+//	在一个事务中导入上市公司指定间隔的存档文件
+func restoreInterval(market Market, code string, interval Interval, paths []string) (int, error) {
+
+	tx, err := store.BeginInterval(market, code, interval)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, path := range paths {
+		list, err := readArchive(path)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		for _, sp := range list {
+			if !periods[sp.Session] {
+				tx.Rollback()
+				return 0, fmt.Errorf("[Retention]\t存档%s中的交易时段%s不正确", path, sp.Session)
+			}
+
+			//	与抓取时一样使用本地时区
+			for index := range sp.Peroids {
+				sp.Peroids[index].Time = sp.Peroids[index].Time.In(time.Local)
+			}
+
+			err = tx.SavePeriod(sp.Session, sp.Peroids)
+			if err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+	}
+
+	return len(paths), tx.Commit()
+}
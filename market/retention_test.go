@@ -0,0 +1,129 @@
+package market
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestArchiveBefore(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockRetention", "AAA")
+	defer cleanup()
+	Add(market)
+	defer Remove(market.Name())
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Get().ArchiveDir = dir
+	defer func() { config.Get().ArchiveDir = "" }()
+
+	old := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	recent := old.AddDate(0, 0, 1)
+
+	err = store.SaveCompanies(market, market.companies, recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, day := range []time.Time{old, recent} {
+		point := func(hour, minute int, price float32, volume int64) Peroid60 {
+			return Peroid60{Market: market.Name(), Code: "AAA", Time: day.Add(time.Hour*time.Duration(hour) + time.Minute*time.Duration(minute)), Open: price, High: price + 1, Low: price - 1, Close: price, Volume: volume}
+		}
+
+		tx, err := store.Begin(market, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = saveResult(tx, day, &ParseResult{
+			Success: true,
+			Pre:     []Peroid60{point(8, 0, 10, 100)},
+			Regular: []Peroid60{point(9, 30, 11, 1000), point(9, 31, 12, 2000)}})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := DailyBar(market, "AAA", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ArchiveBefore(market.Name(), recent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	只清理cutoff之前的分时数据
+	list, err := loadDay(market, "AAA", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sp := range list {
+		if len(sp.Peroids) != 0 {
+			t.Errorf("%s的分时数据应已清理,实际还有%d条", sp.Session, len(sp.Peroids))
+		}
+	}
+
+	_, err = DailyBar(market, "AAA", recent)
+	if err != nil {
+		t.Errorf("cutoff当天的分时数据应该保留:%v", err)
+	}
+
+	//	日线保留
+	bar, err := DailyBar(market, "AAA", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bar != expected {
+		t.Errorf("清理后的日线应为%+v,实际%+v", expected, bar)
+	}
+
+	_, err = os.Stat(archivePath(market, "AAA", old, Interval1m))
+	if err != nil {
+		t.Fatalf("应该存档了清理的分时数据:%v", err)
+	}
+
+	//	再次清理时没有需要清理的数据
+	count, err := archiveBefore(market, recent)
+	if err != nil || count != 0 {
+		t.Errorf("再次清理应清理0天,实际%d天,%v", count, err)
+	}
+
+	//	从存档导入
+	count, err = RestoreArchive(market.Name(), "AAA", old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("应导入1天,实际%d天", count)
+	}
+
+	list, err = loadDay(market, "AAA", old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for _, sp := range list {
+		counts[sp.Session] = len(sp.Peroids)
+	}
+
+	if counts["pre"] != 1 || counts["regular"] != 2 || counts["post"] != 0 {
+		t.Errorf("导入后盘前应为1条,常规应为2条,盘后应为0条,实际%v", counts)
+	}
+}
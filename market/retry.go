@@ -0,0 +1,142 @@
+package market
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/metrics"
+)
+
+//	重试队列条目
+type RetryEntry struct {
+	Company  string
+	Day      time.Time
+	Message  string
+	Attempts int
+	//	超过最大重试次数后不再重试
+	Dead    bool
+	Updated time.Time
+}
+
+//	查询重试队列
+func RetryQueue(marketName string) ([]RetryEntry, error) {
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Retry]\t未能找到市场%s", marketName)
+	}
+
+	return store.RetryEntries(market)
+}
+
+//	清除重试队列(deadOnly为true时只清除不再重试的条目),返回清除的数量(运行期间锁定数据目录)
+func PurgeRetryQueue(marketName string, deadOnly bool) (int, error) {
+
+	err := Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer Unlock()
+
+	market, found := Get(marketName)
+	if !found {
+		return 0, fmt.Errorf("[Retry]\t未能找到市场%s", marketName)
+	}
+
+	entries, err := store.RetryEntries(market)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if deadOnly && !entry.Dead {
+			continue
+		}
+
+		err = store.RemoveRetry(market, entry)
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	logger.Info("已清除重试队列", "market", market.Name(), "count", count)
+
+	return count, nil
+}
+
+//	定时处理重试队列(done关闭时停止)
+func retryTask(market Market, done <-chan struct{}) {
+
+	ticker := time.NewTicker(time.Minute * time.Duration(config.Get().RetryInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			drainRetryQueue(market)
+		case <-done:
+			return
+		}
+	}
+}
+
+//	处理重试队列
+func drainRetryQueue(market Market) {
+
+	entries, err := store.RetryEntries(market)
+	if err != nil {
+		logger.Error("读取重试队列时出错", "market", market.Name(), "error", err)
+		return
+	}
+
+	maxAttempts := config.Get().RetryMaxAttempts
+	for _, entry := range entries {
+		if isShuttingDown() {
+			return
+		}
+
+		if entry.Dead {
+			continue
+		}
+
+		//	清除之前的处理状态后重新抓取
+		company := Company{Market: market.Name(), Code: entry.Company}
+		release := acquireGlobalSlot()
+		result, err := companyTransaction(market, company, entry.Day, true)
+		release()
+		if err == nil && result != nil && result.Success {
+			metrics.Retries.WithLabelValues(market.Name(), "success").Inc()
+			updateActivity(market, company, entry.Day, true)
+			recordCrawl(market)
+			err = store.RemoveRetry(market, entry)
+			if err != nil {
+				logger.Error("从重试队列移除时出错", "market", market.Name(), "company", entry.Company, "day", entry.Day.Format("20060102"), "error", err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			entry.Message = err.Error()
+		} else if result != nil {
+			entry.Message = result.Message
+		}
+
+		entry.Attempts++
+		entry.Dead = entry.Attempts >= maxAttempts
+		metrics.Retries.WithLabelValues(market.Name(), "failed").Inc()
+		if entry.Dead {
+			metrics.Retries.WithLabelValues(market.Name(), "dead").Inc()
+			logger.Warn("超过最大重试次数,不再重试", "market", market.Name(), "company", entry.Company, "day", entry.Day.Format("20060102"), "attempts", entry.Attempts)
+		}
+
+		err = store.UpdateRetry(market, entry)
+		if err != nil {
+			logger.Error("更新重试队列时出错", "market", market.Name(), "company", entry.Company, "day", entry.Day.Format("20060102"), "error", err)
+		}
+	}
+}
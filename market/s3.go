@@ -0,0 +1,395 @@
+package market
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//	S3兼容的对象存储(路径形式的地址,AWS签名V4)
+type s3Client struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+//	创建对象存储客户端
+func newS3Client(endpoint, bucket, region, accessKey, secretKey string) (*s3Client, error) {
+
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("[S3]\t错误的对象存储地址%s:%s", endpoint, err.Error())
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("[S3]\t错误的对象存储地址%s", endpoint)
+	}
+
+	if bucket == "" {
+		return nil, fmt.Errorf("[S3]\t没有配置存储桶")
+	}
+
+	return &s3Client{u, bucket, region, accessKey, secretKey, &http.Client{}}, nil
+}
+
+//	上传对象(meta为x-amz-meta-*元数据)
+func (c *s3Client) put(key string, body []byte, meta map[string]string) error {
+
+	header := http.Header{}
+	for name, value := range meta {
+		header.Set("x-amz-meta-"+name, value)
+	}
+
+	response, err := c.do("PUT", key, nil, body, header)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+//	分段上传大文件
+func (c *s3Client) putMultipart(key string, file *os.File, partSize int64, meta map[string]string) error {
+
+	header := http.Header{}
+	for name, value := range meta {
+		header.Set("x-amz-meta-"+name, value)
+	}
+
+	//	开始分段上传
+	response, err := c.do("POST", key, url.Values{"uploads": {""}}, nil, header)
+	if err != nil {
+		return err
+	}
+
+	var initiate struct {
+		UploadId string
+	}
+	err = decodeXML(response, &initiate)
+	if err != nil {
+		return err
+	}
+
+	err = c.uploadParts(key, initiate.UploadId, file, partSize)
+	if err != nil {
+		//	放弃分段上传,释放已上传的分段
+		if response, e := c.do("DELETE", key, url.Values{"uploadId": {initiate.UploadId}}, nil, nil); e == nil {
+			response.Body.Close()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+//	逐段上传并完成分段上传
+func (c *s3Client) uploadParts(key, uploadID string, file *os.File, partSize int64) error {
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+
+	buffer := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(file, buffer)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		response, err := c.do("PUT", key, url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}, buffer[:n], nil)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		complete.Parts = append(complete.Parts, part{number, response.Header.Get("ETag")})
+
+		if n < len(buffer) {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.do("POST", key, url.Values{"uploadId": {uploadID}}, body, nil)
+	if err != nil {
+		return err
+	}
+
+	//	完成分段上传时出错也可能返回200
+	var result struct {
+		XMLName xml.Name
+		Message string
+	}
+	err = decodeXML(response, &result)
+	if err != nil {
+		return err
+	}
+
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("[S3]\t完成分段上传%s时出错:%s", key, result.Message)
+	}
+
+	return nil
+}
+
+//	对象的元数据
+func (c *s3Client) head(key string) (map[string]string, error) {
+
+	response, err := c.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	meta := make(map[string]string)
+	for name := range response.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-meta-") {
+			meta[strings.TrimPrefix(lower, "x-amz-meta-")] = response.Header.Get(name)
+		}
+	}
+
+	return meta, nil
+}
+
+//	下载对象
+func (c *s3Client) get(key string, w io.Writer) error {
+
+	response, err := c.do("GET", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+
+	return err
+}
+
+//	删除对象
+func (c *s3Client) delete(key string) error {
+
+	response, err := c.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+//	以prefix开头的所有对象的键(按键排序)
+func (c *s3Client) list(prefix string) ([]string, error) {
+
+	keys := make([]string, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		response, err := c.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = decodeXML(response, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+//	发送签名后的请求(状态码不是2xx时返回错误)
+func (c *s3Client) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+
+	uri := "/" + s3Escape(c.bucket, false)
+	if key != "" {
+		uri += "/" + s3Escape(key, false)
+	}
+
+	rawQuery := s3Query(query)
+	address := c.endpoint.Scheme + "://" + c.endpoint.Host + uri
+	if rawQuery != "" {
+		address += "?" + rawQuery
+	}
+
+	request, err := http.NewRequest(method, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	c.sign(request, uri, rawQuery, body, time.Now())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("[S3]\t%s %s出错:%s", method, key, err.Error())
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		return nil, fmt.Errorf("[S3]\t%s %s出错:%s %s", method, key, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	return response, nil
+}
+
+//	AWS签名V4
+func (c *s3Client) sign(request *http.Request, uri, rawQuery string, body []byte, now time.Time) {
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	//	签名的请求头(host及所有x-amz-*)
+	headers := map[string]string{"host": c.endpoint.Host}
+	for name := range request.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(request.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{request.Method, uri, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, text string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(text))
+	return mac.Sum(nil)
+}
+
+//	按签名V4的要求编码(除了A-Za-z0-9-_.~都编码,slash为true时也编码/)
+func s3Escape(text string, slash bool) string {
+
+	var buffer bytes.Buffer
+	for _, b := range []byte(text) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			buffer.WriteByte(b)
+		case b == '/' && !slash:
+			buffer.WriteByte(b)
+		default:
+			buffer.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+
+	return buffer.String()
+}
+
+//	按键排序的查询字符串
+func s3Query(query url.Values) string {
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+//	解析xml响应并关闭
+func decodeXML(response *http.Response, v interface{}) error {
+
+	defer response.Body.Close()
+
+	err := xml.NewDecoder(response.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("[S3]\t解析响应出错:%s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package market
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	默认收盘后多少分钟运行每日任务
+	defaultScheduleDelay = 120
+)
+
+//	提供收盘时间的市场(包括盘后交易,没有实现时按0点收盘)
+type ClosingTimer interface {
+	//	当地的收盘时间
+	ClosingTime() (hour, minute int)
+}
+
+//	计算下一次运行每日任务的时间(当地收盘时间加上延迟)及要抓取的日期
+//	每次都按市场所在时区的日期重新计算,夏令时切换当天也不会偏移
+func nextDailyRun(market Market, now time.Time) (time.Time, time.Time) {
+
+	location, err := time.LoadLocation(market.Timezone())
+	if err != nil {
+		location = time.Local
+	}
+
+	hour, minute := 24, 0
+	if closer, ok := market.(ClosingTimer); ok {
+		hour, minute = closer.ClosingTime()
+	}
+
+	delay := time.Minute * time.Duration(scheduleDelay(market))
+
+	//	配置了运行时间时不使用延迟,收盘前的时间按第二天算
+	if runHour, runMinute, ok := runAt(market); ok {
+		if runHour*60+runMinute <= hour*60+minute {
+			runHour += 24
+		}
+
+		hour, minute, delay = runHour, runMinute, 0
+	}
+
+	local := now.In(location)
+	for offset := -1; ; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, location)
+		at := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, location).Add(delay)
+		if at.After(now) {
+			return at, day
+		}
+	}
+}
+
+//	市场收盘后运行每日任务的延迟(分钟)
+func scheduleDelay(market Market) int {
+
+	if delay, found := config.Get().ScheduleDelay[market.Name()]; found && delay >= 0 {
+		return delay
+	}
+
+	return defaultScheduleDelay
+}
+
+//	配置的每日任务运行时间(当地时间)
+func runAt(market Market) (int, int, bool) {
+
+	value := config.Get().Markets[market.Name()].RunAt
+	if value == "" {
+		return 0, 0, false
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return t.Hour(), t.Minute(), true
+}
+
+//	检查每日任务运行时间的格式
+func validateRunAt(marketName, value string) error {
+
+	if value == "" {
+		return nil
+	}
+
+	_, err := time.Parse("15:04", value)
+	if err != nil {
+		return fmt.Errorf("[%s]	错误的每日任务运行时间(RunAt):%s", marketName, value)
+	}
+
+	return nil
+}
+
+//	按next计算的时间定时运行每日任务(每次运行后重新计算下一次的时间),返回停止定时任务的函数
+func scheduleDailyTask(market Market, next func(now time.Time) (time.Time, time.Time)) func() {
+
+	now := time.Now()
+	at, day := next(now)
+	logger.Info("定时任务已启动", "market", market.Name(), "next", at.Format("2006-01-02 15:04:05 MST"), "after", at.Sub(now).Round(time.Second).String(), "day", day.Format("20060102"))
+
+	done := make(chan struct{})
+	goTask(func() {
+		timer := time.NewTimer(jitterDelay(at))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				//	上一次还没有结束时dailyTaskDay会跳过本次
+				runDay := day
+				goTask(func() { dailyTaskDay(market, runDay) })
+
+				//	提前运行时从原定的时间算起,避免同一天运行两次
+				from := time.Now()
+				if from.Before(at) {
+					from = at
+				}
+
+				at, day = next(from)
+				timer.Reset(jitterDelay(at))
+			case <-done:
+				return
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+//	到运行时间的等待时间,加上正负ScheduleJitter分钟以内的随机偏移(不会小于0)
+func jitterDelay(at time.Time) time.Duration {
+
+	delay := at.Sub(time.Now())
+
+	jitter := time.Minute * time.Duration(config.Get().ScheduleJitter)
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)*2+1)) - jitter
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay
+}
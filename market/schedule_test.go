@@ -0,0 +1,158 @@
+package market
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestNextDailyRun(t *testing.T) {
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		now time.Time
+		at  string
+		day string
+	}{
+		//	收盘前运行当天的
+		{time.Date(2024, 1, 5, 10, 0, 0, 0, newYork), "2024-01-05 22:00 EST", "20240105"},
+		//	已经过了运行时间就等到第二天
+		{time.Date(2024, 1, 5, 22, 0, 0, 0, newYork), "2024-01-06 22:00 EST", "20240106"},
+		//	夏令时开始当天(2024-03-10 2点跳到3点)
+		{time.Date(2024, 3, 9, 23, 0, 0, 0, newYork), "2024-03-10 22:00 EDT", "20240310"},
+		//	夏令时结束当天(2024-11-03 2点回到1点)
+		{time.Date(2024, 11, 2, 23, 0, 0, 0, newYork), "2024-11-03 22:00 EST", "20241103"},
+		//	0点之后仍未到运行时间
+		{time.Date(2024, 11, 3, 1, 30, 0, 0, newYork), "2024-11-03 22:00 EST", "20241103"},
+	}
+
+	for _, c := range cases {
+		at, day := nextDailyRun(America{}, c.now)
+		if at.In(newYork).Format("2006-01-02 15:04 MST") != c.at || day.Format("20060102") != c.day {
+			t.Errorf("%s之后应在%s运行%s,实际%s运行%s", c.now, c.at, c.day, at.In(newYork).Format("2006-01-02 15:04 MST"), day.Format("20060102"))
+		}
+	}
+
+	//	夏令时切换前后两次运行相隔23和25小时
+	at, _ := nextDailyRun(America{}, time.Date(2024, 3, 9, 12, 0, 0, 0, newYork))
+	next, _ := nextDailyRun(America{}, at)
+	if next.Sub(at) != time.Hour*23 {
+		t.Errorf("夏令时开始前后应相隔23小时,实际%s", next.Sub(at))
+	}
+
+	at, _ = nextDailyRun(America{}, time.Date(2024, 11, 2, 12, 0, 0, 0, newYork))
+	next, _ = nextDailyRun(America{}, at)
+	if next.Sub(at) != time.Hour*25 {
+		t.Errorf("夏令时结束前后应相隔25小时,实际%s", next.Sub(at))
+	}
+
+	//	没有收盘时间的市场按0点收盘,运行前一天的
+	at, day := nextDailyRun(mockMarket{name: "MockSchedule"}, time.Date(2024, 1, 5, 1, 0, 0, 0, time.UTC))
+	if !at.Equal(time.Date(2024, 1, 5, 2, 0, 0, 0, time.UTC)) || day.Format("20060102") != "20240104" {
+		t.Errorf("应在2024-01-05 02:00运行20240104,实际%s运行%s", at, day.Format("20060102"))
+	}
+}
+
+func TestNextDailyRunAt(t *testing.T) {
+
+	market := mockMarket{name: "MockRunAt"}
+	defer overrideSettings(market.Name(), config.MarketConfig{RunAt: "01:30"})()
+
+	//	没有收盘时间的市场按0点收盘,01:30运行前一天的
+	cases := []struct {
+		now   time.Time
+		delay time.Duration
+		day   string
+	}{
+		{time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), time.Minute * 90, "20240104"},
+		{time.Date(2024, 1, 5, 1, 29, 0, 0, time.UTC), time.Minute, "20240104"},
+		{time.Date(2024, 1, 5, 1, 30, 0, 0, time.UTC), time.Hour * 24, "20240105"},
+		{time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC), time.Minute * 810, "20240105"},
+		{time.Date(2024, 1, 5, 23, 59, 0, 0, time.UTC), time.Minute * 91, "20240105"},
+	}
+
+	for _, c := range cases {
+		at, day := nextDailyRun(market, c.now)
+		if at.Sub(c.now) != c.delay || day.Format("20060102") != c.day {
+			t.Errorf("%s之后应在%s后运行%s,实际%s后运行%s", c.now, c.delay, c.day, at.Sub(c.now), day.Format("20060102"))
+		}
+	}
+
+	//	晚于收盘时间的运行时间在当天
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer overrideSettings("America", config.MarketConfig{RunAt: "21:00"})()
+	at, day := nextDailyRun(America{}, time.Date(2024, 1, 5, 10, 0, 0, 0, newYork))
+	if at.In(newYork).Format("2006-01-02 15:04") != "2024-01-05 21:00" || day.Format("20060102") != "20240105" {
+		t.Errorf("应在2024-01-05 21:00运行20240105,实际%s运行%s", at.In(newYork), day.Format("20060102"))
+	}
+
+	defer overrideSettings(market.Name(), config.MarketConfig{RunAt: "1:30am"})()
+	if validateSettings(market.Name()) == nil {
+		t.Errorf("错误的运行时间应该返回错误")
+	}
+}
+
+func TestJitterDelay(t *testing.T) {
+
+	c := config.Get()
+	defer func(jitter int) { c.ScheduleJitter = jitter }(c.ScheduleJitter)
+
+	at := time.Now().Add(time.Hour)
+	c.ScheduleJitter = 0
+	if delay := jitterDelay(at); delay > time.Hour || delay < time.Minute*59 {
+		t.Errorf("没有偏移时应等待1小时,实际%s", delay)
+	}
+
+	c.ScheduleJitter = 10
+	delays := make(map[time.Duration]bool)
+	for index := 0; index < 20; index++ {
+		delay := jitterDelay(at).Round(time.Minute)
+		if delay < time.Minute*50 || delay > time.Minute*70 {
+			t.Errorf("偏移应在正负10分钟以内,实际等待%s", delay)
+		}
+		delays[delay] = true
+	}
+
+	if len(delays) < 2 {
+		t.Errorf("偏移应是随机的,实际%v", delays)
+	}
+
+	//	偏移后不会小于0
+	if delay := jitterDelay(time.Now()); delay < 0 {
+		t.Errorf("等待时间不应小于0,实际%s", delay)
+	}
+}
+
+func TestMarketWallClock(t *testing.T) {
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	夏令时开始(2024-03-10)前后的开盘时间,UTC分别是14:30和13:30
+	for _, ts := range []int64{1709908200, 1710163800} {
+		t1 := marketWallClock(newYork, ts)
+		if t1.Hour() != 9 || t1.Minute() != 30 || t1.Location() != time.Local {
+			t.Errorf("%d应为纽约时间9:30,实际%s", ts, t1)
+		}
+	}
+
+	//	与本地时区无关
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+8", 8*3600)
+
+	t1 := marketWallClock(newYork, 1710163800)
+	if t1.Format("2006-01-02 15:04") != "2024-03-11 09:30" {
+		t.Errorf("本地时区为UTC+8时应为2024-03-11 09:30,实际%s", t1.Format("2006-01-02 15:04"))
+	}
+}
@@ -0,0 +1,119 @@
+package market
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//	交易时段的起止时间(市场所在地的时间,不包括End)
+type SessionBoundary struct {
+	//	pre, regular, post
+	Session string
+	Start   time.Time
+	End     time.Time
+}
+
+//	某日的交易时段(数据源提供,提前收盘的日期常规交易时段较短)
+type TradingSessions []SessionBoundary
+
+//	交易时段的摘要,如:pre 04:00-09:30,regular 09:30-13:00,post 13:00-17:00
+func (s TradingSessions) String() string {
+
+	parts := make([]string, 0, len(s))
+	for _, b := range s {
+		parts = append(parts, fmt.Sprintf("%s %s-%s", b.Session, b.Start.Format("15:04"), b.End.Format("15:04")))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+//	交易时段的总长度
+func (s TradingSessions) Duration(session string) time.Duration {
+
+	var total time.Duration
+	for _, b := range s {
+		if b.Session == session {
+			total += b.End.Sub(b.Start)
+		}
+	}
+
+	return total
+}
+
+//	交易时段内按指定间隔应有的分时数据条数(没有交易时段或者日线时为0)
+func (s TradingSessions) ExpectedRows(session string, interval Interval) int {
+
+	step, err := time.ParseDuration(string(interval))
+	if err != nil || step <= 0 {
+		return 0
+	}
+
+	return int(s.Duration(session) / step)
+}
+
+//	常规交易时段的分时数据是否明显少于交易时段的长度(少于一半)
+//	按数据源提供的交易时段计算,提前收盘的日期不会误报
+func (s TradingSessions) isShort(rows int, interval Interval) bool {
+
+	expected := s.ExpectedRows("regular", interval)
+
+	return expected > 0 && rows*2 < expected
+}
+
+//	解析交易时段的摘要(day为市场所在地的日期)
+func parseTradingSessions(day time.Time, text string) (TradingSessions, error) {
+
+	sessions := make(TradingSessions, 0)
+	if text == "" {
+		return sessions, nil
+	}
+
+	for _, part := range strings.Split(text, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("[Session]\t错误的交易时段:%s", part)
+		}
+
+		times := strings.Split(fields[1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("[Session]\t错误的交易时段:%s", part)
+		}
+
+		boundary := SessionBoundary{Session: fields[0]}
+		for index, text := range times {
+			clock, err := time.Parse("15:04", text)
+			if err != nil {
+				return nil, fmt.Errorf("[Session]\t错误的交易时段:%s", part)
+			}
+
+			value := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+			if index == 0 {
+				boundary.Start = value
+			} else {
+				boundary.End = value
+			}
+		}
+
+		//	结束于午夜
+		if !boundary.End.After(boundary.Start) {
+			boundary.End = boundary.End.AddDate(0, 0, 1)
+		}
+
+		sessions = append(sessions, boundary)
+	}
+
+	return sessions, nil
+}
+
+//	读取保存的某日交易时段(1m间隔,没有处理过时返回ErrNotProcessed)
+func LoadSessions(market Market, code string, day time.Time) (TradingSessions, error) {
+
+	tx, err := store.Begin(market, code)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return tx.LoadSessions(day)
+}
@@ -0,0 +1,103 @@
+package market
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	市场的抓取设置(全局设置被市场的设置覆盖后)
+type marketSettings struct {
+	//	同时抓取的上市公司数
+	concurrency int
+	//	历史任务抓取的天数
+	historyDays int
+	//	下载失败时的重试次数
+	downloadRetries int
+	//	下载失败时重试的间隔
+	downloadRetryInterval time.Duration
+}
+
+//	获取市场的抓取设置
+func getSettings(marketName string) marketSettings {
+
+	c := config.Get()
+	concurrency, historyDays, retries, interval := c.Concurrency, c.HistoryDays, c.DownloadRetries, c.DownloadRetryInterval
+
+	if mc, found := c.Markets[marketName]; found {
+		if mc.Concurrency != nil {
+			concurrency = *mc.Concurrency
+		}
+
+		if mc.HistoryDays != nil {
+			historyDays = *mc.HistoryDays
+		}
+
+		if mc.DownloadRetries != nil {
+			retries = *mc.DownloadRetries
+		}
+
+		if mc.DownloadRetryInterval != nil {
+			interval = *mc.DownloadRetryInterval
+		}
+	}
+
+	return marketSettings{concurrency, historyDays, retries, time.Second * time.Duration(interval)}
+}
+
+//	检查市场的抓取设置
+func validateSettings(marketName string) error {
+
+	s := getSettings(marketName)
+
+	if s.concurrency < 1 {
+		return fmt.Errorf("[%s]\t同时抓取的上市公司数(Concurrency)必须大于0,实际为%d", marketName, s.concurrency)
+	}
+
+	if s.historyDays < 1 {
+		return fmt.Errorf("[%s]\t历史任务抓取的天数(HistoryDays)必须大于0,实际为%d", marketName, s.historyDays)
+	}
+
+	if s.downloadRetries < 1 {
+		return fmt.Errorf("[%s]\t下载的重试次数(DownloadRetries)必须大于0,实际为%d", marketName, s.downloadRetries)
+	}
+
+	if s.downloadRetryInterval < 0 {
+		return fmt.Errorf("[%s]\t下载重试的间隔(DownloadRetryInterval)不能为负数,实际为%s", marketName, s.downloadRetryInterval)
+	}
+
+	mc := config.Get().Markets[marketName]
+	err := validateCompanyFile(marketName, mc.CompanyFile)
+	if err != nil {
+		return err
+	}
+
+	err = validateHolidays(marketName, mc.Holidays)
+	if err != nil {
+		return err
+	}
+
+	err = validateRunAt(marketName, mc.RunAt)
+	if err != nil {
+		return err
+	}
+
+	err = validateSymbols(marketName, mc.Symbols)
+	if err != nil {
+		return err
+	}
+
+	switch mc.Source {
+	case "", "yahoo", "stooq":
+	default:
+		return fmt.Errorf("[%s]\t错误的数据源(Source):%s", marketName, mc.Source)
+	}
+
+	err = validatePatterns(marketName, mc.Include)
+	if err != nil {
+		return err
+	}
+
+	return validatePatterns(marketName, mc.Exclude)
+}
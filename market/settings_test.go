@@ -0,0 +1,92 @@
+package market
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	临时修改市场的设置
+func overrideSettings(marketName string, mc config.MarketConfig) func() {
+
+	c := config.Get()
+	markets := c.Markets
+	c.Markets = map[string]config.MarketConfig{marketName: mc}
+
+	return func() { c.Markets = markets }
+}
+
+func TestSettingsConcurrency(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockConcurrency", "AAA", "BBB", "CCC")
+	defer cleanup()
+
+	concurrency := 1
+	defer overrideSettings(mock.Name(), config.MarketConfig{Concurrency: &concurrency})()
+
+	var active, maxActive int32
+	market := slowMarket{mock, time.Millisecond * 50, &active, &maxActive}
+
+	_, err := dailyTask(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if max := atomic.LoadInt32(&maxActive); max != 1 {
+		t.Errorf("同时只应抓取1家上市公司,实际最多%d家", max)
+	}
+}
+
+func TestSettingsHistoryDays(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockHistoryDays", "AAA")
+	defer cleanup()
+
+	historyDays := 3
+	defer overrideSettings(market.Name(), config.MarketConfig{HistoryDays: &historyDays})()
+
+	yesterday := locationYesterdayZero(market)
+	historyTask(market, yesterday)
+
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	days, err := tx.ProcessedDays(yesterday.AddDate(0, 0, -30), yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(days) != historyDays {
+		t.Errorf("应处理%d天,实际%d天", historyDays, len(days))
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+
+	if err := validateSettings("MockValidate"); err != nil {
+		t.Errorf("默认设置应该有效:%s", err.Error())
+	}
+
+	zero, negative := 0, -1
+	cases := []config.MarketConfig{
+		{Concurrency: &zero},
+		{HistoryDays: &zero},
+		{DownloadRetries: &negative},
+		{DownloadRetryInterval: &negative},
+	}
+
+	for _, mc := range cases {
+		restore := overrideSettings("MockValidate", mc)
+		err := validateSettings("MockValidate")
+		restore()
+
+		if err == nil {
+			t.Errorf("设置%+v应该返回错误", mc)
+		}
+	}
+}
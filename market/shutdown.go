@@ -0,0 +1,183 @@
+package market
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//	正在关闭,任务没有运行完
+var ErrShutdown = errors.New("正在关闭,任务没有运行完")
+
+//	等待事务结束时检查的间隔
+var shutdownPollInterval = time.Millisecond * 100
+
+var (
+	//	正在关闭时为1,不再开始新的抓取
+	shuttingDown int32
+	//	进行中的事务数(所有存储的事务,提交或回滚后减一)
+	openTxs int64
+	//	监视启动的任务(定时任务、每日任务、历史任务、重试、清理和维护)
+	taskGroup sync.WaitGroup
+	//	关闭时取消,进行中的抓取随之中止
+	shutdownCtx    context.Context
+	cancelShutdown context.CancelFunc
+	shutdownMutex  sync.Mutex
+)
+
+//	是否正在关闭
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+//	抓取使用的context(关闭时取消)
+func shutdownContext() context.Context {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	if shutdownCtx == nil {
+		shutdownCtx, cancelShutdown = context.WithCancel(context.Background())
+	}
+
+	return shutdownCtx
+}
+
+//	重新启动监视时清除关闭状态
+func resetShutdown() {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	atomic.StoreInt32(&shuttingDown, 0)
+	if shutdownCtx != nil && shutdownCtx.Err() != nil {
+		shutdownCtx, cancelShutdown = nil, nil
+	}
+}
+
+//	开始关闭:不再开始新的抓取,取消进行中的抓取
+func beginShutdown() {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	atomic.StoreInt32(&shuttingDown, 1)
+	if cancelShutdown != nil {
+		cancelShutdown()
+	}
+}
+
+//	在单独的goroutine中运行任务,关闭时等待任务结束(正在关闭时不再启动)
+//	由任务启动的任务在父任务结束前加入,不会和Shutdown中的等待冲突
+func goTask(task func()) {
+
+	if isShuttingDown() {
+		return
+	}
+
+	taskGroup.Add(1)
+	go func() {
+		defer taskGroup.Done()
+		task()
+	}()
+}
+
+//	停止所有市场的定时任务,不再开始新的抓取并取消进行中的抓取,等待进行中的任务结束、事务提交或回滚,然后关闭数据库并释放数据目录的锁
+//	超过timeout仍有任务或事务没有结束时返回错误(数据库保持打开,由进程退出时释放)
+func Shutdown(timeout time.Duration) error {
+	logger.Info("正在关闭")
+
+	beginShutdown()
+
+	marketMutex.Lock()
+	for name := range marketStops {
+		stopMarket(name)
+	}
+	monitoring = false
+	marketMutex.Unlock()
+
+	deadline := time.Now().Add(timeout)
+
+	//	等待任务结束(任务中的写入不一定都在事务中,如重试队列和最后运行时间)
+	tasksDone := make(chan struct{})
+	go func() {
+		taskGroup.Wait()
+		close(tasksDone)
+	}()
+
+	select {
+	case <-tasksDone:
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("[Shutdown]\t等待%s后仍有任务没有结束", timeout)
+	}
+
+	for atomic.LoadInt64(&openTxs) > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("[Shutdown]\t等待%s后仍有%d个事务没有结束", timeout, atomic.LoadInt64(&openTxs))
+		}
+
+		time.Sleep(shutdownPollInterval)
+	}
+
+	err := CloseDatabases()
+	if e := Unlock(); err == nil {
+		err = e
+	}
+
+	logger.Info("已经关闭")
+
+	return err
+}
+
+//	加入市场并启动监视,收到SIGINT或SIGTERM后关闭(等待进行中的事务最多timeout)再返回
+func RunWithSignals(timeout time.Duration, markets ...Market) error {
+
+	for _, market := range markets {
+		Add(market)
+	}
+
+	//	先注册信号,避免启动过程中收到的信号被忽略
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	err := Monitor()
+	if err != nil {
+		return err
+	}
+
+	s := <-signals
+	logger.Info("收到信号,开始关闭", "signal", s.String())
+
+	return Shutdown(timeout)
+}
+
+//	跟踪进行中的事务,提交或回滚后计数减一
+type trackedTx struct {
+	Tx
+	ended int32
+}
+
+func trackTx(tx Tx) Tx {
+	atomic.AddInt64(&openTxs, 1)
+	return &trackedTx{Tx: tx}
+}
+
+func (t *trackedTx) end() {
+	if atomic.CompareAndSwapInt32(&t.ended, 0, 1) {
+		atomic.AddInt64(&openTxs, -1)
+	}
+}
+
+func (t *trackedTx) Commit() error {
+	defer t.end()
+	return t.Tx.Commit()
+}
+
+func (t *trackedTx) Rollback() error {
+	defer t.end()
+	return t.Tx.Rollback()
+}
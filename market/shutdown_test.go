@@ -0,0 +1,85 @@
+package market
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockShutdown", "AAA", "BBB")
+	defer cleanup()
+	defer resetShutdown()
+
+	//	直接启动的事务也会等待
+	tx, err := store.Begin(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	有进行中的事务时等待超时后返回错误
+	err = Shutdown(time.Millisecond * 200)
+	if err == nil {
+		t.Errorf("有进行中的事务时应该返回错误")
+	}
+
+	//	关闭时不再开始新的抓取
+	result, err := dailyTaskDay(market, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	if err != ErrShutdown || result == nil || result.Total != 0 {
+		t.Errorf("关闭时每日任务应不抓取并返回ErrShutdown,实际%+v %v", result, err)
+	}
+
+	//	事务结束后正常关闭
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		tx.Rollback()
+	}()
+
+	err = Shutdown(time.Second * 5)
+	if err != nil {
+		t.Errorf("事务结束后应正常关闭:%v", err)
+	}
+
+	if n := atomic.LoadInt64(&openTxs); n != 0 {
+		t.Errorf("事务结束后应没有进行中的事务,实际%d个", n)
+	}
+}
+
+func TestShutdownCancelsCrawl(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockShutdownCrawl", "AAA")
+	defer cleanup()
+	defer resetShutdown()
+
+	var active, maxActive int32
+	market := slowMarket{mock, time.Second * 3, &active, &maxActive}
+
+	//	进行中的抓取在关闭时取消,不等到CrawlTimeout
+	var crawlErr error
+	goTask(func() {
+		_, _, crawlErr = crawlInterval(market, "AAA", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Interval1m)
+	})
+	time.Sleep(time.Millisecond * 50)
+
+	start := time.Now()
+	err := Shutdown(time.Second * 2)
+	if err != nil {
+		t.Fatalf("取消抓取后应正常关闭:%v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("关闭时应取消进行中的抓取,实际用时%s", elapsed)
+	}
+
+	if crawlErr != ErrShutdown {
+		t.Errorf("取消的抓取应返回ErrShutdown,实际%v", crawlErr)
+	}
+
+	//	关闭时不再启动新的任务
+	started := false
+	goTask(func() { started = true })
+	if started {
+		t.Errorf("正在关闭时不应启动新的任务")
+	}
+}
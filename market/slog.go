@@ -0,0 +1,39 @@
+//go:build go1.21
+// +build go1.21
+
+package market
+
+import (
+	"context"
+	"log/slog"
+)
+
+//	基于log/slog的日志,如 SetLogger(NewSlogLogger(slog.New(slog.NewJSONHandler(os.Stdout, nil))))
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+//	使用slog输出结构化日志(Go 1.21及以上)
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return slogLogger{l}
+}
+
+func (l slogLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, keyvals...)
+}
+
+func (l slogLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, keyvals...)
+}
+
+func (l slogLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, keyvals...)
+}
+
+func (l slogLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, keyvals...)
+}
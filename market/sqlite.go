@@ -1,44 +1,812 @@
 package market
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/nzai/go-utility/db/sqlite"
 	"github.com/nzai/stockrecorder/config"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var (
+	//	回收空间时等待其他事务结束的最长时间
+	compactTimeout = time.Minute * 5
+	//	被锁定(SQLITE_BUSY)时重试前额外等待的时间(按重试次数递增)
+	sqliteBusyWait = time.Millisecond * 500
+)
+
+//	每个上市公司一个sqlite文件的存储
+type sqliteStore struct{}
+
+//	sqlite事务
+type sqliteTx struct {
+	db       *sqliteDB
+	tx       *sql.Tx
+	market   string
+	code     string
+	interval Interval
+}
+
+//	针对某个上市公司启动事务
+func (s sqliteStore) Begin(market Market, code string) (Tx, error) {
+	return s.BeginInterval(market, code, Interval1m)
+}
+
+//	针对某个上市公司的指定间隔启动事务
+func (s sqliteStore) BeginInterval(market Market, code string, interval Interval) (Tx, error) {
+
+	//	打开数据库连接
+	db, err := getIntervalDB(market, code, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	//	启动事务
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	//	关闭时等待进行中的事务
+	return trackTx(&sqliteTx{db, tx, market.Name(), code, interval}), nil
+}
+
+func (t *sqliteTx) Interval() Interval {
+	return t.interval
+}
+
+func (t *sqliteTx) IsProcessed(day time.Time) (bool, error) {
+	return isProcessed(t.tx, day.Format("20060102"))
+}
+
+func (t *sqliteTx) MarkProcessed(day time.Time, success bool) error {
+	return saveProcessStatus(t.tx, day.Format("20060102"), success)
+}
+
+func (t *sqliteTx) SaveValidation(day time.Time, summary string) error {
+	_, err := t.tx.Exec("update process set validation=? where [date]=?", summary, day.Format("20060102"))
+	return err
+}
+
+func (t *sqliteTx) SaveSessions(day time.Time, sessions TradingSessions) error {
+	_, err := t.tx.Exec("update process set sessions=? where [date]=?", sessions.String(), day.Format("20060102"))
+	return err
+}
+
+func (t *sqliteTx) LoadSessions(day time.Time) (TradingSessions, error) {
+
+	var text string
+	err := t.tx.QueryRow("select sessions from process where [date]=?", day.Format("20060102")).Scan(&text)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotProcessed
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTradingSessions(day, text)
+}
+
+func (t *sqliteTx) SavePeriod(period string, peroids []Peroid60) error {
+	return savePeroid(t.tx, period, peroids)
+}
+
+func (t *sqliteTx) SaveDividends(dividends []Dividend) error {
+	return saveDividends(t.tx, dividends)
+}
+
+func (t *sqliteTx) SaveSplits(splits []Split) error {
+	return saveSplits(t.tx, splits)
+}
+
+func (t *sqliteTx) SaveError(day time.Time, message string) error {
+	return saveError(t.tx, day.Format("20060102"), message)
+}
+
+func (t *sqliteTx) ProcessedDays(start, end time.Time) ([]time.Time, error) {
+	return processedDays(t.tx, start, end)
+}
+
+func (t *sqliteTx) ClearProcessed(day time.Time) error {
+	return clearProcessStatus(t.tx, day.Format("20060102"))
+}
+
+func (t *sqliteTx) Errors(start, end time.Time) ([]CrawlError, error) {
+	return loadErrors(t.tx, start, end)
+}
+
+func (t *sqliteTx) LoadPeriod(period string, start, end time.Time) ([]Peroid60, error) {
+	return loadPeroid(t.tx, t.market, t.code, start, end, period)
+}
+
+func (t *sqliteTx) CorporateActions(start, end time.Time) ([]CorporateAction, error) {
+	return loadCorporateActions(t.tx, t.market, t.code, start, end)
+}
+
+func (t *sqliteTx) DeletePeriod(period string, start, end time.Time) error {
+	return deletePeroid(t.tx, start, end, period)
+}
+
+func (t *sqliteTx) SaveBar(bar Bar) error {
+	_, err := t.tx.Exec("replace into bar values(?,?,?,?,?,?)", bar.Day.Format("20060102"), bar.Open, bar.High, bar.Low, bar.Close, bar.Volume)
+	return err
+}
+
+func (t *sqliteTx) LoadBar(day time.Time) (Bar, error) {
+
+	bar := Bar{Market: t.market, Code: t.code, Day: day}
+	err := t.tx.QueryRow("select open, high, low, close, volume from bar where [date]=?", day.Format("20060102")).Scan(&bar.Open, &bar.High, &bar.Low, &bar.Close, &bar.Volume)
+	if err == sql.ErrNoRows {
+		return Bar{}, ErrNoData
+	}
+
+	return bar, err
+}
+
+func (t *sqliteTx) Commit() error {
+	defer t.release()
+	return t.tx.Commit()
+}
+
+func (t *sqliteTx) Rollback() error {
+	defer t.release()
+	return t.tx.Rollback()
+}
+
+//	释放数据库连接(提交后再回滚时只释放一次)
+func (t *sqliteTx) release() {
+	if t.db != nil {
+		t.db.Close()
+		t.db = nil
+	}
+}
+
+//	加入重试队列(已存在则忽略)
+func (s sqliteStore) EnqueueRetry(market Market, entry RetryEntry) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("insert or ignore into retry values(?,?,?,?,?,?)", entry.Company, entry.Day.Format("20060102"), entry.Message, entry.Attempts, entry.Dead, time.Now())
+
+	return err
+}
+
+//	重试队列中的所有条目
+func (s sqliteStore) RetryEntries(market Market) ([]RetryEntry, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select code, date, message, attempts, dead, updated from retry order by date, code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	location := locationYesterdayZero(market).Location()
+
+	entries := make([]RetryEntry, 0)
+	for rows.Next() {
+		var entry RetryEntry
+		var date string
+		err = rows.Scan(&entry.Company, &date, &entry.Message, &entry.Attempts, &entry.Dead, &entry.Updated)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Day, err = time.ParseInLocation("20060102", date, location)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+//	更新重试队列中的条目
+func (s sqliteStore) UpdateRetry(market Market, entry RetryEntry) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("update retry set message=?, attempts=?, dead=?, updated=? where code=? and date=?", entry.Message, entry.Attempts, entry.Dead, time.Now(), entry.Company, entry.Day.Format("20060102"))
+
+	return err
+}
+
+//	从重试队列中移除
+func (s sqliteStore) RemoveRetry(market Market, entry RetryEntry) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("delete from retry where code=? and date=?", entry.Company, entry.Day.Format("20060102"))
+
+	return err
+}
+
+//	市场所有上市公司在某日的错误数量
+func (s sqliteStore) CountErrors(market Market, day time.Time) (int, error) {
+
+	//	遍历市场目录下所有上市公司的数据库文件
+	files, err := filepath.Glob(filepath.Join(marketDir(market), "*.db"))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		if filepath.Base(file) == marketDBFileName {
+			continue
+		}
+
+		code := strings.TrimSuffix(filepath.Base(file), ".db")
+		db, err := getDB(market, code)
+		if err != nil {
+			return 0, err
+		}
+
+		var n int
+		err = db.QueryRow("select count(*) from error where [date]=?", day.Format("20060102")).Scan(&n)
+		db.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+//	保存某日的上市公司列表
+func (s sqliteStore) SaveCompanies(market Market, companies []Company, day time.Time) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`insert into companies([code], [name], [exchange], [currency], [sector], [industry], [first_seen], [last_seen], [kind]) values(?,?,?,?,?,?,?,?,?)
+		on conflict([code]) do update set [name]=excluded.[name], [exchange]=excluded.[exchange], [currency]=excluded.[currency], [sector]=excluded.[sector], [industry]=excluded.[industry], [last_seen]=excluded.[last_seen], [delisted]='', [kind]=excluded.[kind]`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	date := day.Format("20060102")
+	for _, company := range companies {
+		_, err = stmt.Exec(company.Code, company.Name, company.Exchange, company.Currency, company.Sector, company.Industry, date, date, company.Kind)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//	读取保存过的所有上市公司
+func (s sqliteStore) LoadCompanies(market Market) ([]Company, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select code, name, exchange, currency, sector, industry, first_seen, last_seen, delisted, kind from companies order by code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCompanies(market, rows)
+}
+
+//	保存上市和退市记录,退市的上市公司同时标记退市日期
+func (s sqliteStore) SaveListingChanges(market Market, changes []ListingChange) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		date := change.Day.Format("20060102")
+		_, err = tx.Exec("insert or replace into listing values(?,?,?,?)", change.Code, date, change.Change, change.Name)
+		if err == nil && change.Change == ListingDelisted {
+			_, err = tx.Exec("update companies set [delisted]=? where [code]=?", date, change.Code)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//	指定日期以来的上市和退市记录(按日期排序)
+func (s sqliteStore) ListingChanges(market Market, since time.Time) ([]ListingChange, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select code, date, change, name from listing where [date]>=? order by [date], code", since.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListingChanges(market, rows)
+}
+
+//	保存每日任务的完成时间
+func (s sqliteStore) SaveLastRun(market Market, completed time.Time) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("replace into lastrun values(1,?)", completed)
+
+	return err
+}
+
+//	每日任务最后一次的完成时间
+func (s sqliteStore) LastRun(market Market) (time.Time, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer db.Close()
+
+	var completed time.Time
+	err = db.QueryRow("select completed from lastrun where id=1").Scan(&completed)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+
+	return completed, err
+}
+
+//	保存上市公司指定间隔的历史任务进度
+func (s sqliteStore) SaveHistoryCheckpoint(market Market, code string, interval Interval, checkpoint HistoryCheckpoint) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("replace into checkpoint values(?,?,?,?)", code, string(interval), checkpoint.Oldest.Format("20060102"), checkpoint.Newest.Format("20060102"))
+
+	return err
+}
+
+//	上市公司指定间隔的历史任务进度
+func (s sqliteStore) LoadHistoryCheckpoint(market Market, code string, interval Interval) (HistoryCheckpoint, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return HistoryCheckpoint{}, err
+	}
+	defer db.Close()
+
+	var oldest, newest string
+	err = db.QueryRow("select oldest, newest from checkpoint where code=? and interval=?", code, string(interval)).Scan(&oldest, &newest)
+	if err == sql.ErrNoRows {
+		return HistoryCheckpoint{}, nil
+	}
+
+	if err != nil {
+		return HistoryCheckpoint{}, err
+	}
+
+	return parseHistoryCheckpoint(market, oldest, newest)
+}
+
+//	所有上市公司指定间隔的历史任务进度
+func (s sqliteStore) HistoryCheckpoints(market Market, interval Interval) (map[string]HistoryCheckpoint, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select code, oldest, newest from checkpoint where interval=?", string(interval))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanHistoryCheckpoints(market, rows)
+}
+
+//	上市公司的抓取情况
+func (s sqliteStore) LoadActivity(market Market, code string) (CompanyActivity, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return CompanyActivity{}, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select code, failures, last_failed, inactive from activity where code=?", code)
+	if err != nil {
+		return CompanyActivity{}, err
+	}
+	defer rows.Close()
+
+	activities, err := scanActivities(market, rows)
+	if err != nil || len(activities) == 0 {
+		return CompanyActivity{Market: market.Name(), Code: code}, err
+	}
+
+	return activities[0], nil
+}
+
+//	保存上市公司的抓取情况
+func (s sqliteStore) SaveActivity(market Market, activity CompanyActivity) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("replace into activity values(?,?,?,?)", activity.Code, activity.Failures, formatDay(activity.LastFailed), formatDay(activity.Inactive))
+
+	return err
+}
+
+//	被标记为不活跃的上市公司
+func (s sqliteStore) InactiveCompanies(market Market) ([]CompanyActivity, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select code, failures, last_failed, inactive from activity where inactive<>'' order by code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanActivities(market, rows)
+}
+
+//	保存上市公司导出的最后日期
+func (s sqliteStore) SaveExportWatermark(market Market, code, target string, day time.Time) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("replace into export values(?,?,?)", code, target, day.Format("20060102"))
+
+	return err
+}
+
+//	上市公司导出的最后日期
+func (s sqliteStore) LoadExportWatermark(market Market, code, target string) (time.Time, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer db.Close()
+
+	var day string
+	err = db.QueryRow("select day from export where code=? and target=?", code, target).Scan(&day)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.ParseInLocation("20060102", day, locationYesterdayZero(market).Location())
+}
+
+//	回收上市公司指定间隔的数据库文件中删除数据后的空间并更新查询统计
+//	每个文件只有一个连接,有事务时等待它结束,超过compactTimeout返回错误而不是一直等待
+func (s sqliteStore) Compact(market Market, code string, interval Interval) error {
+
+	db, err := getIntervalDB(market, code, interval)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), compactTimeout)
+	defer cancel()
+
+	_, err = db.ExecContext(ctx, "vacuum")
+	if err == nil {
+		_, err = db.ExecContext(ctx, "pragma optimize")
+	}
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("[SQLite]\t%s的数据库正在使用,等待%s后放弃回收空间", code, compactTimeout)
+	}
+
+	return err
+}
+
+//	保存一次抓取的统计(用时以毫秒保存)
+func (s sqliteStore) SaveCrawlStat(market Market, stat CrawlStat) error {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("replace into crawl_stats values(?,?,?,?,?,?,?,?,?)", stat.Company, stat.Day.Format("20060102"), string(stat.Interval), stat.Duration.Nanoseconds()/int64(time.Millisecond), stat.Status, stat.Bytes, stat.Retries, stat.Rows, stat.Success)
+
+	return err
+}
+
+//	市场某日的抓取统计
+func (s sqliteStore) CrawlStats(market Market, day time.Time) ([]CrawlStat, error) {
+
+	db, err := getMarketDB(market)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select [code], [interval], [duration], [status], [bytes], [retries], [rows], [success] from crawl_stats where [date]=? order by [code], [interval]", day.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCrawlStats(market, day, rows)
+}
+
 //	获取数据库连接
-func getDB(market Market, code string) (*sql.DB, error) {
+func getDB(market Market, code string) (*sqliteDB, error) {
+	return getIntervalDB(market, code, Interval1m)
+}
+
+//	获取上市公司指定间隔的数据库连接(1m以外的间隔保存在以间隔命名的子目录中)
+func getIntervalDB(market Market, code string, interval Interval) (*sqliteDB, error) {
+
+	dir := marketDir(market)
+	if interval != Interval1m {
+		dir = filepath.Join(dir, string(interval))
+	}
 
-	filePath := filepath.Join(config.Get().DataDir, market.Name(), strings.ToLower(code)+".db")
-	db, err := sql.Open("sqlite3", filePath)
+	//	单独抓取时市场目录可能还不存在
+	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return nil, err
 	}
 
-	//	确保数据表都存在
-	err = ensureTables(db)
+	filePath := filepath.Join(dir, strings.ToLower(code)+".db")
+
+	return openCachedDB(filePath, companyMigrations)
+}
+
+//	打开sqlite数据库(日志模式、同步方式和等待时间见配置)
+//	默认的WAL模式下读写互不阻塞,被锁定时等待而不是立即返回database is locked
+//	sqlite同一时间只能有一个写入者,每个文件句柄只用一个连接
+func openSQLite(filePath string) (*sql.DB, error) {
+
+	c := config.Get()
+	options := fmt.Sprintf("?_journal_mode=%s&_synchronous=%s&_busy_timeout=%d&_txlock=immediate", c.SQLiteJournalMode, c.SQLiteSynchronous, c.SQLiteBusyTimeout)
+
+	db, err := sql.Open("sqlite3", filePath+options)
 	if err != nil {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(1)
+
 	return db, nil
 }
 
-//	保证表结构存在
-func ensureTables(db *sql.DB) error {
+//	是否为数据库被锁定的错误(SQLITE_BUSY或SQLITE_LOCKED,其他进程或连接正在写入,等待SQLiteBusyTimeout后仍未释放)
+//	按错误信息判断,不依赖需要cgo的sqlite3.Error
+func isBusy(err error) bool {
+
+	if err == nil {
+		return false
+	}
+
+	message := err.Error()
+
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
+}
+
+//	执行action,被锁定时最多重试SQLiteBusyRetries次
+func retryBusy(path string, action func() error) error {
+
+	err := action()
+	for attempt := 1; attempt <= config.Get().SQLiteBusyRetries && isBusy(err); attempt++ {
+		logger.Warn("数据库被锁定,稍后重试", "path", path, "attempt", attempt, "error", err)
+		time.Sleep(sqliteBusyWait * time.Duration(attempt))
+		err = action()
+	}
+
+	return err
+}
+
+//	启动事务(_txlock=immediate,启动时就获取写锁),被锁定时重试而不是直接失败
+func (db *sqliteDB) Begin() (*sql.Tx, error) {
+
+	var tx *sql.Tx
+	err := retryBusy(db.path, func() error {
+		var err error
+		tx, err = db.DB.Begin()
+		return err
+	})
+
+	return tx, err
+}
+
+//	执行不在事务中的语句,被锁定时重试
+func (db *sqliteDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+
+	var result sql.Result
+	err := retryBusy(db.path, func() error {
+		var err error
+		result, err = db.DB.Exec(query, args...)
+		return err
+	})
+
+	return result, err
+}
+
+//	检查sqlite的配置
+func validateSQLiteConfig() error {
+
+	c := config.Get()
+
+	switch strings.ToUpper(c.SQLiteJournalMode) {
+	case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		return fmt.Errorf("[Config]\t错误的sqlite日志模式(SQLiteJournalMode):%s", c.SQLiteJournalMode)
+	}
+
+	switch strings.ToUpper(c.SQLiteSynchronous) {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return fmt.Errorf("[Config]\t错误的sqlite同步方式(SQLiteSynchronous):%s", c.SQLiteSynchronous)
+	}
 
-	tables := map[string]string{
-		"process": `CREATE TABLE [process] ([date] CHAR(8) NOT NULL, [success] TINYINT(1) NOT NULL, CONSTRAINT [] PRIMARY KEY ([date]));CREATE INDEX [process_success] ON [process] ([success]);`,
-		"pre":     `CREATE TABLE [pre] ([time] DATETIME NOT NULL, [open] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, PRIMARY KEY ([time]));`,
-		"regular": `CREATE TABLE [regular] ([time] DATETIME NOT NULL, [open] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, PRIMARY KEY ([time]));`,
-		"post":    `CREATE TABLE [post] ([time] DATETIME NOT NULL, [open] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, PRIMARY KEY ([time]));`,
-		"error":   `CREATE TABLE [error] ([date] CHAR(8) NOT NULL, [message] TEXT NOT NULL, PRIMARY KEY ([date]));`}
+	if c.SQLiteBatchSize < 1 || c.SQLiteBatchSize > maxPeroidBatchSize {
+		return fmt.Errorf("[Config]\tsqlite每条insert语句保存的分时数据条数(SQLiteBatchSize)必须在1到%d之间,实际为%d", maxPeroidBatchSize, c.SQLiteBatchSize)
+	}
+
+	return nil
+}
+
+//	获取市场数据库连接(保存重试队列等市场级别的数据)
+func getMarketDB(market Market) (*sqliteDB, error) {
+
+	//	还没有抓取过时市场目录可能还不存在
+	dir, err := ensureMarketDir(market)
+	if err != nil {
+		return nil, err
+	}
+
+	return openCachedDB(filepath.Join(dir, marketDBFileName), marketMigrations)
+}
+
+//	确保数据表和字段都存在(适用于没有版本记录的新旧数据库)
+func ensureSchema(db sqlQueryer, tables map[string]string, columns [][3]string) error {
+
+	//	确保数据表都存在
+	err := ensureTables(db, tables)
+	if err != nil {
+		return err
+	}
+
+	//	确保字段都存在
+	for _, column := range columns {
+		err = ensureColumn(db, column[0], column[1], column[2])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	//	以下划线开头,避免与上市公司代码冲突
+	marketDBFileName = "_market.db"
+	//	每条insert语句最多保存的分时数据条数(sqlite每条语句最多999个参数,每条7个)
+	maxPeroidBatchSize = 142
+)
+
+var (
+	//	上市公司数据库表结构(迁移版本1,之后的修改通过companyMigrations完成)
+	companyTables = map[string]string{
+		"process":  `CREATE TABLE [process] ([date] CHAR(8) NOT NULL, [success] TINYINT(1) NOT NULL, [validation] TEXT NOT NULL DEFAULT '', CONSTRAINT [] PRIMARY KEY ([date]));CREATE INDEX [process_success] ON [process] ([success]);`,
+		"pre":      `CREATE TABLE [pre] ([time] DATETIME NOT NULL, [open] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, [adjclose] FLOAT(20, 3) NOT NULL DEFAULT 0, PRIMARY KEY ([time]));`,
+		"regular":  `CREATE TABLE [regular] ([time] DATETIME NOT NULL, [open] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, [adjclose] FLOAT(20, 3) NOT NULL DEFAULT 0, PRIMARY KEY ([time]));`,
+		"post":     `CREATE TABLE [post] ([time] DATETIME NOT NULL, [open] FLOAT(20, 3) NOT NULL, [close] FLOAT(20, 3) NOT NULL, [high] FLOAT(20, 3) NOT NULL, [low] FLOAT(20, 3) NOT NULL, [volume] INTEGER NOT NULL, [adjclose] FLOAT(20, 3) NOT NULL DEFAULT 0, PRIMARY KEY ([time]));`,
+		"error":    `CREATE TABLE [error] ([date] CHAR(8) NOT NULL, [message] TEXT NOT NULL, [created] INTEGER NOT NULL DEFAULT 0, PRIMARY KEY ([date]));`,
+		"dividend": `CREATE TABLE [dividend] ([time] DATETIME NOT NULL, [amount] FLOAT(20, 4) NOT NULL, PRIMARY KEY ([time]));`,
+		"split":    `CREATE TABLE [split] ([time] DATETIME NOT NULL, [numerator] FLOAT(20, 4) NOT NULL, [denominator] FLOAT(20, 4) NOT NULL, [ratio] VARCHAR(20) NOT NULL, PRIMARY KEY ([time]));`}
+
+	//	旧版本数据库中缺少的字段
+	companyColumns = [][3]string{
+		{"error", "created", "INTEGER NOT NULL DEFAULT 0"},
+		{"process", "validation", "TEXT NOT NULL DEFAULT ''"},
+		{"pre", "adjclose", "FLOAT(20, 3) NOT NULL DEFAULT 0"},
+		{"regular", "adjclose", "FLOAT(20, 3) NOT NULL DEFAULT 0"},
+		{"post", "adjclose", "FLOAT(20, 3) NOT NULL DEFAULT 0"}}
+
+	//	市场数据库表结构(迁移版本1,之后的修改通过marketMigrations完成)
+	marketTables = map[string]string{
+		"retry":      `CREATE TABLE [retry] ([code] VARCHAR(20) NOT NULL, [date] CHAR(8) NOT NULL, [message] TEXT NOT NULL, [attempts] INTEGER NOT NULL, [dead] TINYINT(1) NOT NULL, [updated] DATETIME NOT NULL, PRIMARY KEY ([code], [date]));`,
+		"companies":  `CREATE TABLE [companies] ([code] VARCHAR(20) NOT NULL, [name] TEXT NOT NULL, [exchange] VARCHAR(32) NOT NULL, [sector] TEXT NOT NULL, [industry] TEXT NOT NULL, [first_seen] CHAR(8) NOT NULL, [last_seen] CHAR(8) NOT NULL, PRIMARY KEY ([code]));`,
+		"listing":    `CREATE TABLE [listing] ([code] VARCHAR(20) NOT NULL, [date] CHAR(8) NOT NULL, [change] VARCHAR(8) NOT NULL, [name] TEXT NOT NULL, PRIMARY KEY ([code], [date], [change]));`,
+		"lastrun":    `CREATE TABLE [lastrun] ([id] INTEGER NOT NULL, [completed] DATETIME NOT NULL, PRIMARY KEY ([id]));`,
+		"checkpoint": `CREATE TABLE [checkpoint] ([code] VARCHAR(20) NOT NULL, [interval] VARCHAR(4) NOT NULL, [oldest] CHAR(8) NOT NULL, [newest] CHAR(8) NOT NULL, PRIMARY KEY ([code], [interval]));`}
+
+	//	旧版本市场数据库中缺少的字段
+	marketColumns = [][3]string{
+		{"companies", "delisted", "CHAR(8) NOT NULL DEFAULT ''"}}
+)
+
+//	保证表结构存在
+func ensureTables(db sqlQueryer, tables map[string]string) error {
 
 	for name, script := range tables {
 		err := ensureTable(db, name, script)
@@ -51,15 +819,16 @@ func ensureTables(db *sql.DB) error {
 }
 
 //	保存单表结构存在
-func ensureTable(db *sql.DB, tableName, createScript string) error {
+func ensureTable(db sqlQueryer, tableName, createScript string) error {
 
 	//	判断表是否存在
-	found, err := sqlite.TableExists(db, tableName)
+	var count int
+	err := db.QueryRow("select count(*) from sqlite_master where type='table' and name=?", tableName).Scan(&count)
 	if err != nil {
 		return err
 	}
 
-	if found {
+	if count > 0 {
 		return nil
 	}
 
@@ -69,6 +838,49 @@ func ensureTable(db *sql.DB, tableName, createScript string) error {
 	return err
 }
 
+//	保证字段存在(旧版本数据库升级)
+func ensureColumn(db sqlQueryer, tableName, columnName, definition string) error {
+
+	rows, err := db.Query("pragma table_info([" + tableName + "])")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	for index := range values {
+		values[index] = new(interface{})
+	}
+
+	for rows.Next() {
+		err = rows.Scan(values...)
+		if err != nil {
+			return err
+		}
+
+		//	第二列为字段名
+		name := *(values[1].(*interface{}))
+		if fmt.Sprintf("%s", name) == columnName {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	//	加字段
+	_, err = db.Exec("alter table [" + tableName + "] add column [" + columnName + "] " + definition)
+
+	return err
+}
+
 //	是否处理过
 func isProcessed(tx *sql.Tx, date string) (bool, error) {
 
@@ -89,7 +901,7 @@ func isProcessed(tx *sql.Tx, date string) (bool, error) {
 
 //	保存处理状态
 func saveProcessStatus(tx *sql.Tx, date string, success bool) error {
-	stmt, err := tx.Prepare("replace into process values(?,?)")
+	stmt, err := tx.Prepare("replace into process([date], success) values(?,?)")
 	if err != nil {
 		return err
 	}
@@ -113,35 +925,95 @@ func saveProcessStatus(tx *sql.Tx, date string, success bool) error {
 	return nil
 }
 
-//	处理分时数据
-func savePeroid(tx *sql.Tx, table string, peroid []Peroid60) error {
+//	指定日期范围内已处理的日期
+func processedDays(tx *sql.Tx, start, end time.Time) ([]time.Time, error) {
 
-	if len(peroid) == 0 {
-		return nil
+	stmt, err := tx.Prepare("select [date] from process where [date] >= ? and [date] <= ? order by [date]")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(start.Format("20060102"), end.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make([]time.Time, 0)
+	for rows.Next() {
+		var date string
+		err = rows.Scan(&date)
+		if err != nil {
+			return nil, err
+		}
+
+		day, err := time.ParseInLocation("20060102", date, start.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		days = append(days, day)
 	}
 
-	stmt, err := tx.Prepare("replace into " + table + " values(?,?,?,?,?,?)")
+	return days, rows.Err()
+}
+
+//	清除处理状态及错误信息
+func clearProcessStatus(tx *sql.Tx, date string) error {
+
+	_, err := tx.Exec("delete from process where [date]=?", date)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	for _, p := range peroid {
+	_, err = tx.Exec("delete from error where [date]=?", date)
 
-		//	新增
-		result, err := stmt.Exec(p.Time, p.Open, p.Close, p.High, p.Low, p.Volume)
-		if err != nil {
-			return err
+	return err
+}
+
+//	处理分时数据(多行合并成一条insert语句)
+func savePeroid(tx *sql.Tx, table string, peroid []Peroid60) error {
+
+	batchSize := config.Get().SQLiteBatchSize
+	for start := 0; start < len(peroid); start += batchSize {
+		end := start + batchSize
+		if end > len(peroid) {
+			end = len(peroid)
 		}
 
-		ra, err := result.RowsAffected()
+		err := savePeroidBatch(tx, table, peroid[start:end])
 		if err != nil {
 			return err
 		}
+	}
 
-		if ra == 0 {
-			return sql.ErrNoRows
-		}
+	return nil
+}
+
+//	一次保存一批分时数据
+func savePeroidBatch(tx *sql.Tx, table string, peroid []Peroid60) error {
+
+	values := make([]string, 0, len(peroid))
+	args := make([]interface{}, 0, len(peroid)*7)
+	for _, p := range peroid {
+		values = append(values, "(?,?,?,?,?,?,?)")
+		args = append(args, p.Time, p.Open, p.Close, p.High, p.Low, p.Volume, p.AdjClose)
+	}
+
+	//	新增
+	result, err := tx.Exec("replace into "+table+"([time], [open], [close], [high], [low], [volume], [adjclose]) values"+strings.Join(values, ","), args...)
+	if err != nil {
+		return err
+	}
+
+	ra, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if ra == 0 {
+		return sql.ErrNoRows
 	}
 
 	return nil
@@ -150,14 +1022,14 @@ func savePeroid(tx *sql.Tx, table string, peroid []Peroid60) error {
 //	保存错误信息
 func saveError(tx *sql.Tx, date, message string) error {
 
-	stmt, err := tx.Prepare("replace into error values(?,?)")
+	stmt, err := tx.Prepare("replace into error([date], [message], [created]) values(?,?,?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	//	新增
-	result, err := stmt.Exec(date, message)
+	result, err := stmt.Exec(date, message, time.Now().Unix())
 	if err != nil {
 		return err
 	}
@@ -174,17 +1046,139 @@ func saveError(tx *sql.Tx, date, message string) error {
 	return nil
 }
 
-//	从文件读取分时数据
-func loadPeroid(market Market, code string, start, end time.Time, table string) ([]Peroid60, error) {
+//	读取指定日期范围内的错误信息(只填充Day, Message, Time)
+func loadErrors(tx *sql.Tx, start, end time.Time) ([]CrawlError, error) {
 
-	filePath := filepath.Join(config.Get().DataDir, market.Name(), strings.ToLower(code)+".db")
-	db, err := sql.Open("sqlite3", filePath)
+	rows, err := tx.Query("select [date], [message], [created] from error where [date] >= ? and [date] <= ? order by [date]", start.Format("20060102"), end.Format("20060102"))
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
+	defer rows.Close()
+
+	errors := make([]CrawlError, 0)
+	for rows.Next() {
+		var date, message string
+		var created int64
+		err = rows.Scan(&date, &message, &created)
+		if err != nil {
+			return nil, err
+		}
+
+		day, err := time.ParseInLocation("20060102", date, start.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		errors = append(errors, CrawlError{Day: day, Message: message, Time: time.Unix(created, 0)})
+	}
+
+	return errors, rows.Err()
+}
+
+//	保存分红
+func saveDividends(tx *sql.Tx, dividends []Dividend) error {
+
+	if len(dividends) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare("replace into dividend values(?,?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, d := range dividends {
+		_, err = stmt.Exec(d.Time, d.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//	保存拆股
+func saveSplits(tx *sql.Tx, splits []Split) error {
+
+	if len(splits) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare("replace into split values(?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range splits {
+		_, err = stmt.Exec(s.Time, s.Numerator, s.Denominator, s.Ratio)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//	读取分红和拆股
+func loadCorporateActions(tx *sql.Tx, marketName, code string, start, end time.Time) ([]CorporateAction, error) {
+
+	actions := make([]CorporateAction, 0)
+
+	rows, err := tx.Query("select time, amount from dividend where time >= ? and time <= ?", start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := CorporateAction{Market: marketName, Company: code, Type: ActionDividend}
+		err = rows.Scan(&a.Time, &a.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query("select time, numerator, denominator, ratio from split where time >= ? and time <= ?", start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := CorporateAction{Market: marketName, Company: code, Type: ActionSplit}
+		err = rows.Scan(&a.Time, &a.Numerator, &a.Denominator, &a.Ratio)
+		if err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, a)
+	}
+
+	sortCorporateActions(actions)
+
+	return actions, rows.Err()
+}
+
+//	删除分时数据
+func deletePeroid(tx *sql.Tx, start, end time.Time, table string) error {
+
+	_, err := tx.Exec("delete from "+table+" where time >= ? and time <= ?", start, end)
+
+	return err
+}
+
+//	读取分时数据
+func loadPeroid(tx *sql.Tx, marketName, code string, start, end time.Time, table string) ([]Peroid60, error) {
 
-	stmt, err := db.Prepare("select time, open, close, high, low, volume from " + table + " where time >= ? and time <= ? order by time")
+	stmt, err := tx.Prepare("select time, open, close, high, low, volume, adjclose from " + table + " where time >= ? and time <= ? order by time")
 	if err != nil {
 		return nil, err
 	}
@@ -198,18 +1192,18 @@ func loadPeroid(market Market, code string, start, end time.Time, table string)
 	defer row.Close()
 
 	var _time time.Time
-	var open, _close, high, low float32
+	var open, _close, high, low, adjclose float32
 	var volume int64
 
 	peroids := make([]Peroid60, 0)
 	for row.Next() {
-		err = row.Scan(&_time, &open, &_close, &high, &low, &volume)
+		err = row.Scan(&_time, &open, &_close, &high, &low, &volume, &adjclose)
 		if err != nil {
 			return nil, err
 		}
 
-		peroids = append(peroids, Peroid60{market.Name(), code, _time, open, _close, high, low, volume})
+		peroids = append(peroids, Peroid60{marketName, code, _time, open, _close, high, low, volume, adjclose})
 	}
 
-	return peroids, nil
+	return peroids, row.Err()
 }
@@ -0,0 +1,556 @@
+package market
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	常规交易时段(390分钟)的分时数据
+func regularSession() []Peroid60 {
+
+	start := time.Date(2015, 8, 26, 9, 30, 0, 0, time.UTC)
+	peroids := make([]Peroid60, 0, 390)
+	for index := 0; index < 390; index++ {
+		peroids = append(peroids, Peroid60{
+			Market: "America",
+			Code:   "AAPL",
+			Time:   start.Add(time.Minute * time.Duration(index)),
+			Open:   100 + float32(index)/100,
+			Close:  100.5 + float32(index)/100,
+			High:   101 + float32(index)/100,
+			Low:    99 + float32(index)/100,
+			Volume: int64(1000 + index)})
+	}
+
+	return peroids
+}
+
+//	临时数据库
+func openTempDB(tb testing.TB) (*sql.DB, func()) {
+
+	dir, err := ioutil.TempDir("", "stockrecorder")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	err = ensureTables(db, companyTables)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSavePeroid(t *testing.T) {
+
+	db, cleanup := openTempDB(t)
+	defer cleanup()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = savePeroid(tx, "regular", regularSession())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	err = db.QueryRow("select count(*) from regular").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 390 {
+		t.Errorf("应保存390条分时数据,实际%d条", count)
+	}
+}
+
+//	合并成多行insert语句保存
+func BenchmarkSavePeroid(b *testing.B) {
+
+	db, cleanup := openTempDB(b)
+	defer cleanup()
+
+	peroids := regularSession()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		tx, _ := db.Begin()
+		err := savePeroid(tx, "regular", peroids)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tx.Commit()
+	}
+}
+
+//	不同的每条insert语句保存条数
+func BenchmarkSavePeroidBatchSize(b *testing.B) {
+
+	defer func(size int) { config.Get().SQLiteBatchSize = size }(config.Get().SQLiteBatchSize)
+
+	for _, size := range []int{1, 10, 50, maxPeroidBatchSize} {
+		b.Run(fmt.Sprintf("batch%d", size), func(b *testing.B) {
+			config.Get().SQLiteBatchSize = size
+
+			db, cleanup := openTempDB(b)
+			defer cleanup()
+
+			peroids := regularSession()
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				tx, _ := db.Begin()
+				err := savePeroid(tx, "regular", peroids)
+				if err != nil {
+					b.Fatal(err)
+				}
+				tx.Commit()
+			}
+		})
+	}
+}
+
+//	逐行insert保存(对照)
+func BenchmarkSavePeroidSingleRow(b *testing.B) {
+
+	db, cleanup := openTempDB(b)
+	defer cleanup()
+
+	peroids := regularSession()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		tx, _ := db.Begin()
+		for _, p := range peroids {
+			_, err := tx.Exec("replace into regular values(?,?,?,?,?,?,?)", p.Time, p.Open, p.Close, p.High, p.Low, p.Volume, p.AdjClose)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		tx.Commit()
+	}
+}
+
+func TestSaveCompanies(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockCompanies")
+	defer cleanup()
+
+	Add(market)
+	defer Remove(market.Name())
+
+	first := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	err := store.SaveCompanies(market, []Company{
+		{Code: "AAPL", Name: "Apple Inc.", Exchange: "NASDAQ", Currency: "USD", Sector: "Technology"},
+		{Code: "IBM", Name: "International Business Machines", Exchange: "NYSE"}}, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	第二天IBM不在列表中,AAPL更新了行业
+	second := first.AddDate(0, 0, 1)
+	err = store.SaveCompanies(market, []Company{{Code: "AAPL", Name: "Apple Inc.", Exchange: "NASDAQ", Currency: "USD", Sector: "Technology", Industry: "Computer Manufacturing"}}, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	company, err := GetCompany(market.Name(), "aapl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if company.Industry != "Computer Manufacturing" || company.Currency != "USD" || company.FirstSeen.Format("20060102") != "20240105" || company.LastSeen.Format("20060102") != "20240106" {
+		t.Errorf("上市公司信息不正确:%+v", company)
+	}
+
+	company, err = GetCompany(market.Name(), "IBM")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if company.LastSeen.Format("20060102") != "20240105" {
+		t.Errorf("IBM最后出现日期应为20240105,实际%s", company.LastSeen.Format("20060102"))
+	}
+
+	_, err = GetCompany(market.Name(), "MSFT")
+	if err != ErrCompanyNotFound {
+		t.Errorf("没有保存过的上市公司应返回ErrCompanyNotFound,实际%v", err)
+	}
+
+	//	代码前缀或名称
+	for query, expected := range map[string]int{"aa": 1, "ib": 1, "apple": 1, "machines": 1, "": 2, "x": 0} {
+		companies, err := SearchCompanies(market.Name(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(companies) != expected {
+			t.Errorf("查询%s应有%d家上市公司,实际%d家", query, expected, len(companies))
+		}
+	}
+}
+
+func TestListingChanges(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockListing")
+	defer cleanup()
+
+	Add(market)
+	defer Remove(market.Name())
+
+	companies := func(codes ...string) []Company {
+		list := make([]Company, 0, len(codes))
+		for _, code := range codes {
+			list = append(list, Company{Market: market.Name(), Code: code, Name: code})
+		}
+
+		return list
+	}
+
+	codes := func(list []Company) string {
+		text := ""
+		for _, company := range list {
+			text += company.Code
+		}
+
+		return text
+	}
+
+	//	第一次保存时不算新上市
+	first := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	list, err := updateListing(market, companies("A", "B"), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if codes(list) != "AB" {
+		t.Errorf("应抓取AB,实际%s", codes(list))
+	}
+
+	//	C新上市,B退市但仍在宽限期内
+	second := first.AddDate(0, 0, 1)
+	list, err = updateListing(market, companies("A", "C"), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if codes(list) != "ACB" {
+		t.Errorf("应抓取ACB,实际%s", codes(list))
+	}
+
+	company, err := GetCompany(market.Name(), "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if company.Delisted.Format("20060102") != "20240106" {
+		t.Errorf("B的退市日期应为20240106,实际%s", company.Delisted.Format("20060102"))
+	}
+
+	//	超过宽限期后不再抓取
+	list, err = updateListing(market, companies("A", "C"), second.AddDate(0, 0, config.Get().DelistGraceDays))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if codes(list) != "AC" {
+		t.Errorf("应抓取AC,实际%s", codes(list))
+	}
+
+	changes, err := ListingChanges(market.Name(), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 2 || changes[0].Code != "B" || changes[0].Change != ListingDelisted || changes[1].Code != "C" || changes[1].Change != ListingListed {
+		t.Errorf("上市和退市记录不正确:%+v", changes)
+	}
+
+	//	重新上市后清除退市日期
+	_, err = updateListing(market, companies("A", "B", "C"), second.AddDate(0, 0, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	company, err = GetCompany(market.Name(), "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !company.Delisted.IsZero() {
+		t.Errorf("重新上市后不应有退市日期:%s", company.Delisted)
+	}
+}
+
+func TestSQLiteWAL(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockWAL", "AAA")
+	defer cleanup()
+
+	db, err := getDB(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mode string
+	err = db.QueryRow("pragma journal_mode").Scan(&mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode != "wal" {
+		t.Errorf("日志模式应为wal,实际%s", mode)
+	}
+
+	var timeout int
+	err = db.QueryRow("pragma busy_timeout").Scan(&timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout != 5000 {
+		t.Errorf("busy_timeout应为5000,实际%d", timeout)
+	}
+
+	//	NORMAL为1
+	var synchronous int
+	err = db.QueryRow("pragma synchronous").Scan(&synchronous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if synchronous != 1 {
+		t.Errorf("synchronous应为NORMAL(1),实际%d", synchronous)
+	}
+
+	//	写入后生成-wal文件
+	_, err = db.Exec("insert into process([date], success) values(?,?)", "20240105", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(filepath.Join(config.Get().DataDir, market.Name(), "aaa.db-wal"))
+	if err != nil {
+		t.Errorf("应该生成WAL文件:%s", err.Error())
+	}
+}
+
+func TestSQLiteBusyRetry(t *testing.T) {
+
+	previous := config.Get()
+	defer config.Set(previous)
+
+	err := config.Set(&config.Config{DataDir: previous.DataDir, SQLiteBusyTimeout: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(wait time.Duration) { sqliteBusyWait = wait }(sqliteBusyWait)
+	sqliteBusyWait = time.Millisecond * 100
+
+	market, cleanup := newMockMarket(t, "MockBusy", "AAA")
+	defer cleanup()
+
+	db, err := getDB(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//	模拟共享数据目录的另一个进程正在写入
+	other, err := openSQLite(filepath.Join(marketDir(market), "aaa.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	otherTx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.DB.Begin()
+	if !isBusy(err) {
+		t.Fatalf("另一个连接写入时应返回database is locked,实际%v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 150)
+		otherTx.Rollback()
+	}()
+
+	//	等待后重试成功
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("锁释放后重试应成功:%v", err)
+	}
+	tx.Rollback()
+}
+
+func TestDBCache(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockDBCache", "AAA", "BBB")
+	defer cleanup()
+
+	first, err := getDB(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	同一个文件复用连接
+	second, err := getDB(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second || first.refs != 2 {
+		t.Errorf("应该复用同一个连接,引用数应为2,实际%d", first.refs)
+	}
+
+	first.Close()
+	second.Close()
+
+	other, err := getDB(market, "BBB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	只关闭空闲的连接
+	err = CloseDatabases()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = first.Ping(); err == nil {
+		t.Errorf("空闲的连接应该已关闭")
+	}
+
+	if err = other.Ping(); err != nil {
+		t.Errorf("使用中的连接不应关闭:%s", err.Error())
+	}
+	other.Close()
+
+	//	空闲的连接超过上限时关闭最久未使用的
+	older, err := getDB(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	older.Close()
+
+	newer, err := getDB(market, "BBB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer.Close()
+
+	dbCacheMutex.Lock()
+	err = closeIdleDBs(1)
+	_, olderCached := dbCache[older.path]
+	_, newerCached := dbCache[newer.path]
+	dbCacheMutex.Unlock()
+
+	if err != nil || olderCached || !newerCached {
+		t.Errorf("应该只保留最近使用的连接")
+	}
+
+	//	释放引用时按配置的上限关闭空闲的连接
+	c := config.Get()
+	defer func(max int) { c.SQLiteMaxIdleDBs = max }(c.SQLiteMaxIdleDBs)
+	c.SQLiteMaxIdleDBs = 1
+
+	older, err = getDB(market, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	older.Close()
+
+	dbCacheMutex.Lock()
+	_, olderCached = dbCache[older.path]
+	_, newerCached = dbCache[newer.path]
+	dbCacheMutex.Unlock()
+
+	if !olderCached || newerCached {
+		t.Errorf("空闲的连接超过SQLiteMaxIdleDBs时应关闭最久未使用的")
+	}
+}
+
+func TestSavePeroidBatchSize(t *testing.T) {
+
+	defer func(size int) { config.Get().SQLiteBatchSize = size }(config.Get().SQLiteBatchSize)
+
+	for _, size := range []int{1, 7, maxPeroidBatchSize} {
+		config.Get().SQLiteBatchSize = size
+
+		db, cleanup := openTempDB(t)
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peroids := regularSession()
+		err = savePeroid(tx, "regular", peroids)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var count int
+		err = tx.QueryRow("select count(*) from regular").Scan(&count)
+		tx.Rollback()
+		cleanup()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != len(peroids) {
+			t.Errorf("每次保存%d条时应保存%d条,实际%d条", size, len(peroids), count)
+		}
+	}
+}
+
+func TestValidateSQLiteConfig(t *testing.T) {
+
+	c := config.Get()
+	defer func(mode, synchronous string, size int) {
+		c.SQLiteJournalMode, c.SQLiteSynchronous, c.SQLiteBatchSize = mode, synchronous, size
+	}(c.SQLiteJournalMode, c.SQLiteSynchronous, c.SQLiteBatchSize)
+
+	if err := validateSQLiteConfig(); err != nil {
+		t.Errorf("默认配置应该有效:%s", err.Error())
+	}
+
+	c.SQLiteBatchSize = maxPeroidBatchSize + 1
+	if validateSQLiteConfig() == nil {
+		t.Errorf("每条insert语句超过%d条时应该返回错误", maxPeroidBatchSize)
+	}
+
+	c.SQLiteBatchSize, c.SQLiteJournalMode = 140, "fast"
+	if validateSQLiteConfig() == nil {
+		t.Errorf("错误的日志模式应该返回错误")
+	}
+}
@@ -0,0 +1,154 @@
+package market
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	市场的运行状态
+type MarketStatus struct {
+	Market string
+	//	开始监视的时间(没有启动监视时为零值)
+	Monitored time.Time
+	//	每日任务是否正在运行
+	Running bool
+	//	最近一次每日任务的开始和结束时间
+	LastStart time.Time
+	LastEnd   time.Time
+	//	最近一次每日任务成功和失败的上市公司数
+	Success int
+	Failed  int
+	//	最近一次成功完成每日任务的时间
+	LastSuccessfulRun time.Time
+	//	最近一次成功抓取上市公司分时数据的时间
+	LastCrawl time.Time
+	//	重试队列中等待重试的条目数
+	RetryQueue int
+}
+
+//	最近一次成功的每日任务是否超过了HealthMaxAge小时(没有成功过时从开始监视算起)
+func (s MarketStatus) Stale(now time.Time) bool {
+
+	last := s.LastSuccessfulRun
+	if last.IsZero() {
+		last = s.Monitored
+	}
+
+	return now.Sub(last) > time.Hour*time.Duration(config.Get().HealthMaxAge)
+}
+
+var (
+	//	各市场的运行状态
+	statuses    = make(map[string]*MarketStatus)
+	statusMutex sync.Mutex
+)
+
+//	市场的运行状态(没有时创建),调用时必须持有statusMutex
+func marketStatus(market Market) *MarketStatus {
+
+	status, found := statuses[market.Name()]
+	if !found {
+		status = &MarketStatus{Market: market.Name()}
+		statuses[market.Name()] = status
+	}
+
+	return status
+}
+
+//	开始监视时初始化运行状态(最近一次成功的每日任务取保存的完成时间)
+func initStatus(market Market) error {
+
+	lastRun, err := store.LastRun(market)
+	if err != nil {
+		return err
+	}
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := marketStatus(market)
+	status.Monitored = time.Now()
+	if status.LastSuccessfulRun.Before(lastRun) {
+		status.LastSuccessfulRun = lastRun
+	}
+
+	return nil
+}
+
+//	移除市场时删除它的运行状态,重新加入时从头开始
+func removeStatus(name string) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	delete(statuses, name)
+}
+
+//	记录每日任务开始
+func recordRunStart(market Market) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := marketStatus(market)
+	status.LastStart = time.Now()
+	status.LastEnd = time.Time{}
+	status.Success, status.Failed = 0, 0
+}
+
+//	记录每日任务结束(result为nil表示任务失败)
+func recordRunEnd(market Market, result *TaskResult) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := marketStatus(market)
+	status.LastEnd = time.Now()
+	if result == nil {
+		return
+	}
+
+	status.Success, status.Failed = result.Success, result.Failed
+	status.LastSuccessfulRun = status.LastEnd
+}
+
+//	记录成功抓取了上市公司的分时数据
+func recordCrawl(market Market) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	marketStatus(market).LastCrawl = time.Now()
+}
+
+//	所有市场的运行状态(按市场名称排序)
+func Status() ([]MarketStatus, error) {
+
+	names := MarketNames()
+	list := make([]MarketStatus, 0, len(names))
+	for _, name := range names {
+		//	期间被移除的市场
+		market, found := Get(name)
+		if !found {
+			continue
+		}
+
+		entries, err := store.RetryEntries(market)
+		if err != nil {
+			return nil, err
+		}
+
+		statusMutex.Lock()
+		status := *marketStatus(market)
+		statusMutex.Unlock()
+
+		status.Running = isDailyTaskRunning(market)
+		for _, entry := range entries {
+			if !entry.Dead {
+				status.RetryQueue++
+			}
+		}
+
+		list = append(list, status)
+	}
+
+	return list, nil
+}
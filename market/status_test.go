@@ -0,0 +1,66 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockStatus", "AAA", "BBB")
+	defer cleanup()
+
+	Add(market)
+	defer Remove(market.Name())
+
+	err := initStatus(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	find := func() MarketStatus {
+		list, err := Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, status := range list {
+			if status.Market == market.Name() {
+				return status
+			}
+		}
+
+		t.Fatalf("运行状态中没有%s", market.Name())
+		return MarketStatus{}
+	}
+
+	//	还没有运行过时从开始监视算起
+	status := find()
+	if status.Running || !status.LastSuccessfulRun.IsZero() || status.Stale(time.Now()) || !status.Stale(status.Monitored.Add(time.Hour*27)) {
+		t.Errorf("刚开始监视时的运行状态不正确:%+v", status)
+	}
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	_, err = dailyTaskDay(market, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.EnqueueRetry(market, RetryEntry{Company: "AAA", Day: day.AddDate(0, 0, -1), Message: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status = find()
+	if status.Running || status.Success != 2 || status.Failed != 0 || status.RetryQueue != 1 {
+		t.Errorf("每日任务结束后的运行状态不正确:%+v", status)
+	}
+
+	if status.LastStart.IsZero() || status.LastEnd.Before(status.LastStart) || !status.LastSuccessfulRun.Equal(status.LastEnd) || status.LastCrawl.IsZero() {
+		t.Errorf("每日任务的时间不正确:%+v", status)
+	}
+
+	if status.Stale(status.LastSuccessfulRun.Add(time.Hour*25)) || !status.Stale(status.LastSuccessfulRun.Add(time.Hour*27)) {
+		t.Errorf("超过26小时没有成功的每日任务才算过期")
+	}
+}
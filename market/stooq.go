@@ -0,0 +1,189 @@
+package market
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var stooqHost = "https://stooq.com"
+
+//	使用Stooq日线数据的市场(上市公司列表、名称和时区使用原来的市场)
+//	Stooq没有分时数据,每天只保存一条常规交易时段的日线
+type Stooq struct {
+	Market
+}
+
+//	创建使用Stooq数据的市场,如NewStooq(America{})
+func NewStooq(market Market) Stooq {
+	return Stooq{market}
+}
+
+//	收盘时间(使用原来的市场的)
+func (m Stooq) ClosingTime() (int, int) {
+
+	if closer, ok := m.Market.(ClosingTimer); ok {
+		return closer.ClosingTime()
+	}
+
+	return 24, 0
+}
+
+//	抓取当天的日线
+func (m Stooq) Crawl(code string, day time.Time) (string, error) {
+	return m.CrawlInterval(code, day, Interval1m)
+}
+
+//	按指定间隔抓取(1m和1d都返回日线,其他间隔不支持)
+func (m Stooq) CrawlInterval(code string, day time.Time, interval Interval) (string, error) {
+
+	if interval != Interval1m && interval != Interval1d {
+		return "", fmt.Errorf("Stooq不支持按%s间隔抓取", interval)
+	}
+
+	symbol, err := stooqSymbol(m.Name(), code)
+	if err != nil {
+		return "", err
+	}
+
+	date := day.Format("20060102")
+	url := fmt.Sprintf("%s/q/d/l/?s=%s&d1=%s&d2=%s&i=d", stooqHost, symbol, date, date)
+
+	getRateLimiter(m).Wait()
+
+	body, trace, err := downloadTraced(m.Name(), url, "")
+	recordDownload(m.Name(), code, day, interval, trace)
+
+	return body, err
+}
+
+//	解析Stooq的日线CSV(Date,Open,High,Low,Close,Volume)
+func (m Stooq) Parse(code string, day time.Time, raw []byte) (*ParseResult, error) {
+
+	content := strings.TrimSpace(string(raw))
+
+	//	没有数据时返回No data
+	if content == "" || strings.EqualFold(content, "No data") {
+		return &ParseResult{Success: false, Message: "Stooq没有该日的数据"}, nil
+	}
+
+	records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("错误的Stooq CSV格式:%s", err.Error())
+	}
+
+	if len(records) < 1 || len(records[0]) < 5 || records[0][0] != "Date" {
+		return nil, fmt.Errorf("错误的Stooq CSV表头:%v", records[0])
+	}
+
+	points := make([]sessionPeroid, 0, len(records)-1)
+	for _, parts := range records[1:] {
+		p, err := m.parseLine(code, parts)
+		if err != nil {
+			return nil, err
+		}
+
+		//	只保留要抓取的那天
+		if p.Time.Format("20060102") != day.Format("20060102") {
+			continue
+		}
+
+		points = append(points, sessionPeroid{"regular", p})
+	}
+
+	if len(points) == 0 {
+		return &ParseResult{Success: false, Message: "Stooq没有该日的数据"}, nil
+	}
+
+	rules := getValidationRules()
+	valid, summary := validatePeroids(points, rules)
+	if summary.Invalid > 0 {
+		logger.Warn("已丢弃异常的分时数据", "market", m.Name(), "company", code, "day", day.Format("20060102"), "count", summary.Invalid, "summary", summary.String())
+	}
+
+	if message := summary.failure(rules); message != "" {
+		return &ParseResult{Success: false, Message: message, Validation: summary}, nil
+	}
+
+	regular := make([]Peroid60, 0, len(valid))
+	for _, point := range valid {
+		regular = append(regular, point.peroid)
+	}
+
+	return &ParseResult{Success: true, Pre: []Peroid60{}, Regular: regular, Post: []Peroid60{}, Validation: summary}, nil
+}
+
+//	解析一行日线(没有成交量的按0)
+func (m Stooq) parseLine(code string, parts []string) (Peroid60, error) {
+
+	if len(parts) < 5 {
+		return Peroid60{}, fmt.Errorf("错误的Stooq日线:%v", parts)
+	}
+
+	date, err := time.Parse("2006-01-02", parts[0])
+	if err != nil {
+		return Peroid60{}, fmt.Errorf("错误的Stooq日期:%s", parts[0])
+	}
+
+	prices := make([]float32, 4)
+	for index := range prices {
+		price, err := strconv.ParseFloat(parts[index+1], 32)
+		if err != nil {
+			return Peroid60{}, fmt.Errorf("错误的Stooq价格:%s", parts[index+1])
+		}
+
+		prices[index] = float32(price)
+	}
+
+	var volume int64
+	if len(parts) > 5 && parts[5] != "" {
+		v, err := strconv.ParseFloat(parts[5], 64)
+		if err != nil {
+			return Peroid60{}, fmt.Errorf("错误的Stooq成交量:%s", parts[5])
+		}
+
+		volume = int64(v)
+	}
+
+	return Peroid60{
+		Market: m.Name(),
+		Code:   code,
+		//	和分时数据一样按市场当地的日期保存在本地时区中
+		Time:   time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local),
+		Open:   prices[0],
+		High:   prices[1],
+		Low:    prices[2],
+		Close:  prices[3],
+		Volume: volume}, nil
+}
+
+//	Stooq的股票代码(小写加市场后缀,如AAPL为aapl.us,BRK.B为brk-b.us,00700为700.hk,7203为7203.jp)
+func stooqSymbol(marketName, code string) (string, error) {
+
+	switch marketName {
+	case "America":
+		symbol, err := americaSymbol(code)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.ToLower(symbol) + ".us", nil
+	case "HongKong":
+		number, err := strconv.Atoi(code)
+		if err != nil || number <= 0 {
+			return "", fmt.Errorf("错误的香港上市公司代码:%s", code)
+		}
+
+		return fmt.Sprintf("%d.hk", number), nil
+	case "Japan":
+		if !japanCodeRegex.MatchString(code) {
+			return "", fmt.Errorf("错误的东京上市公司代码:%s", code)
+		}
+
+		return code + ".jp", nil
+	}
+
+	return "", fmt.Errorf("Stooq不支持市场%s", marketName)
+}
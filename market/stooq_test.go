@@ -0,0 +1,115 @@
+package market
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestStooqSymbol(t *testing.T) {
+
+	cases := []struct {
+		market string
+		code   string
+		symbol string
+	}{
+		{"America", "AAPL", "aapl.us"},
+		{"America", "BRK.B", "brk-b.us"},
+		{"HongKong", "00700", "700.hk"},
+		{"Japan", "7203", "7203.jp"},
+	}
+
+	for _, c := range cases {
+		symbol, err := stooqSymbol(c.market, c.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if symbol != c.symbol {
+			t.Errorf("%s的Stooq代码应为%s,实际%s", c.code, c.symbol, symbol)
+		}
+	}
+
+	_, err := stooqSymbol("China", "600000")
+	if err == nil {
+		t.Errorf("不支持的市场应该返回错误")
+	}
+}
+
+func TestStooqParse(t *testing.T) {
+
+	m := NewStooq(America{})
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+
+	result, err := m.Parse("AAPL", day, []byte("Date,Open,High,Low,Close,Volume\n2024-01-04,182.15,183.09,180.88,181.91,71983600\n2024-01-05,181.99,182.76,180.17,181.18,62303300\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success || len(result.Pre) != 0 || len(result.Regular) != 1 || len(result.Post) != 0 {
+		t.Fatalf("应只有1条常规交易时段的日线,实际%+v", result)
+	}
+
+	p := result.Regular[0]
+	if p.Time.Format("2006-01-02 15:04") != "2024-01-05 00:00" || p.Open != 181.99 || p.High != 182.76 || p.Low != 180.17 || p.Close != 181.18 || p.Volume != 62303300 {
+		t.Errorf("日线不正确:%+v", p)
+	}
+
+	result, err = m.Parse("AAPL", day, []byte("No data"))
+	if err != nil || result.Success {
+		t.Errorf("没有数据时应返回失败的结果,实际%+v %v", result, err)
+	}
+
+	_, err = m.Parse("AAPL", day, []byte("<html></html>"))
+	if err == nil {
+		t.Errorf("错误的格式应该返回错误")
+	}
+}
+
+func TestStooqCrawl(t *testing.T) {
+
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprint(w, "Date,Open,High,Low,Close,Volume\n2024-01-05,181.99,182.76,180.17,181.18,62303300\n")
+	}))
+	defer server.Close()
+
+	defer func(host string) { stooqHost = host }(stooqHost)
+	stooqHost = server.URL
+
+	//	使用原来的市场名称保存,其他流程不变
+	market := NewStooq(America{})
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	result, err := companyTransaction(market, Company{Market: market.Name(), Code: "BRK.B"}, day, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if query != "s=brk-b.us&d1=20240105&d2=20240105&i=d" || !result.Success {
+		t.Errorf("请求或者结果不正确,请求%s,结果%+v", query, result)
+	}
+
+	peroids, err := LoadPeriods(market, "BRK.B", day, "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peroids) != 1 || peroids[0].Close != 181.18 {
+		t.Errorf("应保存1条日线,实际%+v", peroids)
+	}
+
+	_, err = market.CrawlInterval("BRK.B", day, Interval5m)
+	if err == nil {
+		t.Errorf("不支持的间隔应该返回错误")
+	}
+
+	defer overrideSettings(market.Name(), config.MarketConfig{Source: "iex"})()
+	if validateSettings(market.Name()) == nil {
+		t.Errorf("错误的数据源应该返回错误")
+	}
+}
@@ -0,0 +1,124 @@
+package market
+
+import (
+	"time"
+)
+
+//	存储
+type Store interface {
+	//	针对某个上市公司启动事务(1m间隔)
+	Begin(market Market, code string) (Tx, error)
+	//	针对某个上市公司的指定间隔启动事务
+	BeginInterval(market Market, code string, interval Interval) (Tx, error)
+
+	//	加入重试队列(已存在则忽略)
+	EnqueueRetry(market Market, entry RetryEntry) error
+	//	重试队列中的所有条目
+	RetryEntries(market Market) ([]RetryEntry, error)
+	//	更新重试队列中的条目
+	UpdateRetry(market Market, entry RetryEntry) error
+	//	从重试队列中移除
+	RemoveRetry(market Market, entry RetryEntry) error
+
+	//	市场所有上市公司在某日的错误数量
+	CountErrors(market Market, day time.Time) (int, error)
+
+	//	保存某日的上市公司列表(更新名称、交易所、行业和最后出现日期,新的上市公司同时记录首次出现日期)
+	SaveCompanies(market Market, companies []Company, day time.Time) error
+	//	读取保存过的所有上市公司(包括已经不在上市公司列表中的)
+	LoadCompanies(market Market) ([]Company, error)
+	//	保存上市和退市记录,退市的上市公司同时标记退市日期
+	SaveListingChanges(market Market, changes []ListingChange) error
+	//	指定日期以来的上市和退市记录(按日期排序)
+	ListingChanges(market Market, since time.Time) ([]ListingChange, error)
+
+	//	保存每日任务的完成时间
+	SaveLastRun(market Market, completed time.Time) error
+	//	每日任务最后一次的完成时间(没有运行过时为零值)
+	LastRun(market Market) (time.Time, error)
+
+	//	保存上市公司指定间隔的历史任务进度
+	SaveHistoryCheckpoint(market Market, code string, interval Interval, checkpoint HistoryCheckpoint) error
+	//	上市公司指定间隔的历史任务进度(没有进度时为零值)
+	LoadHistoryCheckpoint(market Market, code string, interval Interval) (HistoryCheckpoint, error)
+	//	所有上市公司指定间隔的历史任务进度(按上市公司代码)
+	HistoryCheckpoints(market Market, interval Interval) (map[string]HistoryCheckpoint, error)
+
+	//	上市公司的抓取情况(没有记录时只有Market和Code)
+	LoadActivity(market Market, code string) (CompanyActivity, error)
+	//	保存上市公司的抓取情况
+	SaveActivity(market Market, activity CompanyActivity) error
+	//	被标记为不活跃的上市公司(按代码排序)
+	InactiveCompanies(market Market) ([]CompanyActivity, error)
+
+	//	保存上市公司导出到target(如influx)的最后日期
+	SaveExportWatermark(market Market, code, target string, day time.Time) error
+	//	上市公司导出到target的最后日期(没有导出过时为零值)
+	LoadExportWatermark(market Market, code, target string) (time.Time, error)
+
+	//	删除分时数据后回收上市公司指定间隔的存储空间
+	Compact(market Market, code string, interval Interval) error
+
+	//	保存一次抓取的统计(同一上市公司、日期和间隔只保留最后一次)
+	SaveCrawlStat(market Market, stat CrawlStat) error
+	//	市场某日所有上市公司和间隔的抓取统计
+	CrawlStats(market Market, day time.Time) ([]CrawlStat, error)
+}
+
+//	存储事务
+type Tx interface {
+	//	事务对应的间隔
+	Interval() Interval
+
+	//	是否处理过
+	IsProcessed(day time.Time) (bool, error)
+	//	保存处理状态
+	MarkProcessed(day time.Time, success bool) error
+	//	在处理状态中保存分时数据的检查结果
+	SaveValidation(day time.Time, summary string) error
+	//	在处理状态中保存数据源提供的交易时段
+	SaveSessions(day time.Time, sessions TradingSessions) error
+	//	读取处理状态中保存的交易时段(没有处理过时返回ErrNotProcessed)
+	LoadSessions(day time.Time) (TradingSessions, error)
+	//	保存分时数据(period为pre, regular, post)
+	SavePeriod(period string, peroids []Peroid60) error
+	//	保存分红
+	SaveDividends(dividends []Dividend) error
+	//	保存拆股
+	SaveSplits(splits []Split) error
+	//	保存错误信息
+	SaveError(day time.Time, message string) error
+	//	指定日期范围内已处理的日期
+	ProcessedDays(start, end time.Time) ([]time.Time, error)
+	//	清除处理状态及错误信息
+	ClearProcessed(day time.Time) error
+	//	指定日期范围内的错误信息
+	Errors(start, end time.Time) ([]CrawlError, error)
+	//	读取指定时间范围内的分时数据
+	LoadPeriod(period string, start, end time.Time) ([]Peroid60, error)
+	//	指定时间范围内的分红和拆股(按时间排序)
+	CorporateActions(start, end time.Time) ([]CorporateAction, error)
+	//	删除指定时间范围内的分时数据
+	DeletePeriod(period string, start, end time.Time) error
+	//	保存日线(删除分时数据前保留)
+	SaveBar(bar Bar) error
+	//	读取保存的日线(没有时返回ErrNoData)
+	LoadBar(day time.Time) (Bar, error)
+
+	//	提交
+	Commit() error
+	//	回滚
+	Rollback() error
+}
+
+//	当前使用的存储,默认每个上市公司一个sqlite文件
+var store Store = sqliteStore{}
+
+//	设置存储(传入nil则恢复为默认的sqlite存储)
+func SetStore(s Store) {
+	if s == nil {
+		s = sqliteStore{}
+	}
+
+	store = s
+}
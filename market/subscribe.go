@@ -0,0 +1,93 @@
+package market
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/metrics"
+)
+
+//	上市公司某日保存成功的分时数据摘要
+type CompanyDayResult struct {
+	Market  string
+	Company string
+	Day     time.Time
+	//	每个交易时段保存的分时数据条数
+	Pre     int
+	Regular int
+	Post    int
+	//	常规交易时段的日线(没有常规交易时段数据时为零值)
+	Bar Bar
+}
+
+var (
+	//	每个市场的订阅(值为缓冲区)
+	dayResultSubscriptions = make(map[string]map[chan CompanyDayResult]bool)
+	dayResultMutex         sync.RWMutex
+)
+
+//	订阅市场保存成功的上市公司每日分时数据,返回接收的通道和取消订阅的函数(取消后通道关闭)
+//	缓冲区(EventBuffer)满时丢弃最早的结果,不会阻塞抓取
+func Subscribe(marketName string) (<-chan CompanyDayResult, func()) {
+
+	ch := make(chan CompanyDayResult, config.Get().EventBuffer)
+
+	dayResultMutex.Lock()
+	if dayResultSubscriptions[marketName] == nil {
+		dayResultSubscriptions[marketName] = make(map[chan CompanyDayResult]bool)
+	}
+	dayResultSubscriptions[marketName][ch] = true
+	dayResultMutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			dayResultMutex.Lock()
+			defer dayResultMutex.Unlock()
+
+			delete(dayResultSubscriptions[marketName], ch)
+			close(ch)
+		})
+	}
+}
+
+//	发送保存成功的结果到市场的所有订阅
+func publishDayResult(market Market, company Company, day time.Time, result *ParseResult) {
+
+	dayResultMutex.RLock()
+	defer dayResultMutex.RUnlock()
+
+	if len(dayResultSubscriptions[market.Name()]) == 0 {
+		return
+	}
+
+	r := CompanyDayResult{
+		Market:  market.Name(),
+		Company: company.Code,
+		Day:     day,
+		Pre:     len(result.Pre),
+		Regular: len(result.Regular),
+		Post:    len(result.Post)}
+
+	list := []sessionPeriods{{"pre", result.Pre}, {"regular", result.Regular}, {"post", result.Post}}
+	if bar, err := sessionsBar(market, company.Code, day, list, false); err == nil {
+		r.Bar = bar
+	}
+
+	for ch := range dayResultSubscriptions[market.Name()] {
+		for sent := false; !sent; {
+			select {
+			case ch <- r:
+				sent = true
+			default:
+				//	缓冲区满时丢弃最早的
+				select {
+				case <-ch:
+					metrics.SubscriptionDropped.WithLabelValues(market.Name()).Inc()
+				default:
+				}
+			}
+		}
+	}
+}
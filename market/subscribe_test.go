@@ -0,0 +1,76 @@
+package market
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestSubscribe(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockSubscribe", "AAA", "BBB", "CCC")
+	defer cleanup()
+
+	//	同时只抓取1家,按上市公司的顺序保存
+	concurrency := 1
+	defer overrideSettings(market.Name(), config.MarketConfig{Concurrency: &concurrency})()
+
+	results, unsubscribe := Subscribe(market.Name())
+	other, unsubscribeOther := Subscribe("MockSubscribeOther")
+	defer unsubscribeOther()
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	_, err := dailyTaskDay(market, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsubscribe()
+
+	codes := ""
+	for r := range results {
+		codes += r.Company
+
+		if r.Market != market.Name() || !r.Day.Equal(day) || r.Pre != 0 || r.Regular != 1 || r.Post != 0 {
+			t.Errorf("%s的结果不正确:%+v", r.Company, r)
+		}
+
+		if r.Bar.Open != 1 || r.Bar.Close != 1 || r.Bar.Volume != 1 {
+			t.Errorf("%s的日线不正确:%+v", r.Company, r.Bar)
+		}
+	}
+
+	if codes != "AAABBBCCC" {
+		t.Errorf("应依次收到AAA、BBB和CCC,实际%s", codes)
+	}
+
+	if len(other) != 0 {
+		t.Errorf("不应收到其他市场的结果")
+	}
+}
+
+func TestSubscribeDropsOldest(t *testing.T) {
+
+	c := config.Get()
+	defer func(buffer int) { c.EventBuffer = buffer }(c.EventBuffer)
+	c.EventBuffer = 2
+
+	market := mockMarket{name: "MockSubscribeDrop"}
+	results, unsubscribe := Subscribe(market.Name())
+
+	//	没有读取时不阻塞,只保留最新的2个
+	for _, code := range []string{"AAA", "BBB", "CCC"} {
+		publishDayResult(market, Company{Code: code}, time.Now(), &ParseResult{Success: true})
+	}
+	unsubscribe()
+
+	codes := ""
+	for r := range results {
+		codes += r.Company
+	}
+
+	if codes != "BBBCCC" {
+		t.Errorf("缓冲区满时应丢弃最早的,实际收到%s", codes)
+	}
+}
@@ -0,0 +1,108 @@
+package market
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	指数(代码以^开头,如^GSPC)
+	CompanyKindIndex = "index"
+	//	ETF等不在上市公司列表中的代码
+	CompanyKindETF = "etf"
+)
+
+//	配置的指数和ETF代码(Symbols)对应的上市公司
+func configuredSymbols(market Market) []Company {
+
+	symbols := config.Get().Markets[market.Name()].Symbols
+
+	companies := make([]Company, 0, len(symbols))
+	for _, symbol := range symbols {
+		code := strings.ToUpper(strings.TrimSpace(symbol))
+
+		kind := CompanyKindETF
+		if strings.HasPrefix(code, "^") {
+			kind = CompanyKindIndex
+		}
+
+		companies = append(companies, Company{Market: market.Name(), Code: code, Name: code, Kind: kind})
+	}
+
+	return companies
+}
+
+//	在上市公司列表中加入配置的指数和ETF(列表中已有的代码不重复加入)
+func withSymbols(market Market, companies []Company) []Company {
+
+	symbols := configuredSymbols(market)
+	if len(symbols) == 0 {
+		return companies
+	}
+
+	list := make([]Company, len(companies), len(companies)+len(symbols))
+	copy(list, companies)
+
+	for _, symbol := range symbols {
+		if !containsCompany(list, symbol.Code) {
+			list = append(list, symbol)
+		}
+	}
+
+	return list
+}
+
+//	检查配置的指数和ETF代码
+func validateSymbols(marketName string, symbols []string) error {
+
+	for _, symbol := range symbols {
+		code := strings.TrimSpace(symbol)
+		if code == "" || code == "^" || strings.ContainsAny(code, " /\\") || strings.LastIndex(code, "^") > 0 {
+			return fmt.Errorf("[%s]\t错误的指数或ETF代码(Symbols):%q", marketName, symbol)
+		}
+	}
+
+	return nil
+}
+
+//	数据源的代码与上市公司列表的代码不同的市场(如美股分类股BRK.B在雅虎财经为BRK-B)
+//	抓取时使用数据源的代码,保存时仍使用上市公司列表的代码
+type SymbolNormalizer interface {
+	//	上市公司列表的代码转换为数据源的代码
+	NormalizeSymbol(listingCode string) (crawlCode string)
+	//	数据源的代码转换回上市公司列表的代码
+	ListingSymbol(crawlCode string) (listingCode string)
+}
+
+//	抓取时使用的代码(市场没有实现SymbolNormalizer时不变)
+func crawlSymbol(market Market, code string) string {
+
+	if normalizer, ok := market.(SymbolNormalizer); ok {
+		return normalizer.NormalizeSymbol(code)
+	}
+
+	return code
+}
+
+//	数据源的代码对应的上市公司列表代码(市场没有实现SymbolNormalizer时不变)
+func listingSymbol(market Market, code string) string {
+
+	if normalizer, ok := market.(SymbolNormalizer); ok {
+		return normalizer.ListingSymbol(code)
+	}
+
+	return code
+}
+
+//	指数代码(以^开头)按原样查询雅虎财经,不加市场的后缀
+func indexSymbol(code string) (string, bool) {
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) < 2 || code[0] != '^' || strings.ContainsAny(code[1:], "^ ") {
+		return "", false
+	}
+
+	return code, true
+}
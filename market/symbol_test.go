@@ -0,0 +1,189 @@
+package market
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestSymbols(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockSymbols", "AAA", "SPY")
+	defer cleanup()
+
+	restore := overrideSettings(market.Name(), config.MarketConfig{Symbols: []string{"^gspc", "SPY", "QQQ"}})
+	companies, err := getCompanies(market)
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+
+	//	上市公司列表中已有的代码不重复加入
+	kinds := make(map[string]string)
+	for _, company := range companies {
+		kinds[company.Code] = company.Kind
+	}
+
+	if len(companies) != 4 || kinds["^GSPC"] != CompanyKindIndex || kinds["QQQ"] != CompanyKindETF || kinds["SPY"] != "" {
+		t.Errorf("应加入指数^GSPC和ETF QQQ,实际%+v", companies)
+	}
+
+	//	存档中只有上市公司
+	archived := CompanyList{}
+	err = archived.Load(market)
+	if err != nil || len(archived) != 2 {
+		t.Errorf("存档中应只有2家上市公司,实际%d家,%v", len(archived), err)
+	}
+
+	stored, err := store.LoadCompanies(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, company := range stored {
+		if company.Code == "^GSPC" && company.Kind != CompanyKindIndex {
+			t.Errorf("保存的^GSPC应标记为指数,实际%q", company.Kind)
+		}
+	}
+
+	//	去掉配置后指数和ETF不算退市
+	restore()
+	_, err = getCompanies(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := store.ListingChanges(market, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 0 {
+		t.Errorf("指数和ETF不应有上市和退市记录,实际%+v", changes)
+	}
+}
+
+func TestValidateSymbols(t *testing.T) {
+
+	if err := validateSymbols("America", []string{"^GSPC", "^VIX", "SPY", "510300"}); err != nil {
+		t.Errorf("正确的代码不应返回错误:%v", err)
+	}
+
+	for _, symbol := range []string{"", "^", "A B", "A^B", "A/B"} {
+		if validateSymbols("America", []string{symbol}) == nil {
+			t.Errorf("错误的代码%q应该返回错误", symbol)
+		}
+	}
+}
+
+func TestCrawlIndexEscaped(t *testing.T) {
+
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/test/getcrumb" {
+			w.Write([]byte("crumb"))
+			return
+		}
+
+		path = r.URL.EscapedPath()
+		w.Write([]byte(mockYahooJson))
+	}))
+	defer server.Close()
+
+	SetHTTPClient(server.Client())
+	defer SetHTTPClient(nil)
+	defer useYahooServer(server.URL)()
+
+	_, err := America{}.Crawl("^GSPC", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v8/finance/chart/%5EGSPC" {
+		t.Errorf("^应编码为%%5E,实际%s", path)
+	}
+
+	//	抓取任务转换代码后美股直接使用,不再转换
+	_, _, err = crawlInterval(America{}, "BRK.B", time.Now(), Interval1m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v8/finance/chart/BRK-B" {
+		t.Errorf("应使用BRK-B抓取,实际%s", path)
+	}
+}
+
+//	使用美股代码规则的测试用市场,记录抓取时使用的代码
+type normalizingMarket struct {
+	mockMarket
+	crawled []string
+}
+
+func (m *normalizingMarket) Crawl(code string, day time.Time) (string, error) {
+	m.crawled = append(m.crawled, code)
+	return m.mockMarket.Crawl(code, day)
+}
+
+func (m *normalizingMarket) NormalizeSymbol(listingCode string) string {
+	return America{}.NormalizeSymbol(listingCode)
+}
+
+func (m *normalizingMarket) ListingSymbol(crawlCode string) string {
+	return America{}.ListingSymbol(crawlCode)
+}
+
+func TestNormalizeSymbol(t *testing.T) {
+
+	mock, cleanup := newMockMarket(t, "MockNormalize", "BRK.B")
+	defer cleanup()
+
+	market := &normalizingMarket{mockMarket: mock}
+	Add(market)
+	defer Remove(market.Name())
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	tx, err := store.Begin(market, "BRK.B")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = companyDayTask(tx, market, mock.companies[0], day)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	抓取时使用数据源的代码
+	if len(market.crawled) != 1 || market.crawled[0] != "BRK-B" {
+		t.Errorf("应使用BRK-B抓取,实际%v", market.crawled)
+	}
+
+	//	保存时使用上市公司列表的代码
+	tx, err = store.Begin(market, "BRK.B")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processed, err := tx.IsProcessed(day)
+	tx.Rollback()
+	if err != nil || !processed {
+		t.Errorf("处理状态应保存在BRK.B中,实际%v,%v", processed, err)
+	}
+
+	//	也可以用数据源的代码查询上市公司
+	err = store.SaveCompanies(market, mock.companies, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	company, err := GetCompany(market.Name(), "brk-b")
+	if err != nil || company.Code != "BRK.B" {
+		t.Errorf("用BRK-B应查询到BRK.B,实际%+v,%v", company, err)
+	}
+}
@@ -0,0 +1,222 @@
+package market
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+const (
+	//	分时数据的检查规则
+	rulePrice     = "price"
+	ruleRange     = "range"
+	ruleVolume    = "volume"
+	ruleSession   = "session"
+	ruleDuplicate = "duplicate"
+)
+
+//	分时数据的检查规则
+type ValidationRules struct {
+	//	价格必须为正数
+	Price bool
+	//	最高价不能低于最低价,开盘价和收盘价必须在最低价和最高价之间
+	Range bool
+	//	成交量不能为负数
+	Volume bool
+	//	时间必须在交易时段内(不检查时直接忽略交易时段以外的数据)
+	Session bool
+	//	时间不能重复(保留第一条)
+	Duplicate bool
+	//	有异常数据时整天失败
+	FailOnInvalid bool
+	//	异常数据的占比超过该值时整天失败(为0时只丢弃异常数据)
+	MaxInvalidRatio float64
+}
+
+//	分时数据的检查结果
+type ValidationSummary struct {
+	//	检查的数据条数
+	Total int
+	//	异常的数据条数
+	Invalid int
+	//	每条规则的异常数量
+	Rules map[string]int
+	//	第一条异常数据的描述
+	First string
+}
+
+//	检查结果的摘要,如:共6条,异常4条(range:2,volume:1,price:1)
+func (s ValidationSummary) String() string {
+
+	if s.Invalid == 0 {
+		return fmt.Sprintf("共%d条,没有异常", s.Total)
+	}
+
+	names := make([]string, 0, len(s.Rules))
+	for name := range s.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	counts := make([]string, 0, len(names))
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%s:%d", name, s.Rules[name]))
+	}
+
+	return fmt.Sprintf("共%d条,异常%d条(%s)", s.Total, s.Invalid, strings.Join(counts, ","))
+}
+
+//	按规则是否应该整天失败,返回失败的原因(不失败时为空)
+func (s ValidationSummary) failure(rules ValidationRules) string {
+
+	if s.Invalid == 0 {
+		return ""
+	}
+
+	if rules.FailOnInvalid {
+		return s.First
+	}
+
+	ratio := float64(s.Invalid) / float64(s.Total)
+	if rules.MaxInvalidRatio > 0 && ratio > rules.MaxInvalidRatio {
+		return fmt.Sprintf("异常分时数据占%.1f%%,超过%.1f%%:%s", ratio*100, rules.MaxInvalidRatio*100, s.First)
+	}
+
+	return ""
+}
+
+var (
+	//	通过SetValidationRules设置的检查规则(为nil时使用配置)
+	validationRules      *ValidationRules
+	validationRulesMutex sync.Mutex
+)
+
+//	设置分时数据的检查规则(传入nil则恢复为配置中的规则)
+func SetValidationRules(rules *ValidationRules) {
+	validationRulesMutex.Lock()
+	defer validationRulesMutex.Unlock()
+
+	validationRules = rules
+}
+
+//	当前使用的检查规则
+func getValidationRules() ValidationRules {
+	validationRulesMutex.Lock()
+	defer validationRulesMutex.Unlock()
+
+	if validationRules != nil {
+		return *validationRules
+	}
+
+	c := config.Get()
+	rules := ValidationRules{FailOnInvalid: c.InvalidPoints == invalidPointsFail, MaxInvalidRatio: c.MaxInvalidRatio}
+
+	//	为空时全部检查
+	names := c.ValidationRules
+	if len(names) == 0 {
+		names = []string{rulePrice, ruleRange, ruleVolume, ruleSession, ruleDuplicate}
+	}
+
+	for _, name := range names {
+		switch name {
+		case rulePrice:
+			rules.Price = true
+		case ruleRange:
+			rules.Range = true
+		case ruleVolume:
+			rules.Volume = true
+		case ruleSession:
+			rules.Session = true
+		case ruleDuplicate:
+			rules.Duplicate = true
+		}
+	}
+
+	return rules
+}
+
+//	检查配置中的检查规则
+func validateValidationRules() error {
+
+	c := config.Get()
+	for _, name := range c.ValidationRules {
+		switch name {
+		case rulePrice, ruleRange, ruleVolume, ruleSession, ruleDuplicate:
+		default:
+			return fmt.Errorf("[Config]\t未知的分时数据检查规则(ValidationRules):%s", name)
+		}
+	}
+
+	if c.MaxInvalidRatio < 0 || c.MaxInvalidRatio > 1 {
+		return fmt.Errorf("[Config]\t异常分时数据的占比(MaxInvalidRatio)必须在0到1之间,实际为%v", c.MaxInvalidRatio)
+	}
+
+	return nil
+}
+
+//	属于某个交易时段的分时数据(交易时段以外时session为空)
+type sessionPeroid struct {
+	session string
+	peroid  Peroid60
+}
+
+//	按规则检查分时数据,返回正常的数据和检查结果
+func validatePeroids(points []sessionPeroid, rules ValidationRules) ([]sessionPeroid, ValidationSummary) {
+
+	summary := ValidationSummary{Rules: make(map[string]int)}
+	valid := make([]sessionPeroid, 0, len(points))
+	seen := make(map[int64]bool, len(points))
+
+	for _, point := range points {
+		//	不检查交易时段时直接忽略交易时段以外的数据
+		if point.session == "" && !rules.Session {
+			continue
+		}
+
+		summary.Total++
+
+		rule, message := checkPeroid(point, rules)
+		if rule == "" && rules.Duplicate && seen[point.peroid.Time.Unix()] {
+			rule, message = ruleDuplicate, "时间重复"
+		}
+
+		if rule != "" {
+			summary.Invalid++
+			summary.Rules[rule]++
+			if summary.First == "" {
+				summary.First = fmt.Sprintf("%s的分时数据异常:%s", point.peroid.Time.Format("2006-01-02 15:04:05"), message)
+			}
+			continue
+		}
+
+		seen[point.peroid.Time.Unix()] = true
+		valid = append(valid, point)
+	}
+
+	return valid, summary
+}
+
+//	检查分时数据是否合理,返回违反的规则和异常的描述(正常时都为空)
+func checkPeroid(point sessionPeroid, rules ValidationRules) (string, string) {
+
+	p := point.peroid
+	switch {
+	case rules.Session && point.session == "":
+		return ruleSession, "不在交易时段内"
+	case rules.Price && (p.Open <= 0 || p.Close <= 0 || p.High <= 0 || p.Low <= 0):
+		return rulePrice, fmt.Sprintf("价格不是正数(开%v 收%v 高%v 低%v)", p.Open, p.Close, p.High, p.Low)
+	case rules.Range && p.High < p.Low:
+		return ruleRange, fmt.Sprintf("最高价%v低于最低价%v", p.High, p.Low)
+	case rules.Range && (p.Open < p.Low || p.Open > p.High):
+		return ruleRange, fmt.Sprintf("开盘价%v不在最低价%v和最高价%v之间", p.Open, p.Low, p.High)
+	case rules.Range && (p.Close < p.Low || p.Close > p.High):
+		return ruleRange, fmt.Sprintf("收盘价%v不在最低价%v和最高价%v之间", p.Close, p.Low, p.High)
+	case rules.Volume && p.Volume < 0:
+		return ruleVolume, fmt.Sprintf("成交量%d为负数", p.Volume)
+	}
+
+	return "", ""
+}
@@ -0,0 +1,151 @@
+package market
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	解析测试数据
+func parseFixture(t *testing.T, name string) *ParseResult {
+
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestValidationAnomalies(t *testing.T) {
+
+	cases := []struct {
+		fixture string
+		valid   int
+		rules   map[string]int
+	}{
+		{"yahoo_negative_price.json", 2, map[string]int{rulePrice: 1}},
+		{"yahoo_out_of_session.json", 2, map[string]int{ruleSession: 1}},
+		{"yahoo_duplicate.json", 2, map[string]int{ruleDuplicate: 1}},
+		{"yahoo_corrupt.json", 2, map[string]int{rulePrice: 1, ruleRange: 2, ruleVolume: 1}},
+	}
+
+	for _, c := range cases {
+		result := parseFixture(t, c.fixture)
+		if !result.Success {
+			t.Errorf("%s默认只丢弃异常数据,实际失败:%s", c.fixture, result.Message)
+			continue
+		}
+
+		if valid := len(result.Pre) + len(result.Regular) + len(result.Post); valid != c.valid {
+			t.Errorf("%s应保留%d条,实际%d条", c.fixture, c.valid, valid)
+		}
+
+		for rule, count := range c.rules {
+			if result.Validation.Rules[rule] != count {
+				t.Errorf("%s违反%s规则的应有%d条,实际%d条", c.fixture, rule, count, result.Validation.Rules[rule])
+			}
+		}
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+
+	defer SetValidationRules(nil)
+
+	//	不检查重复时保留所有数据
+	SetValidationRules(&ValidationRules{Price: true, Range: true, Volume: true, Session: true})
+	result := parseFixture(t, "yahoo_duplicate.json")
+	if !result.Success || len(result.Regular) != 3 || result.Validation.Invalid != 0 {
+		t.Errorf("不检查重复时应保留3条,实际%d条", len(result.Regular))
+	}
+
+	//	异常数据占比超过阈值时整天失败(yahoo_corrupt.json中6条有4条异常)
+	SetValidationRules(&ValidationRules{Price: true, Range: true, Volume: true, MaxInvalidRatio: 0.5})
+	result = parseFixture(t, "yahoo_corrupt.json")
+	if result.Success || !strings.Contains(result.Message, "超过50.0%") {
+		t.Errorf("异常数据超过50%%时应该失败,实际:%+v", result)
+	}
+
+	SetValidationRules(&ValidationRules{Price: true, Range: true, Volume: true, MaxInvalidRatio: 0.7})
+	result = parseFixture(t, "yahoo_corrupt.json")
+	if !result.Success || len(result.Regular) != 2 {
+		t.Errorf("异常数据未超过70%%时应该成功,实际:%+v", result)
+	}
+}
+
+func TestValidateValidationRules(t *testing.T) {
+
+	c := config.Get()
+	defer func(rules []string, ratio float64) { c.ValidationRules, c.MaxInvalidRatio = rules, ratio }(c.ValidationRules, c.MaxInvalidRatio)
+
+	c.ValidationRules, c.MaxInvalidRatio = []string{rulePrice, ruleSession}, 0.5
+	if err := validateValidationRules(); err != nil {
+		t.Errorf("检查规则应该有效:%s", err.Error())
+	}
+
+	rules := getValidationRules()
+	if !rules.Price || !rules.Session || rules.Range || rules.Volume || rules.Duplicate || rules.MaxInvalidRatio != 0.5 {
+		t.Errorf("检查规则不正确:%+v", rules)
+	}
+
+	c.ValidationRules = []string{"unknown"}
+	if validateValidationRules() == nil {
+		t.Errorf("未知的检查规则应该返回错误")
+	}
+
+	c.ValidationRules, c.MaxInvalidRatio = nil, 1.5
+	if validateValidationRules() == nil {
+		t.Errorf("占比超过1时应该返回错误")
+	}
+}
+
+func TestSaveValidation(t *testing.T) {
+
+	market, cleanup := newMockMarket(t, "MockValidation", "AAPL")
+	defer cleanup()
+
+	result := parseFixture(t, "yahoo_corrupt.json")
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+
+	tx, err := store.Begin(market, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = saveResult(tx, day, result)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := getDB(market, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var validation string
+	err = db.QueryRow("select validation from process where [date]=?", day.Format("20060102")).Scan(&validation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validation != result.Validation.String() || !strings.Contains(validation, "异常4条") {
+		t.Errorf("处理状态中的检查结果不正确:%s", validation)
+	}
+}
@@ -0,0 +1,203 @@
+package market
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+//	分时数据计算的日线与数据源的日线不一致
+type Discrepancy struct {
+	Market  string
+	Company string
+	Day     time.Time
+	//	用保存的分时数据计算的日线
+	Stored Bar
+	//	数据源的日线
+	Reference Bar
+	//	超过误差的字段(open, high, low, close)
+	Fields []string
+}
+
+//	说明,如:AAPL 20240105 close 181.9/181.18
+func (d Discrepancy) String() string {
+
+	parts := make([]string, 0, len(d.Fields))
+	for _, field := range d.Fields {
+		stored, reference := barField(d.Stored, field), barField(d.Reference, field)
+		parts = append(parts, fmt.Sprintf("%s %s/%s", field, formatPrice(stored), formatPrice(reference)))
+	}
+
+	return fmt.Sprintf("%s %s %s", d.Company, d.Day.Format("20060102"), strings.Join(parts, ","))
+}
+
+//	用数据源的日线核对指定日期范围内保存的分时数据,返回超过误差(VerifyTolerance)的日期
+//	没有处理过或者没有分时数据的日期不核对,配置了VerifyReset时不一致的日期清除处理状态并加入重试队列
+//	数据源已经不提供分时数据的日期(超过HistoryDays)只报告不清除,避免重新抓取失败后丢失数据
+func Verify(marketName, companyCode string, from, to time.Time) ([]Discrepancy, error) {
+
+	//	清除处理状态时锁定数据目录
+	if config.Get().VerifyReset {
+		err := Lock()
+		if err != nil {
+			return nil, err
+		}
+		defer Unlock()
+	}
+
+	market, found := Get(marketName)
+	if !found {
+		return nil, fmt.Errorf("[Verify]\t未能找到市场%s", marketName)
+	}
+
+	reference, err := verifySource(market)
+	if err != nil {
+		return nil, err
+	}
+
+	//	数据源还能提供分时数据的最早日期
+	earliest := locationYesterdayZero(market).AddDate(0, 0, 1-getSettings(market.Name()).historyDays)
+
+	list := make([]Discrepancy, 0)
+	for _, day := range tradingDays(market, from, to) {
+		stored, err := DailyBar(market, companyCode, day)
+		if err == ErrNotProcessed || err == ErrNoData {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		bar, err := referenceBar(reference, companyCode, day)
+		if err == ErrNoData {
+			logger.Warn("数据源没有该日的日线,不核对", "market", market.Name(), "company", companyCode, "day", day.Format("20060102"))
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		fields := compareBars(stored, bar, config.Get().VerifyTolerance)
+		if len(fields) == 0 {
+			continue
+		}
+
+		d := Discrepancy{Market: market.Name(), Company: companyCode, Day: day, Stored: stored, Reference: bar, Fields: fields}
+		list = append(list, d)
+		logger.Warn("分时数据与日线不一致", "market", market.Name(), "company", companyCode, "day", day.Format("20060102"), "detail", d.String())
+
+		if config.Get().VerifyReset && day.Before(earliest) {
+			logger.Warn("数据源已经不提供该日的分时数据,不清除处理状态", "market", market.Name(), "company", companyCode, "day", day.Format("20060102"))
+		} else if config.Get().VerifyReset {
+			err = resetDay(market, companyCode, day, "与日线不一致:"+d.String())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return list, nil
+}
+
+//	核对使用的日线数据源
+func verifySource(market Market) (Market, error) {
+
+	switch config.Get().VerifySource {
+	case "":
+		return market, nil
+	case "stooq":
+		return NewStooq(market), nil
+	}
+
+	return nil, fmt.Errorf("[Config]\t错误的日线数据源(VerifySource):%s", config.Get().VerifySource)
+}
+
+//	从数据源获取某日的日线(没有时返回ErrNoData)
+func referenceBar(market Market, code string, day time.Time) (Bar, error) {
+
+	raw, _, err := crawlInterval(market, code, day, Interval1d)
+	if err != nil {
+		return Bar{}, err
+	}
+
+	result, err := parseRaw(market, code, day, []byte(raw))
+	if err != nil {
+		return Bar{}, err
+	}
+
+	if !result.Success {
+		return Bar{}, ErrNoData
+	}
+
+	for _, p := range result.Regular {
+		if p.Time.Format("20060102") == day.Format("20060102") {
+			return Bar{Market: market.Name(), Code: code, Day: day, Open: p.Open, High: p.High, Low: p.Low, Close: p.Close, Volume: p.Volume}, nil
+		}
+	}
+
+	return Bar{}, ErrNoData
+}
+
+//	比较日线的价格,返回相对误差超过tolerance的字段(成交量的统计口径不同,不比较)
+func compareBars(stored, reference Bar, tolerance float64) []string {
+
+	fields := make([]string, 0)
+	for _, field := range []string{"open", "high", "low", "close"} {
+		a, b := float64(barField(stored, field)), float64(barField(reference, field))
+		if b == 0 {
+			if a != 0 {
+				fields = append(fields, field)
+			}
+			continue
+		}
+
+		if math.Abs(a-b)/math.Abs(b) > tolerance {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+//	日线的价格
+func barField(bar Bar, field string) float32 {
+
+	switch field {
+	case "open":
+		return bar.Open
+	case "high":
+		return bar.High
+	case "low":
+		return bar.Low
+	}
+
+	return bar.Close
+}
+
+//	清除某日的处理状态并加入重试队列
+//	保留分时数据,重新抓取成功时覆盖(replace into),失败时仍然可用
+func resetDay(market Market, code string, day time.Time, message string) error {
+
+	tx, err := store.Begin(market, code)
+	if err != nil {
+		return err
+	}
+
+	err = tx.ClearProcessed(day)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return store.EnqueueRetry(market, RetryEntry{Company: code, Day: day, Message: message})
+}
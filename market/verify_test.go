@@ -0,0 +1,131 @@
+package market
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+)
+
+func TestVerify(t *testing.T) {
+
+	_, cleanup := newMockMarket(t, "America")
+	defer cleanup()
+	market := America{}
+
+	//	最近的两个交易日(在HistoryDays以内)和数据源已经不提供分时数据的日期
+	days := make([]time.Time, 0, 2)
+	for day := locationYesterdayZero(market); len(days) < 2; day = day.AddDate(0, 0, -1) {
+		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+			days = append([]time.Time{time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)}, days...)
+		}
+	}
+	old := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+
+	//	Stooq的日线:前一个交易日一致,最近的交易日和很早以前的日期收盘价不一致
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		day := r.URL.Query().Get("d1")
+		close := "10.5"
+		if day == days[1].Format("20060102") || day == old.Format("20060102") {
+			close = "10.9"
+		}
+
+		fmt.Fprintf(w, "Date,Open,High,Low,Close,Volume\n%s-%s-%s,10,11,9.5,%s,1000\n", day[:4], day[4:6], day[6:], close)
+	}))
+	defer server.Close()
+
+	defer func(host string) { stooqHost = host }(stooqHost)
+	stooqHost = server.URL
+
+	c := config.Get()
+	defer func(source string, reset bool) { c.VerifySource, c.VerifyReset = source, reset }(c.VerifySource, c.VerifyReset)
+	c.VerifySource, c.VerifyReset = "stooq", true
+
+	for _, day := range append(days, old) {
+		tx, err := store.Begin(market, "AAPL")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		regular := []Peroid60{
+			{Market: market.Name(), Code: "AAPL", Time: time.Date(day.Year(), day.Month(), day.Day(), 9, 30, 0, 0, time.Local), Open: 10, High: 11, Low: 10, Close: 10.2, Volume: 600},
+			{Market: market.Name(), Code: "AAPL", Time: time.Date(day.Year(), day.Month(), day.Day(), 15, 59, 0, 0, time.Local), Open: 10.2, High: 10.6, Low: 9.5, Close: 10.5, Volume: 400},
+		}
+
+		err = saveResult(tx, day, &ParseResult{Success: true, Regular: regular})
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := Verify(market.Name(), "AAPL", days[0], days[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].Day.Format("20060102") != days[1].Format("20060102") || len(list[0].Fields) != 1 || list[0].Fields[0] != "close" {
+		t.Fatalf("应只有最近交易日的收盘价不一致,实际%+v", list)
+	}
+
+	if list[0].String() != "AAPL "+days[1].Format("20060102")+" close 10.5/10.9" {
+		t.Errorf("不一致的说明不正确:%s", list[0].String())
+	}
+
+	//	不一致的日期清除处理状态并加入重试队列,分时数据保留到重新抓取成功
+	_, err = DailyBar(market, "AAPL", days[1])
+	if err != ErrNotProcessed {
+		t.Errorf("不一致的日期应清除处理状态,实际%v", err)
+	}
+
+	tx, err := store.Begin(market, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, end := localDayRange(days[1], days[1])
+	peroids, err := tx.LoadPeriod("regular", start, end)
+	tx.Rollback()
+	if err != nil || len(peroids) != 2 {
+		t.Errorf("清除处理状态后应保留分时数据,实际%d条,%v", len(peroids), err)
+	}
+
+	entries, err := store.RetryEntries(market)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Company != "AAPL" || entries[0].Day.Format("20060102") != days[1].Format("20060102") {
+		t.Errorf("不一致的日期应加入重试队列,实际%+v", entries)
+	}
+
+	//	超过HistoryDays的日期只报告,不清除处理状态
+	list, err = Verify(market.Name(), "AAPL", old, old)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("很早以前的日期也应报告不一致,实际%+v %v", list, err)
+	}
+
+	_, err = DailyBar(market, "AAPL", old)
+	if err != nil {
+		t.Errorf("超过HistoryDays的日期不应清除处理状态,实际%v", err)
+	}
+
+	entries, err = store.RetryEntries(market)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("超过HistoryDays的日期不应加入重试队列,实际%+v %v", entries, err)
+	}
+
+	//	已经清除的日期不再核对
+	list, err = Verify(market.Name(), "AAPL", days[0], days[1])
+	if err != nil || len(list) != 0 {
+		t.Errorf("清除后不应再有不一致,实际%+v %v", list, err)
+	}
+}
@@ -1,11 +1,30 @@
 package market
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
 	"time"
+)
+
+//	雅虎财经接口地址
+var yahooHost = "https://query1.finance.yahoo.com"
+
+const (
+	//	雅虎财经限速时返回的非标准状态码
+	yahooStatusThrottled = 999
 
-	"github.com/nzai/go-utility/net"
+	//	异常分时数据的处理方式:丢弃(默认)或者整天失败
+	invalidPointsDrop = "drop"
+	invalidPointsFail = "fail"
+
+	//	分时数据存档文件后缀
+	rawSuffix     = "_raw.txt"
+	regularSuffix = "_regular.txt"
+	errorSuffix   = "_error.txt"
 )
 
 type YahooJson struct {
@@ -26,6 +45,25 @@ type YahooResult struct {
 	Meta       YahooMeta       `json:"meta"`
 	Timestamp  []int64         `json:"timestamp"`
 	Indicators YahooIndicators `json:"indicators"`
+	Events     YahooEvents     `json:"events"`
+}
+
+//	分红和拆股(以时间戳为key,没有时为空)
+type YahooEvents struct {
+	Dividends map[string]YahooDividend `json:"dividends"`
+	Splits    map[string]YahooSplit    `json:"splits"`
+}
+
+type YahooDividend struct {
+	Amount float32 `json:"amount"`
+	Date   int64   `json:"date"`
+}
+
+type YahooSplit struct {
+	Date        int64   `json:"date"`
+	Numerator   float32 `json:"numerator"`
+	Denominator float32 `json:"denominator"`
+	SplitRatio  string  `json:"splitRatio"`
 }
 
 type YahooMeta struct {
@@ -50,12 +88,40 @@ type YahooTradingPeroid struct {
 	Post    YahooTradingPeroidSection `json:"post"`
 }
 
+//	每天一组交易时段,每组可能有多段(如午间休市)
 type YahooTradingPeroids struct {
 	Pres     [][]YahooTradingPeroidSection `json:"pre"`
 	Regulars [][]YahooTradingPeroidSection `json:"regular"`
 	Posts    [][]YahooTradingPeroidSection `json:"post"`
 }
 
+//	v8接口不包含盘前盘后数据时tradingPeriods是数组,只有常规交易时段
+func (p *YahooTradingPeroids) UnmarshalJSON(buffer []byte) error {
+
+	if len(buffer) > 0 && buffer[0] == '[' {
+		p.Pres, p.Posts = nil, nil
+		return json.Unmarshal(buffer, &p.Regulars)
+	}
+
+	//	避免递归调用UnmarshalJSON
+	type peroids YahooTradingPeroids
+	return json.Unmarshal(buffer, (*peroids)(p))
+}
+
+//	时间是否在交易时段内
+func inTradingPeroids(ts int64, days [][]YahooTradingPeroidSection) bool {
+
+	for _, sections := range days {
+		for _, section := range sections {
+			if ts >= section.Start && ts < section.End {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 type YahooTradingPeroidSection struct {
 	Timezone  string `json:"timezone"`
 	Start     int64  `json:"start"`
@@ -64,9 +130,16 @@ type YahooTradingPeroidSection struct {
 }
 
 type YahooIndicators struct {
-	Quotes []YahooQuote `json:"quote"`
+	Quotes    []YahooQuote    `json:"quote"`
+	AdjCloses []YahooAdjClose `json:"adjclose"`
+}
+
+//	复权收盘价(分时数据一般没有)
+type YahooAdjClose struct {
+	AdjClose []float32 `json:"adjclose"`
 }
 
+//	没有成交的分钟为null,解析后为0(全为0的会被忽略)
 type YahooQuote struct {
 	Open   []float32 `json:"open"`
 	Close  []float32 `json:"close"`
@@ -84,28 +157,112 @@ type Peroid60 struct {
 	High   float32
 	Low    float32
 	Volume int64
+	//	复权收盘价(数据源没有提供时为0)
+	AdjClose float32
+}
+
+//	分红
+type Dividend struct {
+	Market string
+	Code   string
+	Time   time.Time
+	Amount float32
+}
+
+//	拆股
+type Split struct {
+	Market      string
+	Code        string
+	Time        time.Time
+	Numerator   float32
+	Denominator float32
+	Ratio       string
 }
 
 type ParseResult struct {
-	Success bool
-	Message string
-	Pre     []Peroid60
-	Regular []Peroid60
-	Post    []Peroid60
+	Success   bool
+	Message   string
+	Pre       []Peroid60
+	Regular   []Peroid60
+	Post      []Peroid60
+	Dividends []Dividend
+	Splits    []Split
+	//	分时数据的检查结果
+	Validation ValidationSummary
+	//	数据源提供的交易时段(日线和没有提供时为空)
+	Sessions TradingSessions
 }
 
 //	从雅虎财经获取上市公司分时数据
-func downloadCompanyDaily(market Market, code, queryCode string, date time.Time) (string, error) {
+func downloadCompanyDaily(ctx context.Context, market Market, code, queryCode string, date time.Time, interval Interval) (string, error) {
 
 	//	如果不存在就抓取
 	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
 	end := start.Add(time.Hour * 24)
 
-	pattern := "https://finance-yql.media.yahoo.com/v7/finance/chart/%s?period2=%d&period1=%d&interval=1m&indicators=quote&includeTimestamps=true&includePrePost=true&events=div%7Csplit%7Cearn&corsDomain=finance.yahoo.com"
-	url := fmt.Sprintf(pattern, queryCode, end.Unix(), start.Unix())
+	pattern := yahooHost + "/v8/finance/chart/%s?period1=%d&period2=%d&interval=%s&includePrePost=true&events=div%%7Csplit"
+	url := fmt.Sprintf(pattern, neturl.PathEscape(queryCode), start.Unix(), end.Unix(), interval)
 
 	//	查询Yahoo财经接口,返回股票分时数据
-	return net.DownloadStringRetry(url, retryTimes, retryIntervalSeconds)
+	body, trace, err := downloadYahoo(ctx, market, url)
+	recordDownload(market.Name(), code, date, interval, trace)
+
+	return body, err
+}
+
+//	下载雅虎财经数据,被限速时降低请求频率,cookie和crumb失效时重新获取(ctx取消或超时时不再重试)
+func downloadYahoo(ctx context.Context, market Market, url string) (string, downloadTrace, error) {
+
+	limiter := getRateLimiter(market)
+	settings := getSettings(market.Name())
+
+	var err error
+	trace := downloadTrace{}
+	for index := 0; index < settings.downloadRetries && ctx.Err() == nil; index++ {
+		limiter.Wait()
+
+		session := getYahooSession(market.Name())
+		query, header := url, http.Header{"User-Agent": []string{session.UserAgent}}
+		if session.Crumb != "" {
+			query += "&crumb=" + neturl.QueryEscape(session.Crumb)
+			header.Set("Cookie", session.Cookie)
+		}
+
+		status, body, e := httpGetContext(ctx, market.Name(), query, header)
+		trace.Status, trace.Attempts = status, index+1
+		switch {
+		case e != nil:
+			err = e
+		case status == http.StatusOK:
+			return body, trace, nil
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			//	cookie和crumb失效,重新获取(同时更换User-Agent)后重试
+			resetYahooSession(session)
+			err = fmt.Errorf("雅虎财经要求验证,HTTP状态码%d", status)
+			continue
+		case status == http.StatusTooManyRequests || status == yahooStatusThrottled:
+			//	被限速,暂停后重试
+			limiter.Throttle()
+			err = fmt.Errorf("被雅虎财经限速,HTTP状态码%d", status)
+			continue
+		case status >= 400 && status < 500 && body != "":
+			//	错误信息由解析时处理
+			return body, trace, nil
+		default:
+			err = fmt.Errorf("HTTP状态码%d", status)
+		}
+
+		select {
+		case <-time.After(settings.downloadRetryInterval):
+		case <-ctx.Done():
+		}
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return "", trace, err
 }
 
 //	处理雅虎Json
@@ -121,45 +278,137 @@ func processDailyYahooJson(market Market, code string, date time.Time, buffer []
 	//	检查数据
 	err = validateDailyYahooJson(yj)
 	if err != nil {
-		return &ParseResult{false, err.Error(), nil, nil, nil}, nil
+		return &ParseResult{Success: false, Message: err.Error()}, nil
 	}
 
-	//	服务所在时区与市场所在时区的时间差(秒)
-	timezoneOffset := marketOffset[market.Name()]
+	//	市场所在时区
+	location := marketLocation(market)
 
 	pre := make([]Peroid60, 0)
 	regular := make([]Peroid60, 0)
 	post := make([]Peroid60, 0)
 
 	periods, quote := yj.Chart.Result[0].Meta.TradingPeriods, yj.Chart.Result[0].Indicators.Quotes[0]
+
+	daily := yj.Chart.Result[0].Meta.DataGranularity == string(Interval1d)
+
+	//	复权收盘价(数量不一致时忽略)
+	var adjclose []float32
+	if adjs := yj.Chart.Result[0].Indicators.AdjCloses; len(adjs) > 0 && len(adjs[0].AdjClose) == len(yj.Chart.Result[0].Timestamp) {
+		adjclose = adjs[0].AdjClose
+	}
+
+	points := make([]sessionPeroid, 0, len(yj.Chart.Result[0].Timestamp))
 	for index, ts := range yj.Chart.Result[0].Timestamp {
 
 		p := Peroid60{
 			Code:   code,
 			Market: market.Name(),
-			Time:   time.Unix(ts+timezoneOffset, 0),
+			Time:   marketWallClock(location, ts),
 			Open:   quote.Open[index],
 			Close:  quote.Close[index],
 			High:   quote.High[index],
 			Low:    quote.Low[index],
 			Volume: quote.Volume[index]}
 
+		if adjclose != nil {
+			p.AdjClose = adjclose[index]
+		}
+
 		//	如果全为0就忽略
 		if p.Open == 0 && p.Close == 0 && p.High == 0 && p.Low == 0 && p.Volume == 0 {
 			continue
 		}
 
-		//	Pre, Regular, Post
-		if ts >= periods.Pres[0][0].Start && ts < periods.Pres[0][0].End {
-			pre = append(pre, p)
-		} else if ts >= periods.Regulars[0][0].Start && ts < periods.Regulars[0][0].End {
-			regular = append(regular, p)
-		} else if ts >= periods.Posts[0][0].Start && ts < periods.Posts[0][0].End {
-			post = append(post, p)
+		//	Pre, Regular, Post(日线每天一条,都算常规交易时段)
+		session := ""
+		if daily {
+			session = "regular"
+		} else if inTradingPeroids(ts, periods.Pres) {
+			session = "pre"
+		} else if inTradingPeroids(ts, periods.Regulars) {
+			session = "regular"
+		} else if inTradingPeroids(ts, periods.Posts) {
+			session = "post"
 		}
+
+		points = append(points, sessionPeroid{session, p})
 	}
 
-	return &ParseResult{true, "", pre, regular, post}, nil
+	//	检查数据,丢弃异常的分时数据
+	rules := getValidationRules()
+	valid, summary := validatePeroids(points, rules)
+	if summary.Invalid > 0 {
+		logger.Warn("已丢弃异常的分时数据", "market", market.Name(), "company", code, "day", date.Format("20060102"), "count", summary.Invalid, "summary", summary.String())
+	}
+
+	//	异常数据过多时整天失败
+	if message := summary.failure(rules); message != "" {
+		return &ParseResult{Success: false, Message: message, Validation: summary}, nil
+	}
+
+	for _, point := range valid {
+		switch point.session {
+		case "pre":
+			pre = append(pre, point.peroid)
+		case "regular":
+			regular = append(regular, point.peroid)
+		case "post":
+			post = append(post, point.peroid)
+		}
+	}
+
+	//	分红和拆股
+	dividends, splits := parseYahooEvents(market, code, yj.Chart.Result[0].Events, location)
+
+	//	交易时段(提前收盘的日期常规交易时段较短)
+	var sessions TradingSessions
+	if !daily {
+		sessions = yahooTradingSessions(periods, location)
+	}
+
+	return &ParseResult{true, "", pre, regular, post, dividends, splits, summary, sessions}, nil
+}
+
+//	雅虎财经的交易时段转换为市场所在地的时间(按时间排序)
+func yahooTradingSessions(periods YahooTradingPeroids, location *time.Location) TradingSessions {
+
+	sessions := make(TradingSessions, 0)
+	for _, sp := range []struct {
+		session string
+		days    [][]YahooTradingPeroidSection
+	}{{"pre", periods.Pres}, {"regular", periods.Regulars}, {"post", periods.Posts}} {
+		for _, day := range sp.days {
+			for _, section := range day {
+				if section.End <= section.Start {
+					continue
+				}
+
+				sessions = append(sessions, SessionBoundary{sp.session, marketWallClock(location, section.Start), marketWallClock(location, section.End)})
+			}
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Start.Before(sessions[j].Start) })
+
+	return sessions
+}
+
+//	解析分红和拆股(按时间排序)
+func parseYahooEvents(market Market, code string, events YahooEvents, location *time.Location) ([]Dividend, []Split) {
+
+	dividends := make([]Dividend, 0, len(events.Dividends))
+	for _, d := range events.Dividends {
+		dividends = append(dividends, Dividend{market.Name(), code, marketWallClock(location, d.Date), d.Amount})
+	}
+	sort.Slice(dividends, func(i, j int) bool { return dividends[i].Time.Before(dividends[j].Time) })
+
+	splits := make([]Split, 0, len(events.Splits))
+	for _, s := range events.Splits {
+		splits = append(splits, Split{market.Name(), code, marketWallClock(location, s.Date), s.Numerator, s.Denominator, s.SplitRatio})
+	}
+	sort.Slice(splits, func(i, j int) bool { return splits[i].Time.Before(splits[j].Time) })
+
+	return dividends, splits
 }
 
 //	验证雅虎Json
@@ -186,11 +435,13 @@ func validateDailyYahooJson(yj *YahooJson) error {
 		return fmt.Errorf("Quotes数量不正确")
 	}
 
-	if len(result.Meta.TradingPeriods.Pres) == 0 ||
-		len(result.Meta.TradingPeriods.Pres[0]) == 0 ||
-		len(result.Meta.TradingPeriods.Posts) == 0 ||
-		len(result.Meta.TradingPeriods.Posts[0]) == 0 ||
-		len(result.Meta.TradingPeriods.Regulars) == 0 ||
+	//	日线没有交易时段
+	if result.Meta.DataGranularity == string(Interval1d) {
+		return nil
+	}
+
+	//	盘前盘后可以没有
+	if len(result.Meta.TradingPeriods.Regulars) == 0 ||
 		len(result.Meta.TradingPeriods.Regulars[0]) == 0 {
 		return fmt.Errorf("TradingPeriods数量不正确")
 	}
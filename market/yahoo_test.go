@@ -1,45 +1,284 @@
 package market
 
 import (
-	"os"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
-	"log"
-
 	"github.com/nzai/stockrecorder/config"
-	"github.com/nzai/go-utility/io"
 )
 
-func init() {
+//	重新生成golden文件
+var update = flag.Bool("update", false, "重新生成testdata中的golden文件")
+
+func TestParseYahooV8(t *testing.T) {
+
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_v8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success {
+		t.Fatalf("解析失败:%s", result.Message)
+	}
+
+	//	全为null的分钟会被忽略
+	if len(result.Pre) != 1 || len(result.Regular) != 2 || len(result.Post) != 2 {
+		t.Fatalf("盘前应为1条,常规应为2条,盘后应为2条,实际%d,%d,%d条", len(result.Pre), len(result.Regular), len(result.Post))
+	}
+
+	if len(result.Dividends) != 0 || len(result.Splits) != 0 {
+		t.Errorf("没有events时不应有分红和拆股")
+	}
+
+	p := result.Regular[0]
+	if p.Open != 182.09 || p.Close != 182.15 || p.High != 182.76 || p.Low != 181.89 || p.Volume != 3021417 {
+		t.Errorf("分时数据不正确:%+v", p)
+	}
+}
+
+func TestParseYahooEvents(t *testing.T) {
+
+	buffer := []byte(`{"chart":{"result":[{"meta":{"tradingPeriods":[[{"start":10,"end":20}]]},"timestamp":[10],"indicators":{"quote":[{"open":[1],"close":[1],"high":[1],"low":[1],"volume":[1]}]},"events":{"dividends":{"20":{"amount":0.24,"date":20},"10":{"amount":0.23,"date":10}},"splits":{"15":{"date":15,"numerator":4,"denominator":1,"splitRatio":"4:1"}}}}],"error":null}}`)
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Unix(0, 0), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Dividends) != 2 || len(result.Splits) != 1 {
+		t.Fatalf("应有2条分红1条拆股,实际%d条分红%d条拆股", len(result.Dividends), len(result.Splits))
+	}
+
+	if result.Dividends[0].Amount != 0.23 || result.Dividends[1].Amount != 0.24 {
+		t.Errorf("分红应按时间排序:%+v", result.Dividends)
+	}
+
+	if s := result.Splits[0]; s.Numerator != 4 || s.Denominator != 1 || s.Ratio != "4:1" {
+		t.Errorf("拆股数据不正确:%+v", s)
+	}
+}
+
+func TestParseYahooV8RegularOnly(t *testing.T) {
+
+	buffer := []byte(`{"chart":{"result":[{"meta":{"tradingPeriods":[[{"start":10,"end":20}]]},"timestamp":[5,10,15],"indicators":{"quote":[{"open":[1,2,3],"close":[1,2,3],"high":[1,2,3],"low":[1,2,3],"volume":[1,2,3]}]}}],"error":null}}`)
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Unix(0, 0), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success {
+		t.Fatalf("解析失败:%s", result.Message)
+	}
+
+	if len(result.Pre) != 0 || len(result.Regular) != 2 || len(result.Post) != 0 {
+		t.Errorf("应只有2条常规交易时段数据,实际%d,%d,%d条", len(result.Pre), len(result.Regular), len(result.Post))
+	}
+}
+
+func TestParseYahooAdjClose(t *testing.T) {
+
+	buffer := []byte(`{"chart":{"result":[{"meta":{"tradingPeriods":[[{"start":10,"end":20}]]},"timestamp":[10,15],"indicators":{"quote":[{"open":[1,2],"close":[1,2],"high":[1,2],"low":[1,2],"volume":[1,2]}],"adjclose":[{"adjclose":[0.5,1]}]}}],"error":null}}`)
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Unix(0, 0), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Regular) != 2 || result.Regular[0].AdjClose != 0.5 || result.Regular[1].AdjClose != 1 {
+		t.Errorf("复权收盘价不正确:%+v", result.Regular)
+	}
+}
+
+func TestParseYahooDaily(t *testing.T) {
+
+	//	日线没有tradingPeriods
+	buffer := []byte(`{"chart":{"result":[{"meta":{"dataGranularity":"1d"},"timestamp":[1704465000],"indicators":{"quote":[{"open":[182.09],"close":[181.18],"high":[182.76],"low":[180.17],"volume":[62303300]}],"adjclose":[{"adjclose":[180.4]}]}}],"error":null}}`)
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Unix(0, 0), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success {
+		t.Fatalf("解析失败:%s", result.Message)
+	}
+
+	if len(result.Pre) != 0 || len(result.Regular) != 1 || len(result.Post) != 0 {
+		t.Errorf("日线应只有1条常规交易时段数据,实际%d,%d,%d条", len(result.Pre), len(result.Regular), len(result.Post))
+	}
+}
+
+func TestParseYahooInvalidPoints(t *testing.T) {
+
+	//	第2条最高价低于最低价,第3条收盘价高于最高价,第4条成交量为负,第5条最高价为0
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_corrupt.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	defer func() { config.Get().InvalidPoints = "" }()
+
+	//	默认丢弃异常数据
+	config.Get().InvalidPoints = ""
+	result, err := processDailyYahooJson(America{}, "AAPL", day, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success || len(result.Regular) != 2 {
+		t.Errorf("应丢弃4条异常数据,剩余2条,实际%d条", len(result.Regular))
+	}
 
-	//	读取配置文件
-	err := config.Init()
+	//	整天失败
+	config.Get().InvalidPoints = invalidPointsFail
+	result, err = processDailyYahooJson(America{}, "AAPL", day, buffer)
 	if err != nil {
-		log.Fatal("读取配置文件错误: ", err)
-		return
+		t.Fatal(err)
+	}
+
+	if result.Success || !strings.Contains(result.Message, "最高价181低于最低价182") {
+		t.Errorf("应该因为异常数据失败,实际:%+v", result)
 	}
 }
 
 func TestParse60(t *testing.T) {
 
-	var u1 int64 = 1444829400
-	t.Logf("%d is %s", u1, time.Unix(u1, 0).Format("2006-01-02 15:04:05"))
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_v8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := processDailyYahooJson(America{}, "AAPL", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	按交易时段输出解析结果,与golden文件比较(go test -run TestParse60 -update 重新生成)
+	//	时间为纽约当地的时间,与运行测试的时区无关
+	lines := make([]string, 0)
+	for _, sp := range []sessionPeriods{{"pre", result.Pre}, {"regular", result.Regular}, {"post", result.Post}} {
+		for _, p := range sp.Peroids {
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%d", sp.Session, p.Time.Format("2006-01-02 15:04"), formatPrice(p.Open), formatPrice(p.High), formatPrice(p.Low), formatPrice(p.Close), p.Volume))
+		}
+	}
+	actual := strings.Join(lines, "\n") + "\n"
+
+	golden := filepath.Join("testdata", "yahoo_v8.golden")
+	if *update {
+		err = ioutil.WriteFile(golden, []byte(actual), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual != string(expected) {
+		t.Errorf("解析结果与%s不一致:\n%s", golden, actual)
+	}
 }
 
+func TestParseEarlyClose(t *testing.T) {
 
+	market, cleanup := newMockMarket(t, "MockEarlyClose", "AAPL")
+	defer cleanup()
+
+	buffer, err := ioutil.ReadFile(filepath.Join("testdata", "yahoo_early_close.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	感恩节次日13:00提前收盘
+	day := time.Date(2023, 11, 24, 0, 0, 0, 0, time.UTC)
+	result, err := processDailyYahooJson(America{}, "AAPL", day, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success {
+		t.Fatalf("解析失败:%s", result.Message)
+	}
+
+	expected := "pre 04:00-09:30,regular 09:30-13:00,post 13:00-17:00"
+	if result.Sessions.String() != expected {
+		t.Errorf("交易时段应为%s,实际%s", expected, result.Sessions.String())
+	}
+
+	//	13:00之后属于盘后
+	if len(result.Pre) != 1 || len(result.Regular) != 3 || len(result.Post) != 2 {
+		t.Fatalf("盘前应为1条,常规应为3条,盘后应为2条,实际%d,%d,%d条", len(result.Pre), len(result.Regular), len(result.Post))
+	}
+
+	if last := result.Regular[len(result.Regular)-1].Time.Format("15:04"); last != "12:59" {
+		t.Errorf("常规交易时段最后一条应为12:59,实际%s", last)
+	}
+
+	//	应有的条数按实际的交易时段计算
+	if rows := result.Sessions.ExpectedRows("regular", Interval1m); rows != 210 {
+		t.Errorf("常规交易时段应有210条,实际%d条", rows)
+	}
+
+	if rows := result.Sessions.ExpectedRows("regular", Interval5m); rows != 42 {
+		t.Errorf("5m间隔常规交易时段应有42条,实际%d条", rows)
+	}
+
+	if result.Sessions.isShort(150, Interval1m) || !result.Sessions.isShort(100, Interval1m) {
+		t.Errorf("分时数据偏少的判断不正确")
+	}
+
+	//	交易时段保存在处理状态中
+	tx, err := store.Begin(market, "AAPL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = saveResult(tx, day, result)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := LoadSessions(market, "AAPL", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions.String() != expected || sessions.Duration("regular") != time.Hour*3+time.Minute*30 {
+		t.Errorf("保存的交易时段不正确:%s", sessions.String())
+	}
+
+	_, err = LoadSessions(market, "AAPL", day.AddDate(0, 0, 1))
+	if err != ErrNotProcessed {
+		t.Errorf("没有处理过的日期应返回ErrNotProcessed,实际%v", err)
+	}
+}
 
 func TestReplace(t *testing.T) {
-	path := `c:\data\America\AAOI\20150826_raw.txt`
-	regular := strings.Replace(path, rawSuffix, regularSuffix, -1)
-	t.Log(strings.Replace(path, rawSuffix, errorSuffix, -1))
-	t.Log(regular)
-
-	_, err := os.Stat(regular)
-	if err == nil || os.IsExist(err) {
-		t.Log("Exists")
-	} else {
-		t.Log("Not Exists")
+
+	path := filepath.Join("data", "America", "AAOI", "20150826"+rawSuffix)
+
+	if regular := strings.Replace(path, rawSuffix, regularSuffix, -1); filepath.Base(regular) != "20150826_regular.txt" {
+		t.Errorf("常规交易时段文件名不正确:%s", regular)
+	}
+
+	if errorPath := strings.Replace(path, rawSuffix, errorSuffix, -1); filepath.Base(errorPath) != "20150826_error.txt" {
+		t.Errorf("错误信息文件名不正确:%s", errorPath)
 	}
 }
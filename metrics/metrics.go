@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "stockrecorder"
+)
+
+var (
+	//	抓取次数
+	CrawlAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "crawl_attempts_total",
+		Help:      "Number of company-day crawls attempted.",
+	}, []string{"market"})
+
+	//	抓取成功次数
+	CrawlSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "crawl_successes_total",
+		Help:      "Number of company-day crawls that were parsed and saved.",
+	}, []string{"market"})
+
+	//	抓取失败次数
+	CrawlFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "crawl_failures_total",
+		Help:      "Number of company-day crawls that failed.",
+	}, []string{"market"})
+
+	//	抓取耗时
+	CrawlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "crawl_duration_seconds",
+		Help:      "Latency of a single company-day crawl.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"market"})
+
+	//	保存的分时数据行数(session为pre, regular, post)
+	RowsSaved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_saved_total",
+		Help:      "Number of intraday rows saved by trading session.",
+	}, []string{"market", "session"})
+
+	//	重试次数(result为success, failed, dead)
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Number of retry queue attempts by result.",
+	}, []string{"market", "result"})
+
+	//	正在进行的抓取
+	InFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "crawls_in_flight",
+		Help:      "Number of company-day crawls currently running.",
+	}, []string{"market"})
+
+	//	当前的请求频率(每秒请求数)
+	RateLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_requests_per_second",
+		Help:      "Current effective request rate per market.",
+	}, []string{"market"})
+
+	//	每日任务处理的上市公司数(result为success, failed)
+	CompaniesProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "companies_processed_total",
+		Help:      "Number of companies processed by daily tasks by result.",
+	}, []string{"market", "result"})
+
+	//	每日任务最后一次的完成时间(unix时间戳)
+	LastRun = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix time the last daily task completed.",
+	}, []string{"market"})
+
+	//	更新上市公司列表次数(result为success, archive, failed)
+	CompanyListUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "company_list_updates_total",
+		Help:      "Number of company list updates by result.",
+	}, []string{"market", "result"})
+
+	//	订阅的缓冲区满时丢弃的最早的结果数
+	SubscriptionDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subscription_dropped_total",
+		Help:      "Number of company-day results dropped from full subscription buffers.",
+	}, []string{"market"})
+)
+
+//	所有指标
+var collectors = []prometheus.Collector{
+	CrawlAttempts,
+	CrawlSuccesses,
+	CrawlFailures,
+	CrawlDuration,
+	RowsSaved,
+	Retries,
+	InFlight,
+	RateLimit,
+	CompaniesProcessed,
+	LastRun,
+	CompanyListUpdates,
+	SubscriptionDropped,
+}
+
+//	注册所有指标
+func Register(reg prometheus.Registerer) error {
+
+	for _, c := range collectors {
+		err := reg.Register(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var (
+	registry     *prometheus.Registry
+	registryOnce sync.Once
+)
+
+//	内置的/metrics处理(使用单独的Registry)
+func Handler() http.Handler {
+
+	registryOnce.Do(func() {
+		registry = prometheus.NewRegistry()
+		Register(registry)
+	})
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/market"
+	"github.com/nzai/stockrecorder/server"
+)
+
+//	监视市场并启动http服务(一直运行)
+//	用法: stockrecorder monitor [--port 8080] [--api :8081] [--data-dir dir] [--concurrency 64] [--dry-run] [--force]
+func monitor(args []string) error {
+
+	flags := newFlagSet("monitor", "[参数]")
+	port := flags.Int("port", 0, "http服务的端口(覆盖配置文件的Port)")
+	api := flags.String("api", "", "只读查询接口的监听地址,如:8081(覆盖配置文件的APIAddress)")
+	force := flags.Bool("force", false, "数据目录的锁文件中记录的进程已经退出时强制解除(只对Windows有效,Unix上指定时报错)")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	err := initialize(func(c *config.Config) {
+		cf.override(flags, c)
+
+		flags.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "port":
+				c.Port = *port
+			case "api":
+				c.APIAddress = *api
+			}
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = market.SetForceLock(*force)
+	if err != nil {
+		return err
+	}
+
+	//	启动只读查询接口
+	if config.Get().APIAddress != "" {
+		go server.StartAPI()
+	}
+
+	//	启动http server
+	go server.Start()
+
+	log.Print("启动市场监视任务")
+
+	//	启动监视,收到SIGINT或SIGTERM后等待进行中的事务结束再退出
+	err = market.RunWithSignals(time.Second * time.Duration(config.Get().ShutdownTimeout))
+	if err != nil {
+		return fmt.Errorf("市场监视任务发生错误: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	删除市场指定日期之前的分时数据、处理状态和错误信息(日线保留)
+//	用法: stockrecorder prune --market America [--days 180 | --before 20240101] [--dry-run]
+func prune(args []string) error {
+
+	flags := newFlagSet("prune", "--market America [--days 180 | --before 20240101] [--dry-run]")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	days := flags.Int("days", 0, "保留的天数,默认为配置文件的RetentionDays")
+	before := flags.String("before", "", "删除这一天之前的数据(20060102),指定时忽略--days")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *marketName == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	err := cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	if *days <= 0 {
+		*days = config.Get().RetentionDays
+	}
+
+	if *before == "" && *days <= 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	m, err := market.GetMarket(*marketName)
+	if err != nil {
+		return err
+	}
+
+	//	按市场所在时区计算日期,Prune检查不会删除每日任务仍会抓取的数据
+	location, err := time.LoadLocation(m.Timezone())
+	if err != nil {
+		return err
+	}
+
+	cutoff := market.PruneCutoff(m, *days)
+	if *before != "" {
+		cutoff, err = parseDayIn(*before, cutoff, location)
+		if err != nil {
+			return err
+		}
+	}
+
+	return market.Prune(m, cutoff)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	用存档的原始数据重新解析市场某日的分时数据(不访问网络)
+//	用法: stockrecorder reprocess --market America --day 20240105 [--company AAPL]
+func reprocess(args []string) error {
+
+	flags := newFlagSet("reprocess", "--market America --day 20240105 [--company AAPL]")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	day := flags.String("day", "", "日期(20060102)")
+	company := flags.String("company", "", "只重新解析这家上市公司,默认为全部")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if *marketName == "" || *day == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	date, err := parseDay(*day, yesterday())
+	if err != nil {
+		return err
+	}
+
+	err = cf.init(flags)
+	if err != nil {
+		return err
+	}
+
+	if *company != "" {
+		return market.Reprocess(*marketName, *company, date)
+	}
+
+	m, err := market.GetMarket(*marketName)
+	if err != nil {
+		return err
+	}
+
+	return market.ReprocessAll(m, date)
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	mw "github.com/labstack/echo/middleware"
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/market"
+	"github.com/nzai/stockrecorder/server/result"
+)
+
+const (
+	//	默认每页的条数
+	defaultPageLimit = 1000
+	//	每页最多的条数
+	maxPageLimit = 10000
+)
+
+//	分页结果
+type page struct {
+	Total  int
+	Offset int
+	Limit  int
+	Items  interface{}
+}
+
+//	市场
+type apiMarket struct {
+	Name     string
+	Timezone string
+}
+
+//	启动只读查询接口
+func StartAPI() {
+
+	e := echo.New()
+	e.Use(mw.Recover())
+	e.Use(mw.Gzip())
+
+	registerAPIRoute(e)
+
+	log.Printf("启动查询接口,地址:%s", config.Get().APIAddress)
+	e.Run(config.Get().APIAddress)
+}
+
+//	注册查询接口的路由
+func registerAPIRoute(e *echo.Echo) {
+
+	e.Get("/markets", apiMarkets)
+	e.Get("/markets/:market/companies", apiCompanies)
+	e.Get("/markets/:market/companies/:code/days", apiDays)
+	e.Get("/markets/:market/companies/:code/peroids", apiPeroids)
+
+	//	运行状态
+	e.Get("/healthz", apiHealthz)
+	e.Get("/status", apiStatus)
+}
+
+//	健康检查(有市场最近一次成功的每日任务超过HealthMaxAge小时时返回503)
+func apiHealthz(c *echo.Context) error {
+
+	statuses, err := market.Status()
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	stale := make([]string, 0)
+	for _, status := range statuses {
+		if status.Stale(time.Now()) {
+			stale = append(stale, status.Market)
+		}
+	}
+
+	if len(stale) > 0 {
+		return c.JSON(http.StatusServiceUnavailable, result.HttpResult{
+			Success: false,
+			Message: fmt.Sprintf("%v超过%d小时没有成功的每日任务", stale, config.Get().HealthMaxAge),
+			Data:    statuses})
+	}
+
+	return c.JSON(http.StatusOK, result.Create(statuses))
+}
+
+//	所有市场的运行状态
+func apiStatus(c *echo.Context) error {
+
+	statuses, err := market.Status()
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result.Create(statuses))
+}
+
+//	所有市场
+func apiMarkets(c *echo.Context) error {
+
+	list := make([]apiMarket, 0)
+	for _, name := range market.MarketNames() {
+		m, err := market.GetMarket(name)
+		if err != nil {
+			continue
+		}
+
+		list = append(list, apiMarket{m.Name(), m.Timezone()})
+	}
+
+	return c.JSON(http.StatusOK, result.Create(list))
+}
+
+//	市场的上市公司(q为代码前缀或名称包含的文字)
+func apiCompanies(c *echo.Context) error {
+
+	m, err := market.GetMarket(c.Param("market"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, result.Failed(err.Error()))
+	}
+
+	offset, limit, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, result.Failed(err.Error()))
+	}
+
+	companies, err := market.SearchCompanies(m.Name(), c.Query("q"))
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result.Create(paginate(len(companies), offset, limit, func(start, end int) interface{} {
+		return companies[start:end]
+	})))
+}
+
+//	上市公司处理过的日期(from和to为20060102,默认全部)
+func apiDays(c *echo.Context) error {
+
+	m, company, ok, err := findCompany(c)
+	if !ok {
+		return err
+	}
+
+	offset, limit, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, result.Failed(err.Error()))
+	}
+
+	from, err := parseDate(c.Query("from"), time.Time{})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, result.Failed(err.Error()))
+	}
+
+	to, err := parseDate(c.Query("to"), time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, result.Failed(err.Error()))
+	}
+
+	days, err := market.ProcessedDays(m, company.Code, from, to)
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result.Create(paginate(len(days), offset, limit, func(start, end int) interface{} {
+		list := make([]string, 0, end-start)
+		for _, day := range days[start:end] {
+			list = append(list, day.Format("20060102"))
+		}
+
+		return list
+	})))
+}
+
+//	上市公司某日某个交易时段的分时数据(date为20060102,session默认为regular)
+func apiPeroids(c *echo.Context) error {
+
+	m, company, ok, err := findCompany(c)
+	if !ok {
+		return err
+	}
+
+	offset, limit, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, result.Failed(err.Error()))
+	}
+
+	if c.Query("date") == "" {
+		return c.JSON(http.StatusBadRequest, result.Failed("缺少日期(date)"))
+	}
+
+	day, err := parseDate(c.Query("date"), time.Time{})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, result.Failed(err.Error()))
+	}
+
+	session := c.Query("session")
+	if session == "" {
+		session = "regular"
+	}
+
+	if session != "pre" && session != "regular" && session != "post" {
+		return c.JSON(http.StatusBadRequest, result.Failed(fmt.Sprintf("错误的交易时段%s", session)))
+	}
+
+	peroids, err := market.LoadPeriods(m, company.Code, day, session)
+	if err != nil {
+		return internalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result.Create(paginate(len(peroids), offset, limit, func(start, end int) interface{} {
+		return peroids[start:end]
+	})))
+}
+
+//	查找路径中的市场和上市公司,没有找到时返回404(ok为false时返回的err是响应的结果)
+func findCompany(c *echo.Context) (market.Market, *market.Company, bool, error) {
+
+	m, err := market.GetMarket(c.Param("market"))
+	if err != nil {
+		return nil, nil, false, c.JSON(http.StatusNotFound, result.Failed(err.Error()))
+	}
+
+	company, err := market.GetCompany(m.Name(), c.Param("code"))
+	if err == market.ErrCompanyNotFound {
+		return nil, nil, false, c.JSON(http.StatusNotFound, result.Failed(err.Error()))
+	}
+
+	if err != nil {
+		return nil, nil, false, internalError(c, err)
+	}
+
+	return m, company, true, nil
+}
+
+//	记录错误并返回500
+func internalError(c *echo.Context, err error) error {
+	log.Printf("[API]\t%s出错:%s", c.Request().URL.String(), err.Error())
+	return c.JSON(http.StatusInternalServerError, result.Failed("查询时发生错误"))
+}
+
+//	分页参数(offset默认0,limit默认1000,最多10000)
+func parsePage(c *echo.Context) (int, int, error) {
+
+	offset, limit := 0, defaultPageLimit
+
+	if text := c.Query("offset"); text != "" {
+		value, err := strconv.Atoi(text)
+		if err != nil || value < 0 {
+			return 0, 0, fmt.Errorf("错误的offset:%s", text)
+		}
+
+		offset = value
+	}
+
+	if text := c.Query("limit"); text != "" {
+		value, err := strconv.Atoi(text)
+		if err != nil || value <= 0 || value > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit必须在1到%d之间:%s", maxPageLimit, text)
+		}
+
+		limit = value
+	}
+
+	return offset, limit, nil
+}
+
+//	解析20060102格式的日期(为空时返回默认值)
+func parseDate(text string, defaultValue time.Time) (time.Time, error) {
+
+	if text == "" {
+		return defaultValue, nil
+	}
+
+	day, err := time.Parse("20060102", text)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("错误的日期:%s", text)
+	}
+
+	return day, nil
+}
+
+//	按offset和limit截取一页(items返回[start, end)范围内的数据)
+func paginate(total, offset, limit int, items func(start, end int) interface{}) page {
+
+	start, end := offset, offset+limit
+	if start > total {
+		start = total
+	}
+
+	if end > total {
+		end = total
+	}
+
+	return page{Total: total, Offset: offset, Limit: limit, Items: items(start, end)}
+}
@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/labstack/echo"
+	"github.com/nzai/stockrecorder/config"
 	"github.com/nzai/stockrecorder/market"
+	"github.com/nzai/stockrecorder/metrics"
 	"github.com/nzai/stockrecorder/server/result"
 )
 
@@ -18,13 +20,24 @@ func registerRoute(e *echo.Echo) {
 	e.Get("/", welcome)
 	e.Favicon("favicon.ico")
 
-	e.Get("/:market/:code/:start/:end/1m", queryPeroid60)
+	e.Get("/:market/:code/:start/:end/:interval", queryPeroid60)
+
+	//	Prometheus指标
+	if config.Get().Metrics {
+		e.Get("/metrics", prometheusMetrics)
+	}
 }
 
 func welcome(c *echo.Context) error {
 	return c.String(http.StatusOK, "Welcome to stockrecorder http service!")
 }
 
+//	Prometheus指标
+func prometheusMetrics(c *echo.Context) error {
+	metrics.Handler().ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
 //	查询分时数据
 func queryPeroid60(c *echo.Context) error {
 
@@ -33,6 +46,11 @@ func queryPeroid60(c *echo.Context) error {
 	start := c.Param("start")
 	end := c.Param("end")
 
+	interval, err := market.ParseInterval(c.Param("interval"))
+	if err != nil {
+		return c.JSON(http.StatusOK, result.Failed("查询参数不正确"))
+	}
+
 	//	log.Printf("m=%s c=%s s=%s e=%s", _market, code, start, end)
 	if _market == "" || code == "" || start == "" || end == "" {
 		return c.JSON(http.StatusOK, result.Failed("查询参数为空"))
@@ -49,7 +67,7 @@ func queryPeroid60(c *echo.Context) error {
 	}
 
 	//	查询
-	peroids, err := market.QueryPeroid60(strings.Title(_market), code, _start, _end)
+	peroids, err := market.QueryInterval(strings.Title(_market), code, interval, _start, _end)
 	if err != nil {
 		log.Printf("[Query]\t查询分时数据发生错误(m=%s c=%s s=%s e=%s):%s", _market, code, start, end, err.Error())
 		return c.JSON(http.StatusOK, result.Failed("查询分时数据发生错误"))
@@ -68,7 +86,8 @@ func queryPeroid60(c *echo.Context) error {
 			int64(p.Close * 1000),
 			int64(p.High * 1000),
 			int64(p.Low * 1000),
-			p.Volume})
+			p.Volume,
+			int64(p.AdjClose * 1000)})
 	}
 
 	return c.JSON(http.StatusOK, result.Create(resultList))
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nzai/stockrecorder/config"
+	"github.com/nzai/stockrecorder/market"
+)
+
+//	分时数据与日线不一致
+var errDiscrepancy = errors.New("分时数据与日线不一致")
+
+//	检查配置和市场的设置,指定了上市公司时用日线核对保存的分时数据
+//	用法: stockrecorder verify [--market America --company AAPL [--from 20240101] [--to 20240201] [--source stooq] [--reset]]
+func verify(args []string) error {
+
+	flags := newFlagSet("verify", "[--market America --company AAPL [--from 20240101] [--to 20240201] [--source stooq] [--reset]]")
+	marketName := flags.String("market", "", "市场(America, China, HongKong, Japan)")
+	company := flags.String("company", "", "用日线核对这家上市公司的分时数据,为空时只检查配置")
+	from := flags.String("from", "", "起始日期(20060102),默认为结束日期前30天")
+	to := flags.String("to", "", "结束日期(20060102),默认为昨天")
+	source := flags.String("source", "", "日线数据源(stooq),默认使用市场自己的数据源(覆盖配置文件的VerifySource)")
+	reset := flags.Bool("reset", false, "不一致的日期清除处理状态并加入重试队列(覆盖配置文件的VerifyReset)")
+	cf := addConfigFlags(flags)
+	flags.Parse(args)
+
+	if (*marketName == "") != (*company == "") {
+		flags.Usage()
+		return errUsage
+	}
+
+	err := initialize(func(c *config.Config) {
+		cf.override(flags, c)
+
+		flags.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "source":
+				c.VerifySource = *source
+			case "reset":
+				c.VerifyReset = *reset
+			}
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	//	配置已经在initialize中检查过
+	if *company == "" {
+		log.Print("配置检查通过")
+		return nil
+	}
+
+	end, err := parseDay(*to, yesterday())
+	if err != nil {
+		return err
+	}
+
+	start, err := parseDay(*from, end.AddDate(0, 0, -30))
+	if err != nil {
+		return err
+	}
+
+	list, err := market.Verify(*marketName, *company, start, end)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range list {
+		fmt.Fprintln(os.Stdout, d.String())
+	}
+
+	if len(list) > 0 {
+		return errDiscrepancy
+	}
+
+	return nil
+}